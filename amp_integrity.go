@@ -0,0 +1,214 @@
+package kindling
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrIntegrity is returned, wrapped, for a response through the AMP cache
+// whose body doesn't match its Content-Length or digest headers.
+var ErrIntegrity = errors.New("response failed integrity check")
+
+// digestAlgorithms are the digest algorithms checked, keyed by their
+// lowercase names in Content-Digest and Repr-Digest (RFC 9530) and Digest
+// (RFC 3230).
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// integrityPrefetchBytes is how much of a response body integrityRoundTripper
+// reads before returning the response. A body that fits is checked whole,
+// so a mismatch still fails the round trip; a longer one is checked as it's
+// read.
+const integrityPrefetchBytes = 64 << 10
+
+// integrityRoundTripper checks responses from a transport that relays them
+// through an intermediary able to transform them, such as an AMP cache.
+// A response whose body doesn't match its Content-Length, or any
+// Content-Digest, Repr-Digest, or Digest it carries in a known algorithm,
+// fails with ErrIntegrity. Small bodies, such as control-plane payloads,
+// are checked before the response is returned, so replay-safe requests
+// retry on the next transport; larger ones are hashed as they stream, and
+// reading them fails with ErrIntegrity at the end. Responses with neither
+// are passed through unread; use WithResponseVerification for end-to-end
+// signatures.
+type integrityRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt *integrityRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if err := checkIntegrity(req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// checkIntegrity checks resp's body, if it has anything to be checked
+// against. A body of up to integrityPrefetchBytes is read and checked here,
+// and on success replaced with the buffered copy; on failure it's closed.
+// A longer body is replaced with one that checks it on reaching EOF.
+func checkIntegrity(req *http.Request, resp *http.Response) error {
+	if req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	digests := responseDigests(resp)
+	if resp.ContentLength < 0 && len(digests) == 0 {
+		return nil
+	}
+	check := newIntegrityCheck(resp.ContentLength, digests)
+	body := io.TeeReader(resp.Body, check)
+	prefix, err := io.ReadAll(io.LimitReader(body, integrityPrefetchBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return fmt.Errorf("reading response body to check integrity: %w", err)
+	}
+	if len(prefix) <= integrityPrefetchBytes {
+		resp.Body.Close()
+		if err := check.verify(); err != nil {
+			return err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(prefix))
+		resp.ContentLength = int64(len(prefix))
+		return nil
+	}
+	if check.want >= 0 && check.n > check.want {
+		resp.Body.Close()
+		return check.verify()
+	}
+	resp.Body = &verifyingBody{
+		r:      io.MultiReader(bytes.NewReader(prefix), body),
+		Closer: resp.Body,
+		check:  check,
+	}
+	return nil
+}
+
+// integrityCheck hashes a body written to it and checks it against the
+// expected length and digests.
+type integrityCheck struct {
+	// want is the expected length, or -1 if unknown.
+	want    int64
+	n       int64
+	digests []responseDigest
+	hashes  []hash.Hash
+}
+
+func newIntegrityCheck(want int64, digests []responseDigest) *integrityCheck {
+	c := &integrityCheck{want: want, digests: digests}
+	for _, d := range digests {
+		c.hashes = append(c.hashes, digestAlgorithms[d.algorithm]())
+	}
+	return c
+}
+
+func (c *integrityCheck) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	for _, h := range c.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify checks the body written so far, which should be all of it.
+func (c *integrityCheck) verify() error {
+	if c.want >= 0 && c.n != c.want {
+		return fmt.Errorf("%w: got %d bytes, Content-Length %d", ErrIntegrity, c.n, c.want)
+	}
+	for i, d := range c.digests {
+		if !bytes.Equal(c.hashes[i].Sum(nil), d.sum) {
+			return fmt.Errorf("%w: %s %s mismatch", ErrIntegrity, d.header, d.algorithm)
+		}
+	}
+	return nil
+}
+
+// verifyingBody is a response body checked as it's read. At EOF it fails
+// with ErrIntegrity instead if the body doesn't match.
+type verifyingBody struct {
+	r io.Reader
+	io.Closer
+	check *integrityCheck
+	err   error
+}
+
+func (b *verifyingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		if verr := b.check.verify(); verr != nil {
+			err = verr
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+type responseDigest struct {
+	header    string
+	algorithm string
+	sum       []byte
+}
+
+// responseDigests returns the digests in resp's headers, in algorithms this
+// package knows, that can be checked against its body as read. Content-Digest
+// and Digest cover the bytes as sent, and Repr-Digest the bytes with any
+// Content-Encoding undone, so a body the transport decompressed is checked
+// against Repr-Digest alone and one still encoded against the other two.
+func responseDigests(resp *http.Response) []responseDigest {
+	headers := []string{"Content-Digest", "Repr-Digest"}
+	if resp.Uncompressed {
+		headers = []string{"Repr-Digest"}
+	} else if ce := resp.Header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		headers = []string{"Content-Digest"}
+	}
+	var digests []responseDigest
+	for _, header := range headers {
+		// Structured field dictionary: sha-256=:base64:, sha-512=:base64:
+		for _, member := range strings.Split(strings.Join(resp.Header.Values(header), ","), ",") {
+			alg, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+			alg = strings.ToLower(alg)
+			if _, known := digestAlgorithms[alg]; !ok || !known {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				continue
+			}
+			if sum, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1]); err == nil {
+				digests = append(digests, responseDigest{header, alg, sum})
+			}
+		}
+	}
+	if resp.Uncompressed {
+		return digests
+	}
+	// RFC 3230: SHA-256=base64
+	for _, member := range strings.Split(strings.Join(resp.Header.Values("Digest"), ","), ",") {
+		alg, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		alg = strings.ToLower(alg)
+		if _, known := digestAlgorithms[alg]; !ok || !known {
+			continue
+		}
+		if sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value)); err == nil {
+			digests = append(digests, responseDigest{"Digest", alg, sum})
+		}
+	}
+	return digests
+}
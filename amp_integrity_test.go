@@ -0,0 +1,193 @@
+package kindling
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	t.Parallel()
+	const body = "relayed through the cache"
+	sha256sum := sha256.Sum256([]byte(body))
+	sha512sum := sha512.Sum512([]byte(body))
+	good256 := base64.StdEncoding.EncodeToString(sha256sum[:])
+	good512 := base64.StdEncoding.EncodeToString(sha512sum[:])
+	bad := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	for name, tc := range map[string]struct {
+		header        http.Header
+		contentLength int64
+		method        string
+		uncompressed  bool
+		wantErr       bool
+	}{
+		"NothingToCheck":            {contentLength: -1},
+		"ContentLength":             {contentLength: int64(len(body))},
+		"ShortContentLength":        {contentLength: int64(len(body)) - 1, wantErr: true},
+		"LongContentLength":         {contentLength: int64(len(body)) + 1, wantErr: true},
+		"ContentDigest":             {contentLength: -1, header: http.Header{"Content-Digest": {"sha-256=:" + good256 + ":"}}},
+		"ContentDigestBad":          {contentLength: -1, header: http.Header{"Content-Digest": {"sha-256=:" + bad + ":"}}, wantErr: true},
+		"ReprDigest":                {contentLength: -1, header: http.Header{"Repr-Digest": {"sha-512=:" + good512 + ":, sha-256=:" + good256 + ":"}}},
+		"ReprDigestOneBad":          {contentLength: -1, header: http.Header{"Repr-Digest": {"sha-512=:" + good512 + ":, sha-256=:" + bad + ":"}}, wantErr: true},
+		"LegacyDigest":              {contentLength: -1, header: http.Header{"Digest": {"SHA-256=" + good256}}},
+		"LegacyDigestBad":           {contentLength: -1, header: http.Header{"Digest": {"SHA-256=" + bad}}, wantErr: true},
+		"UnknownAlgorithm":          {contentLength: -1, header: http.Header{"Content-Digest": {"md5=:" + bad + ":"}}},
+		"Head":                      {contentLength: 1 << 20, method: http.MethodHead},
+		"DecompressedDigest":        {contentLength: -1, header: http.Header{"Content-Digest": {"sha-256=:" + bad + ":"}}, uncompressed: true},
+		"DigestAndLengthGood":       {contentLength: int64(len(body)), header: http.Header{"Content-Digest": {"sha-256=:" + good256 + ":"}}},
+		"EncodedReprDigest":         {contentLength: -1, header: http.Header{"Content-Encoding": {"gzip"}, "Content-Digest": {"sha-256=:" + good256 + ":"}, "Repr-Digest": {"sha-256=:" + bad + ":"}}},
+		"EncodedContentDigestBad":   {contentLength: -1, header: http.Header{"Content-Encoding": {"gzip"}, "Content-Digest": {"sha-256=:" + bad + ":"}}, wantErr: true},
+		"DecompressedReprDigest":    {contentLength: -1, header: http.Header{"Repr-Digest": {"sha-256=:" + good256 + ":"}}, uncompressed: true},
+		"DecompressedReprDigestBad": {contentLength: -1, header: http.Header{"Repr-Digest": {"sha-256=:" + bad + ":"}}, uncompressed: true, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        tc.header,
+				Body:          io.NopCloser(strings.NewReader(body)),
+				ContentLength: tc.contentLength,
+				Uncompressed:  tc.uncompressed,
+			}
+			if resp.Header == nil {
+				resp.Header = make(http.Header)
+			}
+			req := httptest.NewRequest(cmp.Or(tc.method, http.MethodGet), "http://example.com", nil)
+			err := checkIntegrity(req, resp)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrIntegrity)
+				return
+			}
+			require.NoError(t, err)
+			if tc.method == http.MethodHead {
+				return
+			}
+			got, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(got))
+		})
+	}
+}
+
+func TestCheckIntegrityStreaming(t *testing.T) {
+	t.Parallel()
+	body := strings.Repeat("x", 3*integrityPrefetchBytes)
+	sum := sha256.Sum256([]byte(body))
+	good := base64.StdEncoding.EncodeToString(sum[:])
+	bad := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	check := func(t *testing.T, digest string, contentLength int64) (string, error) {
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Digest": {"sha-256=:" + digest + ":"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: contentLength,
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, checkIntegrity(req, resp), "large bodies are checked as they're read")
+		got, err := io.ReadAll(resp.Body)
+		return string(got), err
+	}
+
+	t.Run("Good", func(t *testing.T) {
+		t.Parallel()
+		got, err := check(t, good, int64(len(body)))
+		require.NoError(t, err)
+		assert.Equal(t, body, got)
+	})
+
+	t.Run("DigestMismatch", func(t *testing.T) {
+		t.Parallel()
+		_, err := check(t, bad, -1)
+		assert.ErrorIs(t, err, ErrIntegrity)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		t.Parallel()
+		_, err := check(t, good, int64(len(body))+1)
+		assert.ErrorIs(t, err, ErrIntegrity)
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		t.Parallel()
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        make(http.Header),
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: integrityPrefetchBytes,
+		}
+		err := checkIntegrity(httptest.NewRequest(http.MethodGet, "http://example.com", nil), resp)
+		assert.ErrorIs(t, err, ErrIntegrity)
+	})
+}
+
+// fakeAMP is an amp.Client whose cache rewrites the body but keeps the
+// origin's digest.
+type fakeAMP struct {
+	calls atomic.Int32
+}
+
+func (f *fakeAMP) RoundTripper() (http.RoundTripper, error) {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		f.calls.Add(1)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(strings.NewReader("<amp>transformed</amp>"))
+		resp.ContentLength = -1
+		return resp, nil
+	}), nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestWithAMPCache_RetriesOnIntegrityFailure(t *testing.T) {
+	t.Parallel()
+	const body = "config"
+	sum := sha256.Sum256([]byte(body))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	amp := &fakeAMP{}
+	k, err := NewKindling("test",
+		WithAMPCache(amp),
+		WithStreamDialerTransport("direct", &transport.TCPDialer{}),
+		WithStrategy(Sequential),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+
+	resp, err := k.NewHTTPClient().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+	assert.EqualValues(t, 1, amp.calls.Load(), "amp not tried first")
+
+	rt, err := amp.RoundTripper()
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	_, err = (&integrityRoundTripper{next: rt}).RoundTrip(req)
+	assert.ErrorIs(t, err, ErrIntegrity)
+}
@@ -0,0 +1,88 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// AuthProvider returns the headers that authenticate a request, such as an
+// Authorization bearer token.
+type AuthProvider func(ctx context.Context) (http.Header, error)
+
+// WithAuthProvider has every request carry the headers provider returns,
+// which override any the request already has, so control-plane calls are
+// authenticated whichever transport wins. provider is called before each
+// request, and again when the server answers 401 Unauthorized, in which
+// case the request is sent once more with the new headers; AuthRejected
+// tells the two calls apart so provider knows when to refresh its token. A
+// request whose body can't be replayed, because it has no GetBody, isn't
+// resent: its 401 is returned as is.
+func WithAuthProvider(provider AuthProvider) Option {
+	return func(k *kindling) error {
+		if provider == nil {
+			return errors.New("auth provider must not be nil")
+		}
+		k.auth = provider
+		return nil
+	}
+}
+
+type authRejectedKey struct{}
+
+// AuthRejected reports whether an AuthProvider is being called because the
+// server rejected the headers it returned last, meaning they should be
+// refreshed rather than reused.
+func AuthRejected(ctx context.Context) bool {
+	rejected, _ := ctx.Value(authRejectedKey{}).(bool)
+	return rejected
+}
+
+// authorized sends req with the auth provider's headers, asking for fresh
+// ones and sending it again if the server rejects them.
+func (t *raceTransport) authorized(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	authReq, err := t.authorize(req.Context(), req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.dispatch(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !replayable {
+		return resp, err
+	}
+	resp.Body.Close()
+	t.logFor(req.Context()).Debug("Server rejected auth headers, refreshing", "host", req.URL.Host)
+
+	retry, err := t.authorize(context.WithValue(req.Context(), authRejectedKey{}, true), req)
+	if err == nil && req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+	}
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.dispatch(retry)
+}
+
+// authorize returns a copy of req carrying the headers the auth provider
+// returns when called with ctx.
+func (t *raceTransport) authorize(ctx context.Context, req *http.Request) (*http.Request, error) {
+	h, err := t.auth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting auth headers: %w", err)
+	}
+	r := req.Clone(req.Context())
+	for k, vs := range h {
+		r.Header.Del(k)
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	return r, nil
+}
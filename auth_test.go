@@ -0,0 +1,89 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAuthProvider(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithAuthProvider(nil)(&kindling{}))
+
+	// The origin accepts only "Bearer fresh" and echoes the request body.
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(origin.Close)
+	newClient := func(t *testing.T, provider AuthProvider) *http.Client {
+		direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		}}
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithTransport(direct), WithAuthProvider(provider))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		return k.NewHTTPClient()
+	}
+	// refreshing hands out a stale token until asked to refresh.
+	refreshing := func(calls *atomic.Int32) AuthProvider {
+		return func(ctx context.Context) (http.Header, error) {
+			calls.Add(1)
+			token := "Bearer stale"
+			if AuthRejected(ctx) {
+				token = "Bearer fresh"
+			}
+			return http.Header{"Authorization": {token}}, nil
+		}
+	}
+
+	t.Run("RefreshesOn401", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		client := newClient(t, refreshing(&calls))
+		req, _ := http.NewRequest(http.MethodPost, origin.URL, strings.NewReader("payload"))
+		req.Header.Set("Authorization", "Bearer caller")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "payload", string(body), "the body is replayed")
+		assert.EqualValues(t, 2, calls.Load())
+		assert.Equal(t, "Bearer caller", req.Header.Get("Authorization"), "the caller's request is left alone")
+	})
+
+	t.Run("UnreplayableBody", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		client := newClient(t, refreshing(&calls))
+		req, _ := http.NewRequest(http.MethodPost, origin.URL, io.NopCloser(strings.NewReader("payload")))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("ProviderError", func(t *testing.T) {
+		t.Parallel()
+		errNoToken := errors.New("no token")
+		client := newClient(t, func(ctx context.Context) (http.Header, error) {
+			return nil, errNoToken
+		})
+		_, err := client.Get(origin.URL)
+		assert.ErrorIs(t, err, errNoToken)
+	})
+}
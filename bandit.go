@@ -0,0 +1,111 @@
+package kindling
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// banditHalfLife is how long it takes an arm's past outcomes to lose
+	// half their weight, so the bandit follows a network that changes
+	// under it, and a transport that failed a while ago is tried again.
+	banditHalfLife = 10 * time.Minute
+	// banditLatencyRef is the latency that halves a success's reward.
+	banditLatencyRef = time.Second
+)
+
+// bandit orders transports for the Adaptive strategy, treating each as an
+// arm of a multi-armed bandit. A success earns a reward that shrinks with
+// latency, 1/(1+latency/banditLatencyRef), and a failure earns nothing.
+// Arms are ordered by UCB1 score: the mean reward plus a bonus that is
+// larger for arms tried less often, so the best performer gets most of the
+// traffic while the others are still tried now and then. Arms never tried
+// come first, in configured order.
+type bandit struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	arms map[string]*banditArm
+}
+
+// banditArm holds an arm's number of tries and total reward, both decayed
+// to the time at.
+type banditArm struct {
+	tries  float64
+	reward float64
+	at     time.Time
+}
+
+// decay ages the arm's outcomes to now.
+func (a *banditArm) decay(now time.Time) {
+	f := math.Exp2(-float64(now.Sub(a.at)) / float64(banditHalfLife))
+	a.tries *= f
+	a.reward *= f
+	a.at = now
+}
+
+func newBandit() *bandit {
+	return &bandit{now: time.Now, arms: make(map[string]*banditArm)}
+}
+
+// order returns transports by descending score. A nil bandit keeps their
+// order.
+func (b *bandit) order(transports []Transport) []Transport {
+	if b == nil {
+		return transports
+	}
+	b.mu.Lock()
+	now := b.now()
+	var total float64
+	for _, tr := range transports {
+		if a := b.arms[tr.Name()]; a != nil {
+			a.decay(now)
+			total += a.tries
+		}
+	}
+	scores := make(map[string]float64, len(transports))
+	for _, tr := range transports {
+		a := b.arms[tr.Name()]
+		if a == nil || a.tries == 0 {
+			scores[tr.Name()] = math.Inf(1)
+			continue
+		}
+		bonus := math.Sqrt(2 * math.Log1p(total) / a.tries)
+		scores[tr.Name()] = a.reward/a.tries + bonus
+	}
+	b.mu.Unlock()
+
+	ordered := slices.Clone(transports)
+	slices.SortStableFunc(ordered, func(x, y Transport) int {
+		sx, sy := scores[x.Name()], scores[y.Name()]
+		switch {
+		case sx > sy:
+			return -1
+		case sx < sy:
+			return 1
+		}
+		return 0
+	})
+	return ordered
+}
+
+// observe records the outcome of trying the named transport.
+func (b *bandit) observe(name string, ok bool, latency time.Duration) {
+	var reward float64
+	if ok {
+		reward = 1 / (1 + float64(latency)/float64(banditLatencyRef))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	a := b.arms[name]
+	if a == nil {
+		a = &banditArm{at: now}
+		b.arms[name] = a
+	}
+	a.decay(now)
+	a.tries++
+	a.reward += reward
+}
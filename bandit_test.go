@@ -0,0 +1,92 @@
+package kindling
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandit(t *testing.T) {
+	t.Parallel()
+	arms := []Transport{bareTransport{"a"}, bareTransport{"b"}, bareTransport{"c"}}
+
+	newTestBandit := func() (*bandit, *time.Time) {
+		now := time.Now()
+		b := newBandit()
+		b.now = func() time.Time { return now }
+		return b, &now
+	}
+
+	t.Run("UntriedFirst", func(t *testing.T) {
+		t.Parallel()
+		b, _ := newTestBandit()
+		b.observe("a", true, 10*time.Millisecond)
+		assert.Equal(t, []string{"b", "c", "a"}, names(b.order(arms)))
+	})
+
+	t.Run("PrefersSuccessThenSpeed", func(t *testing.T) {
+		t.Parallel()
+		b, _ := newTestBandit()
+		for range 20 {
+			b.observe("a", false, 0)
+			b.observe("b", true, 3*time.Second)
+			b.observe("c", true, 100*time.Millisecond)
+		}
+		assert.Equal(t, []string{"c", "b", "a"}, names(b.order(arms)))
+	})
+
+	t.Run("ReexploresOverTime", func(t *testing.T) {
+		t.Parallel()
+		b, now := newTestBandit()
+		two := arms[:2]
+		for range 20 {
+			b.observe("a", false, 0)
+		}
+		for minute := 1; minute <= 60; minute++ {
+			*now = now.Add(time.Minute)
+			b.observe("b", true, 100*time.Millisecond)
+			if minute == 5 {
+				assert.Equal(t, []string{"b", "a"}, names(b.order(two)), "failing arm tried first too soon")
+			}
+		}
+		assert.Equal(t, []string{"a", "b"}, names(b.order(two)), "failing arm never re-explored")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		var b *bandit
+		assert.Equal(t, []string{"a", "b", "c"}, names(b.order(arms)))
+	})
+}
+
+func TestStrategy_Adaptive(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	k := &kindling{}
+	require.NoError(t, WithStrategy(Adaptive)(k))
+	require.NotNil(t, k.bandit)
+
+	rec := &dialRecorder{}
+	rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+		rec.transport("blocked", nil, errors.New("blocked")),
+		rec.transport("direct", server.Client().Transport, nil),
+	})
+	rt.strategy = k.strategy
+	rt.bandit = k.bandit
+
+	for range 2 {
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	// The first request explores both in order; the second goes straight
+	// to the one that worked.
+	assert.Equal(t, []string{"blocked", "direct", "direct"}, rec.order())
+}
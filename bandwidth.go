@@ -0,0 +1,74 @@
+package kindling
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// withByteCounting wraps rt so the bytes it carries are added to tr's
+// totals in TransportInfo.
+func (t *raceTransport) withByteCounting(tr Transport, rt http.RoundTripper) http.RoundTripper {
+	counts := t.stats.counts(tr.Name())
+	if counts == nil {
+		return rt
+	}
+	return &countingRoundTripper{rt: rt, counts: counts}
+}
+
+// countingRoundTripper counts the headers of each request and response as
+// HTTP/1.1 would send them, and their bodies as they're read.
+type countingRoundTripper struct {
+	rt     http.RoundTripper
+	counts *byteCounts
+}
+
+// RoundTrip counts the request's headers as it's sent and the response's
+// as it arrives, and wraps both bodies to count them as they're read.
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.counts.sent.Add(int64(len(req.Method)+len(" ")+len(req.URL.RequestURI())+len(" HTTP/1.1\r\n")) + headerSize(req.Header))
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingBody{ReadCloser: req.Body, n: &c.counts.sent}
+	}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil || body == http.NoBody {
+				return body, err
+			}
+			return &countingBody{ReadCloser: body, n: &c.counts.sent}, nil
+		}
+	}
+	resp, err := c.rt.RoundTrip(req)
+	if resp != nil {
+		c.counts.received.Add(int64(len(resp.Proto)+len(resp.Status)+len(" \r\n")) + headerSize(resp.Header))
+		if resp.Body != nil {
+			resp.Body = &countingBody{ReadCloser: resp.Body, n: &c.counts.received}
+		}
+	}
+	return resp, err
+}
+
+// headerSize is the length of h in HTTP/1.1 form, including the blank line
+// that ends it.
+func headerSize(h http.Header) int64 {
+	n := len("\r\n")
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(": \r\n") + len(v)
+		}
+	}
+	return int64(n)
+}
+
+// countingBody adds the bytes read from it to n.
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,85 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	counts := &byteCounts{}
+	rt := &countingRoundTripper{counts: counts, rt: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(body))
+		return &http.Response{
+			Proto:  "HTTP/1.1",
+			Status: "200 OK",
+			Header: http.Header{"A": {"b"}},
+			Body:   io.NopCloser(strings.NewReader("pong!")),
+		}, nil
+	})}
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/p", strings.NewReader("ping"))
+	require.NoError(t, err)
+	req.Header = http.Header{"X": {"y"}}
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// "POST /p HTTP/1.1\r\n" "X: y\r\n" "\r\n" "ping"
+	assert.Equal(t, int64(18+6+2+4), counts.sent.Load())
+	// "HTTP/1.1 200 OK\r\n" "A: b\r\n" "\r\n" "pong!"
+	assert.Equal(t, int64(17+6+2+5), counts.received.Load())
+}
+
+func TestBandwidthAccounting(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	t.Cleanup(origin.Close)
+
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+	broken := &mockTransport{
+		name: "broken",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return nil, errors.New("blocked")
+		},
+	}
+	k, err := NewKindling("test", WithTransport(direct), WithTransport(broken))
+	require.NoError(t, err)
+	resp, err := k.NewHTTPClient().Post(origin.URL, "text/plain", strings.NewReader(strings.Repeat("y", 500)))
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	infos := map[string]TransportInfo{}
+	for _, info := range k.Transports() {
+		infos[info.Name] = info
+	}
+	assert.Greater(t, infos["direct"].BytesSent, int64(500))
+	assert.Less(t, infos["direct"].BytesSent, int64(1000))
+	assert.Greater(t, infos["direct"].BytesReceived, int64(1000))
+	assert.Less(t, infos["direct"].BytesReceived, int64(1500))
+	assert.Zero(t, infos["broken"].BytesSent)
+	assert.Zero(t, infos["broken"].BytesReceived)
+}
@@ -0,0 +1,301 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request chunking lets transports with a MaxLength (AMP caps bodies at 6000
+// bytes) carry larger bodies by splitting them into framed sub-requests that
+// the origin reassembles with NewChunkReassembler.
+//
+// Framing: every sub-request repeats the original method, URL, and headers,
+// with a slice of the body and these headers added:
+//
+//	X-Kindling-Chunk-Id:     random hex id shared by all chunks of one request
+//	X-Kindling-Chunk:        "<index>/<count>", index counting from 0
+//	X-Kindling-Chunk-Length: length of the whole reassembled body in bytes
+//
+// Chunks are sent in order, one at a time. The server answers each chunk but
+// the last with 202 Accepted and an empty body; any other response aborts the
+// upload and is returned to the caller as is. Once the last chunk arrives the
+// server hands the reassembled request, without the chunk headers, to the
+// application, and its response is the response to the whole request.
+const (
+	ChunkIDHeader     = "X-Kindling-Chunk-Id"
+	ChunkHeader       = "X-Kindling-Chunk"
+	ChunkLengthHeader = "X-Kindling-Chunk-Length"
+)
+
+const (
+	// maxChunkCount bounds how many sub-requests one body may be split into.
+	maxChunkCount = 1024
+	// maxReassembledBytes bounds the memory one pending upload may pin on
+	// the server. It's as much as maxChunkCount AMP-sized chunks carry, so
+	// the limits agree for the transport chunking exists for; transports
+	// with a larger MaxLength hit it before they run out of chunks.
+	maxReassembledBytes = maxChunkCount * ampMaxLength
+	// maxPendingChunkSets and maxPendingChunkBytes bound the incomplete
+	// uploads the server holds at once, counting each by its declared
+	// length. New uploads are refused while they're exceeded.
+	maxPendingChunkSets  = 64
+	maxPendingChunkBytes = 64 << 20
+	// chunkSetTTL is how long the server keeps an incomplete upload.
+	chunkSetTTL = 2 * time.Minute
+)
+
+// WithRequestChunking lets bodies larger than a transport's MaxLength be sent
+// on it in chunks instead of skipping the transport. Only enable it for
+// origins wrapped in NewChunkReassembler: an origin that doesn't understand
+// the framing would treat each chunk as a complete request.
+func WithRequestChunking() Option {
+	return func(k *kindling) error {
+		k.chunking = true
+		return nil
+	}
+}
+
+// chunkedTransport lifts a Transport's MaxLength by chunking bodies that
+// exceed it. It keeps the wrapped transport's name so domain policies, the
+// circuit breaker, and logs treat it as the same transport.
+type chunkedTransport struct {
+	Transport
+}
+
+func (c *chunkedTransport) MaxLength() int { return 0 }
+func (c *chunkedTransport) Priority() int  { return priorityOf(c.Transport) }
+func (c *chunkedTransport) Reusable() bool { return reusable(c.Transport) }
+
+func (c *chunkedTransport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
+	rt, err := c.Transport.NewRoundTripper(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkingRoundTripper{rt: rt, chunkSize: int64(c.Transport.MaxLength())}, nil
+}
+
+// chunkingRoundTripper sends bodies longer than chunkSize as a sequence of
+// framed sub-requests over rt.
+type chunkingRoundTripper struct {
+	rt        http.RoundTripper
+	chunkSize int64
+}
+
+func (c *chunkingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.ContentLength <= c.chunkSize {
+		return c.rt.RoundTrip(req)
+	}
+	defer req.Body.Close()
+	if req.ContentLength > maxReassembledBytes {
+		return nil, fmt.Errorf("body of %d bytes is too large to chunk, more than %d", req.ContentLength, maxReassembledBytes)
+	}
+	count := (req.ContentLength + c.chunkSize - 1) / c.chunkSize
+	if count > maxChunkCount {
+		return nil, fmt.Errorf("body of %d bytes needs %d chunks, more than %d", req.ContentLength, count, maxChunkCount)
+	}
+	id, err := newChunkID()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, c.chunkSize)
+	for i := int64(0); i < count; i++ {
+		n, err := io.ReadFull(req.Body, buf)
+		if err != nil && !(err == io.ErrUnexpectedEOF && i == count-1) {
+			return nil, fmt.Errorf("reading chunk %d: %w", i, err)
+		}
+		chunk := buf[:n]
+		sub := req.Clone(req.Context())
+		sub.Header.Set(ChunkIDHeader, id)
+		sub.Header.Set(ChunkHeader, fmt.Sprintf("%d/%d", i, count))
+		sub.Header.Set(ChunkLengthHeader, strconv.FormatInt(req.ContentLength, 10))
+		sub.Body = io.NopCloser(bytes.NewReader(chunk))
+		sub.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(chunk)), nil }
+		sub.ContentLength = int64(n)
+
+		resp, err := c.rt.RoundTrip(sub)
+		if err != nil {
+			return nil, fmt.Errorf("sending chunk %d/%d: %w", i, count, err)
+		}
+		if i == count-1 || resp.StatusCode != http.StatusAccepted {
+			return resp, nil
+		}
+		drainAndClose(resp)
+	}
+	return nil, fmt.Errorf("no chunks sent")
+}
+
+func newChunkID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generating chunk id: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// NewChunkReassembler returns a handler that reassembles requests chunked by
+// WithRequestChunking before passing them to next. Requests without chunk
+// headers go straight to next. Incomplete uploads are dropped after a few
+// minutes, and while too many are pending, chunks starting new ones are
+// answered 503 Service Unavailable.
+func NewChunkReassembler(next http.Handler) http.Handler {
+	return &chunkReassembler{
+		next:     next,
+		sets:     make(map[string]*chunkSet),
+		now:      time.Now,
+		maxSets:  maxPendingChunkSets,
+		maxBytes: maxPendingChunkBytes,
+	}
+}
+
+// errTooManyChunkSets refuses a new upload while pending ones fill the
+// reassembler.
+var errTooManyChunkSets = errors.New("too many chunked uploads pending")
+
+type chunkReassembler struct {
+	next     http.Handler
+	now      func() time.Time
+	maxSets  int
+	maxBytes int64
+
+	mu   sync.Mutex
+	sets map[string]*chunkSet
+	// pending is the sum of the declared lengths of sets.
+	pending int64
+}
+
+// chunkSet is one upload being reassembled.
+type chunkSet struct {
+	parts    [][]byte
+	received int
+	length   int64
+	size     int64
+	created  time.Time
+}
+
+func (c *chunkReassembler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(ChunkIDHeader)
+	if id == "" {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	index, count, length, err := parseChunkHeaders(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	part, err := io.ReadAll(io.LimitReader(r.Body, length+1))
+	if err != nil {
+		http.Error(w, "reading chunk", http.StatusBadRequest)
+		return
+	}
+
+	body, err := c.add(id, index, count, length, part)
+	if errors.Is(err, errTooManyChunkSets) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	full := r.Clone(r.Context())
+	full.Header.Del(ChunkIDHeader)
+	full.Header.Del(ChunkHeader)
+	full.Header.Del(ChunkLengthHeader)
+	full.Body = io.NopCloser(bytes.NewReader(body))
+	full.ContentLength = int64(len(body))
+	c.next.ServeHTTP(w, full)
+}
+
+// add records one chunk and returns the reassembled body once the set is
+// complete, or nil while chunks are still missing.
+func (c *chunkReassembler) add(id string, index, count int, length int64, part []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for k, set := range c.sets {
+		if now.Sub(set.created) > chunkSetTTL {
+			c.drop(k)
+		}
+	}
+
+	set, ok := c.sets[id]
+	if !ok {
+		if len(c.sets) >= c.maxSets || c.pending+length > c.maxBytes {
+			return nil, errTooManyChunkSets
+		}
+		set = &chunkSet{parts: make([][]byte, count), length: length, created: now}
+		c.sets[id] = set
+		c.pending += length
+	}
+	if len(set.parts) != count || set.length != length {
+		c.drop(id)
+		return nil, fmt.Errorf("chunk %d/%d does not match earlier chunks", index, count)
+	}
+	if set.parts[index] == nil {
+		set.received++
+		set.size += int64(len(part))
+	}
+	set.parts[index] = part
+	if set.size > set.length {
+		c.drop(id)
+		return nil, fmt.Errorf("chunks exceed declared length %d", set.length)
+	}
+	if set.received < count {
+		return nil, nil
+	}
+	c.drop(id)
+	body := bytes.Join(set.parts, nil)
+	if int64(len(body)) != set.length {
+		return nil, fmt.Errorf("reassembled %d bytes, expected %d", len(body), set.length)
+	}
+	return body, nil
+}
+
+// drop forgets the upload with id. c.mu must be held.
+func (c *chunkReassembler) drop(id string) {
+	if set, ok := c.sets[id]; ok {
+		c.pending -= set.length
+		delete(c.sets, id)
+	}
+}
+
+func parseChunkHeaders(h http.Header) (index, count int, length int64, err error) {
+	i, n, ok := strings.Cut(h.Get(ChunkHeader), "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed %s header", ChunkHeader)
+	}
+	if index, err = strconv.Atoi(i); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed chunk index: %w", err)
+	}
+	if count, err = strconv.Atoi(n); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed chunk count: %w", err)
+	}
+	if count < 1 || count > maxChunkCount || index < 0 || index >= count {
+		return 0, 0, 0, fmt.Errorf("chunk %d/%d out of range", index, count)
+	}
+	if length, err = strconv.ParseInt(h.Get(ChunkLengthHeader), 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed %s header: %w", ChunkLengthHeader, err)
+	}
+	if length < 1 || length > maxReassembledBytes {
+		return 0, 0, 0, fmt.Errorf("chunked body length %d out of range", length)
+	}
+	return index, count, length, nil
+}
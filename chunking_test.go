@@ -0,0 +1,202 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestChunking(t *testing.T) {
+	t.Parallel()
+
+	// newOrigin serves handler behind a chunk reassembler and counts the
+	// sub-requests that reach it.
+	newOrigin := func(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+		var hits atomic.Int32
+		reassembler := NewChunkReassembler(handler)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			reassembler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		return srv, &hits
+	}
+	limited := func(target string) Transport {
+		return &mockTransport{name: "amp", maxLength: 100, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return &urlRewritingTransport{target: target}, nil
+		}}
+	}
+	post := func(t *testing.T, rt http.RoundTripper, body []byte) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, "http://example.com/upload", opaqueReader{bytes.NewReader(body)})
+		require.NoError(t, err)
+		return rt.RoundTrip(req)
+	}
+	content := bytes.Repeat([]byte("0123456789"), 105)
+
+	t.Run("ReassemblesOversizedBody", func(t *testing.T) {
+		t.Parallel()
+		srv, hits := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get(ChunkHeader), "chunk headers must be stripped")
+			assert.Equal(t, "/upload", r.URL.Path)
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			w.Write(body)
+		})
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{limited(srv.URL)})
+		rt.chunking = true
+
+		resp, err := post(t, rt, content)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content, got)
+		assert.Equal(t, int32(11), hits.Load(), "1050 bytes in 100-byte chunks")
+	})
+
+	t.Run("SmallBodyNotChunked", func(t *testing.T) {
+		t.Parallel()
+		srv, hits := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {})
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{limited(srv.URL)})
+		rt.chunking = true
+
+		resp, err := post(t, rt, content[:50])
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("DisabledSkipsTransport", func(t *testing.T) {
+		t.Parallel()
+		srv, hits := newOrigin(t, func(w http.ResponseWriter, r *http.Request) {})
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{limited(srv.URL)})
+
+		_, err := post(t, rt, content)
+		assert.ErrorContains(t, err, "no eligible transports")
+		assert.Zero(t, hits.Load())
+	})
+
+	t.Run("RejectedChunkAbortsUpload", func(t *testing.T) {
+		t.Parallel()
+		var hits atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}))
+		t.Cleanup(srv.Close)
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{limited(srv.URL)})
+		rt.chunking = true
+
+		resp, err := post(t, rt, content)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("TooLargeToReassemble", func(t *testing.T) {
+		t.Parallel()
+		c := &chunkingRoundTripper{rt: http.DefaultTransport, chunkSize: 1 << 20}
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/upload", bytes.NewReader(nil))
+		req.ContentLength = maxReassembledBytes + 1
+		_, err := c.RoundTrip(req)
+		assert.ErrorContains(t, err, "too large to chunk", "the reassembler would refuse it")
+	})
+
+	t.Run("WithRequestChunking", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithRequestChunking())
+		require.NoError(t, err)
+		assert.True(t, k.(*kindling).newRaceTransport(nil).chunking)
+	})
+}
+
+func TestChunkReassembler(t *testing.T) {
+	t.Parallel()
+
+	var got []byte
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func(h http.Handler, id, chunk string, length int, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(body)))
+		if id != "" {
+			r.Header.Set(ChunkIDHeader, id)
+			r.Header.Set(ChunkHeader, chunk)
+			r.Header.Set(ChunkLengthHeader, strconv.Itoa(length))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	t.Run("PassesThroughUnchunked", func(t *testing.T) {
+		h := NewChunkReassembler(next)
+		assert.Equal(t, http.StatusCreated, send(h, "", "", 0, "plain"))
+		assert.Equal(t, "plain", string(got))
+	})
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		h := NewChunkReassembler(next)
+		assert.Equal(t, http.StatusAccepted, send(h, "a", "2/3", 9, "ghi"))
+		assert.Equal(t, http.StatusAccepted, send(h, "a", "0/3", 9, "abc"))
+		assert.Equal(t, http.StatusCreated, send(h, "a", "1/3", 9, "def"))
+		assert.Equal(t, "abcdefghi", string(got))
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		h := NewChunkReassembler(next)
+		assert.Equal(t, http.StatusBadRequest, send(h, "b", "3/3", 9, "abc"))
+		assert.Equal(t, http.StatusBadRequest, send(h, "b", "x", 9, "abc"))
+		assert.Equal(t, http.StatusBadRequest, send(h, "b", "0/2", maxReassembledBytes+1, "abc"))
+	})
+
+	t.Run("MismatchedChunks", func(t *testing.T) {
+		h := NewChunkReassembler(next)
+		assert.Equal(t, http.StatusAccepted, send(h, "c", "0/3", 9, "abc"))
+		assert.Equal(t, http.StatusBadRequest, send(h, "c", "1/2", 9, "def"))
+	})
+
+	t.Run("ExceedsDeclaredLength", func(t *testing.T) {
+		h := NewChunkReassembler(next)
+		assert.Equal(t, http.StatusAccepted, send(h, "d", "0/2", 4, "abc"))
+		assert.Equal(t, http.StatusBadRequest, send(h, "d", "1/2", 4, "def"))
+	})
+
+	t.Run("ExpiresIncompleteUploads", func(t *testing.T) {
+		h := NewChunkReassembler(next).(*chunkReassembler)
+		now := time.Now()
+		h.now = func() time.Time { return now }
+		assert.Equal(t, http.StatusAccepted, send(h, "e", "0/2", 6, "abc"))
+		now = now.Add(chunkSetTTL + time.Second)
+		assert.Equal(t, http.StatusAccepted, send(h, "e", "1/2", 6, "def"),
+			"the first chunk expired, so the upload starts over")
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		assert.Len(t, h.sets, 1)
+		assert.EqualValues(t, 6, h.pending)
+	})
+
+	t.Run("BoundsPendingUploads", func(t *testing.T) {
+		h := NewChunkReassembler(next).(*chunkReassembler)
+		h.maxSets = 2
+		h.maxBytes = 15
+		assert.Equal(t, http.StatusAccepted, send(h, "f", "0/2", 6, "abc"))
+		assert.Equal(t, http.StatusAccepted, send(h, "g", "0/2", 6, "abc"))
+		assert.Equal(t, http.StatusServiceUnavailable, send(h, "h", "0/2", 2, "a"), "too many sets")
+		assert.Equal(t, http.StatusCreated, send(h, "f", "1/2", 6, "def"), "pending uploads go on")
+		assert.Equal(t, http.StatusServiceUnavailable, send(h, "h", "0/2", 10, "abcde"), "too many bytes")
+		assert.Equal(t, http.StatusAccepted, send(h, "h", "0/2", 8, "abcd"), "completing an upload frees room")
+	})
+}
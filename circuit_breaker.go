@@ -0,0 +1,145 @@
+package kindling
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBreakerCooldown caps how long failed probes can stretch a tripped
+	// transport's cooldown.
+	maxBreakerCooldown = 10 * time.Minute
+	// defaultBreakerFailures and defaultBreakerCooldown configure the
+	// breaker WithHealthCheck turns on when no WithCircuitBreaker is given.
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// WithCircuitBreaker turns on the per-transport circuit breaker, which is
+// off by default. After failures consecutive connection or request
+// failures, a transport is left out of the race for cooldown, then let back
+// in for a single probe request. A failed probe doubles the cooldown (up to
+// 10 minutes); any success closes the breaker again. 5 failures and a 30
+// second cooldown suit most apps; failures <= 0 turns the breaker off.
+//
+// The breaker never empties the race: if every eligible transport is
+// tripped, they are all tried anyway.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(k *kindling) error {
+		k.breakerSet = true
+		if failures <= 0 {
+			k.breaker = nil
+			return nil
+		}
+		if cooldown <= 0 {
+			return fmt.Errorf("circuit breaker cooldown must be positive")
+		}
+		k.breaker = newCircuitBreaker(failures, cooldown)
+		return nil
+	}
+}
+
+// circuitBreaker tracks consecutive failures per transport name. It is
+// shared by every client an instance creates, so one request's failures
+// spare the next request the wasted dial.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+type breakerState struct {
+	failures int
+	// trips counts consecutive times the breaker opened, which sets the
+	// length of the current cooldown.
+	trips     int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		states:    make(map[string]*breakerState),
+	}
+}
+
+// allow reports whether the named transport may join a race. Once a tripped
+// transport's cooldown has passed, allow lets one caller through as a probe
+// and holds the rest off for another cooldown while it runs.
+func (b *circuitBreaker) allow(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.states[name]
+	if s == nil || s.failures < b.threshold {
+		return true
+	}
+	now := b.now()
+	if now.Before(s.openUntil) {
+		return false
+	}
+	s.openUntil = now.Add(b.currentCooldown(s))
+	return true
+}
+
+func (b *circuitBreaker) success(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.states, name)
+}
+
+// failure records a failure and reports whether it tripped the breaker.
+func (b *circuitBreaker) failure(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.states[name]
+	if s == nil {
+		s = &breakerState{}
+		b.states[name] = s
+	}
+	s.failures++
+	if s.failures < b.threshold {
+		return false
+	}
+	s.trips++
+	s.openUntil = b.now().Add(b.currentCooldown(s))
+	return true
+}
+
+// quarantinedUntil reports whether the named transport is tripped and
+// cooling down, and until when. A nil breaker quarantines nothing.
+func (b *circuitBreaker) quarantinedUntil(name string) (time.Time, bool) {
+	if b == nil {
+		return time.Time{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.states[name]
+	if s == nil || s.failures < b.threshold || !b.now().Before(s.openUntil) {
+		return time.Time{}, false
+	}
+	return s.openUntil, true
+}
+
+// reset closes every breaker. A nil breaker has nothing to reset.
+func (b *circuitBreaker) reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.states)
+}
+
+func (b *circuitBreaker) currentCooldown(s *breakerState) time.Duration {
+	d := b.cooldown
+	for i := 1; i < s.trips && d < maxBreakerCooldown; i++ {
+		d *= 2
+	}
+	return min(d, maxBreakerCooldown)
+}
@@ -0,0 +1,114 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.allow("x"))
+	assert.False(t, b.failure("x"))
+	assert.True(t, b.allow("x"), "below threshold")
+	assert.True(t, b.failure("x"), "second failure trips")
+	assert.False(t, b.allow("x"))
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow("x"), "probe after cooldown")
+	assert.False(t, b.allow("x"), "only one probe at a time")
+
+	// A failed probe doubles the cooldown.
+	b.failure("x")
+	now = now.Add(time.Minute)
+	assert.False(t, b.allow("x"))
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow("x"))
+
+	b.success("x")
+	assert.True(t, b.allow("x"))
+	assert.True(t, b.allow("x"))
+	assert.False(t, b.failure("x"), "success resets the count")
+
+	// The cooldown is capped.
+	s := &breakerState{trips: 30}
+	assert.Equal(t, maxBreakerCooldown, b.currentCooldown(s))
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(origin.Close)
+
+	var brokenCalls atomic.Int32
+	broken := &mockTransport{
+		name: "broken",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			brokenCalls.Add(1)
+			return nil, errors.New("blocked")
+		},
+	}
+	slowDirect := newSlowDirect()
+
+	t.Run("InvalidCooldown", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithCircuitBreaker(3, 0))
+		assert.Error(t, err)
+	})
+
+	t.Run("TrippedTransportSitsOut", func(t *testing.T) {
+		k, err := NewKindling("test",
+			WithTransport(broken),
+			WithTransport(slowDirect),
+			WithCircuitBreaker(2, time.Hour),
+		)
+		require.NoError(t, err)
+		for range 4 {
+			resp, err := k.NewHTTPClient().Get(origin.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.EqualValues(t, 2, brokenCalls.Load())
+	})
+
+	t.Run("AllTripped_StillTried", func(t *testing.T) {
+		var calls atomic.Int32
+		onlyBroken := &mockTransport{
+			name: "only",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				calls.Add(1)
+				return nil, errors.New("blocked")
+			},
+		}
+		k, err := NewKindling("test", WithTransport(onlyBroken), WithCircuitBreaker(1, time.Hour))
+		require.NoError(t, err)
+		for range 3 {
+			_, err := k.NewHTTPClient().Get(origin.URL)
+			assert.Error(t, err)
+		}
+		assert.EqualValues(t, 3, calls.Load())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		k, err := NewKindling("test", WithTransport(broken), WithCircuitBreaker(0, 0))
+		require.NoError(t, err)
+		assert.Nil(t, k.(*kindling).breaker)
+
+		k, err = NewKindling("test", WithTransport(broken))
+		require.NoError(t, err)
+		assert.Nil(t, k.(*kindling).breaker, "off unless asked for")
+	})
+}
@@ -0,0 +1,45 @@
+package kindling
+
+import (
+	"net/http"
+	"time"
+)
+
+// ClientOption configures a client returned by NewHTTPClientWith.
+type ClientOption func(*http.Client)
+
+// WithCheckRedirect sets the client's redirect policy, as
+// http.Client.CheckRedirect does. Without it, the client follows up to 10
+// redirects.
+func WithCheckRedirect(fn func(req *http.Request, via []*http.Request) error) ClientOption {
+	return func(c *http.Client) {
+		c.CheckRedirect = fn
+	}
+}
+
+// WithCookieJar stores and sends cookies with jar, such as one from
+// net/http/cookiejar. Without it, cookies are ignored.
+func WithCookieJar(jar http.CookieJar) ClientOption {
+	return func(c *http.Client) {
+		c.Jar = jar
+	}
+}
+
+// WithClientTimeout limits the whole of each request made with the client,
+// redirects and reading the response body included. Races still end at
+// their own time budget when that's shorter.
+func WithClientTimeout(d time.Duration) ClientOption {
+	return func(c *http.Client) {
+		c.Timeout = d
+	}
+}
+
+// NewHTTPClientWith is NewHTTPClient with client-level settings applied,
+// so callers don't have to change the returned client's fields.
+func (k *kindling) NewHTTPClientWith(opts ...ClientOption) *http.Client {
+	c := k.NewHTTPClient()
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
@@ -0,0 +1,76 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClientWith(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+			http.Redirect(w, r, "/home", http.StatusFound)
+		case "/home":
+			if c, err := r.Cookie("session"); err == nil {
+				w.Write([]byte(c.Value))
+			}
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(origin.Close)
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+	k, err := NewKindling("test", WithTransport(direct))
+	require.NoError(t, err)
+
+	t.Run("CookieJar", func(t *testing.T) {
+		t.Parallel()
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		client := k.NewHTTPClientWith(WithCookieJar(jar))
+		resp, err := client.Get(origin.URL + "/login")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "s1", string(body))
+	})
+
+	t.Run("CheckRedirect", func(t *testing.T) {
+		t.Parallel()
+		client := k.NewHTTPClientWith(WithCheckRedirect(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}))
+		resp, err := client.Get(origin.URL + "/login")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+		client := k.NewHTTPClientWith(WithClientTimeout(50 * time.Millisecond))
+		_, err := client.Get(origin.URL + "/slow")
+		var netErr interface{ Timeout() bool }
+		require.True(t, errors.As(err, &netErr), "error %v", err)
+		assert.True(t, netErr.Timeout())
+	})
+}
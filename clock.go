@@ -0,0 +1,112 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Clock is where kindling tells time for its request deadlines, connect
+// timeouts, head starts, retry backoff, health checks, and circuit breaker
+// cooldowns. The default is the system clock; tests can swap in a fake one,
+// such as kindlingtest.Clock, with WithClock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a Timer that fires once d has passed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a Clock's counterpart to time.Timer.
+type Timer interface {
+	// C delivers the time once the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, reporting whether it was still
+	// pending.
+	Stop() bool
+}
+
+// WithClock makes kindling tell time with c, so tests can step through
+// timeouts and staggers without real sleeps.
+func WithClock(c Clock) Option {
+	return func(k *kindling) error {
+		if c == nil {
+			return fmt.Errorf("clock is nil")
+		}
+		k.clock = c
+		return nil
+	}
+}
+
+// systemClock is the real Clock.
+var systemClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// contextWithTimeout is context.WithTimeout on clock's time.
+func contextWithTimeout(parent context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	return contextWithDeadline(parent, clock, clock.Now().Add(d))
+}
+
+// contextWithDeadline is context.WithDeadline on clock's time. With the
+// system clock it is context.WithDeadline itself.
+func contextWithDeadline(parent context.Context, clock Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithDeadline(parent, deadline)
+	}
+	if d, ok := parent.Deadline(); ok && !deadline.Before(d) {
+		return context.WithCancel(parent)
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := clock.NewTimer(deadline.Sub(clock.Now()))
+	go func() {
+		select {
+		case <-timer.C():
+			cancel(context.DeadlineExceeded)
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return &deadlineContext{Context: ctx, deadline: deadline}, func() { cancel(context.Canceled) }
+}
+
+// deadlineContext reports the deadline of a context canceled by a Clock's
+// timer, and DeadlineExceeded once the timer has fired.
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func (c *deadlineContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// sleepContext waits for d on clock or until ctx is done, reporting whether
+// the full wait elapsed.
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+package kindling_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/getlantern/kindling"
+	"github.com/getlantern/kindling/kindlingtest"
+)
+
+func TestWithClock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newKindling := func(t *testing.T, clock *kindlingtest.Clock, opts ...kindling.Option) kindling.Kindling {
+		t.Helper()
+		opts = append([]kindling.Option{kindling.WithLogWriter(io.Discard), kindling.WithClock(clock)}, opts...)
+		k, err := kindling.NewKindling("test", opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		return k
+	}
+	waitForTimers := func(t *testing.T, clock *kindlingtest.Clock, n int) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, clock.WaitForTimers(ctx, n))
+	}
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	get := func(k kindling.Kindling) <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			resp, err := k.NewHTTPClient().Get("https://example.com/")
+			if err == nil {
+				resp.Body.Close()
+			}
+			done <- result{resp, err}
+		}()
+		return done
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		_, err := kindling.NewKindling("test", kindling.WithClock(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("RequestDeadline", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		slow := kindlingtest.NewTransport("slow", kindlingtest.WithClock(clock), kindlingtest.WithConnectLatency(time.Hour))
+		done := get(newKindling(t, clock, kindling.WithTransport(slow)))
+		// The race's deadline and the connect latency.
+		waitForTimers(t, clock, 2)
+		clock.Advance(80 * time.Second)
+		res := <-done
+		assert.True(t, errors.Is(res.err, context.DeadlineExceeded), "got %v", res.err)
+	})
+
+	t.Run("ConnectTimeout", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		slow := kindlingtest.NewTransport("slow", kindlingtest.WithClock(clock), kindlingtest.WithConnectLatency(time.Hour))
+		done := get(newKindling(t, clock, kindling.WithTransport(slow), kindling.WithConnectTimeout("slow", 5*time.Second)))
+		// The race's deadline, the connect timeout, and the connect latency.
+		waitForTimers(t, clock, 3)
+		clock.Advance(5 * time.Second)
+		res := <-done
+		assert.ErrorContains(t, res.err, "connect timed out after 5s")
+	})
+
+	t.Run("HeadStart", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		cheap := kindlingtest.NewTransport("cheap", kindlingtest.WithClock(clock), kindlingtest.WithConnectLatency(time.Hour))
+		other := kindlingtest.NewTransport("other")
+		done := get(newKindling(t, clock,
+			kindling.WithTransport(cheap),
+			kindling.WithTransport(other),
+			kindling.WithHeadStart("cheap", 300*time.Millisecond),
+		))
+		// The race's deadline, cheap's connect latency, and the head start.
+		waitForTimers(t, clock, 3)
+		assert.Zero(t, other.Connects())
+		clock.Advance(300 * time.Millisecond)
+		res := <-done
+		require.NoError(t, res.err)
+		kindlingtest.AssertServedBy(t, res.resp, "other")
+	})
+
+	t.Run("CircuitBreaker", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		broken := kindlingtest.NewTransport("broken", kindlingtest.WithConnectError(errors.New("blocked")))
+		working := kindlingtest.NewTransport("working", kindlingtest.WithConnectLatency(10*time.Millisecond))
+		k := newKindling(t, clock,
+			kindling.WithTransport(broken),
+			kindling.WithTransport(working),
+			kindling.WithCircuitBreaker(1, 30*time.Second),
+		)
+		res := <-get(k)
+		require.NoError(t, res.err)
+		state := func() kindling.TransportInfo {
+			for _, info := range k.Transports() {
+				if info.Name == "broken" {
+					return info
+				}
+			}
+			t.Fatal("broken transport missing")
+			return kindling.TransportInfo{}
+		}
+		assert.Equal(t, kindling.TransportQuarantined, state().State)
+		assert.Equal(t, start.Add(30*time.Second), state().QuarantinedUntil)
+		clock.Advance(30 * time.Second)
+		assert.Equal(t, kindling.TransportEnabled, state().State)
+	})
+
+	t.Run("HealthCheck", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		probes := make(chan struct{}, 10)
+		tr := kindlingtest.HandlerTransport(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			probes <- struct{}{}
+		}))
+		newKindling(t, clock, kindling.WithTransport(tr), kindling.WithHealthCheck("https://example.com/health", time.Minute))
+		<-probes
+		select {
+		case <-probes:
+			t.Fatal("probed again before the interval passed")
+		case <-time.After(50 * time.Millisecond):
+		}
+		waitForTimers(t, clock, 1)
+		clock.Advance(time.Minute)
+		select {
+		case <-probes:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no probe after the interval passed")
+		}
+	})
+}
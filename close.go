@@ -0,0 +1,61 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrClosed is returned for requests and dials made through a Kindling after
+// Close.
+var ErrClosed = errors.New("kindling: closed")
+
+// Close shuts the instance down. It cancels background work such as config
+// refreshers and health checks and waits for it to stop, fails in-flight
+// races, closes pooled connections, SOCKS5 listeners and WireGuard devices,
+// and stops Tor and Psiphon clients that kindling launched. Responses
+// already returned keep streaming. Clients passed in by the caller, such as
+// a domainfront.Client or a DNSTT, stay open. Close is safe to call more than once.
+func (k *kindling) Close() error {
+	k.closeOnce.Do(func() {
+		k.cancel()
+		k.bg.Wait()
+		k.pool.close()
+
+		k.mu.Lock()
+		closers := k.closers
+		k.closers = nil
+		k.mu.Unlock()
+		var errs []error
+		for _, c := range closers {
+			if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				errs = append(errs, err)
+			}
+		}
+		k.closeErr = errors.Join(errs...)
+	})
+	return k.closeErr
+}
+
+// onClose registers c to be closed by Close, or closes it right away if the
+// instance is already closed.
+func (k *kindling) onClose(c io.Closer) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.ctx.Err() != nil {
+		c.Close()
+		return ErrClosed
+	}
+	k.closers = append(k.closers, c)
+	return nil
+}
+
+// stopOnClose arranges for cancel to be called if the instance is closed,
+// until the returned stop function is called.
+func stopOnClose(closed context.Context, cancel context.CancelFunc) (stop func() bool) {
+	if closed == nil {
+		return func() bool { return true }
+	}
+	return context.AfterFunc(closed, cancel)
+}
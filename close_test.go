@@ -0,0 +1,124 @@
+package kindling
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(origin.Close)
+
+	direct := &mockTransport{
+		name:     "direct",
+		reusable: true,
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+
+	t.Run("StopsBackgroundWork", func(t *testing.T) {
+		t.Parallel()
+		probing := make(chan struct{}, 1)
+		var stopped atomic.Bool
+		// stalling holds each health check probe until it's canceled.
+		stalling := &mockTransport{
+			name: "stalling",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					select {
+					case probing <- struct{}{}:
+					default:
+					}
+					<-req.Context().Done()
+					stopped.Store(true)
+					return nil, req.Context().Err()
+				}), nil
+			},
+		}
+		k, err := NewKindling("test", WithTransport(stalling), WithHealthCheck(origin.URL, time.Millisecond))
+		require.NoError(t, err)
+		<-probing
+		require.NoError(t, k.Close())
+		assert.True(t, stopped.Load(), "Close waits for the health check to return")
+		select {
+		case <-probing:
+			t.Fatal("probed after Close")
+		default:
+		}
+	})
+
+	t.Run("FailsInFlightRaces", func(t *testing.T) {
+		t.Parallel()
+		dialing := make(chan struct{}, 1)
+		hanging := &mockTransport{
+			name: "hanging",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				select {
+				case dialing <- struct{}{}:
+				default:
+				}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}
+		k, err := NewKindling("test", WithTransport(hanging))
+		require.NoError(t, err)
+		errc := make(chan error, 1)
+		go func() {
+			_, err := k.NewHTTPClient().Get(origin.URL)
+			errc <- err
+		}()
+		<-dialing
+		require.NoError(t, k.Close())
+		select {
+		case err := <-errc:
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("race still running after Close")
+		}
+	})
+
+	t.Run("RefusesNewWork", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(direct))
+		require.NoError(t, err)
+		client := k.NewHTTPClient()
+		require.NoError(t, k.Close())
+
+		_, err = client.Get(origin.URL)
+		assert.ErrorIs(t, err, ErrClosed)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		assert.ErrorIs(t, err, ErrClosed)
+		_, err = k.ListenSOCKS5("127.0.0.1:0")
+		assert.ErrorIs(t, err, ErrClosed)
+		assert.NoError(t, k.Close(), "closing twice is fine")
+	})
+
+	t.Run("ClosesListenersAndPool", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(direct))
+		require.NoError(t, err)
+		l, err := k.ListenSOCKS5("127.0.0.1:0")
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		drainAndClose(resp)
+		require.NotEmpty(t, k.(*kindling).pool.idle)
+
+		require.NoError(t, k.Close())
+		_, err = net.Dial("tcp", l.Addr().String())
+		assert.Error(t, err)
+		assert.Empty(t, k.(*kindling).pool.idle)
+	})
+}
@@ -0,0 +1,129 @@
+package kindling
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxCoalescedBody is the largest response body WithRequestCoalescing
+// shares between requests.
+const maxCoalescedBody = 4 << 20
+
+// WithRequestCoalescing makes concurrent identical GET requests, such as
+// the config fetches several components fire off at app startup, share a
+// single race instead of each racing every transport. Requests are
+// identical when their URLs and headers match and their contexts don't
+// route them differently (WithTransportHint, ExcludeTransports, WithSNI).
+// The first request's response body is read in full and every request gets
+// its own copy. Bodies over 4 MiB, and event streams, aren't shared: the
+// first request gets the response, and the rest race on their own, as they
+// do if the first request is canceled.
+func WithRequestCoalescing() Option {
+	return func(k *kindling) error {
+		k.flights = &flightGroup{flights: make(map[string]*flight)}
+		return nil
+	}
+}
+
+// flightGroup tracks the requests being raced on behalf of others.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is one shared request. Its fields are set before done is closed.
+type flight struct {
+	done chan struct{}
+	// shared is false when the outcome can't be handed to other requests.
+	shared bool
+	resp   *http.Response
+	body   []byte
+	err    error
+}
+
+// coalesceKey returns the key identifying requests identical to req, or
+// false if req can't be coalesced.
+func coalesceKey(req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet || (req.Body != nil && req.Body != http.NoBody) {
+		return "", false
+	}
+	ctx := req.Context()
+	if ctx.Value(transportHintKey{}) != nil || ctx.Value(excludeTransportKey{}) != nil || ctx.Value(sniKey{}) != nil {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(req.URL.String())
+	b.WriteString("\n")
+	req.Header.Write(&b)
+	return b.String(), true
+}
+
+// do sends req with fn, unless an identical request is already under way,
+// in which case it waits for that one and returns a copy of its response.
+func (g *flightGroup) do(key string, req *http.Request, fn func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if !f.shared {
+			return fn(req)
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.response(req), nil
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	resp, err := fn(req)
+	if err != nil {
+		// The leader's own cancellation says nothing about the others'.
+		f.shared, f.err = req.Context().Err() == nil, err
+		return nil, err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCoalescedBody+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCoalescedBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	f.shared, f.resp, f.body = true, resp, body
+	return f.response(req), nil
+}
+
+// response returns a copy of the shared response for req.
+func (f *flight) response(req *http.Request) *http.Response {
+	resp := *f.resp
+	resp.Header = f.resp.Header.Clone()
+	resp.Trailer = f.resp.Trailer.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(f.body))
+	resp.ContentLength = int64(len(f.body))
+	resp.Request = req
+	return &resp
+}
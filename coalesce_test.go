@@ -0,0 +1,118 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRequestCoalescing(t *testing.T) {
+	t.Parallel()
+
+	// newOrigin serves body once release is closed and counts requests.
+	newOrigin := func(t *testing.T, body string) (url string, hits *atomic.Int32, release chan struct{}) {
+		hits, release = new(atomic.Int32), make(chan struct{})
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			<-release
+			w.Header().Set("Etag", `"v1"`)
+			io.WriteString(w, body)
+		}))
+		t.Cleanup(origin.Close)
+		return origin.URL, hits, release
+	}
+	newClient := func(t *testing.T) *http.Client {
+		direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		}}
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithTransport(direct), WithRequestCoalescing())
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		return k.NewHTTPClient()
+	}
+	// getAll sends n requests made by newReq at once and returns their
+	// bodies once release is closed.
+	getAll := func(t *testing.T, client *http.Client, n int, newReq func(i int) *http.Request, hits *atomic.Int32, release chan struct{}) []string {
+		bodies := make([]string, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Do(newReq(i))
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := io.ReadAll(resp.Body)
+				bodies[i] = string(b)
+			}()
+		}
+		require.Eventually(t, func() bool { return hits.Load() >= 1 }, 5*time.Second, time.Millisecond)
+		// Give the other requests time to join the first.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		return bodies
+	}
+
+	t.Run("SharesOneRace", func(t *testing.T) {
+		t.Parallel()
+		url, hits, release := newOrigin(t, "config")
+		client := newClient(t)
+		bodies := getAll(t, client, 5, func(int) *http.Request {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			return req
+		}, hits, release)
+		assert.EqualValues(t, 1, hits.Load())
+		for _, b := range bodies {
+			assert.Equal(t, "config", b)
+		}
+	})
+
+	t.Run("DifferentHeadersRaceApart", func(t *testing.T) {
+		t.Parallel()
+		url, hits, release := newOrigin(t, "config")
+		client := newClient(t)
+		getAll(t, client, 3, func(i int) *http.Request {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Header.Set("Authorization", strings.Repeat("x", i+1))
+			return req
+		}, hits, release)
+		assert.EqualValues(t, 3, hits.Load())
+	})
+
+	t.Run("LargeBodiesArentShared", func(t *testing.T) {
+		t.Parallel()
+		large := strings.Repeat("x", maxCoalescedBody+1)
+		url, hits, release := newOrigin(t, large)
+		client := newClient(t)
+		bodies := getAll(t, client, 3, func(int) *http.Request {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			return req
+		}, hits, release)
+		assert.EqualValues(t, 3, hits.Load(), "the others race on their own")
+		for _, b := range bodies {
+			assert.Equal(t, len(large), len(b))
+		}
+	})
+
+	t.Run("NotCoalesced", func(t *testing.T) {
+		t.Parallel()
+		post, _ := http.NewRequest(http.MethodPost, "https://example.com/", strings.NewReader("x"))
+		_, ok := coalesceKey(post)
+		assert.False(t, ok)
+		hinted, _ := http.NewRequestWithContext(WithTransportHint(context.Background(), "direct"), http.MethodGet, "https://example.com/", nil)
+		_, ok = coalesceKey(hinted)
+		assert.False(t, ok)
+	})
+}
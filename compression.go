@@ -0,0 +1,205 @@
+package kindling
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionAlgorithms are the content codings WithCompression supports, in
+// the default order of preference.
+var compressionAlgorithms = []string{"zstd", "br", "gzip"}
+
+// WithCompression negotiates compressed responses on every transport and
+// decodes them before they reach the caller, so fronted, AMP, and DNS tunnel
+// round-trippers all behave like the stdlib's gzip handling. algorithms are
+// any of "zstd", "br", and "gzip" in order of preference, defaulting to all
+// three.
+//
+// Request bodies sent over low-bandwidth transports (length-limited ones such
+// as AMP, and last-resort ones such as DNS tunneling) are also compressed
+// with the first algorithm when that makes them smaller. The origin must
+// accept a Content-Encoding on requests.
+//
+// Requests that already carry an Accept-Encoding are left for the caller to
+// decode.
+func WithCompression(algorithms ...string) Option {
+	return func(k *kindling) error {
+		if len(algorithms) == 0 {
+			algorithms = compressionAlgorithms
+		}
+		for _, a := range algorithms {
+			if !slices.Contains(compressionAlgorithms, a) {
+				return fmt.Errorf("unsupported compression algorithm %q", a)
+			}
+		}
+		k.compression = slices.Clone(algorithms)
+		return nil
+	}
+}
+
+// withCompression wraps rt to negotiate and decode compression when
+// WithCompression is on.
+func (t *raceTransport) withCompression(tr Transport, rt http.RoundTripper) http.RoundTripper {
+	if len(t.compression) == 0 {
+		return rt
+	}
+	return &compressingRoundTripper{rt: rt, algorithms: t.compression, compressBody: lowBandwidth(tr)}
+}
+
+// lowBandwidth reports whether tr is slow or small enough that compressing
+// request bodies is worth the origin's trouble.
+func lowBandwidth(tr Transport) bool {
+	if c, ok := tr.(*chunkedTransport); ok {
+		tr = c.Transport
+	}
+	return tr.MaxLength() > 0 || priorityOf(tr) >= priorityLastResort
+}
+
+type compressingRoundTripper struct {
+	rt           http.RoundTripper
+	algorithms   []string
+	compressBody bool
+}
+
+// RoundTrip compresses the request body if it's worth it, and asks for a
+// compressed response and decodes it unless the caller set its own
+// Accept-Encoding.
+func (c *compressingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var encoded *requestBody
+	if c.compressBody && req.Body != nil && req.Body != http.NoBody && req.GetBody != nil && req.Header.Get("Content-Encoding") == "" {
+		var err error
+		if encoded, err = compressRequestBody(req, c.algorithms[0]); err != nil {
+			return nil, err
+		}
+	}
+	decode := req.Header.Get("Accept-Encoding") == ""
+	if decode {
+		req.Header.Set("Accept-Encoding", strings.Join(c.algorithms, ", "))
+	}
+	resp, err := c.rt.RoundTrip(req)
+	resp = encoded.releaseAfter(resp)
+	if err != nil || !decode {
+		return resp, err
+	}
+	decodeResponse(resp)
+	return resp, nil
+}
+
+// compressRequestBody replaces req's body with its encoding, unless that
+// wouldn't be any smaller, in which case req.GetBody brings back the
+// original. The encoder streams into a requestBody, so a large upload
+// spills to a temp file rather than being held in memory. The encoded body
+// is returned for the caller to release once the response is done with it.
+func compressRequestBody(req *http.Request, algorithm string) (*requestBody, error) {
+	pr, pw := io.Pipe()
+	rawSize := make(chan int64, 1)
+	go func(raw io.ReadCloser) {
+		var n int64
+		w, err := newEncoder(pw, algorithm)
+		if err == nil {
+			n, err = io.Copy(w, raw)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		raw.Close()
+		rawSize <- n
+		pw.CloseWithError(err)
+	}(req.Body)
+	encoded, err := newRequestBody(&http.Request{Body: pr, ContentLength: -1}, bodySpillThreshold)
+	if err == nil && encoded.Len() > 0 && encoded.Len() < <-rawSize {
+		body, err := encoded.reader()
+		if err != nil {
+			encoded.Close()
+			return nil, fmt.Errorf("reading compressed request body: %w", err)
+		}
+		req.Body = body
+		req.GetBody = encoded.reader
+		req.ContentLength = encoded.Len()
+		req.Header.Set("Content-Encoding", algorithm)
+		return encoded, nil
+	}
+	encoded.Close()
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("rewinding request body: %w", err)
+	}
+	req.Body = body
+	return nil, nil
+}
+
+func newEncoder(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "br":
+		return brotli.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm %q", algorithm)
+}
+
+// decodeResponse replaces a compressed response body with its decoding and
+// drops the headers that described the encoded form. Unknown codings are
+// left alone.
+func decodeResponse(resp *http.Response) {
+	algorithm := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if !slices.Contains(compressionAlgorithms, algorithm) || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	resp.Body = &decodedBody{body: resp.Body, algorithm: algorithm}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decodedBody decodes body lazily, so that an empty body (say, from a HEAD
+// request) isn't mistaken for a truncated stream.
+type decodedBody struct {
+	body      io.ReadCloser
+	algorithm string
+	r         io.Reader
+	zr        *zstd.Decoder
+	err       error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		switch d.algorithm {
+		case "gzip":
+			d.r, d.err = gzip.NewReader(d.body)
+		case "br":
+			d.r = brotli.NewReader(d.body)
+		case "zstd":
+			d.zr, d.err = zstd.NewReader(d.body, zstd.WithDecoderConcurrency(1))
+			d.r = d.zr
+		}
+		if d.err == io.EOF {
+			// No body at all rather than a truncated one.
+			return 0, io.EOF
+		}
+		if d.err != nil {
+			d.err = fmt.Errorf("decoding %s response: %w", d.algorithm, d.err)
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decodedBody) Close() error {
+	if d.zr != nil {
+		d.zr.Close()
+	}
+	return d.body.Close()
+}
@@ -0,0 +1,184 @@
+package kindling
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCompression(t *testing.T) {
+	t.Parallel()
+
+	t.Run("UnsupportedAlgorithm", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithCompression("gzip", "lzma"))
+		assert.ErrorContains(t, err, "lzma")
+	})
+
+	t.Run("DefaultsToAll", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithCompression())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"zstd", "br", "gzip"}, k.(*kindling).compression)
+	})
+
+	payload := strings.Repeat("kindling config ", 200)
+	// srv gzips its response when asked and echoes what it saw of the
+	// request: the decoded body length and the encoding headers.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		received, _ := io.ReadAll(body)
+		w.Header().Set("X-Request-Encoding", r.Header.Get("Content-Encoding"))
+		w.Header().Set("X-Request-Length", r.Header.Get("Content-Length"))
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		w.Header().Set("X-Received", string(received))
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			io.WriteString(w, payload)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, payload)
+		zw.Close()
+	}))
+	t.Cleanup(srv.Close)
+
+	// rawTransport sends without the stdlib's own gzip handling, like the
+	// fronted, AMP, and dnstt round-trippers.
+	rawTransport := func(maxLength int) Transport {
+		return &mockTransport{name: "raw", maxLength: maxLength, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				out, err := http.NewRequestWithContext(req.Context(), req.Method, srv.URL, req.Body)
+				if err != nil {
+					return nil, err
+				}
+				out.Header = req.Header.Clone()
+				out.ContentLength = req.ContentLength
+				return (&http.Transport{DisableCompression: true}).RoundTrip(out)
+			}), nil
+		}}
+	}
+
+	t.Run("DecodesResponses", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(rawTransport(0)), WithCompression("gzip"))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get("http://example.com/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(body))
+		assert.Equal(t, "gzip", resp.Header.Get("X-Accept-Encoding"))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.True(t, resp.Uncompressed)
+	})
+
+	t.Run("CallerAcceptEncodingLeftAlone", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(rawTransport(0)), WithCompression())
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := k.NewHTTPClient().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.False(t, resp.Uncompressed)
+	})
+
+	t.Run("CompressesBodiesOnLowBandwidthTransports", func(t *testing.T) {
+		t.Parallel()
+		upload := strings.Repeat("a", 5000)
+		for _, tc := range []struct {
+			name      string
+			maxLength int
+			encoding  string
+		}{
+			{"LengthLimited", 6000, "gzip"},
+			{"Unlimited", 0, ""},
+		} {
+			k, err := NewKindling("test", WithTransport(rawTransport(tc.maxLength)), WithCompression("gzip"))
+			require.NoError(t, err)
+			resp, err := k.NewHTTPClient().Post("http://example.com/", "text/plain", strings.NewReader(upload))
+			require.NoError(t, err, tc.name)
+			resp.Body.Close()
+			assert.Equal(t, tc.encoding, resp.Header.Get("X-Request-Encoding"), tc.name)
+			assert.Equal(t, upload, resp.Header.Get("X-Received"), tc.name)
+		}
+	})
+
+	t.Run("IncompressibleBodySentAsIs", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest(http.MethodPost, "http://example.com/", bytes.NewReader([]byte{1}))
+		require.NoError(t, err)
+		encoded, err := compressRequestBody(req, "gzip")
+		require.NoError(t, err)
+		assert.Nil(t, encoded)
+		assert.Empty(t, req.Header.Get("Content-Encoding"))
+		assert.Equal(t, int64(1), req.ContentLength)
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, body)
+	})
+
+	t.Run("LargeBodyStreamed", func(t *testing.T) {
+		t.Parallel()
+		var upload strings.Builder
+		for i := 0; upload.Len() <= 2*bodySpillThreshold; i++ {
+			fmt.Fprintf(&upload, "%d\n", i*7919)
+		}
+		req, err := http.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(upload.String()))
+		require.NoError(t, err)
+		encoded, err := compressRequestBody(req, "gzip")
+		require.NoError(t, err)
+		require.NotNil(t, encoded)
+		t.Cleanup(func() { encoded.Close() })
+		assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+		assert.Equal(t, encoded.Len(), req.ContentLength)
+		assert.Less(t, req.ContentLength, int64(upload.Len()))
+
+		decode := func(body io.ReadCloser) string {
+			defer body.Close()
+			zr, err := gzip.NewReader(body)
+			require.NoError(t, err)
+			got, err := io.ReadAll(zr)
+			require.NoError(t, err)
+			return string(got)
+		}
+		assert.Equal(t, upload.String(), decode(req.Body))
+		replay, err := req.GetBody()
+		require.NoError(t, err)
+		assert.Equal(t, upload.String(), decode(replay), "GetBody replays the encoded body")
+	})
+
+	t.Run("EmptyEncodedBody", func(t *testing.T) {
+		t.Parallel()
+		resp := &http.Response{
+			Header: http.Header{"Content-Encoding": {"gzip"}},
+			Body:   io.NopCloser(strings.NewReader("")),
+		}
+		decodeResponse(resp)
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Empty(t, body)
+	})
+}
@@ -0,0 +1,191 @@
+package kindling
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/getlantern/amp"
+	"github.com/getlantern/dnstt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the document NewKindlingFromConfig builds a Kindling from. It's
+// written in YAML or JSON, with the keys given in the field tags, so a server
+// can push the transports a client should use without a new release:
+//
+//	name: myapp
+//	domainFronting:
+//	  configURL: https://example.com/fronted.yaml.gz
+//	proxyless:
+//	  domains: [example.com]
+//	dnsTunnel:
+//	  domain: t.example.com
+//	  publicKey: 0123...cdef
+//	  resolvers: [https://dns.google/dns-query]
+//	ampCache:
+//	  brokerURL: https://broker.example.com
+//	  cacheURL: https://cdn.ampproject.org
+//	  fronts: [www.google.com]
+//	  publicKey: |
+//	    -----BEGIN PUBLIC KEY-----
+//	    ...
+//
+// Each transport section is optional, but at least one must be present.
+// Unknown keys are ignored so that older clients accept newer documents.
+type Config struct {
+	// Name is the application name, as passed to NewKindling.
+	Name string `yaml:"name"`
+
+	DomainFronting *DomainFrontingConfig `yaml:"domainFronting"`
+	Proxyless      *ProxylessConfig      `yaml:"proxyless"`
+	DNSTunnel      *DNSTunnelConfig      `yaml:"dnsTunnel"`
+	AMPCache       *AMPCacheConfig       `yaml:"ampCache"`
+}
+
+// DomainFrontingConfig declares domain fronting, starting from the config
+// shipped with kindling (see WithDomainFrontingConfigURL).
+type DomainFrontingConfig struct {
+	// ConfigURL keeps the fronting config current. Defaults to
+	// DefaultFrontingConfigURL.
+	ConfigURL string `yaml:"configURL"`
+}
+
+// ProxylessConfig declares proxyless smart dialing (see WithProxyless).
+type ProxylessConfig struct {
+	// Domains are the origins the smart dialer tests its strategies against.
+	Domains []string `yaml:"domains"`
+	// ConfigURL, if set, keeps the strategies current (see
+	// WithProxylessConfigURL).
+	ConfigURL string `yaml:"configURL"`
+	// RefreshInterval is how often ConfigURL is fetched, such as "6h".
+	// Defaults to 12 hours.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// DNSTunnelConfig declares DNS tunneling, raced over several resolvers (see
+// WithDNSTunnelResolvers).
+type DNSTunnelConfig struct {
+	// Domain is the dnstt server's tunnel domain. Defaults to
+	// DefaultDNSTunnelDomain.
+	Domain string `yaml:"domain"`
+	// PublicKey is the dnstt server's hex-encoded Noise public key. Without
+	// it the tunnel isn't authenticated.
+	PublicKey string `yaml:"publicKey"`
+	// Resolvers are DoH (https://) or DoT (tls://) resolvers. Defaults to
+	// DefaultDNSTunnelResolvers.
+	Resolvers []string `yaml:"resolvers"`
+}
+
+// AMPCacheConfig declares AMP caching (see WithAMPCache).
+type AMPCacheConfig struct {
+	BrokerURL string   `yaml:"brokerURL"`
+	CacheURL  string   `yaml:"cacheURL"`
+	Fronts    []string `yaml:"fronts"`
+	// PublicKey is the broker's PEM-encoded RSA public key.
+	PublicKey string `yaml:"publicKey"`
+	// ConfigURL, if set, keeps the AMP config current.
+	ConfigURL string `yaml:"configURL"`
+}
+
+// defaultProxylessRefreshInterval is how often a proxyless ConfigURL is
+// fetched when the document doesn't say.
+const defaultProxylessRefreshInterval = 12 * time.Hour
+
+// NewKindlingFromConfig creates a Kindling from a YAML or JSON Config read
+// from r. options are applied after the transports the document declares,
+// for settings that belong to the app rather than the document, such as
+// WithLogWriter.
+func NewKindlingFromConfig(r io.Reader, options ...Option) (Kindling, error) {
+	var cfg Config
+	if err := yaml.NewDecoder(r).Decode(&cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("kindling config is empty")
+		}
+		return nil, fmt.Errorf("parsing kindling config: %w", err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	return NewKindling(cfg.Name, append(opts, options...)...)
+}
+
+// options returns the Options that set up the transports c declares.
+func (c *Config) options() ([]Option, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("kindling config has no name")
+	}
+	var opts []Option
+	if df := c.DomainFronting; df != nil {
+		configURL := df.ConfigURL
+		if configURL == "" {
+			configURL = DefaultFrontingConfigURL
+		}
+		opts = append(opts, WithDomainFrontingConfigURL(configURL, defaultFrontingConfig))
+	}
+	if p := c.Proxyless; p != nil {
+		if p.ConfigURL == "" {
+			opts = append(opts, WithProxyless(p.Domains...))
+		} else {
+			interval := p.RefreshInterval
+			if interval == 0 {
+				interval = defaultProxylessRefreshInterval
+			}
+			opts = append(opts, WithProxylessConfigURL(p.ConfigURL, interval, p.Domains...))
+		}
+	}
+	if d := c.DNSTunnel; d != nil {
+		domain, resolvers := d.Domain, d.Resolvers
+		if domain == "" {
+			domain = DefaultDNSTunnelDomain
+		}
+		if len(resolvers) == 0 {
+			resolvers = DefaultDNSTunnelResolvers
+		}
+		if d.PublicKey != "" {
+			if key, err := hex.DecodeString(d.PublicKey); err != nil || len(key) != 32 {
+				return nil, fmt.Errorf("dns tunnel public key must be 32 hex-encoded bytes")
+			}
+		}
+		newTunnel := func(resolver string) (dnstt.DNSTT, error) {
+			return newDNSTunnel(domain, d.PublicKey, resolver)
+		}
+		opts = append(opts, WithDNSTunnelResolvers(newTunnel, resolvers...))
+	}
+	if a := c.AMPCache; a != nil {
+		opts = append(opts, withAMPCacheConfig(a))
+	}
+	if len(opts) == 0 {
+		return nil, fmt.Errorf("kindling config declares no transports")
+	}
+	return opts, nil
+}
+
+// withAMPCacheConfig adds AMP caching with an amp.Client that kindling builds
+// from c, fetching c.ConfigURL through kindling itself once NewKindling
+// returns.
+func withAMPCacheConfig(c *AMPCacheConfig) Option {
+	return func(k *kindling) error {
+		opts := []amp.Option{amp.WithConfig(amp.Config{
+			BrokerURL: c.BrokerURL,
+			CacheURL:  c.CacheURL,
+			Fronts:    c.Fronts,
+			PublicKey: c.PublicKey,
+		})}
+		if c.ConfigURL != "" {
+			opts = append(opts,
+				amp.WithConfigURL(c.ConfigURL),
+				amp.WithHTTPClient(&http.Client{Transport: newSelfRoundTripper(k)}),
+			)
+		}
+		client, err := amp.NewClientWithOptions(k.ctx, opts...)
+		if err != nil {
+			return fmt.Errorf("creating amp client: %w", err)
+		}
+		return WithAMPCache(client)(k)
+	}
+}
@@ -0,0 +1,158 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// configFetchTimeout bounds a single refresh fetch.
+const configFetchTimeout = time.Minute
+
+// maxConfigSize caps a fetched config so a misbehaving server can't exhaust
+// memory.
+const maxConfigSize = 1 << 20
+
+// configRefresher periodically fetches a config document and hands changed
+// versions to apply. Fetches use If-None-Match when the server sends ETags;
+// unchanged bodies are skipped either way. A failed fetch or apply keeps the
+// current config and is retried on the next tick.
+type configRefresher struct {
+	url      string
+	interval time.Duration
+	// client returns the HTTP client to fetch with, called per fetch so the
+	// refresher sees transports added or replaced since it started.
+	client func() *http.Client
+	apply  func([]byte) error
+	log    *slog.Logger
+
+	etag string
+	last []byte
+}
+
+// run fetches immediately and then every interval until ctx is done.
+func (r *configRefresher) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.refresh(ctx); err != nil {
+			r.log.Warn("Config refresh failed", "url", r.url, slog.Any("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh fetches the config once and applies it if it changed.
+func (r *configRefresher) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, configFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxConfigSize {
+		return fmt.Errorf("config exceeds %d bytes", maxConfigSize)
+	}
+	if bytes.Equal(body, r.last) {
+		return nil
+	}
+	if err := r.apply(body); err != nil {
+		return fmt.Errorf("applying config: %w", err)
+	}
+	r.last = body
+	r.etag = resp.Header.Get("ETag")
+	r.log.Info("Applied refreshed config", "url", r.url, "bytes", len(body))
+	return nil
+}
+
+// WithProxylessConfigURL is WithProxyless with strategies that are kept up
+// to date from configURL. The transport starts from the instance's smart
+// dialer config (see WithSmartDialerConfig) and, once NewKindling returns,
+// fetches configURL through kindling itself — immediately and then every
+// interval — rebuilding the smart dialer whenever the YAML changes. In-flight
+// connections keep the dialer they started with; a config that fails to
+// build is logged and the previous dialer stays in place.
+func WithProxylessConfigURL(configURL string, interval time.Duration, domains ...string) Option {
+	return func(k *kindling) error {
+		if configURL == "" {
+			return fmt.Errorf("proxyless config url is empty")
+		}
+		if interval <= 0 {
+			return fmt.Errorf("proxyless config refresh interval must be positive")
+		}
+		k.deferred = append(k.deferred, func() error {
+			newDialer := newSmartDialerFn
+			build := func(cfg []byte) (transport.StreamDialer, error) {
+				cfg, stream, err := k.smartDialerBase(cfg)
+				if err != nil {
+					return nil, err
+				}
+				return newDialer(k.smartLogWriter(), cfg, stream, k.basePacketDialer(), domains...)
+			}
+			initial, err := build(k.smartDialerConfig)
+			if err != nil {
+				return fmt.Errorf("creating smart dialer: %w", err)
+			}
+			d := &swappableDialer{}
+			d.current.Store(&initial)
+			k.transports = append(k.transports, k.smartTransport(d))
+
+			r := &configRefresher{
+				url:      configURL,
+				interval: interval,
+				client:   k.NewHTTPClient,
+				log:      k.log,
+				apply: func(cfg []byte) error {
+					next, err := build(cfg)
+					if err != nil {
+						return err
+					}
+					d.current.Store(&next)
+					return nil
+				},
+			}
+			k.background = append(k.background, r.run)
+			return nil
+		})
+		return nil
+	}
+}
+
+// swappableDialer is a StreamDialer whose implementation can be replaced
+// while in use.
+type swappableDialer struct {
+	current atomic.Pointer[transport.StreamDialer]
+}
+
+func (d *swappableDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	return (*d.current.Load()).DialStream(ctx, addr)
+}
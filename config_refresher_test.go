@@ -0,0 +1,182 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// configServer serves a mutable body with an ETag derived from it.
+type configServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	body     string
+	requests atomic.Int32
+}
+
+func newConfigServer(t *testing.T, body string) *configServer {
+	t.Helper()
+	s := &configServer{body: body}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		body := s.body
+		s.mu.Unlock()
+		etag := `"` + body + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *configServer) set(body string) {
+	s.mu.Lock()
+	s.body = body
+	s.mu.Unlock()
+}
+
+func TestConfigRefresher(t *testing.T) {
+	t.Parallel()
+
+	t.Run("AppliesOnlyChanges", func(t *testing.T) {
+		t.Parallel()
+		srv := newConfigServer(t, "v1")
+		var applied []string
+		r := &configRefresher{
+			url:    srv.URL,
+			client: func() *http.Client { return srv.Client() },
+			log:    testLog,
+			apply: func(b []byte) error {
+				applied = append(applied, string(b))
+				return nil
+			},
+		}
+		ctx := context.Background()
+		require.NoError(t, r.refresh(ctx))
+		require.NoError(t, r.refresh(ctx))
+		srv.set("v2")
+		require.NoError(t, r.refresh(ctx))
+		assert.Equal(t, []string{"v1", "v2"}, applied)
+		assert.Equal(t, `"v2"`, r.etag)
+	})
+
+	t.Run("FailedApply_RetriedNextTime", func(t *testing.T) {
+		t.Parallel()
+		srv := newConfigServer(t, "v1")
+		fail := true
+		r := &configRefresher{
+			url:    srv.URL,
+			client: func() *http.Client { return srv.Client() },
+			log:    testLog,
+			apply: func([]byte) error {
+				if fail {
+					return errors.New("bad yaml")
+				}
+				return nil
+			},
+		}
+		assert.ErrorContains(t, r.refresh(context.Background()), "bad yaml")
+		assert.Empty(t, r.etag, "a rejected config must not be cached as current")
+		fail = false
+		require.NoError(t, r.refresh(context.Background()))
+		assert.Equal(t, "v1", string(r.last))
+	})
+
+	t.Run("RejectsBadResponses", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/big" {
+				io.WriteString(w, strings.Repeat("x", maxConfigSize+1))
+				return
+			}
+			http.Error(w, "nope", http.StatusForbidden)
+		}))
+		t.Cleanup(srv.Close)
+		for _, path := range []string{"/missing", "/big"} {
+			r := &configRefresher{
+				url:    srv.URL + path,
+				client: func() *http.Client { return srv.Client() },
+				log:    testLog,
+				apply: func([]byte) error {
+					t.Error("apply called for a bad response")
+					return nil
+				},
+			}
+			assert.Error(t, r.refresh(context.Background()), path)
+		}
+	})
+}
+
+// recordingDialer is a StreamDialer stub that remembers which config built it.
+type recordingDialer struct{ config string }
+
+func (d *recordingDialer) DialStream(context.Context, string) (transport.StreamConn, error) {
+	return nil, errors.New("recordingDialer does not dial")
+}
+
+// Not parallel: swaps the package-level newSmartDialerFn.
+func TestWithProxylessConfigURL(t *testing.T) {
+	orig := newSmartDialerFn
+	newSmartDialerFn = func(_ io.Writer, cfg []byte, _ transport.StreamDialer, _ transport.PacketDialer, _ ...string) (transport.StreamDialer, error) {
+		if string(cfg) == "broken" {
+			return nil, errors.New("invalid strategies")
+		}
+		return &recordingDialer{config: string(cfg)}, nil
+	}
+	t.Cleanup(func() { newSmartDialerFn = orig })
+
+	_, err := NewKindling("test", WithProxylessConfigURL("", time.Minute))
+	assert.Error(t, err)
+	_, err = NewKindling("test", WithProxylessConfigURL("https://example.com/s.yml", 0))
+	assert.Error(t, err)
+
+	srv := newConfigServer(t, "fetched")
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+	k, err := NewKindling("test",
+		WithLogWriter(io.Discard),
+		WithTransport(direct),
+		WithProxylessConfigURL(srv.URL, 20*time.Millisecond, "example.com"),
+	)
+	require.NoError(t, err)
+
+	var d *swappableDialer
+	for _, tr := range k.(*kindling).transports {
+		if tr.Name() == string(TransportSmart) {
+			d = tr.(*namedTransport).dialer.(*swappableDialer)
+		}
+	}
+	require.NotNil(t, d)
+	current := func() string { return (*d.current.Load()).(*recordingDialer).config }
+
+	assert.Eventually(t, func() bool { return current() == "fetched" }, 2*time.Second, 5*time.Millisecond)
+
+	// A config that fails to build leaves the working dialer in place.
+	srv.set("broken")
+	seen := srv.requests.Load()
+	assert.Eventually(t, func() bool { return srv.requests.Load() > seen+1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, "fetched", current())
+
+	srv.set("updated")
+	assert.Eventually(t, func() bool { return current() == "updated" }, 2*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,99 @@
+package kindling
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func transportNames(k Kindling) []string {
+	var names []string
+	for _, tr := range k.(*kindling).snapshot() {
+		names = append(names, tr.Name())
+	}
+	return names
+}
+
+// Not parallel: swaps newSmartDialerFn.
+func TestNewKindlingFromConfigYAML(t *testing.T) {
+	var probed []string
+	orig := newSmartDialerFn
+	newSmartDialerFn = func(_ io.Writer, _ []byte, _ transport.StreamDialer, _ transport.PacketDialer, domains ...string) (transport.StreamDialer, error) {
+		probed = domains
+		return &transport.TCPDialer{}, nil
+	}
+	t.Cleanup(func() { newSmartDialerFn = orig })
+
+	k, err := NewKindlingFromConfig(strings.NewReader(`
+name: test
+domainFronting: {}
+proxyless:
+  domains: [example.com, example.org]
+dnsTunnel:
+  domain: t.example.com
+  publicKey: 0000000000000000000000000000000000000000000000000000000000000000
+  resolvers:
+    - https://dns.google/dns-query
+    - tls://dns.google:853
+futureTransport:
+  enabled: true
+`))
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	assert.Equal(t, "test", k.(*kindling).appName)
+	assert.Equal(t, []string{"domainfront", "dnstt", "smart"}, transportNames(k))
+	assert.Equal(t, []string{"example.com", "example.org"}, probed)
+}
+
+func TestNewKindlingFromConfigJSON(t *testing.T) {
+	t.Parallel()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	doc, err := json.Marshal(map[string]any{
+		"name":      "test",
+		"dnsTunnel": map[string]any{},
+		"ampCache": map[string]any{
+			"brokerURL": "https://broker.example.com",
+			"cacheURL":  "https://cdn.ampproject.org",
+			"fronts":    []string{"www.google.com"},
+			"publicKey": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		},
+	})
+	require.NoError(t, err)
+
+	k, err := NewKindlingFromConfig(strings.NewReader(string(doc)), WithLogWriter(io.Discard))
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	assert.Equal(t, []string{"dnstt", "amp"}, transportNames(k))
+}
+
+func TestNewKindlingFromConfigInvalid(t *testing.T) {
+	t.Parallel()
+	for name, doc := range map[string]string{
+		"Empty":         "",
+		"Malformed":     "name: [test",
+		"NoName":        "dnsTunnel: {}",
+		"NoTransports":  "name: test",
+		"BadPublicKey":  "name: test\ndnsTunnel:\n  publicKey: nothex",
+		"BadResolver":   "name: test\ndnsTunnel:\n  resolvers: [ftp://example.com]",
+		"BadAMPKey":     "name: test\nampCache:\n  publicKey: nope",
+		"BadRefreshDur": "name: test\nproxyless:\n  configURL: https://example.com/c.yml\n  refreshInterval: soon",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := NewKindlingFromConfig(strings.NewReader(doc))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package kindling_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/require"
+
+	"github.com/getlantern/kindling"
+	"github.com/getlantern/kindling/kindlingtest/conformance"
+)
+
+// TestConformance runs the conformance suite against the built-in
+// transports that can reach a loopback origin, each alone in a race so the
+// race transport's request handling is covered too.
+func TestConformance(t *testing.T) {
+	t.Parallel()
+	direct := &transport.TCPDialer{}
+	webTunnel := kindling.NewWebTunnelServer(t, "/secret")
+	webTunnelPin := base64.StdEncoding.EncodeToString(webTunnel.Certificate().RawSubjectPublicKeyInfo)
+	turn, _ := kindling.NewTURNServer(t, "alice", "secret")
+
+	for name, opts := range map[string][]kindling.Option{
+		"StreamDialer":  {kindling.WithStreamDialerTransport("direct", direct)},
+		"UpstreamProxy": {kindling.WithUpstreamProxy("http://" + serveConnectProxy(t))},
+		"SOCKS5":        {kindling.WithUpstreamProxy("socks5://" + kindling.ServeSOCKS5(t))},
+		"WebTunnel":     {kindling.WithWebTunnel(webTunnel.URL, "/secret", webTunnelPin)},
+		"TURN":          {kindling.WithTURN("turn:"+turn, "alice", "secret")},
+		"Shadowsocks":   {kindling.WithShadowsocks(kindling.TestShadowsocksKey(kindling.ServeShadowsocks(t)))},
+		"Chunking":      {kindling.WithStreamDialerTransport("direct", direct), kindling.WithRequestChunking()},
+		"Compression":   {kindling.WithStreamDialerTransport("direct", direct), kindling.WithCompression("gzip")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			k, err := kindling.NewKindling("conformance", opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { k.Close() })
+			conformance.RunRoundTripper(t, k.NewHTTPClient().Transport)
+		})
+	}
+}
+
+// serveConnectProxy starts an HTTP CONNECT proxy on the loopback interface
+// and returns its address.
+func serveConnectProxy(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+				go func() {
+					io.Copy(upstream, conn)
+					upstream.Close()
+				}()
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
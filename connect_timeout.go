@@ -0,0 +1,34 @@
+package kindling
+
+import (
+	"fmt"
+	"time"
+)
+
+// WithConnectTimeout bounds how long the named transport may take to
+// connect, apart from the request's overall budget of 80 seconds or more,
+// so a hung handshake, say a DNS tunnel's, fails early instead of holding
+// its goroutine and connection for the whole budget. An empty name sets the
+// timeout for every transport without one of its own. A connect that runs
+// out counts as the transport failing.
+func WithConnectTimeout(transportName string, d time.Duration) Option {
+	return func(k *kindling) error {
+		if d <= 0 {
+			return fmt.Errorf("connect timeout must be positive, got %v", d)
+		}
+		if k.connectTimeouts == nil {
+			k.connectTimeouts = make(map[string]time.Duration)
+		}
+		k.connectTimeouts[transportName] = d
+		return nil
+	}
+}
+
+// connectTimeout returns how long tr may take to connect, or 0 for no limit
+// short of the request's.
+func (t *raceTransport) connectTimeout(tr Transport) time.Duration {
+	if d, ok := t.connectTimeouts[tr.Name()]; ok {
+		return d
+	}
+	return t.connectTimeouts[""]
+}
@@ -0,0 +1,70 @@
+package kindling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingTransport never connects, until its context ends.
+func hangingTransport(name string) Transport {
+	return &mockTransport{
+		name: name,
+		newRoundTripper: func(ctx context.Context, _ string) (http.RoundTripper, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+}
+
+func TestWithConnectTimeout(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithConnectTimeout("dnstt", 0)(&kindling{}))
+	})
+
+	t.Run("PerTransportOverridesDefault", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{}
+		require.NoError(t, WithConnectTimeout("", 10*time.Second)(k))
+		require.NoError(t, WithConnectTimeout("dnstt", time.Minute)(k))
+		rt := k.newRaceTransport(nil)
+		assert.Equal(t, time.Minute, rt.connectTimeout(bareTransport{"dnstt"}))
+		assert.Equal(t, 10*time.Second, rt.connectTimeout(bareTransport{"smart"}))
+		assert.Zero(t, (&raceTransport{}).connectTimeout(bareTransport{"smart"}))
+	})
+
+	t.Run("HungConnectFailsEarly", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{hangingTransport("dnstt")})
+		rt.connectTimeouts = map[string]time.Duration{"dnstt": 20 * time.Millisecond}
+		start := time.Now()
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+		assert.Less(t, time.Since(start), 10*time.Second)
+		assert.ErrorContains(t, err, "connect timed out after 20ms")
+		var raceErr *RaceError
+		require.ErrorAs(t, err, &raceErr)
+		assert.NotErrorIs(t, err, context.DeadlineExceeded, "the request's own budget didn't run out")
+	})
+
+	t.Run("ConnectedTransportUnaffected", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+		}))
+		defer server.Close()
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{redirectTransport("smart", server.URL)})
+		rt.connectTimeouts = map[string]time.Duration{"": 10 * time.Millisecond}
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
@@ -0,0 +1,190 @@
+package kindling
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// countryDetectionInterval is how often WithCountryDetection checks the
+// country again, in case the device has moved or changed networks.
+const countryDetectionInterval = time.Hour
+
+// CountryStrategy adjusts the race for users in one country.
+type CountryStrategy struct {
+	// Prefer names transports raced ahead of all others, in a tier of their
+	// own, because they're known to work well in the country.
+	Prefer []string
+	// Skip names transports left out of the race because they're known to
+	// be blocked in the country. They're still used if nothing else is left.
+	Skip []string
+}
+
+// defaultCountryStrategies are the built-in strategies, from field reports.
+// WithCountryStrategy adds to or overrides them.
+var defaultCountryStrategies = map[string]CountryStrategy{
+	// The public resolvers DNS tunneling relies on are blocked, while the
+	// Google AMP cache is too costly to block.
+	"IR": {Prefer: []string{string(TransportAMP)}, Skip: []string{string(TransportDNSTunnel)}},
+	// Google's AMP cache is blocked outright.
+	"CN": {Skip: []string{string(TransportAMP)}},
+}
+
+// WithCountryHint tells kindling which country the user is in, as an ISO
+// 3166-1 alpha-2 code such as "IR", so the race follows that country's
+// strategy (see CountryStrategy): transports known to be blocked there are
+// skipped, and ones known to work are tried first. Countries without a
+// strategy race as usual.
+func WithCountryHint(iso2 string) Option {
+	return func(k *kindling) error {
+		code, err := countryCode(iso2)
+		if err != nil {
+			return err
+		}
+		k.country.Store(&code)
+		return nil
+	}
+}
+
+// WithCountryDetection looks the user's country up at geoURL through kindling
+// itself, once NewKindling returns and then hourly, and applies its strategy
+// like WithCountryHint, which it overrides once a lookup succeeds. The
+// endpoint may answer with the bare country code, JSON with a "country",
+// "country_code", or "countryCode" field, or key=value lines with a "loc"
+// key, as Cloudflare's /cdn-cgi/trace does.
+func WithCountryDetection(geoURL string) Option {
+	return func(k *kindling) error {
+		if geoURL == "" {
+			return fmt.Errorf("country detection url is empty")
+		}
+		r := &configRefresher{
+			url:      geoURL,
+			interval: countryDetectionInterval,
+			client:   k.NewHTTPClient,
+			log:      k.log,
+			apply: func(body []byte) error {
+				code, err := parseCountry(body)
+				if err != nil {
+					return err
+				}
+				k.country.Store(&code)
+				k.log.Info("Detected country", "country", code)
+				return nil
+			},
+		}
+		k.background = append(k.background, r.run)
+		return nil
+	}
+}
+
+// WithCountryStrategy sets the strategy for users in country iso2, replacing
+// any built-in one. An empty strategy turns the built-in one off.
+func WithCountryStrategy(iso2 string, strategy CountryStrategy) Option {
+	return func(k *kindling) error {
+		code, err := countryCode(iso2)
+		if err != nil {
+			return err
+		}
+		if k.countryStrategies == nil {
+			k.countryStrategies = make(map[string]CountryStrategy)
+		}
+		k.countryStrategies[code] = CountryStrategy{
+			Prefer: slices.Clone(strategy.Prefer),
+			Skip:   slices.Clone(strategy.Skip),
+		}
+		return nil
+	}
+}
+
+// countryStrategy returns the strategy for the user's country, or nil if
+// the country is unknown or has none.
+func (k *kindling) countryStrategy() *CountryStrategy {
+	code := k.country.Load()
+	if code == nil {
+		return nil
+	}
+	s, ok := k.countryStrategies[*code]
+	if !ok {
+		s, ok = defaultCountryStrategies[*code]
+	}
+	if !ok {
+		return nil
+	}
+	return &s
+}
+
+// skip drops the transports s skips, unless that would leave none.
+func (s *CountryStrategy) skip(transports []Transport) []Transport {
+	if s == nil || len(s.Skip) == 0 {
+		return transports
+	}
+	kept := slices.DeleteFunc(slices.Clone(transports), func(tr Transport) bool {
+		return slices.Contains(s.Skip, tr.Name())
+	})
+	if len(kept) == 0 {
+		return transports
+	}
+	return kept
+}
+
+// prefer moves the transports s prefers out of tiers into a tier of their
+// own, raced first.
+func (s *CountryStrategy) prefer(tiers [][]Transport) [][]Transport {
+	if s == nil || len(s.Prefer) == 0 {
+		return tiers
+	}
+	var first []Transport
+	rest := make([][]Transport, 0, len(tiers)+1)
+	for _, tier := range tiers {
+		var others []Transport
+		for _, tr := range tier {
+			if slices.Contains(s.Prefer, tr.Name()) {
+				first = append(first, tr)
+			} else {
+				others = append(others, tr)
+			}
+		}
+		if len(others) > 0 {
+			rest = append(rest, others)
+		}
+	}
+	if len(first) == 0 {
+		return tiers
+	}
+	return append([][]Transport{first}, rest...)
+}
+
+// countryCode validates and normalizes an ISO 3166-1 alpha-2 code.
+func countryCode(iso2 string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(iso2))
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return "", fmt.Errorf("invalid country code %q", iso2)
+	}
+	return code, nil
+}
+
+// parseCountry extracts the country code from a geo endpoint's response.
+func parseCountry(body []byte) (string, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 2 {
+		return countryCode(string(body))
+	}
+	var fields map[string]any
+	if json.Unmarshal(body, &fields) == nil {
+		for _, key := range []string{"country", "country_code", "countryCode"} {
+			if v, ok := fields[key].(string); ok {
+				return countryCode(v)
+			}
+		}
+		return "", fmt.Errorf("no country in geo response")
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "loc="); ok {
+			return countryCode(v)
+		}
+	}
+	return "", fmt.Errorf("no country in geo response")
+}
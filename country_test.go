@@ -0,0 +1,136 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCountry(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{"Bare", "ir\n", "IR"},
+		{"JSON", `{"ip":"192.0.2.1","country":"CN"}`, "CN"},
+		{"JSONCountryCode", `{"country_code":"ru"}`, "RU"},
+		{"Trace", "fl=123\nip=192.0.2.1\nloc=TM\ntls=TLSv1.3\n", "TM"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseCountry([]byte(tc.body))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	for _, body := range []string{"", "Iran", `{"city":"Tehran"}`, `{"country":"Iran"}`, "loc=1"} {
+		_, err := parseCountry([]byte(body))
+		assert.Error(t, err, body)
+	}
+}
+
+func TestCountryStrategy(t *testing.T) {
+	t.Parallel()
+
+	transports := []Transport{bareTransport{"dnstt"}, bareTransport{"amp"}, bareTransport{"smart"}}
+	s := &CountryStrategy{Prefer: []string{"amp"}, Skip: []string{"dnstt"}}
+
+	assert.Equal(t, []string{"amp", "smart"}, names(s.skip(transports)))
+	assert.Equal(t, []string{"dnstt"}, names(s.skip(transports[:1])), "skipped transports are kept if nothing else is left")
+
+	tiers := s.prefer(groupByPriority(transports))
+	require.Len(t, tiers, 2)
+	assert.Equal(t, []string{"amp"}, names(tiers[0]))
+	assert.Equal(t, []string{"dnstt", "smart"}, names(tiers[1]))
+
+	var none *CountryStrategy
+	assert.Len(t, none.skip(transports), 3)
+	assert.Len(t, none.prefer(groupByPriority(transports)), 1)
+}
+
+func TestWithCountryHint(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithCountryHint("Iran"))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithCountryStrategy("", CountryStrategy{}))
+		assert.Error(t, err)
+	})
+
+	t.Run("Strategies", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{}
+		assert.Nil(t, k.countryStrategy(), "no country")
+		require.NoError(t, WithCountryHint("de")(k))
+		assert.Nil(t, k.countryStrategy(), "no strategy for the country")
+		require.NoError(t, WithCountryHint("ir")(k))
+		assert.Equal(t, defaultCountryStrategies["IR"], *k.countryStrategy())
+		require.NoError(t, WithCountryStrategy("IR", CountryStrategy{Skip: []string{"amp"}})(k))
+		assert.Equal(t, CountryStrategy{Skip: []string{"amp"}}, *k.countryStrategy())
+	})
+
+	t.Run("Race", func(t *testing.T) {
+		t.Parallel()
+		var hits [3]atomic.Int32
+		transports := make([]Option, 3)
+		for i, name := range []string{"dnstt", "amp", "smart"} {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits[i].Add(1)
+				io.WriteString(w, name)
+			}))
+			t.Cleanup(srv.Close)
+			transports[i] = WithTransport(redirectTransport(name, srv.URL))
+		}
+		k, err := NewKindling("test", append(transports, WithCountryHint("IR"))...)
+		require.NoError(t, err)
+		defer k.Close()
+
+		resp, err := k.NewHTTPClient().Get("http://origin.test/")
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "amp", string(body))
+		assert.EqualValues(t, 0, hits[0].Load(), "dnstt is skipped in IR")
+		assert.EqualValues(t, 0, hits[2].Load(), "amp races first in IR")
+	})
+}
+
+func TestWithCountryDetection(t *testing.T) {
+	t.Parallel()
+
+	geo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"country":"cn"}`)
+	}))
+	t.Cleanup(geo.Close)
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+
+	ki, err := NewKindling("test", WithTransport(direct), WithCountryHint("IR"), WithCountryDetection(geo.URL))
+	require.NoError(t, err)
+	defer ki.Close()
+	k := ki.(*kindling)
+	require.Eventually(t, func() bool {
+		return *k.country.Load() == "CN"
+	}, 5*time.Second, 10*time.Millisecond, "detection overrides the hint")
+	assert.Equal(t, defaultCountryStrategies["CN"], *k.countryStrategy())
+
+	_, err = NewKindling("test", WithCountryDetection(""))
+	assert.Error(t, err)
+}
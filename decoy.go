@@ -0,0 +1,93 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// decoyTimeout bounds one decoy request.
+const decoyTimeout = 30 * time.Second
+
+// WithDecoyTraffic fetches one of urls, at random, through an idle transport
+// every interval on average, once NewKindling returns, so genuine
+// control-plane fetches don't stand out as a transport's only traffic.
+// Gaps between decoys are drawn at random around interval rather than
+// ticking like a clock, and a transport that has carried real traffic since
+// the last decoy is left alone. urls should be ordinary sites, ideally ones
+// popular where users are; decoys carry the WithHeaderProfile headers, if
+// any, and never the identification headers of the HeaderPolicy. Their
+// failures don't count against a transport (see WithCircuitBreaker), so a
+// blocked decoy site can't quarantine it.
+func WithDecoyTraffic(interval time.Duration, urls ...string) Option {
+	return func(k *kindling) error {
+		if interval <= 0 {
+			return fmt.Errorf("decoy interval must be positive")
+		}
+		if len(urls) == 0 {
+			return fmt.Errorf("no decoy urls given")
+		}
+		for _, u := range urls {
+			if _, err := http.NewRequest(http.MethodGet, u, nil); err != nil {
+				return fmt.Errorf("invalid decoy url: %w", err)
+			}
+		}
+		k.background = append(k.background, func(ctx context.Context) { k.runDecoys(ctx, interval, urls) })
+		return nil
+	}
+}
+
+// runDecoys sends a decoy request through an idle transport at random
+// intervals averaging interval until ctx is done.
+func (k *kindling) runDecoys(ctx context.Context, interval time.Duration, urls []string) {
+	t := k.newRaceTransport(nil)
+	t.breaker = nil
+	t.headerPolicy = nil
+	if k.headerProfile != 0 {
+		t.headerPolicy = t.headerPolicy.withProfile(k.headerProfile)
+	}
+	seen := make(map[string]int64)
+	for {
+		// Exponential gaps make the decoys a Poisson process, with no
+		// period for a classifier to lock on to.
+		if !sleepContext(ctx, k.clock, time.Duration(rand.ExpFloat64()*float64(interval))) {
+			return
+		}
+		idle := k.quietTransports(seen)
+		if len(idle) == 0 {
+			continue
+		}
+		tr := idle[rand.IntN(len(idle))]
+		url := urls[rand.IntN(len(urls))]
+		reqCtx, cancel := contextWithTimeout(ctx, k.clock, decoyTimeout)
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, url, http.NoBody)
+		if err == nil {
+			res := t.probe(req, tr)
+			k.log.Debug("Sent decoy request", "name", tr.Name(), "url", url, "error", res.Err)
+		}
+		cancel()
+		// The decoy's own bytes aren't real traffic.
+		k.quietTransports(seen)
+	}
+}
+
+// quietTransports returns the transports that have sent and received no
+// bytes since the byte totals recorded in seen, and records their current
+// totals there.
+func (k *kindling) quietTransports(seen map[string]int64) []Transport {
+	var quiet []Transport
+	for _, tr := range k.snapshot() {
+		counts := k.stats.counts(tr.Name())
+		if counts == nil {
+			continue
+		}
+		total := counts.sent.Load() + counts.received.Load()
+		if prev, ok := seen[tr.Name()]; !ok || prev == total {
+			quiet = append(quiet, tr)
+		}
+		seen[tr.Name()] = total
+	}
+	return quiet
+}
@@ -0,0 +1,84 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDecoyTraffic(t *testing.T) {
+	t.Parallel()
+
+	var decoys, identified atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoys.Add(1)
+		if r.Header.Get("X-Kindling-App") != "" {
+			identified.Add(1)
+		}
+		io.WriteString(w, "cover")
+	}))
+	t.Cleanup(origin.Close)
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithDecoyTraffic(0, origin.URL)(&kindling{}))
+		assert.Error(t, WithDecoyTraffic(time.Minute)(&kindling{}))
+		assert.Error(t, WithDecoyTraffic(time.Minute, "://nope")(&kindling{}))
+	})
+
+	t.Run("SendsCoverRequests", func(t *testing.T) {
+		t.Parallel()
+		direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		}}
+		k, err := NewKindling("test",
+			WithLogWriter(io.Discard),
+			WithTransport(direct),
+			WithDecoyTraffic(5*time.Millisecond, origin.URL),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		require.Eventually(t, func() bool { return decoys.Load() >= 3 }, 5*time.Second, 5*time.Millisecond)
+		assert.Zero(t, identified.Load(), "decoys carry no identification headers")
+	})
+
+	t.Run("FailuresDontQuarantine", func(t *testing.T) {
+		t.Parallel()
+		var attempts atomic.Int32
+		broken := &mockTransport{name: "broken", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			attempts.Add(1)
+			return nil, errors.New("blocked")
+		}}
+		k, err := NewKindling("test",
+			WithLogWriter(io.Discard),
+			WithTransport(broken),
+			WithCircuitBreaker(1, time.Hour),
+			WithDecoyTraffic(5*time.Millisecond, origin.URL),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		require.Eventually(t, func() bool { return attempts.Load() >= 3 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, TransportEnabled, k.Transports()[0].State)
+	})
+}
+
+func TestQuietTransports(t *testing.T) {
+	t.Parallel()
+
+	a, b := &mockTransport{name: "a"}, &mockTransport{name: "b"}
+	k := &kindling{stats: newTransportStats(), transports: []Transport{a, b}}
+	seen := make(map[string]int64)
+	assert.Equal(t, []Transport{a, b}, k.quietTransports(seen), "never seen counts as quiet")
+
+	k.stats.counts("a").received.Add(100)
+	assert.Equal(t, []Transport{b}, k.quietTransports(seen))
+	assert.Equal(t, []Transport{a, b}, k.quietTransports(seen))
+}
@@ -0,0 +1,85 @@
+package kindling
+
+import (
+	_ "embed"
+	"fmt"
+	"net/url"
+
+	"github.com/getlantern/dnstt"
+)
+
+// DefaultFrontingConfigURL is where NewDefaultKindling keeps its domain
+// fronting config current from.
+const DefaultFrontingConfigURL = "https://raw.githubusercontent.com/getlantern/fronted/refs/heads/main/fronted.yaml.gz"
+
+// DefaultDNSTunnelDomain is the tunnel domain of Lantern's dnstt server,
+// which NewDefaultKindling tunnels to.
+const DefaultDNSTunnelDomain = "t.iantem.io"
+
+// DefaultDNSTunnelResolvers are the public resolvers NewDefaultKindling
+// races its DNS tunnel over.
+var DefaultDNSTunnelResolvers = []string{
+	"https://dns.google/dns-query",
+	"https://cloudflare-dns.com/dns-query",
+	"https://dns.quad9.net/dns-query",
+	"tls://dns.google:853",
+	"tls://one.one.one.one:853",
+}
+
+// defaultFrontingConfig is the fronting config NewDefaultKindling starts
+// from until DefaultFrontingConfigURL has been fetched.
+//
+//go:embed fronted.yaml.gz
+var defaultFrontingConfig []byte
+
+// NewDefaultKindling returns a Kindling with Lantern's transports set up,
+// for apps that want a censorship-resistant client without choosing and
+// configuring transports themselves:
+//
+//   - domain fronting, from a config shipped with kindling and kept current
+//     from DefaultFrontingConfigURL (see WithDomainFrontingConfigURL)
+//   - proxyless smart dialing for domains (see WithProxyless)
+//   - as a last resort, DNS tunneling to DefaultDNSTunnelDomain, raced over
+//     DefaultDNSTunnelResolvers (see WithDNSTunnelResolvers)
+//
+// domains are the origins the app talks to, which the smart dialer tests its
+// strategies against; without any, proxyless dialing is left out. Build with
+// NewKindling to add, drop, or tune transports.
+func NewDefaultKindling(appName string, domains ...string) (Kindling, error) {
+	opts := []Option{
+		WithDomainFrontingConfigURL(DefaultFrontingConfigURL, defaultFrontingConfig),
+		WithDNSTunnelResolvers(newDefaultDNSTunnel, DefaultDNSTunnelResolvers...),
+	}
+	if len(domains) > 0 {
+		opts = append(opts, WithProxyless(domains...))
+	}
+	return NewKindling(appName, opts...)
+}
+
+// newDefaultDNSTunnel builds a tunnel to DefaultDNSTunnelDomain through
+// resolver, a DoH or DoT resolver as accepted by WithDNSTunnelResolvers.
+func newDefaultDNSTunnel(resolver string) (dnstt.DNSTT, error) {
+	return newDNSTunnel(DefaultDNSTunnelDomain, "", resolver)
+}
+
+// newDNSTunnel builds a tunnel to domain through resolver, authenticating
+// the server with publicKey when it's set.
+func newDNSTunnel(domain, publicKey, resolver string) (dnstt.DNSTT, error) {
+	u, err := url.Parse(resolver)
+	if err != nil {
+		return nil, err
+	}
+	opts := []dnstt.Option{dnstt.WithTunnelDomain(domain)}
+	if publicKey != "" {
+		opts = append(opts, dnstt.WithPublicKey(publicKey))
+	}
+	switch u.Scheme {
+	case "https":
+		opts = append(opts, dnstt.WithDoH(resolver))
+	case "tls":
+		opts = append(opts, dnstt.WithDoT(u.Host))
+	default:
+		return nil, fmt.Errorf("dnstt can't tunnel through %q resolvers", u.Scheme)
+	}
+	return dnstt.NewDNSTT(opts...)
+}
@@ -0,0 +1,51 @@
+package kindling
+
+import (
+	"io"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/getlantern/domainfront"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Not parallel: swaps newSmartDialerFn.
+func TestNewDefaultKindling(t *testing.T) {
+	var probed []string
+	orig := newSmartDialerFn
+	newSmartDialerFn = func(_ io.Writer, _ []byte, _ transport.StreamDialer, _ transport.PacketDialer, domains ...string) (transport.StreamDialer, error) {
+		probed = domains
+		return &transport.TCPDialer{}, nil
+	}
+	t.Cleanup(func() { newSmartDialerFn = orig })
+
+	k, err := NewDefaultKindling("test", "example.com")
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	assert.Equal(t, []string{"domainfront", "dnstt", "smart"}, transportNames(k))
+	assert.Equal(t, []string{"example.com"}, probed)
+
+	k, err = NewDefaultKindling("test")
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	assert.Equal(t, []string{"domainfront", "dnstt"}, transportNames(k))
+}
+
+func TestDefaultFrontingConfig(t *testing.T) {
+	t.Parallel()
+	_, err := domainfront.ParseConfig(defaultFrontingConfig)
+	assert.NoError(t, err)
+}
+
+func TestNewDefaultDNSTunnel(t *testing.T) {
+	t.Parallel()
+	for _, r := range DefaultDNSTunnelResolvers {
+		tunnel, err := newDefaultDNSTunnel(r)
+		if assert.NoError(t, err, r) {
+			tunnel.Close()
+		}
+	}
+	_, err := newDefaultDNSTunnel("udp://9.9.9.9:53")
+	assert.Error(t, err)
+}
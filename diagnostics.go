@@ -0,0 +1,187 @@
+package kindling
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxRecentAttempts is how many transport outcomes DumpDiagnostics reports.
+const maxRecentAttempts = 100
+
+// maxSmartDialerLog is how much of the smart dialer's strategy search
+// output DumpDiagnostics reports.
+const maxSmartDialerLog = 16 << 10
+
+// diagnostics is the report DumpDiagnostics writes.
+type diagnostics struct {
+	Time        time.Time       `json:"time"`
+	App         string          `json:"app"`
+	GoVersion   string          `json:"goVersion"`
+	OS          string          `json:"os"`
+	Arch        string          `json:"arch"`
+	Country     string          `json:"country,omitempty"`
+	DoHResolver string          `json:"dohResolver,omitempty"`
+	Transports  []TransportInfo `json:"transports"`
+	Attempts    []attemptRecord `json:"recentAttempts"`
+	SmartDialer string          `json:"smartDialerLog,omitempty"`
+	Interfaces  []interfaceInfo `json:"interfaces"`
+}
+
+// attemptRecord is one transport outcome in a race.
+type attemptRecord struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestID,omitempty"`
+	Transport string    `json:"transport"`
+	// Phase and Error are empty for a success.
+	Phase string `json:"phase,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// interfaceInfo describes a network interface without its addresses.
+type interfaceInfo struct {
+	Name  string `json:"name"`
+	Flags string `json:"flags"`
+	MTU   int    `json:"mtu"`
+	IPv4  int    `json:"ipv4Addrs"`
+	IPv6  int    `json:"ipv6Addrs"`
+}
+
+// DumpDiagnostics writes a JSON report for support teams triaging
+// connection problems: the transports and their state, the outcomes of the
+// most recent attempts, what the smart dialer's strategy search logged, and
+// the device's network interfaces. Credentials and query strings are
+// stripped from URLs, and interface addresses are only counted, so the
+// report can be attached to a ticket.
+func (k *kindling) DumpDiagnostics(w io.Writer) error {
+	d := diagnostics{
+		Time:       time.Now().UTC(),
+		App:        k.appName,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Transports: k.Transports(),
+		Attempts:   k.stats.recentAttempts(),
+		Interfaces: interfaces(),
+	}
+	if code := k.country.Load(); code != nil {
+		d.Country = *code
+	}
+	if k.dohURL != nil {
+		d.DoHResolver = redact(k.dohURL.String())
+	}
+	if k.smartLog != nil {
+		d.SmartDialer = redact(k.smartLog.String())
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return fmt.Errorf("writing diagnostics: %w", err)
+	}
+	return nil
+}
+
+// attempt records a transport outcome; err is nil for a success.
+func (s *transportStats) attempt(requestID, name, phase string, err error) {
+	if s == nil {
+		return
+	}
+	r := attemptRecord{Time: time.Now().UTC(), RequestID: requestID, Transport: name, Phase: phase}
+	if err != nil {
+		r.Error = redact(err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = append(s.recent, r)
+	if len(s.recent) > maxRecentAttempts {
+		s.recent = slices.Delete(s.recent, 0, len(s.recent)-maxRecentAttempts)
+	}
+}
+
+func (s *transportStats) recentAttempts() []attemptRecord {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.recent)
+}
+
+func interfaces() []interfaceInfo {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	infos := make([]interfaceInfo, 0, len(ifaces))
+	for _, iface := range ifaces {
+		info := interfaceInfo{Name: iface.Name, Flags: iface.Flags.String(), MTU: iface.MTU}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			switch {
+			case !ok:
+			case ipnet.IP.To4() != nil:
+				info.IPv4++
+			default:
+				info.IPv6++
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// urlPattern matches URLs, leaving out trailing punctuation such as the
+// colon in Go's "Get https://...: error" messages.
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]*[^\s"'<>:,.;)]`)
+
+// redact strips credentials, query strings, and fragments from the URLs in
+// s.
+func redact(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, func(raw string) string {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return "[url]"
+		}
+		if u.User != nil {
+			u.User = url.User("redacted")
+		}
+		u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = "", false, "", ""
+		return u.String()
+	})
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if over := b.buf.Len() - b.max; over > 0 {
+		b.buf.Next(over)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,163 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// streamDialerOf returns the dialer a transport can carry raw TCP streams
+// with, or nil if it only speaks HTTP (domain fronting, AMP, DNS tunneling).
+// Custom transports opt in by implementing transport.StreamDialer.
+func streamDialerOf(tr Transport) transport.StreamDialer {
+	if nt, ok := tr.(*namedTransport); ok {
+		return nt.dialer
+	}
+	if d, ok := tr.(transport.StreamDialer); ok {
+		return d
+	}
+	return nil
+}
+
+// packetDialerOf returns the dialer a transport can carry UDP datagrams
+// with, or nil if it can't (most can't). Custom transports opt in by
+// implementing transport.PacketDialer.
+func packetDialerOf(tr Transport) transport.PacketDialer {
+	if nt, ok := tr.(*namedTransport); ok {
+		return nt.packetDialer
+	}
+	if d, ok := tr.(transport.PacketDialer); ok {
+		return d
+	}
+	return nil
+}
+
+var (
+	_ transport.StreamDialer = (*kindling)(nil)
+	_ transport.PacketDialer = (*kindling)(nil)
+)
+
+// DialStream connects to addr through the stream-capable transports, making
+// kindling a transport.StreamDialer that other Outline SDK dialers can be
+// layered over. Like HTTP requests, it dials every transport in a priority
+// tier in parallel and moves on to the next tier only when the whole tier
+// fails; the first connection wins and any later ones are closed.
+func (k *kindling) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	capable := func(tr Transport) bool { return streamDialerOf(tr) != nil }
+	return dialRace(ctx, k, addr, "stream-capable", "TCP streams", capable, func(ctx context.Context, tr Transport, addr string) (transport.StreamConn, error) {
+		return streamDialerOf(tr).DialStream(ctx, addr)
+	})
+}
+
+// DialPacket opens a UDP association with addr through the packet-capable
+// transports (WireGuard, TURN, and custom transports implementing
+// transport.PacketDialer), making kindling a transport.PacketDialer so
+// UDP-based protocols such as QUIC or WebRTC signaling can ride it too.
+// Transports are raced as DialStream races them, although for most a
+// datagram association is up once the tunnel is, before any packet has
+// reached addr.
+func (k *kindling) DialPacket(ctx context.Context, addr string) (net.Conn, error) {
+	capable := func(tr Transport) bool { return packetDialerOf(tr) != nil }
+	return dialRace(ctx, k, addr, "packet-capable", "UDP packets", capable, func(ctx context.Context, tr Transport, addr string) (net.Conn, error) {
+		return packetDialerOf(tr).DialPacket(ctx, addr)
+	})
+}
+
+// dialRace connects to addr with dial through the transports capable
+// reports, tier by tier. kind and carries describe those transports for
+// errors.
+func dialRace[C io.Closer](ctx context.Context, k *kindling, addr, kind, carries string, capable func(Transport) bool, dial func(context.Context, Transport, string) (C, error)) (C, error) {
+	var none C
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return none, err
+	}
+	if err := k.hostFilter.check(host); err != nil {
+		return none, err
+	}
+	allowed, domain, hasPolicy := policyFor(k.domainPolicy, host)
+	k.mu.Lock()
+	var eligible []Transport
+	for _, tr := range k.transports {
+		if capable(tr) && (!hasPolicy || slices.Contains(allowed, tr.Name())) {
+			eligible = append(eligible, tr)
+		}
+	}
+	k.mu.Unlock()
+	if len(eligible) == 0 {
+		if hasPolicy {
+			return none, fmt.Errorf("domain policy for %q allows no %s transport", domain, kind)
+		}
+		return none, fmt.Errorf("no configured transport can carry %s", carries)
+	}
+	if eligible, err = applyTransportHint(ctx, eligible); err != nil {
+		return none, err
+	}
+	addr, _ = k.hostMapping.route(ctx, addr)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer stopOnClose(k.ctx, cancel)()
+
+	var failures []AttemptError
+	for _, tier := range groupByPriority(eligible) {
+		conn, errs := dialTier(ctx, tier, addr, dial)
+		if errs == nil {
+			return conn, nil
+		}
+		failures = append(failures, errs...)
+		if ctx.Err() != nil {
+			return none, &RaceError{Err: ctx.Err(), attempts: failures}
+		}
+	}
+	last := &failures[len(failures)-1]
+	return none, &RaceError{Err: fmt.Errorf("all transports failed to connect to %s: %w", addr, last), attempts: failures}
+}
+
+// dialTier races a connection to addr across tier, returning the winner or
+// else every transport's failure.
+func dialTier[C io.Closer](ctx context.Context, tier []Transport, addr string, dial func(context.Context, Transport, string) (C, error)) (C, []AttemptError) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn C
+		err  *AttemptError
+	}
+	results := make(chan result, len(tier))
+	for _, tr := range tier {
+		go func() {
+			conn, err := dial(ctx, tr, addr)
+			if err != nil {
+				results <- result{err: &AttemptError{Transport: tr.Name(), Phase: PhaseConnect, Err: err}}
+				return
+			}
+			results <- result{conn: conn}
+		}()
+	}
+
+	var errs []AttemptError
+	for i := range tier {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, *r.err)
+			continue
+		}
+		// Close the losers that still manage to connect after the winner.
+		if remaining := len(tier) - i - 1; remaining > 0 {
+			go func() {
+				for range remaining {
+					if late := <-results; late.err == nil {
+						late.conn.Close()
+					}
+				}
+			}()
+		}
+		return r.conn, nil
+	}
+	var none C
+	return none, errs
+}
@@ -0,0 +1,245 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialStream(t *testing.T) {
+	t.Parallel()
+
+	echo := serveEcho(t)
+	failing := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		return nil, errors.New("blocked")
+	})
+
+	t.Run("SkipsHTTPOnlyTransports", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{
+			&namedTransport{name: "http-only"},
+			newStreamTransport("stream", &transport.TCPDialer{}),
+		}}
+		conn, err := k.DialStream(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("SurvivesReplaceTransport", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{newStreamTransport("stream", &transport.TCPDialer{})}}
+		require.NoError(t, k.ReplaceTransport("stream", func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return &dummyRoundTripper{}, nil
+		}))
+		assert.True(t, reusable(k.transports[0]))
+		conn, err := k.DialStream(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("NoStreamTransports", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{&namedTransport{name: "http-only"}}}
+		_, err := k.DialStream(context.Background(), echo)
+		assert.ErrorContains(t, err, "no configured transport")
+	})
+
+	t.Run("FallsBackToLaterTier", func(t *testing.T) {
+		t.Parallel()
+		fallback := newStreamTransport("fallback", &transport.TCPDialer{})
+		fallback.priority = priorityFallback
+		k := &kindling{transports: []Transport{
+			newStreamTransport("blocked", failing),
+			fallback,
+		}}
+		conn, err := k.DialStream(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("AllFail_JoinsErrors", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{newStreamTransport("blocked", failing)}}
+		_, err := k.DialStream(context.Background(), echo)
+		assert.ErrorContains(t, err, "blocked: blocked")
+	})
+
+	t.Run("ComposesWithOutlineSDK", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(newStreamTransport("stream", &transport.TCPDialer{})))
+		require.NoError(t, err)
+		defer k.Close()
+		endpoint := &transport.StreamDialerEndpoint{Dialer: k, Address: echo}
+		conn, err := endpoint.ConnectStream(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("ClosesLateLosers", func(t *testing.T) {
+		t.Parallel()
+		var closed atomic.Bool
+		slow := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			time.Sleep(50 * time.Millisecond)
+			conn, err := (&transport.TCPDialer{}).DialStream(context.Background(), addr)
+			if err != nil {
+				return nil, err
+			}
+			return &closeTrackingConn{StreamConn: conn, closed: &closed}, nil
+		})
+		k := &kindling{transports: []Transport{
+			newStreamTransport("fast", &transport.TCPDialer{}),
+			newStreamTransport("slow", slow),
+		}}
+		conn, err := k.DialStream(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Eventually(t, closed.Load, 2*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestDialPacket(t *testing.T) {
+	t.Parallel()
+
+	echo := serveUDPEcho(t)
+	udp := func(name string) *namedTransport {
+		return newStreamTransport(name, streamAndPacketDialer{&transport.TCPDialer{}, &transport.UDPDialer{}})
+	}
+
+	t.Run("SkipsStreamOnlyTransports", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{
+			newStreamTransport("stream-only", &transport.TCPDialer{}),
+			udp("packets"),
+		}}
+		conn, err := k.DialPacket(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("NoPacketTransports", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{newStreamTransport("stream-only", &transport.TCPDialer{})}}
+		_, err := k.DialPacket(context.Background(), echo)
+		assert.ErrorContains(t, err, "no configured transport can carry UDP packets")
+	})
+
+	t.Run("DomainPolicy", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{
+			transports:   []Transport{udp("packets")},
+			domainPolicy: map[string][]string{"127.0.0.1": {"other"}},
+		}
+		_, err := k.DialPacket(context.Background(), echo)
+		assert.ErrorContains(t, err, "allows no packet-capable transport")
+	})
+
+	t.Run("FallsBackToLaterTier", func(t *testing.T) {
+		t.Parallel()
+		fallback := udp("fallback")
+		fallback.priority = priorityFallback
+		k := &kindling{transports: []Transport{
+			&namedTransport{name: "blocked", packetDialer: transport.FuncPacketDialer(func(context.Context, string) (net.Conn, error) {
+				return nil, errors.New("blocked")
+			})},
+			fallback,
+		}}
+		conn, err := k.DialPacket(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("ComposesWithOutlineSDK", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(udp("packets")))
+		require.NoError(t, err)
+		defer k.Close()
+		endpoint := &transport.PacketDialerEndpoint{Dialer: k, Address: echo}
+		conn, err := endpoint.ConnectPacket(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+}
+
+// streamAndPacketDialer carries both streams and datagrams, as a tunnel
+// might.
+type streamAndPacketDialer struct {
+	*transport.TCPDialer
+	*transport.UDPDialer
+}
+
+type closeTrackingConn struct {
+	transport.StreamConn
+	closed *atomic.Bool
+}
+
+func (c *closeTrackingConn) Close() error {
+	c.closed.Store(true)
+	return c.StreamConn.Close()
+}
+
+// serveEcho starts a TCP server that echoes whatever it reads.
+func serveEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func assertEchoes(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+// serveUDPEcho starts a UDP server that echoes each datagram back to its
+// sender.
+func serveUDPEcho(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
@@ -0,0 +1,81 @@
+package kindling
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// WithDialerControl runs fn on every socket kindling's default dialers open,
+// before it connects: the smart dialer's TCP connections and UDP probes, the
+// first hop of kindling's own tunnels (WithShadowsocks, WithUpstreamProxy, ...), DoH
+// and system DNS lookups, and config and country fetches. Android apps built
+// on VpnService pass a function that calls VpnService.protect on the socket's
+// file descriptor, so kindling's traffic leaves through the real network
+// rather than looping back into the app's own tunnel. An error from fn fails
+// the dial.
+//
+// fn isn't run on sockets kindling doesn't open itself. Those of a
+// WithStreamDialer or WithPacketDialer override, of the clients passed to
+// WithDomainFronting, WithDNSTunnel, and WithAMPCache, and of the Tor and
+// Psiphon libraries must be protected when they're built.
+func WithDialerControl(fn func(network, address string, c syscall.RawConn) error) Option {
+	return func(k *kindling) error {
+		if fn == nil {
+			return fmt.Errorf("dialer control is nil")
+		}
+		k.dialerControl = fn
+		return nil
+	}
+}
+
+// customNetDialer reports whether any option changes the net.Dialer behind
+// the default dialers.
+func (k *kindling) customNetDialer() bool {
+	return k.dialerControl != nil || k.iface != "" || k.localAddr.IsValid() || k.socketOptions != nil || k.fwmark != 0
+}
+
+// netDialer returns the net.Dialer behind the default dialers, for network
+// "tcp" or "udp" or one of their variants.
+func (k *kindling) netDialer(network string) net.Dialer {
+	d := net.Dialer{Control: k.dialerControl}
+	if !strings.HasPrefix(network, "udp") {
+		d.KeepAliveConfig = tcpKeepAlive(k.keepAlive)
+	}
+	if k.localAddr.IsValid() {
+		local := netip.AddrPortFrom(k.localAddr, 0)
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = net.UDPAddrFromAddrPort(local)
+		} else {
+			d.LocalAddr = net.TCPAddrFromAddrPort(local)
+		}
+	}
+	if iface, control := k.iface, k.dialerControl; iface != "" {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			if err := bindToInterface(network, c, iface); err != nil {
+				return fmt.Errorf("binding to interface %s: %w", iface, err)
+			}
+			if control != nil {
+				return control(network, address, c)
+			}
+			return nil
+		}
+	}
+	if mark, next := k.fwmark, d.Control; mark != 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			if err := setMark(c, mark); err != nil {
+				return fmt.Errorf("setting fwmark %d: %w", mark, err)
+			}
+			if next != nil {
+				return next(network, address, c)
+			}
+			return nil
+		}
+	}
+	if k.socketOptions != nil {
+		k.socketOptions.applyToDialer(&d, network)
+	}
+	return d
+}
@@ -0,0 +1,102 @@
+package kindling
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// controlRecorder is a dialer control that records the addresses it saw and
+// fails with err.
+type controlRecorder struct {
+	err   error
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (r *controlRecorder) control(network, address string, _ syscall.RawConn) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs = append(r.addrs, network+" "+address)
+	return r.err
+}
+
+func (r *controlRecorder) seen() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.addrs...)
+}
+
+func TestWithDialerControl(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithDialerControl(nil)(&kindling{}))
+	})
+
+	t.Run("StreamDialer", func(t *testing.T) {
+		t.Parallel()
+		addr := closingListener(t, "127.0.0.1:0")
+
+		rec := &controlRecorder{}
+		k := &kindling{}
+		require.NoError(t, WithDialerControl(rec.control)(k))
+		conn, err := k.baseStreamDialer().DialStream(t.Context(), addr)
+		require.NoError(t, err)
+		conn.Close()
+		assert.Equal(t, []string{"tcp4 " + addr}, rec.seen())
+	})
+
+	t.Run("ControlErrorFailsDial", func(t *testing.T) {
+		t.Parallel()
+		rec := &controlRecorder{err: errors.New("protect failed")}
+		k := &kindling{}
+		require.NoError(t, WithDialerControl(rec.control)(k))
+		_, err := k.baseStreamDialer().DialStream(t.Context(), "127.0.0.1:1")
+		assert.ErrorContains(t, err, "protect failed")
+	})
+
+	t.Run("PacketDialer", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{}
+		assert.Nil(t, k.basePacketDialer(), "without control the smart dialer's default is kept")
+
+		rec := &controlRecorder{}
+		require.NoError(t, WithDialerControl(rec.control)(k))
+		conn, err := k.basePacketDialer().DialPacket(t.Context(), "127.0.0.1:53")
+		require.NoError(t, err)
+		conn.Close()
+		assert.Equal(t, []string{"udp4 127.0.0.1:53"}, rec.seen())
+	})
+
+	t.Run("SmartDialerBase", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{}
+		_, stream, err := k.smartDialerBase(nil)
+		require.NoError(t, err)
+		assert.Nil(t, stream)
+
+		rec := &controlRecorder{}
+		require.NoError(t, WithDialerControl(rec.control)(k))
+		_, stream, err = k.smartDialerBase(nil)
+		require.NoError(t, err)
+		// The smart dialer's system DNS needs a *transport.TCPDialer base.
+		require.IsType(t, &transport.TCPDialer{}, stream)
+		assert.NotNil(t, stream.(*transport.TCPDialer).Dialer.Control)
+	})
+
+	t.Run("OverrideNotWrapped", func(t *testing.T) {
+		t.Parallel()
+		stream := stubStreamDialer{}
+		k := &kindling{}
+		require.NoError(t, WithStreamDialer(stream)(k))
+		require.NoError(t, WithDialerControl((&controlRecorder{}).control)(k))
+		assert.Equal(t, stream, k.rawStreamDialer())
+	})
+}
@@ -0,0 +1,142 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSCacheTTL         = 5 * time.Minute
+	defaultDNSCacheNegativeTTL = 30 * time.Second
+	// maxDNSCacheEntries bounds the cache; expired entries are dropped
+	// first when it fills.
+	maxDNSCacheEntries = 1024
+)
+
+// WithDNSCache sets how long hostname lookups made by kindling's own
+// transports are cached: ttl for addresses, negativeTTL for names that don't
+// exist. Failures such as timeouts aren't cached. The cache is shared by
+// every transport, on by default with a five minute ttl and a 30 second
+// negativeTTL, and can be emptied with FlushDNS. A zero ttl disables it.
+func WithDNSCache(ttl, negativeTTL time.Duration) Option {
+	return func(k *kindling) error {
+		if ttl < 0 || negativeTTL < 0 {
+			return fmt.Errorf("dns cache ttls must not be negative")
+		}
+		if ttl == 0 {
+			k.dnsCache = nil
+			return nil
+		}
+		k.dnsCache = newDNSCache(ttl, negativeTTL)
+		return nil
+	}
+}
+
+// FlushDNS empties the DNS cache, along with any cached ECH configs, so the
+// next lookup of every host goes to the resolver again. Use it when cached
+// answers may have been poisoned, or after a network change.
+func (k *kindling) FlushDNS() {
+	k.dnsCache.flush()
+	k.ech.flush()
+}
+
+type dnsCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(ttl, negativeTTL time.Duration) *dnsCache {
+	return &dnsCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]dnsCacheEntry),
+	}
+}
+
+// cachedResolver answers from cache, falling back to next.
+type cachedResolver struct {
+	cache *dnsCache
+	next  hostResolver
+}
+
+func (r *cachedResolver) lookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	if addrs, err, ok := r.cache.get(key); ok {
+		return addrs, err
+	}
+	addrs, err := r.next.lookupIP(ctx, host)
+	r.cache.put(key, addrs, err)
+	return slices.Clone(addrs), err
+}
+
+func (c *dnsCache) get(host string) ([]netip.Addr, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[host]
+	if !ok || !c.now().Before(e.expires) {
+		return nil, nil, false
+	}
+	return slices.Clone(e.addrs), e.err, true
+}
+
+func (c *dnsCache) put(host string, addrs []netip.Addr, err error) {
+	ttl := c.ttl
+	if err != nil || len(addrs) == 0 {
+		if !isNotFound(err) || c.negativeTTL == 0 {
+			return
+		}
+		ttl = c.negativeTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.entries) >= maxDNSCacheEntries {
+		for h, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, h)
+			}
+		}
+		for h := range c.entries {
+			if len(c.entries) < maxDNSCacheEntries {
+				break
+			}
+			delete(c.entries, h)
+		}
+	}
+	c.entries[host] = dnsCacheEntry{addrs: slices.Clone(addrs), err: err, expires: now.Add(ttl)}
+}
+
+// flush drops every entry. A nil cache has none.
+func (c *dnsCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// isNotFound reports whether err says the name doesn't exist, as opposed to
+// the lookup failing.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,133 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingResolver answers from addrs and errs, counting lookups.
+type countingResolver struct {
+	addrs   map[string][]netip.Addr
+	errs    map[string]error
+	lookups atomic.Int32
+}
+
+func (r *countingResolver) lookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	r.lookups.Add(1)
+	if err := r.errs[host]; err != nil {
+		return nil, err
+	}
+	return r.addrs[host], nil
+}
+
+func TestDNSCache(t *testing.T) {
+	t.Parallel()
+
+	addr := netip.MustParseAddr("192.0.2.1")
+	upstream := &countingResolver{
+		addrs: map[string][]netip.Addr{"a.test": {addr}},
+		errs: map[string]error{
+			"missing.test": &net.DNSError{Err: "no such host", Name: "missing.test", IsNotFound: true},
+			"flaky.test":   errors.New("timeout"),
+		},
+	}
+	now := time.Unix(0, 0)
+	cache := newDNSCache(time.Minute, 10*time.Second)
+	cache.now = func() time.Time { return now }
+	r := &cachedResolver{cache: cache, next: upstream}
+	ctx := context.Background()
+
+	for range 3 {
+		addrs, err := r.lookupIP(ctx, "a.test")
+		require.NoError(t, err)
+		assert.Equal(t, []netip.Addr{addr}, addrs)
+	}
+	_, err := r.lookupIP(ctx, "A.test.")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, upstream.lookups.Load(), "answers are cached by normalized name")
+
+	for range 2 {
+		_, err := r.lookupIP(ctx, "missing.test")
+		assert.True(t, isNotFound(err))
+	}
+	assert.EqualValues(t, 2, upstream.lookups.Load(), "missing names are cached")
+
+	for range 2 {
+		_, err := r.lookupIP(ctx, "flaky.test")
+		assert.Error(t, err)
+	}
+	assert.EqualValues(t, 4, upstream.lookups.Load(), "failures aren't cached")
+
+	now = now.Add(10 * time.Second)
+	r.lookupIP(ctx, "missing.test")
+	r.lookupIP(ctx, "a.test")
+	assert.EqualValues(t, 5, upstream.lookups.Load(), "the negative ttl is shorter")
+
+	now = now.Add(time.Minute)
+	r.lookupIP(ctx, "a.test")
+	assert.EqualValues(t, 6, upstream.lookups.Load(), "expired")
+
+	cache.flush()
+	r.lookupIP(ctx, "a.test")
+	assert.EqualValues(t, 7, upstream.lookups.Load(), "flushed")
+
+	for i := range maxDNSCacheEntries + 10 {
+		cache.put(fmt.Sprintf("h%d.test", i), []netip.Addr{addr}, nil)
+	}
+	assert.Len(t, cache.entries, maxDNSCacheEntries)
+}
+
+func TestWithDNSCache(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithDNSCache(-time.Second, 0))
+		assert.Error(t, err)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithDNSCache(0, 0))
+		require.NoError(t, err)
+		assert.IsType(t, systemResolver{}, k.(*kindling).hostResolver())
+		k.FlushDNS()
+	})
+
+	t.Run("FlushDNS", func(t *testing.T) {
+		t.Parallel()
+		ki, err := NewKindling("test")
+		require.NoError(t, err)
+		k := ki.(*kindling)
+		upstream := &countingResolver{addrs: map[string][]netip.Addr{"a.test": {netip.MustParseAddr("192.0.2.1")}}}
+		r := &cachedResolver{cache: k.dnsCache, next: upstream}
+		r.lookupIP(t.Context(), "a.test")
+		r.lookupIP(t.Context(), "a.test")
+		ki.FlushDNS()
+		r.lookupIP(t.Context(), "a.test")
+		assert.EqualValues(t, 2, upstream.lookups.Load())
+	})
+
+	t.Run("CustomStreamDialerGetsHostnames", func(t *testing.T) {
+		t.Parallel()
+		var dialed string
+		stream := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			dialed = addr
+			return nil, errors.New("refused")
+		})
+		k, err := NewKindling("test", WithStreamDialer(stream))
+		require.NoError(t, err)
+		k.(*kindling).baseStreamDialer().DialStream(t.Context(), "proxy.test:443")
+		assert.Equal(t, "proxy.test:443", dialed)
+	})
+}
@@ -0,0 +1,265 @@
+package kindling
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/getlantern/dnstt"
+)
+
+// WithDNSTunnelResolvers adds a DNS tunnel that races several recursive
+// resolvers instead of depending on one that may be blocked. Each resolver
+// is "https://host/path" for DNS-over-HTTPS, "tls://host:port" for
+// DNS-over-TLS, or "udp://host:port" for plain DNS, and newTunnel builds a
+// dnstt.DNSTT that reaches the tunnel server through it, e.g. with
+// dnstt.WithDoH or dnstt.WithDoT. Tunnels are built on first use and closed
+// with the Kindling.
+//
+// A request through the tunnel goes out over every resolver at once, and
+// the resolver that answers first carries the requests after it until one
+// fails, when the race runs again. Requests that aren't safe to repeat try
+// the resolvers one at a time instead. Like WithDNSTunnel, the tunnel is
+// raced only as a last resort.
+func WithDNSTunnelResolvers(newTunnel func(resolver string) (dnstt.DNSTT, error), resolvers ...string) Option {
+	return func(k *kindling) error {
+		if newTunnel == nil {
+			return fmt.Errorf("dns tunnel constructor is nil")
+		}
+		if len(resolvers) == 0 {
+			return fmt.Errorf("no dns tunnel resolvers")
+		}
+		for i, r := range resolvers {
+			if err := validateTunnelResolver(r); err != nil {
+				return err
+			}
+			if slices.Contains(resolvers[:i], r) {
+				return fmt.Errorf("duplicate dns tunnel resolver %q", r)
+			}
+		}
+		rt := &resolverTunnels{
+			resolvers: slices.Clone(resolvers),
+			newTunnel: newTunnel,
+			onClose:   k.onClose,
+			log:       k.log,
+			tunnels:   make(map[string]*lazyTunnel, len(resolvers)),
+		}
+		for _, r := range resolvers {
+			rt.tunnels[r] = &lazyTunnel{}
+		}
+		k.transports = append(k.transports, &namedTransport{
+			name:         string(TransportDNSTunnel),
+			isStreamable: true,
+			newRT: func(context.Context, string) (http.RoundTripper, error) {
+				return rt, nil
+			},
+			priority: priorityLastResort,
+		})
+		return nil
+	}
+}
+
+func validateTunnelResolver(resolver string) error {
+	u, err := url.Parse(resolver)
+	if err != nil {
+		return fmt.Errorf("parsing dns tunnel resolver: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return fmt.Errorf("dns tunnel resolver %q has no host", resolver)
+		}
+	case "tls", "udp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return fmt.Errorf("dns tunnel resolver %q needs a host and port", resolver)
+		}
+	default:
+		return fmt.Errorf("unsupported dns tunnel resolver scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// resolverTunnels sends requests through a DNS tunnel per resolver,
+// sticking with the resolver that last answered first.
+type resolverTunnels struct {
+	resolvers []string
+	newTunnel func(resolver string) (dnstt.DNSTT, error)
+	onClose   func(io.Closer) error
+	log       *slog.Logger
+	tunnels   map[string]*lazyTunnel
+
+	mu   sync.Mutex
+	best string
+}
+
+// lazyTunnel is the tunnel through one resolver, built on first use.
+type lazyTunnel struct {
+	mu     sync.Mutex
+	tunnel dnstt.DNSTT
+}
+
+// RoundTrip implements http.RoundTripper. Every attempt gets its own copy
+// of the body from GetBody; a body without one allows a single attempt.
+func (rt *resolverTunnels) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	best := rt.best
+	rt.mu.Unlock()
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return rt.send(req, cmp.Or(best, rt.resolvers[0]))
+		}
+		req.Body.Close()
+	}
+	if best != "" {
+		resp, err := rt.sendCopy(req, best)
+		if err == nil {
+			return resp, nil
+		}
+		rt.forget(best)
+		rt.log.Debug("DNS tunnel resolver failed, trying the others", "resolver", best, "error", err)
+	}
+	others := slices.DeleteFunc(slices.Clone(rt.resolvers), func(r string) bool { return r == best })
+	if len(others) == 0 {
+		return nil, fmt.Errorf("dns tunnel via %s failed", best)
+	}
+	if isRetryableMethod(req.Method) || req.Header.Get(IdempotentHeader) != "" {
+		return rt.race(req, others)
+	}
+	return rt.serial(req, others)
+}
+
+// race sends req through every resolver at once and returns the first
+// response, cancelling the rest.
+func (rt *resolverTunnels) race(req *http.Request, resolvers []string) (*http.Response, error) {
+	type result struct {
+		resolver string
+		resp     *http.Response
+		err      error
+		cancel   context.CancelFunc
+	}
+	results := make(chan result, len(resolvers))
+	for _, r := range resolvers {
+		ctx, cancel := context.WithCancel(req.Context())
+		go func() {
+			resp, err := rt.sendCopy(req.WithContext(ctx), r)
+			if err != nil {
+				cancel()
+			}
+			results <- result{r, resp, err, cancel}
+		}()
+	}
+
+	var errs []error
+	for i := range resolvers {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.resolver, res.err))
+			continue
+		}
+		rt.choose(res.resolver)
+		rt.log.Debug("DNS tunnel resolver won the race", "resolver", res.resolver)
+		res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: res.cancel}
+		// Release whatever the losers produce.
+		go func() {
+			for range len(resolvers) - i - 1 {
+				late := <-results
+				if late.err == nil {
+					late.resp.Body.Close()
+					late.cancel()
+				}
+			}
+		}()
+		return res.resp, nil
+	}
+	return nil, fmt.Errorf("dns tunnel failed on every resolver: %w", errors.Join(errs...))
+}
+
+// serial tries the resolvers in order, for requests that mustn't be sent
+// twice at once.
+func (rt *resolverTunnels) serial(req *http.Request, resolvers []string) (*http.Response, error) {
+	var errs []error
+	for _, r := range resolvers {
+		resp, err := rt.sendCopy(req, r)
+		if err == nil {
+			rt.choose(r)
+			return resp, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", r, err))
+	}
+	return nil, fmt.Errorf("dns tunnel failed on every resolver: %w", errors.Join(errs...))
+}
+
+// sendCopy sends a copy of req, with a fresh body from GetBody, through the
+// tunnel for resolver.
+func (rt *resolverTunnels) sendCopy(req *http.Request, resolver string) (*http.Response, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return rt.send(clone, resolver)
+}
+
+// send sends req through the tunnel for resolver, building it if need be.
+func (rt *resolverTunnels) send(req *http.Request, resolver string) (*http.Response, error) {
+	tunnel, err := rt.tunnel(resolver)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := tunnel.NewRoundTripper(req.Context(), hostWithPort(req.URL.Host, req.URL.Scheme))
+	if err != nil {
+		return nil, err
+	}
+	return inner.RoundTrip(req)
+}
+
+// tunnel returns the tunnel through resolver, building it on first use.
+// Each resolver builds on its own, so one whose constructor dials a blocked
+// server doesn't hold up the others.
+func (rt *resolverTunnels) tunnel(resolver string) (dnstt.DNSTT, error) {
+	lt := rt.tunnels[resolver]
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.tunnel != nil {
+		return lt.tunnel, nil
+	}
+	t, err := rt.newTunnel(resolver)
+	if err != nil {
+		return nil, fmt.Errorf("creating dns tunnel: %w", err)
+	}
+	if t == nil {
+		return nil, fmt.Errorf("creating dns tunnel: constructor returned nil")
+	}
+	if err := rt.onClose(t); err != nil {
+		return nil, err
+	}
+	lt.tunnel = t
+	return t, nil
+}
+
+func (rt *resolverTunnels) choose(resolver string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.best = resolver
+}
+
+// forget stops sticking with resolver.
+func (rt *resolverTunnels) forget(resolver string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.best == resolver {
+		rt.best = ""
+	}
+}
@@ -0,0 +1,197 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getlantern/dnstt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTunnel is a dnstt.DNSTT that sends requests straight to the origin
+// after delay, or fails while blocked.
+type fakeTunnel struct {
+	resolver string
+	delay    time.Duration
+	blocked  atomic.Bool
+	closed   atomic.Bool
+	sends    atomic.Int32
+	record   func(resolver string)
+}
+
+func (f *fakeTunnel) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
+	return f, nil
+}
+
+func (f *fakeTunnel) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.sends.Add(1)
+	if f.record != nil {
+		f.record(f.resolver)
+	}
+	select {
+	case <-time.After(f.delay):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if f.blocked.Load() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errors.New("resolver blocked")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (f *fakeTunnel) Close() error {
+	f.closed.Store(true)
+	return nil
+}
+
+func TestWithDNSTunnelResolvers(t *testing.T) {
+	t.Parallel()
+	newTunnel := func(string) (dnstt.DNSTT, error) { return &fakeTunnel{}, nil }
+
+	for name, resolvers := range map[string][]string{
+		"None":       nil,
+		"Scheme":     {"ftp://1.1.1.1"},
+		"NoPort":     {"tls://1.1.1.1"},
+		"QUIC":       {"quic://dns.adguard-dns.com:853"},
+		"NoHost":     {"https:///dns-query"},
+		"Duplicate":  {"udp://8.8.8.8:53", "udp://8.8.8.8:53"},
+		"OneInvalid": {"https://dns.google/dns-query", "udp://8.8.8.8"},
+	} {
+		assert.Error(t, WithDNSTunnelResolvers(newTunnel, resolvers...)(&kindling{}), name)
+	}
+	assert.Error(t, WithDNSTunnelResolvers(nil, "udp://8.8.8.8:53")(&kindling{}))
+
+	k := &kindling{}
+	require.NoError(t, WithDNSTunnelResolvers(newTunnel, "https://dns.google/dns-query", "tls://1.1.1.1:853", "udp://9.9.9.9:53")(k))
+	require.Len(t, k.transports, 1)
+	assert.Equal(t, string(TransportDNSTunnel), k.transports[0].Name())
+	assert.Equal(t, priorityLastResort, priorityOf(k.transports[0]))
+}
+
+func TestResolverTunnels(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(server.Close)
+
+	setup := func(delays map[string]time.Duration) (*resolverTunnels, map[string]*fakeTunnel, func() []string) {
+		var mu sync.Mutex
+		var order []string
+		tunnels := make(map[string]*fakeTunnel)
+		var resolvers []string
+		for _, r := range []string{"udp://a:53", "udp://b:53", "udp://c:53"} {
+			if d, ok := delays[r]; ok {
+				resolvers = append(resolvers, r)
+				tunnels[r] = &fakeTunnel{resolver: r, delay: d, record: func(r string) {
+					mu.Lock()
+					defer mu.Unlock()
+					order = append(order, r)
+				}}
+			}
+		}
+		k := &kindling{log: testLog, ctx: context.Background()}
+		require.NoError(t, WithDNSTunnelResolvers(func(r string) (dnstt.DNSTT, error) {
+			return tunnels[r], nil
+		}, resolvers...)(k))
+		rt, err := k.transports[0].NewRoundTripper(context.Background(), "")
+		require.NoError(t, err)
+		return rt.(*resolverTunnels), tunnels, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), order...)
+		}
+	}
+	get := func(t *testing.T, rt http.RoundTripper) {
+		t.Helper()
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	t.Run("SticksWithFastest", func(t *testing.T) {
+		t.Parallel()
+		rt, tunnels, _ := setup(map[string]time.Duration{
+			"udp://a:53": 200 * time.Millisecond,
+			"udp://b:53": 0,
+		})
+		get(t, rt)
+		get(t, rt)
+		get(t, rt)
+		assert.EqualValues(t, 1, tunnels["udp://a:53"].sends.Load())
+		assert.EqualValues(t, 3, tunnels["udp://b:53"].sends.Load())
+	})
+
+	t.Run("RacesAgainWhenBestFails", func(t *testing.T) {
+		t.Parallel()
+		rt, tunnels, _ := setup(map[string]time.Duration{
+			"udp://a:53": 50 * time.Millisecond,
+			"udp://b:53": 0,
+		})
+		get(t, rt)
+		tunnels["udp://b:53"].blocked.Store(true)
+		get(t, rt)
+		get(t, rt)
+		assert.EqualValues(t, 3, tunnels["udp://a:53"].sends.Load())
+		assert.EqualValues(t, 2, tunnels["udp://b:53"].sends.Load())
+	})
+
+	t.Run("AllFail", func(t *testing.T) {
+		t.Parallel()
+		rt, tunnels, _ := setup(map[string]time.Duration{"udp://a:53": 0, "udp://b:53": 0})
+		for _, tunnel := range tunnels {
+			tunnel.blocked.Store(true)
+		}
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "udp://a:53")
+		assert.Contains(t, err.Error(), "udp://b:53")
+	})
+
+	t.Run("NonIdempotentOneAtATime", func(t *testing.T) {
+		t.Parallel()
+		rt, tunnels, order := setup(map[string]time.Duration{
+			"udp://a:53": 0,
+			"udp://b:53": 0,
+			"udp://c:53": 0,
+		})
+		tunnels["udp://a:53"].blocked.Store(true)
+		req := httptest.NewRequest(http.MethodPost, server.URL, strings.NewReader("payload"))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("payload")), nil }
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "payload", string(body))
+		assert.Equal(t, []string{"udp://a:53", "udp://b:53"}, order())
+	})
+}
+
+func TestResolverTunnels_ClosedWithKindling(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	built := &fakeTunnel{}
+	k, err := NewKindling("test", WithDNSTunnelResolvers(func(string) (dnstt.DNSTT, error) {
+		return built, nil
+	}, "udp://a:53"))
+	require.NoError(t, err)
+	resp, err := k.NewHTTPClient().Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NoError(t, k.Close())
+	assert.True(t, built.closed.Load())
+}
@@ -0,0 +1,269 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dohTimeout bounds a single DNS-over-HTTPS query.
+	dohTimeout = 10 * time.Second
+	// maxDoHResponse caps a DNS-over-HTTPS response body.
+	maxDoHResponse = 64 << 10
+)
+
+// WithDoHResolver resolves hostnames over DNS-over-HTTPS (RFC 8484) at
+// serverURL, such as "https://1.1.1.1/dns-query", instead of the system
+// resolver, so a poisoned local resolver can't break the race before it
+// starts. It covers the first hop of kindling's own transports
+// (WithUpstreamProxy, WithShadowsocks, WithTURN, ...) and the proxyless
+// smart dialer, whose default DNS strategies are replaced by serverURL's
+// host. Clients passed in by the caller, such as a domainfront.Client,
+// resolve names their own way.
+//
+// The DoH server's own name is looked up with the system resolver, so use
+// an IP address in serverURL to keep the system resolver out entirely.
+func WithDoHResolver(serverURL string) Option {
+	return func(k *kindling) error {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return fmt.Errorf("parsing doh url: %w", err)
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("doh url %q must be an https url", serverURL)
+		}
+		k.resolver = newDoHResolver(u.String(), transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			// Read at dial time so WithStreamDialer applies in any order.
+			if err := k.checkSystemDNS(addr); err != nil {
+				return nil, err
+			}
+			return k.rawStreamDialer().DialStream(ctx, addr)
+		}))
+		k.dohURL = u
+		return nil
+	}
+}
+
+// hostResolver looks up the addresses of a hostname for kindling's own
+// transports.
+type hostResolver interface {
+	lookupIP(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// systemResolver is the hostResolver used without WithDoHResolver.
+type systemResolver struct {
+	// dial, if set, opens the connections DNS queries are sent on, which
+	// then go through the Go resolver rather than the platform's.
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func (r systemResolver) lookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	if r.dial == nil {
+		return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	}
+	resolver := &net.Resolver{PreferGo: true, Dial: r.dial}
+	return resolver.LookupNetIP(ctx, "ip", host)
+}
+
+// hostResolver returns the instance's resolver, behind the DNS cache.
+func (k *kindling) hostResolver() hostResolver {
+	sys := systemResolver{}
+	if k.customNetDialer() {
+		sys.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := k.netDialer(network)
+			return d.DialContext(ctx, network, address)
+		}
+	}
+	var r hostResolver = sys
+	if k.resolver != nil {
+		r = k.resolver
+	} else if k.guardsLeaks() {
+		r = &guardedResolver{k: k, next: sys}
+	}
+	if k.dnsCache != nil {
+		return &cachedResolver{cache: k.dnsCache, next: r}
+	}
+	return r
+}
+
+// dohResolver sends A and AAAA queries to a DNS-over-HTTPS server.
+type dohResolver struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHResolver(serverURL string, dialer transport.StreamDialer) *dohResolver {
+	return &dohResolver{
+		url: serverURL,
+		client: &http.Client{
+			Timeout: dohTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return dialer.DialStream(ctx, addr)
+				},
+				ForceAttemptHTTP2:   true,
+				TLSHandshakeTimeout: dohTimeout,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+// lookupIP returns host's IPv4 addresses followed by its IPv6 ones.
+func (r *dohResolver) lookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	type answer struct {
+		addrs []netip.Addr
+		err   error
+	}
+	v6 := make(chan answer, 1)
+	go func() {
+		addrs, err := r.query(ctx, host, dnsmessage.TypeAAAA)
+		v6 <- answer{addrs, err}
+	}()
+	addrs, err4 := r.query(ctx, host, dnsmessage.TypeA)
+	a6 := <-v6
+	addrs = append(addrs, a6.addrs...)
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+	var failures []error
+	for _, err := range []error{err4, a6.err} {
+		if err != nil && !isNotFound(err) {
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("resolving %s over doh: %w", host, errors.Join(failures...))
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.url, IsNotFound: true}
+}
+
+func (r *dohResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]netip.Addr, error) {
+	m, err := r.exchange(ctx, host, qtype)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	for _, a := range m.Answers {
+		switch rr := a.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, netip.AddrFrom4(rr.A))
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, netip.AddrFrom16(rr.AAAA))
+		}
+	}
+	return addrs, nil
+}
+
+// exchange sends one qtype question for host and returns the successful
+// answer. A name that doesn't exist is a *net.DNSError with IsNotFound set.
+func (r *dohResolver) exchange(ctx context.Context, host string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	name, err := dnsmessage.NewName(dnsName(host))
+	if err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Message{
+		// RFC 8484 recommends ID 0 for cache friendliness.
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return nil, err
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(body); err != nil {
+		return nil, fmt.Errorf("decoding doh response: %w", err)
+	}
+	if m.RCode == dnsmessage.RCodeNameError {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.url, IsNotFound: true}
+	}
+	if m.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("doh server answered %v", m.RCode)
+	}
+	return &m, nil
+}
+
+// dnsName returns host as a fully qualified name.
+func dnsName(host string) string {
+	if strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
+
+// resolvingDialer resolves hostnames with resolver before dialing through
+// base. When a name has several addresses they're raced Happy Eyeballs style
+// (RFC 8305): attempts alternate between IPv6 and IPv4 and start 250ms apart,
+// or as soon as the previous one fails, so a network that blackholes one
+// family doesn't stall the whole transport attempt.
+type resolvingDialer struct {
+	base     transport.StreamDialer
+	resolver hostResolver
+}
+
+func (d *resolvingDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return d.base.DialStream(ctx, addr)
+	}
+	he := &transport.HappyEyeballsStreamDialer{
+		Dialer:  d.base,
+		Resolve: transport.NewParallelHappyEyeballsResolveFunc(d.resolver.lookupIP),
+	}
+	conn, err := he.DialStream(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// dohSmartDialerConfig returns the embedded smart dialer config with its DNS
+// strategies replaced by the DoH server at u.
+func dohSmartDialerConfig(u *url.URL) ([]byte, error) {
+	embedded, err := configFS.ReadFile("smart_dialer_config.yml")
+	if err != nil {
+		return nil, fmt.Errorf("reading smart dialer config: %w", err)
+	}
+	_, rest, ok := bytes.Cut(embedded, []byte("\ntls:"))
+	if !ok {
+		return nil, errors.New("smart dialer config has no tls section")
+	}
+	cfg := fmt.Sprintf("dns:\n  - https:\n      name: %s\n      address: %s\n\ntls:",
+		strconv.Quote(u.Hostname()), strconv.Quote(hostWithPort(u.Host, "https")))
+	return append([]byte(cfg), rest...), nil
+}
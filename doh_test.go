@@ -0,0 +1,223 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDoHServer answers A and AAAA queries from records, and NXDOMAIN for
+// names it doesn't know.
+func newDoHServer(t *testing.T, records map[string][]netip.Addr) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var m dnsmessage.Message
+		if err := m.Unpack(body); err != nil || len(m.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		q := m.Questions[0]
+		m.Header.Response = true
+		addrs, ok := records[q.Name.String()]
+		if !ok {
+			m.Header.RCode = dnsmessage.RCodeNameError
+		}
+		for _, a := range addrs {
+			h := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case a.Is4() && q.Type == dnsmessage.TypeA:
+				h.Type = dnsmessage.TypeA
+				m.Answers = append(m.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.AResource{A: a.As4()}})
+			case a.Is6() && q.Type == dnsmessage.TypeAAAA:
+				h.Type = dnsmessage.TypeAAAA
+				m.Answers = append(m.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.AAAAResource{AAAA: a.As16()}})
+			}
+		}
+		packed, err := m.Pack()
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// trustTestServer makes r trust srv's self-signed certificate.
+func trustTestServer(r *dohResolver, srv *httptest.Server) {
+	r.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+}
+
+func TestDoHResolver(t *testing.T) {
+	t.Parallel()
+
+	srv := newDoHServer(t, map[string][]netip.Addr{
+		"origin.test.": {netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")},
+		"empty.test.":  nil,
+	})
+	r := newDoHResolver(srv.URL+"/dns-query", &transport.TCPDialer{})
+	trustTestServer(r, srv)
+
+	t.Run("Resolves", func(t *testing.T) {
+		t.Parallel()
+		addrs, err := r.lookupIP(t.Context(), "origin.test")
+		require.NoError(t, err)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}, addrs)
+	})
+
+	t.Run("NXDomain", func(t *testing.T) {
+		t.Parallel()
+		_, err := r.lookupIP(t.Context(), "missing.test")
+		assert.True(t, isNotFound(err), err)
+	})
+
+	t.Run("NoAddresses", func(t *testing.T) {
+		t.Parallel()
+		_, err := r.lookupIP(t.Context(), "empty.test")
+		assert.True(t, isNotFound(err), err)
+	})
+
+	t.Run("ResolvingDialer", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		var dialed []string
+		base := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dialed = append(dialed, addr)
+			return nil, &net.OpError{Op: "dial", Err: io.EOF}
+		})
+		d := &resolvingDialer{base: base, resolver: r}
+		_, err := d.DialStream(t.Context(), "origin.test:443")
+		assert.Error(t, err)
+		mu.Lock()
+		assert.Equal(t, []string{"[::1]:443", "127.0.0.1:443"}, dialed, "every address is tried, IPv6 first")
+		dialed = nil
+		mu.Unlock()
+
+		d.DialStream(t.Context(), "192.0.2.1:443")
+		mu.Lock()
+		assert.Equal(t, []string{"192.0.2.1:443"}, dialed, "literals aren't resolved")
+		mu.Unlock()
+	})
+
+	t.Run("HappyEyeballs", func(t *testing.T) {
+		t.Parallel()
+		echo := serveEcho(t)
+		_, port, _ := net.SplitHostPort(echo)
+		resolver := &countingResolver{addrs: map[string][]netip.Addr{
+			"origin.test": {netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("2001:db8::1")},
+		}}
+		blackholed := make(chan error, 1)
+		base := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			if strings.HasPrefix(addr, "[2001:db8::1]") {
+				// IPv6 is blackholed: the attempt hangs until it's abandoned.
+				<-ctx.Done()
+				blackholed <- ctx.Err()
+				return nil, ctx.Err()
+			}
+			return (&transport.TCPDialer{}).DialStream(ctx, addr)
+		})
+		d := &resolvingDialer{base: base, resolver: resolver}
+
+		start := time.Now()
+		conn, err := d.DialStream(t.Context(), net.JoinHostPort("origin.test", port))
+		require.NoError(t, err)
+		conn.Close()
+		assert.Less(t, time.Since(start), 2*time.Second, "IPv4 is tried without waiting for IPv6 to time out")
+		assert.Error(t, <-blackholed, "the losing attempt is canceled")
+	})
+}
+
+func TestWithDoHResolver(t *testing.T) {
+	t.Parallel()
+
+	t.Run("InvalidURL", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithDoHResolver("http://1.1.1.1/dns-query"))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithDoHResolver("https:///dns-query"))
+		assert.Error(t, err)
+	})
+
+	t.Run("TransportsResolveOverDoH", func(t *testing.T) {
+		t.Parallel()
+		srv := newDoHServer(t, map[string][]netip.Addr{"proxy.test.": {netip.MustParseAddr("192.0.2.7")}})
+		var mu sync.Mutex
+		var dialed []string
+		stream := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+			if addr == "192.0.2.7:1" {
+				return nil, errors.New("unreachable")
+			}
+			return (&transport.TCPDialer{}).DialStream(ctx, addr)
+		})
+		ki, err := NewKindling("test", WithStreamDialer(stream), WithDoHResolver(srv.URL+"/dns-query"))
+		require.NoError(t, err)
+		k := ki.(*kindling)
+		trustTestServer(k.resolver.(*dohResolver), srv)
+
+		_, err = k.baseStreamDialer().DialStream(t.Context(), "proxy.test:1")
+		assert.ErrorContains(t, err, "unreachable")
+		u, _ := url.Parse(srv.URL)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Contains(t, dialed, u.Host, "the doh query uses the stream dialer")
+		assert.Equal(t, "192.0.2.7:1", dialed[len(dialed)-1])
+	})
+}
+
+func TestDoHSmartDialerConfig(t *testing.T) {
+	t.Parallel()
+
+	u, err := url.Parse("https://1.1.1.1/dns-query")
+	require.NoError(t, err)
+	cfg, err := dohSmartDialerConfig(u)
+	require.NoError(t, err)
+	s := string(cfg)
+	assert.True(t, strings.HasPrefix(s, "dns:\n  - https:\n      name: \"1.1.1.1\"\n      address: \"1.1.1.1:443\"\n\ntls:\n"), s)
+	assert.NotContains(t, s, "system")
+	assert.Contains(t, s, "tlsfrag:1")
+}
+
+// Not parallel: swaps the package-level newSmartDialerFn.
+func TestProxylessWithDoHResolver(t *testing.T) {
+	var gotCfg []byte
+	var gotStream transport.StreamDialer
+	orig := newSmartDialerFn
+	newSmartDialerFn = func(_ io.Writer, cfg []byte, s transport.StreamDialer, _ transport.PacketDialer, _ ...string) (transport.StreamDialer, error) {
+		gotCfg, gotStream = cfg, s
+		return &transport.TCPDialer{}, nil
+	}
+	t.Cleanup(func() { newSmartDialerFn = orig })
+
+	_, err := NewKindling("test", WithProxyless("example.com"), WithDoHResolver("https://9.9.9.9/dns-query"))
+	require.NoError(t, err)
+	assert.Contains(t, string(gotCfg), `name: "9.9.9.9"`)
+	assert.IsType(t, &resolvingDialer{}, gotStream)
+
+	custom := []byte("dns:\n  - system: {}\ntls:\n  - \"\"\n")
+	_, err = NewKindling("test", WithProxylessConfig(custom, "example.com"), WithDoHResolver("https://9.9.9.9/dns-query"))
+	require.NoError(t, err)
+	assert.Equal(t, custom, gotCfg, "caller configs are left alone")
+}
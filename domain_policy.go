@@ -0,0 +1,61 @@
+package kindling
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithDomainPolicy limits requests to domain and its subdomains to the named
+// transports, e.g. to keep auth endpoints off caching transports like AMP:
+//
+//	kindling.WithDomainPolicy("auth.example.com", string(kindling.TransportSmart), string(kindling.TransportDomainfront))
+//
+// When policies exist for both a domain and one of its parents, the most
+// specific one wins. A request whose policy names no configured transport
+// fails rather than falling back to the others. Policies also apply to
+// connections made through ListenSOCKS5.
+func WithDomainPolicy(domain string, transports ...string) Option {
+	return func(k *kindling) error {
+		domain = normalizeDomain(domain)
+		if domain == "" {
+			return fmt.Errorf("domain policy has no domain")
+		}
+		if len(transports) == 0 {
+			return fmt.Errorf("domain policy for %q names no transports", domain)
+		}
+		if k.domainPolicy == nil {
+			k.domainPolicy = make(map[string][]string)
+		}
+		k.domainPolicy[domain] = append([]string(nil), transports...)
+		return nil
+	}
+}
+
+func normalizeDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// policyFor returns the transports allowed for host under the most specific
+// matching policy, and the domain that policy was set for.
+func policyFor(policies map[string][]string, host string) (allowed []string, domain string, ok bool) {
+	return lookupDomain(policies, host)
+}
+
+// lookupDomain finds the entry for host or, failing that, its closest parent
+// domain, returning the matching key.
+func lookupDomain[V any](m map[string]V, host string) (v V, domain string, ok bool) {
+	if len(m) == 0 {
+		return v, "", false
+	}
+	for h := normalizeDomain(host); h != ""; {
+		if v, ok := m[h]; ok {
+			return v, h, true
+		}
+		i := strings.IndexByte(h, '.')
+		if i < 0 {
+			break
+		}
+		h = h[i+1:]
+	}
+	return v, "", false
+}
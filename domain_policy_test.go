@@ -0,0 +1,107 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyFor(t *testing.T) {
+	t.Parallel()
+	policies := map[string][]string{
+		"example.com":      {"smart"},
+		"auth.example.com": {"domainfront"},
+	}
+	tests := []struct {
+		host       string
+		wantDomain string
+		wantOK     bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"AUTH.Example.com.", "auth.example.com", true},
+		{"login.auth.example.com", "auth.example.com", true},
+		{"notexample.com", "", false},
+		{"com", "", false},
+	}
+	for _, tt := range tests {
+		_, domain, ok := policyFor(policies, tt.host)
+		assert.Equal(t, tt.wantOK, ok, tt.host)
+		assert.Equal(t, tt.wantDomain, domain, tt.host)
+	}
+	_, _, ok := policyFor(nil, "example.com")
+	assert.False(t, ok)
+}
+
+func TestWithDomainPolicy(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(origin.Close)
+
+	counting := func(name string, calls *atomic.Int32) *mockTransport {
+		return &mockTransport{
+			name: name,
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				calls.Add(1)
+				return http.DefaultTransport, nil
+			},
+		}
+	}
+
+	t.Run("InvalidArguments", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithDomainPolicy(" ", "smart"))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithDomainPolicy("example.com"))
+		assert.Error(t, err)
+	})
+
+	t.Run("OnlyAllowedTransportsLaunch", func(t *testing.T) {
+		t.Parallel()
+		var allowedCalls, otherCalls atomic.Int32
+		k, err := NewKindling("test",
+			WithTransport(counting("allowed", &allowedCalls)),
+			WithTransport(counting("amp", &otherCalls)),
+			WithDomainPolicy("127.0.0.1", "allowed"),
+		)
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.EqualValues(t, 1, allowedCalls.Load())
+		assert.Zero(t, otherCalls.Load(), "policy-excluded transport must not be launched")
+	})
+
+	t.Run("NoAllowedTransport_FailsClosed", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		k, err := NewKindling("test",
+			WithTransport(counting("amp", &calls)),
+			WithDomainPolicy("127.0.0.1", "smart"),
+		)
+		require.NoError(t, err)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		assert.ErrorContains(t, err, `domain policy for "127.0.0.1"`)
+		assert.Zero(t, calls.Load())
+	})
+
+	t.Run("AppliesToStreamDials", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test",
+			WithUpstreamProxy("socks5://"+serveSOCKS5(t)),
+			WithDomainPolicy("127.0.0.1", "smart"),
+		)
+		require.NoError(t, err)
+		_, err = k.(*kindling).DialStream(context.Background(), origin.Listener.Addr().String())
+		assert.ErrorContains(t, err, "domain policy")
+	})
+}
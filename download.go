@@ -0,0 +1,244 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// downloadChunkSize is how many bytes Download asks for per request.
+	// Each chunk races the transports afresh, so a small one lets a faster
+	// transport take over sooner, at the cost of more round trips.
+	downloadChunkSize = 1 << 20
+	// maxDownloadFailures is how many failed requests in a row, without a
+	// byte of progress, Download tolerates before giving up.
+	maxDownloadFailures = 5
+)
+
+// errDownloadChanged is returned when the file changes partway through a
+// Download, so the bytes already written can't be completed.
+var errDownloadChanged = errors.New("file changed during download")
+
+// Download fetches url into w in chunks, using Range requests, so a large
+// file survives a transport dying mid-body: the download resumes where it
+// stopped, raced afresh without the transport that failed. Chunks are
+// matched with If-Range against the first response's ETag or
+// Last-Modified, and a file that changes partway through fails the
+// download rather than splicing two versions; a server that sends neither
+// is trusted to serve the same file throughout. Servers that ignore Range
+// send the whole file, and a retry skips the bytes already written.
+// Download gives up after 5 failures in a row that make no progress, or
+// when ctx is done. w may have received part of the file when it returns an
+// error.
+//
+// With WithParallelDownload, the rest of the file is fetched a chunk per
+// request over several transports at once, once the first chunk shows the
+// server supports Range.
+func (k *kindling) Download(ctx context.Context, url string, w io.Writer) error {
+	d := &download{
+		client: k.NewHTTPClient(),
+		url:    url,
+		w:      &downloadWriter{w: w},
+		size:   -1,
+	}
+	var exclude string
+	failures := 0
+	for d.size < 0 || d.offset < d.size {
+		reqCtx := ctx
+		if exclude != "" {
+			reqCtx = ExcludeTransports(ctx, exclude)
+		}
+		before := d.offset
+		name, retry, err := d.next(reqCtx)
+		if err == nil {
+			failures, exclude = 0, ""
+			if k.downloadWorkers > 1 && d.ranged && d.offset < d.size {
+				if err := d.parallel(ctx, k.downloadWorkers, k.log); err != nil {
+					return fmt.Errorf("downloading %s: %w", url, err)
+				}
+				return nil
+			}
+			continue
+		}
+		if !retry || ctx.Err() != nil {
+			return fmt.Errorf("downloading %s: %w", url, err)
+		}
+		if d.offset > before {
+			failures = 0
+		}
+		failures++
+		if failures >= maxDownloadFailures {
+			return fmt.Errorf("downloading %s: giving up after %d failures: %w", url, failures, err)
+		}
+		k.log.Debug("Download interrupted, resuming", "url", url, "offset", d.offset, "name", name, "error", err)
+		// A transport that fails mid-body sits out the next chunk; a race
+		// that failed outright (name == "") is simply tried again.
+		exclude = name
+	}
+	return nil
+}
+
+// download is the state of one Download.
+type download struct {
+	client *http.Client
+	url    string
+	w      *downloadWriter
+	// offset is how many bytes have been written; size is the file's
+	// length, or -1 until it's known.
+	offset int64
+	size   int64
+	// validator is the If-Range value that pins chunks to one version of
+	// the file.
+	validator string
+	// ranged is set once the server has answered a Range request.
+	ranged bool
+}
+
+// next fetches the chunk at d.offset and writes it out. It returns the
+// transport that carried the response, if there was one, and whether a
+// failure is worth retrying.
+func (d *download) next(ctx context.Context) (name string, retry bool, err error) {
+	end := d.offset + downloadChunkSize - 1
+	if d.size >= 0 {
+		end = min(end, d.size-1)
+	}
+	resp, err := d.get(ctx, d.offset, end)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	name = TransportFromResponse(resp)
+
+	var body io.Reader = resp.Body
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return name, false, err
+		}
+		if start != d.offset {
+			return name, false, fmt.Errorf("asked for bytes from %d, got bytes from %d", d.offset, start)
+		}
+		d.size = total
+		d.ranged = true
+	case http.StatusOK:
+		// The server ignored the range, or the file changed and If-Range
+		// sent it whole.
+		if d.offset > 0 && validatorOf(resp) != d.validator {
+			return name, false, errDownloadChanged
+		}
+		if _, err := io.CopyN(io.Discard, body, d.offset); err != nil {
+			return name, true, err
+		}
+		d.size = -1
+		if resp.ContentLength >= 0 {
+			d.size = resp.ContentLength
+		}
+		end = -1
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty file has no bytes to ask for.
+		if _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == d.offset {
+			d.size = total
+			return name, false, nil
+		}
+		return name, false, fmt.Errorf("server returned %s", resp.Status)
+	default:
+		return name, resp.StatusCode >= 500, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if d.validator == "" {
+		d.validator = validatorOf(resp)
+	}
+
+	n, err := io.Copy(d.w, body)
+	d.offset += n
+	if d.w.err != nil {
+		return name, false, d.w.err
+	}
+	if err != nil {
+		return name, true, err
+	}
+	switch {
+	case end < 0:
+		// A whole body read to its end is the whole file.
+		d.size = d.offset
+	case d.size < 0 && d.offset <= end:
+		// A short chunk of a file of unknown length was its last.
+		d.size = d.offset
+	}
+	return name, false, nil
+}
+
+// get requests bytes start to end, inclusive, of the file.
+func (d *download) get(ctx context.Context, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	// Offsets count the bytes of the file itself, not of an encoding.
+	req.Header.Set("Accept-Encoding", "identity")
+	if d.validator != "" {
+		req.Header.Set("If-Range", d.validator)
+	}
+	return d.client.Do(req)
+}
+
+// validatorOf returns the value for If-Range that identifies resp's version
+// of the file: its ETag, unless weak, or else its Last-Modified date.
+func validatorOf(resp *http.Response) string {
+	if etag := resp.Header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// parseContentRange parses a Content-Range header, "bytes start-end/total"
+// or "bytes */total". start is -1 in the second form, and total is -1 when
+// it's "*".
+func parseContentRange(s string) (start, total int64, err error) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	rng, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid content range %q", s)
+		}
+	}
+	if rng == "*" {
+		return -1, total, nil
+	}
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid content range %q", s)
+	}
+	return start, total, nil
+}
+
+// downloadWriter records the error of the writer Download writes to, which,
+// unlike a failing transport, a retry can't fix.
+type downloadWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *downloadWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,217 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// truncatedBody fails with io.ErrUnexpectedEOF after n bytes, like a body
+// whose transport died.
+type truncatedBody struct {
+	io.ReadCloser
+	n int
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n, err := b.ReadCloser.Read(p[:min(len(p), b.n)])
+	b.n -= n
+	return n, err
+}
+
+func TestDownload(t *testing.T) {
+	t.Parallel()
+
+	file := make([]byte, 2*downloadChunkSize+1234)
+	for i := range file {
+		file[i] = byte(rand.IntN(256))
+	}
+	var version atomic.Value
+	version.Store(`"v1"`)
+	var ranges atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		w.Header().Set("Etag", version.Load().(string))
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(file))
+	}))
+	t.Cleanup(origin.Close)
+
+	// transport cuts off its first cut responses after 1000 bytes.
+	transport := func(name string, cut int32) *mockTransport {
+		var cuts atomic.Int32
+		return &mockTransport{name: name, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := http.DefaultTransport.RoundTrip(req)
+				if err == nil && cuts.Add(1) <= cut {
+					resp.Body = &truncatedBody{ReadCloser: resp.Body, n: 1000}
+				}
+				return resp, err
+			}), nil
+		}}
+	}
+	newKindling := func(t *testing.T, transports ...Transport) Kindling {
+		opts := []Option{WithLogWriter(io.Discard), WithCircuitBreaker(0, 0)}
+		for _, tr := range transports {
+			opts = append(opts, WithTransport(tr))
+		}
+		k, err := NewKindling("test", opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		return k
+	}
+
+	t.Run("ResumesAfterTransportDies", func(t *testing.T) {
+		k := newKindling(t, transport("flaky", 2))
+		var buf bytes.Buffer
+		require.NoError(t, k.Download(context.Background(), origin.URL, &buf))
+		assert.True(t, bytes.Equal(file, buf.Bytes()), "got %d bytes, want %d", buf.Len(), len(file))
+		assert.GreaterOrEqual(t, ranges.Load(), int32(5), "three chunks and two resumes")
+	})
+
+	t.Run("SwitchesTransports", func(t *testing.T) {
+		k := newKindling(t, transport("broken", 1000), transport("steady", 0))
+		var buf bytes.Buffer
+		require.NoError(t, k.Download(context.Background(), origin.URL, &buf))
+		assert.True(t, bytes.Equal(file, buf.Bytes()))
+	})
+
+	t.Run("FileChanges", func(t *testing.T) {
+		var changed atomic.Bool
+		changing := &mockTransport{name: "changing", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Header.Get("If-Range") != "" && changed.CompareAndSwap(false, true) {
+					version.Store(`"v2"`)
+				}
+				return http.DefaultTransport.RoundTrip(req)
+			}), nil
+		}}
+		t.Cleanup(func() { version.Store(`"v1"`) })
+		k := newKindling(t, changing)
+		err := k.Download(context.Background(), origin.URL, io.Discard)
+		assert.ErrorIs(t, err, errDownloadChanged)
+	})
+
+	t.Run("WriterErrorIsFinal", func(t *testing.T) {
+		var attempts atomic.Int32
+		counting := &mockTransport{name: "counting", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			attempts.Add(1)
+			return http.DefaultTransport, nil
+		}}
+		k := newKindling(t, counting)
+		diskFull := errors.New("disk full")
+		err := k.Download(context.Background(), origin.URL, failingWriter{diskFull})
+		assert.ErrorIs(t, err, diskFull)
+		assert.EqualValues(t, 1, attempts.Load())
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		missing := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(missing.Close)
+		k := newKindling(t, transport("steady", 0))
+		err := k.Download(context.Background(), missing.URL, io.Discard)
+		assert.ErrorContains(t, err, "404")
+	})
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestParseContentRange(t *testing.T) {
+	t.Parallel()
+
+	start, total, err := parseContentRange("bytes 100-199/1000")
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, start)
+	assert.EqualValues(t, 1000, total)
+
+	start, total, err = parseContentRange("bytes 0-9/*")
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, start)
+	assert.EqualValues(t, -1, total)
+
+	start, total, err = parseContentRange("bytes */0")
+	require.NoError(t, err)
+	assert.EqualValues(t, -1, start)
+	assert.EqualValues(t, 0, total)
+
+	for _, bad := range []string{"", "items 0-9/10", "bytes 0-9", "bytes x-9/10", "bytes 0-9/x"} {
+		_, _, err := parseContentRange(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestValidatorOf(t *testing.T) {
+	t.Parallel()
+
+	modified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	assert.Equal(t, `"x"`, validatorOf(&http.Response{Header: http.Header{"Etag": {`"x"`}, "Last-Modified": {modified}}}))
+	assert.Equal(t, modified, validatorOf(&http.Response{Header: http.Header{"Etag": {`W/"x"`}, "Last-Modified": {modified}}}),
+		"weak etags can't be used with If-Range")
+	assert.Empty(t, validatorOf(&http.Response{Header: http.Header{}}))
+}
+
+func TestWithParallelDownload(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewKindling("test", WithParallelDownload(0))
+	require.Error(t, err)
+
+	file := make([]byte, 5*downloadChunkSize+99)
+	for i := range file {
+		file[i] = byte(rand.IntN(256))
+	}
+	var inflight, maxInflight atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			m := maxInflight.Load()
+			if n <= m || maxInflight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Etag", `"v1"`)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(file))
+	}))
+	t.Cleanup(origin.Close)
+
+	opts := []Option{WithLogWriter(io.Discard), WithParallelDownload(3)}
+	for _, name := range []string{"a", "b", "c"} {
+		var cut atomic.Bool
+		opts = append(opts, WithTransport(&mockTransport{name: name, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := http.DefaultTransport.RoundTrip(req)
+				// Every transport dies once partway through a chunk.
+				if err == nil && req.Header.Get("If-Range") != "" && cut.CompareAndSwap(false, true) {
+					resp.Body = &truncatedBody{ReadCloser: resp.Body, n: 1000}
+				}
+				return resp, err
+			}), nil
+		}}))
+	}
+	k, err := NewKindling("test", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+
+	var buf bytes.Buffer
+	require.NoError(t, k.Download(context.Background(), origin.URL, &buf))
+	assert.True(t, bytes.Equal(file, buf.Bytes()), "got %d bytes, want %d", buf.Len(), len(file))
+	assert.GreaterOrEqual(t, maxInflight.Load(), int32(2), "chunks are fetched concurrently")
+}
@@ -0,0 +1,201 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// defaultECHDoHURL is where ECH configs are looked up without
+// WithDoHResolver.
+const defaultECHDoHURL = "https://1.1.1.1/dns-query"
+
+// WithECH turns Encrypted Client Hello on or off for the proxyless smart
+// transport (WithProxyless and friends). With it on, each origin's ECH
+// config is fetched from its DNS HTTPS record over DNS-over-HTTPS (the
+// WithDoHResolver server, or 1.1.1.1), and the real server name travels
+// encrypted inside a ClientHello whose SNI is the provider's public name, so
+// SNI filtering no longer sees which site is being reached. Origins without
+// an ECH config are reached without it, as before. Configs are cached like
+// other lookups (see WithDNSCache) and dropped by FlushDNS.
+func WithECH(enabled bool) Option {
+	return func(k *kindling) error {
+		k.echEnabled = enabled
+		return nil
+	}
+}
+
+// newECHConfigs returns the ECH config cache, looking configs up through the
+// WithDoHResolver server when there is one.
+func (k *kindling) newECHConfigs() *echConfigs {
+	r, ok := k.resolver.(*dohResolver)
+	if !ok {
+		r = newDoHResolver(defaultECHDoHURL, transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			return k.rawStreamDialer().DialStream(ctx, addr)
+		}))
+	}
+	var ttl time.Duration
+	if k.dnsCache != nil {
+		ttl = k.dnsCache.ttl
+	}
+	return &echConfigs{
+		resolver: r,
+		ttl:      ttl,
+		now:      time.Now,
+		log:      k.log,
+		entries:  make(map[string]echEntry),
+	}
+}
+
+// echConfigs looks up and caches ECH config lists by host. A nil list means
+// the host has none.
+type echConfigs struct {
+	resolver *dohResolver
+	// ttl is how long lists are kept; 0 looks every host up each time.
+	ttl time.Duration
+	now func() time.Time
+	log *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]echEntry
+}
+
+type echEntry struct {
+	list    []byte
+	expires time.Time
+}
+
+// lookup returns host's ECH config list, or nil if it has none or the
+// lookup failed, in which case the connection goes ahead without ECH.
+func (e *echConfigs) lookup(ctx context.Context, host string) []byte {
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	e.mu.Lock()
+	entry, ok := e.entries[key]
+	e.mu.Unlock()
+	if ok && e.now().Before(entry.expires) {
+		return entry.list
+	}
+	list, err := e.resolver.lookupECH(ctx, host)
+	if err != nil {
+		e.log.Debug("ECH config lookup failed, connecting without ECH", "host", host, "error", err)
+		return nil
+	}
+	e.put(key, list)
+	return list
+}
+
+// reject records the retry configs a server sent when it rejected ECH, or
+// that it has none, so the next connection to host uses them.
+func (e *echConfigs) reject(host string, retry []byte) {
+	e.put(strings.ToLower(strings.TrimSuffix(host, ".")), retry)
+}
+
+func (e *echConfigs) put(key string, list []byte) {
+	if e.ttl == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	if len(e.entries) >= maxDNSCacheEntries {
+		for h, entry := range e.entries {
+			if !now.Before(entry.expires) {
+				delete(e.entries, h)
+			}
+		}
+		for h := range e.entries {
+			if len(e.entries) < maxDNSCacheEntries {
+				break
+			}
+			delete(e.entries, h)
+		}
+	}
+	e.entries[key] = echEntry{list: slices.Clone(list), expires: now.Add(e.ttl)}
+}
+
+// flush drops every entry. A nil cache has none.
+func (e *echConfigs) flush() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clear(e.entries)
+}
+
+// roundTripper makes the TLS handshake on conn with ECH, using list and
+// base, which may be nil, and notes rejections.
+func (e *echConfigs) roundTripper(conn net.Conn, host string, list []byte, base *tls.Config) http.RoundTripper {
+	config := base.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.ServerName = host
+	config.MinVersion = tls.VersionTLS13
+	config.EncryptedClientHelloConfigList = list
+	rt := preconnectedTransport(conn)
+	rt.TLSClientConfig = config
+	return &echRoundTripper{rt: rt, host: host, configs: e}
+}
+
+// echRoundTripper notes ECH rejections, so that retries use the server's
+// retry configs, or go without ECH if it offered none.
+type echRoundTripper struct {
+	rt      http.RoundTripper
+	host    string
+	configs *echConfigs
+}
+
+func (r *echRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.rt.RoundTrip(req)
+	var rejected *tls.ECHRejectionError
+	if errors.As(err, &rejected) {
+		r.configs.reject(r.host, rejected.RetryConfigList)
+	}
+	return resp, err
+}
+
+func (r *echRoundTripper) CloseIdleConnections() {
+	if c, ok := r.rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// lookupECH returns the ECH config list from host's HTTPS record, or nil if
+// it has none.
+func (r *dohResolver) lookupECH(ctx context.Context, host string) ([]byte, error) {
+	m, err := r.exchange(ctx, host, dnsmessage.TypeHTTPS)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("looking up https record for %s: %w", host, err)
+	}
+	var best *dnsmessage.HTTPSResource
+	for _, a := range m.Answers {
+		rr, ok := a.Body.(*dnsmessage.HTTPSResource)
+		// Priority 0 is an alias, which carries no parameters.
+		if !ok || rr.Priority == 0 {
+			continue
+		}
+		if _, ok := rr.GetParam(dnsmessage.SVCParamECH); ok && (best == nil || rr.Priority < best.Priority) {
+			best = rr
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	list, _ := best.GetParam(dnsmessage.SVCParamECH)
+	return list, nil
+}
@@ -0,0 +1,211 @@
+package kindling
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newHTTPSRecordServer answers HTTPS queries with the ECH config lists in
+// records, and NXDOMAIN for names it doesn't know.
+func newHTTPSRecordServer(t *testing.T, records map[string][]byte) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var m dnsmessage.Message
+		if err := m.Unpack(body); err != nil || len(m.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		q := m.Questions[0]
+		m.Header.Response = true
+		list, ok := records[q.Name.String()]
+		if !ok {
+			m.Header.RCode = dnsmessage.RCodeNameError
+		}
+		if q.Type == dnsmessage.TypeHTTPS && list != nil {
+			h := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET, TTL: 60}
+			rr := &dnsmessage.HTTPSResource{SVCBResource: dnsmessage.SVCBResource{Priority: 1, Target: dnsmessage.MustNewName(".")}}
+			rr.SetParam(dnsmessage.SVCParamECH, list)
+			m.Answers = append(m.Answers, dnsmessage.Resource{Header: h, Body: rr})
+		}
+		packed, err := m.Pack()
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newECHKey returns an ECH key for a server with the given public name, and
+// the config list a client needs to use it.
+func newECHKey(t *testing.T, publicName string) (tls.EncryptedClientHelloKey, []byte) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pub := priv.PublicKey().Bytes()
+
+	var contents []byte
+	contents = append(contents, 1)                             // config_id
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // DHKEM(X25519, HKDF-SHA256)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(pub)))
+	contents = append(contents, pub...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // HKDF-SHA256
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // AES-128-GCM
+	contents = append(contents, 0)                             // maximum_name_length
+	contents = append(contents, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // extensions
+
+	config := binary.BigEndian.AppendUint16(nil, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+	list := binary.BigEndian.AppendUint16(nil, uint16(len(config)))
+	list = append(list, config...)
+	return tls.EncryptedClientHelloKey{Config: config, PrivateKey: priv.Bytes(), SendAsRetry: true}, list
+}
+
+func newTestECHConfigs(t *testing.T, records map[string][]byte) *echConfigs {
+	srv := newHTTPSRecordServer(t, records)
+	r := newDoHResolver(srv.URL+"/dns-query", &transport.TCPDialer{})
+	trustTestServer(r, srv)
+	return &echConfigs{
+		resolver: r,
+		ttl:      time.Minute,
+		now:      time.Now,
+		log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		entries:  make(map[string]echEntry),
+	}
+}
+
+func TestLookupECH(t *testing.T) {
+	t.Parallel()
+
+	configs := newTestECHConfigs(t, map[string][]byte{
+		"ech.test.":   {1, 2, 3},
+		"plain.test.": nil,
+	})
+
+	list, err := configs.resolver.lookupECH(t.Context(), "ech.test")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, list)
+
+	list, err = configs.resolver.lookupECH(t.Context(), "plain.test")
+	require.NoError(t, err)
+	assert.Nil(t, list)
+
+	list, err = configs.resolver.lookupECH(t.Context(), "missing.test")
+	require.NoError(t, err, "a missing name just has no ech config")
+	assert.Nil(t, list)
+}
+
+func TestECHConfigs(t *testing.T) {
+	t.Parallel()
+
+	records := map[string][]byte{"ech.test.": {1, 2, 3}}
+	configs := newTestECHConfigs(t, records)
+
+	assert.Equal(t, []byte{1, 2, 3}, configs.lookup(t.Context(), "ech.test"))
+	assert.Equal(t, []byte{1, 2, 3}, configs.lookup(t.Context(), "ECH.test."), "cached by normalized name")
+
+	configs.reject("ech.test", []byte{4, 5})
+	assert.Equal(t, []byte{4, 5}, configs.lookup(t.Context(), "ech.test"), "retry configs replace the record")
+	configs.reject("ech.test", nil)
+	assert.Nil(t, configs.lookup(t.Context(), "ech.test"), "a rejection without retry configs turns ech off")
+
+	configs.flush()
+	assert.Equal(t, []byte{1, 2, 3}, configs.lookup(t.Context(), "ech.test"))
+
+	var none *echConfigs
+	none.flush()
+}
+
+func TestSmartTransportECH(t *testing.T) {
+	t.Parallel()
+
+	key, list := newECHKey(t, "example.com")
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS.ECHAccepted {
+			io.WriteString(w, "ech")
+		} else {
+			io.WriteString(w, "plain")
+		}
+	}))
+	origin.TLS = &tls.Config{EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key}}
+	origin.StartTLS()
+	t.Cleanup(origin.Close)
+	roots := origin.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	_, otherList := newECHKey(t, "example.com")
+	k := &kindling{echEnabled: true}
+	k.ech = newTestECHConfigs(t, map[string][]byte{
+		"example.com.":       list,
+		"stale.example.com.": otherList,
+	})
+	// Every host is served by origin.
+	dialer := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		conn, err := net.Dial("tcp", origin.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*net.TCPConn), nil
+	})
+	tr := k.smartTransport(dialer)
+
+	get := func(host string) (string, error) {
+		rt, err := tr.NewRoundTripper(t.Context(), host+":443")
+		require.NoError(t, err)
+		ert, ok := rt.(*echRoundTripper)
+		require.True(t, ok, "%s has an ech config", host)
+		ert.rt.(*http.Transport).TLSClientConfig.RootCAs = roots
+		req, _ := http.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	t.Run("Accepted", func(t *testing.T) {
+		body, err := get("example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "ech", body)
+	})
+
+	t.Run("RejectedUsesRetryConfigs", func(t *testing.T) {
+		_, err := get("stale.example.com")
+		var rejected *tls.ECHRejectionError
+		require.ErrorAs(t, err, &rejected)
+		assert.Equal(t, list, k.ech.lookup(t.Context(), "stale.example.com"))
+	})
+
+	t.Run("NoConfig", func(t *testing.T) {
+		rt, err := tr.NewRoundTripper(t.Context(), "plain.example.com:443")
+		require.NoError(t, err)
+		assert.IsType(t, &http.Transport{}, rt)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		off := &kindling{}
+		assert.Nil(t, off.ech)
+		rt, err := off.smartTransport(dialer).NewRoundTripper(t.Context(), "example.com:443")
+		require.NoError(t, err)
+		assert.IsType(t, &http.Transport{}, rt)
+	})
+}
@@ -0,0 +1,80 @@
+package kindling
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Environment variables read by WithEnvOverrides.
+const (
+	// EnvDisable lists transports to leave out, e.g. KINDLING_DISABLE=dnstt,amp.
+	EnvDisable = "KINDLING_DISABLE"
+	// EnvOnly lists the only transports to keep, e.g. KINDLING_ONLY=fronted.
+	EnvOnly = "KINDLING_ONLY"
+)
+
+// WithEnvOverrides lets the KINDLING_DISABLE and KINDLING_ONLY environment
+// variables drop configured transports, so a transport can be ruled in or
+// out while debugging in the field without a new build. Both take a comma
+// separated list of transport names, such as "dnstt,amp". A name also
+// covers the transports it prefixes, so "domainfront" matches
+// "domainfront-akamai", and "fronted" is accepted for "domainfront".
+// KINDLING_ONLY is applied first. The variables are read once, by
+// NewKindling, which fails if they leave no transports.
+func WithEnvOverrides() Option {
+	return func(k *kindling) error {
+		k.envOverrides = true
+		return nil
+	}
+}
+
+// applyEnvOverrides drops the transports excluded by EnvOnly and EnvDisable.
+func (k *kindling) applyEnvOverrides() error {
+	only := parseTransportList(os.Getenv(EnvOnly))
+	disable := parseTransportList(os.Getenv(EnvDisable))
+	if len(only) == 0 && len(disable) == 0 {
+		return nil
+	}
+	kept := k.transports[:0:0]
+	for _, tr := range k.transports {
+		name := tr.Name()
+		if (len(only) > 0 && !matchesTransport(only, name)) || matchesTransport(disable, name) {
+			k.log.Info("Transport disabled by environment", slog.String("transport", name))
+			continue
+		}
+		kept = append(kept, tr)
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("%s=%q and %s=%q leave no transports", EnvOnly, os.Getenv(EnvOnly), EnvDisable, os.Getenv(EnvDisable))
+	}
+	k.transports = kept
+	return nil
+}
+
+func parseTransportList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "fronted" {
+			name = string(TransportDomainfront)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// matchesTransport reports whether any of names is transport or a
+// dash-separated prefix of it.
+func matchesTransport(names []string, transport string) bool {
+	transport = strings.ToLower(transport)
+	for _, name := range names {
+		if transport == name || strings.HasPrefix(transport, name+"-") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package kindling
+
+import (
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Not parallel: sets environment variables.
+func TestWithEnvOverrides(t *testing.T) {
+	newKindling := func(t *testing.T, extra ...Option) (Kindling, error) {
+		var opts []Option
+		for _, name := range []string{"smart", "domainfront-akamai", "domainfront-cloudfront", "dnstt", "amp"} {
+			opts = append(opts, WithStreamDialerTransport(name, &transport.TCPDialer{}))
+		}
+		k, err := NewKindling("test", append(opts, extra...)...)
+		if err == nil {
+			t.Cleanup(func() { k.Close() })
+		}
+		return k, err
+	}
+
+	for _, tt := range []struct {
+		name, only, disable string
+		want                []string
+	}{
+		{"Unset", "", "", []string{"smart", "domainfront-akamai", "domainfront-cloudfront", "dnstt", "amp"}},
+		{"Disable", "", "dnstt, AMP", []string{"smart", "domainfront-akamai", "domainfront-cloudfront"}},
+		{"Only", "fronted", "", []string{"domainfront-akamai", "domainfront-cloudfront"}},
+		{"OnlyExact", "domainfront-akamai,smart", "", []string{"smart", "domainfront-akamai"}},
+		{"OnlyThenDisable", "domainfront", "domainfront-cloudfront", []string{"domainfront-akamai"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvOnly, tt.only)
+			t.Setenv(EnvDisable, tt.disable)
+			k, err := newKindling(t, WithEnvOverrides())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, transportNames(k))
+		})
+	}
+
+	t.Run("NoneLeft", func(t *testing.T) {
+		t.Setenv(EnvOnly, "tor")
+		_, err := newKindling(t, WithEnvOverrides())
+		assert.ErrorContains(t, err, "leave no transports")
+	})
+
+	t.Run("OptIn", func(t *testing.T) {
+		t.Setenv(EnvDisable, "smart,dnstt")
+		k, err := newKindling(t)
+		require.NoError(t, err)
+		assert.Len(t, transportNames(k), 5)
+	})
+}
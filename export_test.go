@@ -0,0 +1,10 @@
+package kindling
+
+// The loopback test servers, for the external conformance tests.
+var (
+	NewTURNServer      = newTURNServer
+	NewWebTunnelServer = newWebTunnelServer
+	ServeShadowsocks   = serveShadowsocks
+	ServeSOCKS5        = serveSOCKS5
+	TestShadowsocksKey = testShadowsocksKey
+)
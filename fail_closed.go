@@ -0,0 +1,182 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LeakKind says what a would-be leak reported by WithLeakAudit is.
+type LeakKind string
+
+const (
+	// LeakSystemDNS is a hostname lookup through the system resolver, which
+	// the local network can see and poison.
+	LeakSystemDNS LeakKind = "system-dns"
+	// LeakDirect is a plain connection to an origin that no circumvention
+	// technique hides, such as a WithHTTP3 dial.
+	LeakDirect LeakKind = "direct"
+)
+
+// Leak describes traffic that would leave kindling unprotected.
+type Leak struct {
+	Kind LeakKind
+	// Transport is the name of the transport the traffic was for, if any.
+	Transport string
+	// Host is the hostname looked up or the host dialed.
+	Host string
+	// Blocked is true when WithFailClosed stopped the traffic.
+	Blocked bool
+}
+
+// LeakError is returned, possibly wrapped in a *RaceError, for traffic
+// WithFailClosed blocked.
+type LeakError struct {
+	Leak
+}
+
+func (e *LeakError) Error() string {
+	if e.Transport != "" {
+		return fmt.Sprintf("fail-closed mode blocked %s traffic to %s on %s", e.Kind, e.Host, e.Transport)
+	}
+	return fmt.Sprintf("fail-closed mode blocked %s traffic to %s", e.Kind, e.Host)
+}
+
+// WithFailClosed guarantees that kindling never falls back to the system
+// resolver or to a plain, unobfuscated connection: such traffic fails with a
+// *LeakError instead. Hostnames kindling's own transports connect to must
+// then be resolved over WithDoHResolver, whose URL needs an IP address, or
+// by a WithStreamDialer override. The proxyless smart dialer loses its
+// system DNS and direct TLS strategies, and WithHTTP3 dials fail, since both
+// reach origins in the clear. Clients passed in by the caller, such as a
+// domainfront.Client, and custom transports are the caller's to vet.
+func WithFailClosed() Option {
+	return func(k *kindling) error {
+		k.failClosed = true
+		return nil
+	}
+}
+
+// WithLeakAudit calls fn for every lookup or connection that would leak past
+// kindling's protections, as WithFailClosed describes them. With
+// WithFailClosed the traffic is blocked and Leak.Blocked is set; without it
+// the traffic goes ahead, which lets deployments measure leaks before
+// turning fail-closed mode on. fn must not block.
+func WithLeakAudit(fn func(Leak)) Option {
+	return func(k *kindling) error {
+		if fn == nil {
+			return fmt.Errorf("leak audit is nil")
+		}
+		k.leakAudit = fn
+		return nil
+	}
+}
+
+// guardsLeaks reports whether WithFailClosed or WithLeakAudit is on.
+func (k *kindling) guardsLeaks() bool {
+	return k.failClosed || k.leakAudit != nil
+}
+
+// leak reports l and returns a *LeakError if fail-closed mode blocks it.
+func (k *kindling) leak(l Leak) error {
+	l.Blocked = k.failClosed
+	k.log.Warn("Unprotected traffic", "kind", l.Kind, "transport", l.Transport, "host", l.Host, slog.Bool("blocked", l.Blocked))
+	if k.leakAudit != nil {
+		k.leakAudit(l)
+	}
+	if l.Blocked {
+		return &LeakError{Leak: l}
+	}
+	return nil
+}
+
+// checkSystemDNS reports the system resolver lookup the default dialer would
+// make to dial addr, which is none for an IP address or with a
+// WithStreamDialer override, since that may resolve names remotely.
+func (k *kindling) checkSystemDNS(addr string) error {
+	if !k.guardsLeaks() || k.streamDialer != nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+	return k.leak(Leak{Kind: LeakSystemDNS, Host: host})
+}
+
+// guardedResolver reports lookups through the system resolver.
+type guardedResolver struct {
+	k    *kindling
+	next hostResolver
+}
+
+func (r *guardedResolver) lookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	if err := r.k.leak(Leak{Kind: LeakSystemDNS, Host: host}); err != nil {
+		return nil, err
+	}
+	return r.next.lookupIP(ctx, host)
+}
+
+// applyFailClosed guards the transports kindling built that dial origins
+// directly.
+func (k *kindling) applyFailClosed() {
+	if !k.guardsLeaks() {
+		return
+	}
+	for _, t := range k.transports {
+		if nt, ok := t.(*namedTransport); ok && nt.direct {
+			k.guardDirect(nt)
+		}
+	}
+}
+
+// guardDirect has nt report, and in fail-closed mode block, the origins it
+// would dial in the clear.
+func (k *kindling) guardDirect(nt *namedTransport) {
+	newRT := nt.newRT
+	nt.newRT = func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		if err := k.leak(Leak{Kind: LeakDirect, Transport: nt.name, Host: host}); err != nil {
+			return nil, err
+		}
+		return newRT(ctx, addr)
+	}
+}
+
+// failClosedSmartDialerConfig returns the smart dialer config cfg, or the
+// embedded one if cfg is nil, without its system DNS and direct TLS
+// strategies.
+func failClosedSmartDialerConfig(cfg []byte) ([]byte, error) {
+	if cfg == nil {
+		var err error
+		if cfg, err = configFS.ReadFile("smart_dialer_config.yml"); err != nil {
+			return nil, fmt.Errorf("reading smart dialer config: %w", err)
+		}
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(cfg, &doc); err != nil {
+		return nil, fmt.Errorf("parsing smart dialer config: %w", err)
+	}
+	dns, _ := doc["dns"].([]any)
+	dns = slices.DeleteFunc(dns, func(s any) bool {
+		m, ok := s.(map[string]any)
+		_, system := m["system"]
+		return ok && system
+	})
+	tls, _ := doc["tls"].([]any)
+	tls = slices.DeleteFunc(tls, func(s any) bool { return s == "" })
+	if len(dns) == 0 || len(tls) == 0 {
+		return nil, fmt.Errorf("fail-closed mode leaves the smart dialer config no dns or tls strategies")
+	}
+	doc["dns"], doc["tls"] = dns, tls
+	return yaml.Marshal(doc)
+}
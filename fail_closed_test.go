@@ -0,0 +1,104 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithFailClosed(t *testing.T) {
+	t.Parallel()
+
+	newKindling := func(t *testing.T, opts ...Option) (*kindling, *[]Leak) {
+		var mu sync.Mutex
+		var leaks []Leak
+		opts = append([]Option{WithLogWriter(io.Discard), WithHTTP3(), WithLeakAudit(func(l Leak) {
+			mu.Lock()
+			defer mu.Unlock()
+			leaks = append(leaks, l)
+		})}, opts...)
+		ki, err := NewKindling("test", opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { ki.Close() })
+		return ki.(*kindling), &leaks
+	}
+
+	t.Run("NilAudit_ReturnsError", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithLeakAudit(nil))
+		require.Error(t, err)
+	})
+
+	t.Run("BlocksDirectTransports", func(t *testing.T) {
+		t.Parallel()
+		k, leaks := newKindling(t, WithFailClosed())
+		_, err := k.transports[0].NewRoundTripper(context.Background(), "example.com:443")
+		var leakErr *LeakError
+		require.True(t, errors.As(err, &leakErr), "got %v", err)
+		assert.Equal(t, LeakDirect, leakErr.Kind)
+		assert.Equal(t, string(TransportHTTP3), leakErr.Transport)
+		assert.Equal(t, []Leak{{Kind: LeakDirect, Transport: string(TransportHTTP3), Host: "example.com", Blocked: true}}, *leaks)
+
+		_, err = k.NewHTTPClient().Get("https://example.com/")
+		assert.True(t, errors.As(err, &leakErr), "the race fails with the leak error, got %v", err)
+	})
+
+	t.Run("ReplacedTransportStaysBlocked", func(t *testing.T) {
+		t.Parallel()
+		k, leaks := newKindling(t, WithFailClosed())
+		require.NoError(t, k.ReplaceTransport(TransportHTTP3, func(context.Context, string) (http.RoundTripper, error) {
+			return &dummyRoundTripper{}, nil
+		}))
+		_, err := k.transports[0].NewRoundTripper(context.Background(), "example.com:443")
+		var leakErr *LeakError
+		require.True(t, errors.As(err, &leakErr), "got %v", err)
+		assert.Equal(t, string(TransportHTTP3), leakErr.Transport)
+		assert.Len(t, *leaks, 1)
+		assert.True(t, k.transports[0].(*namedTransport).direct)
+	})
+
+	t.Run("BlocksSystemResolver", func(t *testing.T) {
+		t.Parallel()
+		k, leaks := newKindling(t, WithFailClosed())
+		_, err := k.hostResolver().lookupIP(context.Background(), "example.com")
+		var leakErr *LeakError
+		require.True(t, errors.As(err, &leakErr), "got %v", err)
+		assert.Equal(t, []Leak{{Kind: LeakSystemDNS, Host: "example.com", Blocked: true}}, *leaks)
+
+		assert.Error(t, k.checkSystemDNS("dns.example:443"), "a doh server named by host needs the system resolver")
+		assert.NoError(t, k.checkSystemDNS("1.1.1.1:443"))
+	})
+
+	t.Run("AuditOnly_LetsTrafficThrough", func(t *testing.T) {
+		t.Parallel()
+		k, leaks := newKindling(t)
+		// A stand-in dial, so nothing goes out.
+		require.NoError(t, k.ReplaceTransport(TransportHTTP3, func(context.Context, string) (http.RoundTripper, error) {
+			return &dummyRoundTripper{}, nil
+		}))
+		_, err := k.transports[0].NewRoundTripper(context.Background(), "example.com:443")
+		require.NoError(t, err)
+		assert.Equal(t, []Leak{{Kind: LeakDirect, Transport: string(TransportHTTP3), Host: "example.com"}}, *leaks)
+	})
+}
+
+func TestFailClosedSmartDialerConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := failClosedSmartDialerConfig(nil)
+	require.NoError(t, err)
+	s := string(cfg)
+	assert.NotContains(t, s, "system")
+	assert.NotContains(t, s, `""`)
+	assert.Contains(t, s, "cloudflare-dns.com.")
+	assert.Contains(t, s, "tlsfrag:1")
+
+	_, err = failClosedSmartDialerConfig([]byte("dns:\n  - system: {}\ntls:\n  - \"\"\n"))
+	assert.Error(t, err, "a config that only leaks is rejected")
+}
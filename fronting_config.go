@@ -0,0 +1,81 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/getlantern/domainfront"
+)
+
+// WithDomainFrontingConfigURL adds domain fronting with a domainfront.Client
+// that kindling builds and closes itself, for callers that don't need to
+// tune one. The client starts from embeddedFallback, a gzipped fronting
+// config such as fronted.yaml.gz shipped with the app, and keeps it current
+// from configURL, which it fetches through kindling itself once NewKindling
+// returns and every 12 hours after. Use WithDomainFronting to pass in a
+// client built with other options.
+func WithDomainFrontingConfigURL(configURL string, embeddedFallback []byte) Option {
+	return func(k *kindling) error {
+		if configURL == "" {
+			return fmt.Errorf("domain fronting config url is empty")
+		}
+		cfg, err := domainfront.ParseConfig(embeddedFallback)
+		if err != nil {
+			return fmt.Errorf("parsing embedded domain fronting config: %w", err)
+		}
+		c, err := domainfront.New(k.ctx, cfg,
+			domainfront.WithConfigURL(configURL),
+			domainfront.WithHTTPClient(&http.Client{Transport: newSelfRoundTripper(k)}),
+			domainfront.WithLogger(k.log),
+		)
+		if err != nil {
+			return fmt.Errorf("creating domain fronting client: %w", err)
+		}
+		if err := k.onClose(closerFunc(c.Close)); err != nil {
+			return err
+		}
+		return WithDomainFronting(c)(k)
+	}
+}
+
+// selfRoundTripper sends requests through the kindling instance being
+// built, for clients created during NewKindling that fetch in the
+// background. Requests wait until NewKindling has returned.
+type selfRoundTripper struct {
+	k     *kindling
+	ready chan struct{}
+
+	once sync.Once
+	rt   http.RoundTripper
+}
+
+// newSelfRoundTripper returns a selfRoundTripper for k that opens once
+// NewKindling returns.
+func newSelfRoundTripper(k *kindling) *selfRoundTripper {
+	self := &selfRoundTripper{k: k, ready: make(chan struct{})}
+	k.background = append(k.background, func(context.Context) { close(self.ready) })
+	return self
+}
+
+func (s *selfRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-s.ready:
+	case <-req.Context().Done():
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, req.Context().Err()
+	}
+	s.once.Do(func() { s.rt = s.k.NewRoundTripper() })
+	return s.rt.RoundTrip(req)
+}
+
+// closerFunc adapts a Close method without a result to io.Closer.
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
@@ -0,0 +1,92 @@
+package kindling
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := io.WriteString(w, s)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestWithDomainFrontingConfigURL(t *testing.T) {
+	t.Parallel()
+	const configURL = "https://example.com/fronted.yaml.gz"
+	fallback := gzipped(t, `providers:
+  akamai:
+    hostaliases:
+      example.com: example.dsa.akamai.example.net
+    masquerades:
+      - domain: a248.e.akamai.net
+        ipaddress: 192.0.2.1
+`)
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithDomainFrontingConfigURL("", fallback))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithDomainFrontingConfigURL(configURL, []byte("not gzipped")))
+		assert.Error(t, err)
+	})
+
+	t.Run("AddsTransport", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithDomainFrontingConfigURL(configURL, fallback))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		var names []string
+		for _, tr := range k.(*kindling).snapshot() {
+			names = append(names, tr.Name())
+		}
+		assert.Equal(t, []string{string(TransportDomainfront)}, names)
+	})
+}
+
+func TestSelfRoundTripper(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("X-Kindling-Method"))
+	}))
+	t.Cleanup(server.Close)
+
+	k, err := NewKindling("test", WithStreamDialerTransport("direct", &transport.TCPDialer{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	self := &selfRoundTripper{k: k.(*kindling), ready: make(chan struct{})}
+	client := &http.Client{Transport: self}
+
+	t.Run("WaitsForNewKindling", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("SendsThroughKindling", func(t *testing.T) {
+		close(self.ready)
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "direct", string(body))
+	})
+}
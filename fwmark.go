@@ -0,0 +1,25 @@
+package kindling
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// WithFwmark sets the firewall mark mark (SO_MARK) on every socket kindling's
+// default dialers open, so a policy-routing rule can send them around a VPN
+// tunnel that would otherwise capture them, as when kindling bootstraps the
+// VPN app that owns the tunnel. It covers the same sockets as
+// WithDialerControl. Setting a mark takes CAP_NET_ADMIN, without which dials
+// fail. Linux and Android support it; elsewhere NewKindling fails.
+func WithFwmark(mark uint32) Option {
+	return func(k *kindling) error {
+		if mark == 0 {
+			return fmt.Errorf("fwmark must not be 0")
+		}
+		if !canSetMark {
+			return fmt.Errorf("setting a fwmark is not supported on %s", runtime.GOOS)
+		}
+		k.fwmark = mark
+		return nil
+	}
+}
@@ -0,0 +1,16 @@
+package kindling
+
+import "syscall"
+
+const canSetMark = true
+
+// setMark sets the socket's SO_MARK.
+func setMark(c syscall.RawConn, mark uint32) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
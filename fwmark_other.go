@@ -0,0 +1,14 @@
+//go:build !linux
+
+package kindling
+
+import (
+	"errors"
+	"syscall"
+)
+
+const canSetMark = false
+
+func setMark(syscall.RawConn, uint32) error {
+	return errors.ErrUnsupported
+}
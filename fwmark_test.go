@@ -0,0 +1,31 @@
+package kindling
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithFwmark(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithFwmark(0)(&kindling{}))
+	if runtime.GOOS != "linux" {
+		assert.Error(t, WithFwmark(0x100)(&kindling{}))
+		return
+	}
+
+	addr := closingListener(t, "127.0.0.1:0")
+	k := &kindling{}
+	require.NoError(t, WithFwmark(0x100)(k))
+	conn, err := k.baseStreamDialer().DialStream(t.Context(), addr)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("setting SO_MARK needs CAP_NET_ADMIN")
+	}
+	require.NoError(t, err)
+	conn.Close()
+}
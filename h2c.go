@@ -0,0 +1,69 @@
+package kindling
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// WithH2C sends http:// requests as cleartext HTTP/2 with prior knowledge
+// (h2c) instead of HTTP/1.1, for deployments whose control plane terminates
+// TLS upstream of an app server that speaks HTTP/2 without it. It applies to
+// the transports that connect to origins themselves, such as proxyless
+// dialing, Shadowsocks, and the other stream-based ones; https:// requests
+// still negotiate their protocol with ALPN. The origin must accept h2c, as
+// prior knowledge has no fallback to HTTP/1.1.
+func WithH2C() Option {
+	return func(k *kindling) error {
+		k.h2c = true
+		return nil
+	}
+}
+
+// applyH2C turns WithH2C on for the stream transports kindling built.
+func (k *kindling) applyH2C() {
+	if !k.h2c {
+		return
+	}
+	for _, t := range k.transports {
+		if nt, ok := t.(*namedTransport); ok && nt.dialer != nil {
+			nt.h2c = true
+		}
+	}
+}
+
+// roundTripper returns a single-connection round-tripper over conn for a
+// request made with ctx.
+func (t *namedTransport) roundTripper(ctx context.Context, conn net.Conn) http.RoundTripper {
+	rt := preconnectedTransport(conn)
+	rt.TLSClientConfig = withSNI(ctx, t.tlsConfig)
+	rt.HTTP2 = http2KeepAlive(t.keepAlive)
+	if !t.h2c {
+		return rt
+	}
+	h2c := preconnectedTransport(conn)
+	h2c.Protocols = new(http.Protocols)
+	h2c.Protocols.SetUnencryptedHTTP2(true)
+	h2c.HTTP2 = rt.HTTP2
+	return &h2cRoundTripper{h2c: h2c, Transport: rt}
+}
+
+// h2cRoundTripper sends http:// requests over h2c and the rest over the
+// embedded Transport. The two share one connection, of which only one
+// ever makes use.
+type h2cRoundTripper struct {
+	h2c *http.Transport
+	*http.Transport
+}
+
+func (r *h2cRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return r.h2c.RoundTrip(req)
+	}
+	return r.Transport.RoundTrip(req)
+}
+
+func (r *h2cRoundTripper) CloseIdleConnections() {
+	r.h2c.CloseIdleConnections()
+	r.Transport.CloseIdleConnections()
+}
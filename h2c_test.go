@@ -0,0 +1,73 @@
+package kindling
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithH2C(t *testing.T) {
+	t.Parallel()
+
+	k := &kindling{transports: []Transport{
+		newStreamTransport("direct", &transport.TCPDialer{}),
+		&namedTransport{name: "fronted"},
+	}}
+	require.NoError(t, WithH2C()(k))
+	k.applyH2C()
+	assert.True(t, k.transports[0].(*namedTransport).h2c)
+	assert.False(t, k.transports[1].(*namedTransport).h2c)
+}
+
+func TestH2C(t *testing.T) {
+	t.Parallel()
+
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+	// h2cServer speaks HTTP/1.1 and, with prior knowledge, cleartext HTTP/2.
+	h2cServer := httptest.NewUnstartedServer(proto)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetHTTP1(true)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	t.Cleanup(h2cServer.Close)
+	tlsServer := httptest.NewTLSServer(proto)
+	t.Cleanup(tlsServer.Close)
+
+	get := func(t *testing.T, h2c bool, client *http.Client, url string) string {
+		t.Helper()
+		direct := newStreamTransport("direct", &transport.TCPDialer{})
+		direct.h2c = h2c
+		if client != nil {
+			direct.tlsConfig = client.Transport.(*http.Transport).TLSClientConfig
+		}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{direct})
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, url, nil))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("PriorKnowledge", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "HTTP/2.0", get(t, true, nil, h2cServer.URL))
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "HTTP/1.1", get(t, false, nil, h2cServer.URL))
+	})
+
+	t.Run("TLSUnaffected", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "HTTP/1.1", get(t, true, tlsServer.Client(), tlsServer.URL))
+	})
+}
@@ -0,0 +1,93 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithHeadStart gives the named transport d alone before the other
+// transports of its tier start connecting, e.g. 300ms for the proxyless
+// smart transport, so domain fronting, AMP caching, and the like aren't
+// loaded when direct access works. The others start as soon as every
+// transport with a head start has failed to produce a usable response, or
+// the longest head start in the tier runs out, whichever comes first. Head
+// starts apply when racing; the other strategies already try transports one
+// at a time.
+func WithHeadStart(transportName string, d time.Duration) Option {
+	return func(k *kindling) error {
+		if transportName == "" {
+			return fmt.Errorf("head start transport name is empty")
+		}
+		if d <= 0 {
+			return fmt.Errorf("head start for %q must be positive, got %v", transportName, d)
+		}
+		if k.headStarts == nil {
+			k.headStarts = make(map[string]time.Duration)
+		}
+		k.headStarts[transportName] = d
+		return nil
+	}
+}
+
+// startConnects connects each of tier's transports to addr, sending the
+// outcomes on results. Transports with a head start connect at once and
+// the rest are held back: release connects those, and due fires when the
+// longest head start runs out. early is how many connected at once; once
+// that many outcomes have come in without a usable response, the caller
+// releases the rest without waiting. due and release are nil when nothing is
+// held back.
+//
+// The caller must call stop, with how many outcomes it received, once it's
+// done with the tier. stop cancels the connects still under way and parks
+// any connected round-tripper it never received in the pool.
+func (t *raceTransport) startConnects(ctx context.Context, tier []Transport, addr string, results chan connectResult) (early int, due <-chan time.Time, release func(), stop func(received int)) {
+	ctx, cancel := context.WithCancel(ctx)
+	started := 0
+	start := func(tr Transport) {
+		started++
+		go t.connect(ctx, tr, addr, results)
+	}
+	stop = func(received int) {
+		cancel()
+		pending := started - received
+		if pending == 0 {
+			return
+		}
+		t.logFor(ctx).Debug("Cancelling losing connection attempts", "count", pending)
+		go func() {
+			for range pending {
+				if r := <-results; r.unused != nil {
+					r.unused()
+				}
+			}
+		}()
+	}
+
+	var held []Transport
+	var delay time.Duration
+	for _, tr := range tier {
+		if d, ok := t.headStarts[tr.Name()]; ok {
+			delay = max(delay, d)
+			start(tr)
+			early++
+		} else {
+			held = append(held, tr)
+		}
+	}
+	if early == 0 || len(held) == 0 {
+		for _, tr := range held {
+			start(tr)
+		}
+		return len(tier), nil, nil, stop
+	}
+	t.logFor(ctx).Debug("Holding transports back for head start", "count", len(held), "delay", delay)
+	timer := t.clock.NewTimer(delay)
+	release = func() {
+		timer.Stop()
+		for _, tr := range held {
+			start(tr)
+		}
+	}
+	return early, timer.C(), release, stop
+}
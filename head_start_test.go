@@ -0,0 +1,98 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHeadStart(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithHeadStart("", time.Second)(&kindling{}))
+	assert.Error(t, WithHeadStart("smart", 0)(&kindling{}))
+	k := &kindling{}
+	require.NoError(t, WithHeadStart("smart", 300*time.Millisecond)(k))
+	assert.Equal(t, map[string]time.Duration{"smart": 300 * time.Millisecond}, k.headStarts)
+}
+
+func TestHeadStart(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	get := func(t *testing.T, rt *raceTransport) time.Duration {
+		t.Helper()
+		start := time.Now()
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return time.Since(start)
+	}
+
+	for name, mode := range map[string]IdempotencyMode{"Serial": IdempotencyStrict, "Parallel": IdempotencyParallel} {
+		t.Run("OthersWaitWhileItWorks/"+name, func(t *testing.T) {
+			t.Parallel()
+			rec := &dialRecorder{}
+			rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+				rec.transport("fronted", server.Client().Transport, nil),
+				rec.transport("smart", server.Client().Transport, nil),
+			})
+			rt.idempotency = mode
+			rt.headStarts = map[string]time.Duration{"smart": time.Minute}
+			get(t, rt)
+			assert.Equal(t, []string{"smart"}, rec.order())
+		})
+	}
+
+	t.Run("OthersStartWhenItFails", func(t *testing.T) {
+		t.Parallel()
+		rec := &dialRecorder{}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			rec.transport("fronted", server.Client().Transport, nil),
+			rec.transport("smart", nil, errors.New("blocked")),
+		})
+		rt.headStarts = map[string]time.Duration{"smart": time.Minute}
+		assert.Less(t, get(t, rt), 10*time.Second, "fronted waited out the head start")
+		assert.Equal(t, []string{"smart", "fronted"}, rec.order())
+	})
+
+	t.Run("OthersStartWhenItRunsOut", func(t *testing.T) {
+		t.Parallel()
+		hanging := &mockTransport{
+			name: "smart",
+			newRoundTripper: func(ctx context.Context, _ string) (http.RoundTripper, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}
+		rec := &dialRecorder{}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			rec.transport("fronted", server.Client().Transport, nil),
+			hanging,
+		})
+		rt.headStarts = map[string]time.Duration{"smart": 50 * time.Millisecond}
+		assert.GreaterOrEqual(t, get(t, rt), 50*time.Millisecond)
+		assert.Equal(t, []string{"fronted"}, rec.order())
+	})
+
+	t.Run("OnlyWithinTier", func(t *testing.T) {
+		t.Parallel()
+		rec := &dialRecorder{}
+		fallback := rec.transport("dnstt", server.Client().Transport, nil)
+		fallback.(*mockTransport).priority = priorityLastResort
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			rec.transport("smart", nil, errors.New("blocked")),
+			fallback,
+		})
+		rt.headStarts = map[string]time.Duration{"smart": time.Minute}
+		get(t, rt)
+		assert.Equal(t, []string{"smart", "dnstt"}, rec.order())
+	})
+}
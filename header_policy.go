@@ -0,0 +1,70 @@
+package kindling
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderPolicy controls the identification headers kindling adds to every
+// request it sends. By default it sends the app name in X-Kindling-App and
+// the transport's name in X-Kindling-Method, which is handy for origin-side
+// metrics but reveals the tool on the wire.
+type HeaderPolicy struct {
+	// AppHeader names the header carrying the app name passed to
+	// NewKindling. Empty omits it.
+	AppHeader string
+	// MethodHeader names the header carrying the name of the transport
+	// that sent the request. Empty omits it.
+	MethodHeader string
+	// RequestIDHeader names the header carrying the request's ID (see
+	// WithRequestID). Empty omits it.
+	RequestIDHeader string
+	// Static headers are added to every request that doesn't already
+	// set them.
+	Static http.Header
+}
+
+// defaultHeaderPolicy is what kindling sends without WithHeaderPolicy.
+var defaultHeaderPolicy = HeaderPolicy{
+	AppHeader:    "X-Kindling-App",
+	MethodHeader: "X-Kindling-Method",
+}
+
+// WithHeaderPolicy replaces the default identification headers with policy.
+// A zero HeaderPolicy sends none at all.
+func WithHeaderPolicy(policy HeaderPolicy) Option {
+	return func(k *kindling) error {
+		for _, name := range []string{policy.AppHeader, policy.MethodHeader, policy.RequestIDHeader} {
+			if name != "" && strings.ContainsAny(name, " \t\r\n:") {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+		}
+		policy.Static = policy.Static.Clone()
+		k.headerPolicy = &policy
+		return nil
+	}
+}
+
+// apply adds the policy's headers for app sent over method, with request ID
+// id, to h. Static headers don't override values the request already has. A
+// nil policy adds nothing.
+func (p *HeaderPolicy) apply(h http.Header, app, method, id string) {
+	if p == nil {
+		return
+	}
+	for k, v := range p.Static {
+		if h.Get(k) == "" {
+			h[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	if p.AppHeader != "" {
+		h.Set(p.AppHeader, app)
+	}
+	if p.MethodHeader != "" {
+		h.Set(p.MethodHeader, method)
+	}
+	if p.RequestIDHeader != "" && id != "" {
+		h.Set(p.RequestIDHeader, id)
+	}
+}
@@ -0,0 +1,70 @@
+package kindling
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHeaderPolicy(t *testing.T) {
+	t.Parallel()
+
+	// send returns the headers the transport saw for one GET.
+	send := func(t *testing.T, reqHeader http.Header, opts ...Option) http.Header {
+		t.Helper()
+		var seen http.Header
+		tr := &mockTransport{name: "mock", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				seen = req.Header.Clone()
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}), nil
+		}}
+		k, err := NewKindling("myapp", append([]Option{WithTransport(tr)}, opts...)...)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		for k, v := range reqHeader {
+			req.Header[k] = v
+		}
+		resp, err := k.NewHTTPClient().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return seen
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		h := send(t, nil)
+		assert.Equal(t, "myapp", h.Get("X-Kindling-App"))
+		assert.Equal(t, "mock", h.Get("X-Kindling-Method"))
+	})
+
+	t.Run("ZeroPolicySendsNothing", func(t *testing.T) {
+		t.Parallel()
+		h := send(t, nil, WithHeaderPolicy(HeaderPolicy{}))
+		assert.Empty(t, h.Get("X-Kindling-App"))
+		assert.Empty(t, h.Get("X-Kindling-Method"))
+	})
+
+	t.Run("RenamedAndStatic", func(t *testing.T) {
+		t.Parallel()
+		h := send(t, http.Header{"X-Client": {"caller"}}, WithHeaderPolicy(HeaderPolicy{
+			AppHeader: "X-Client-App",
+			Static:    http.Header{"x-client": {"static"}, "X-Build": {"42"}},
+		}))
+		assert.Equal(t, "myapp", h.Get("X-Client-App"))
+		assert.Empty(t, h.Get("X-Kindling-App"))
+		assert.Empty(t, h.Get("X-Kindling-Method"))
+		assert.Equal(t, "42", h.Get("X-Build"))
+		assert.Equal(t, "caller", h.Get("X-Client"), "static headers don't override the request's own")
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("myapp", WithHeaderPolicy(HeaderPolicy{MethodHeader: "Bad Header"}))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,101 @@
+package kindling
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HeaderProfile is a browser whose request headers WithHeaderProfile
+// mimics.
+type HeaderProfile int
+
+const (
+	// Chrome is desktop Chrome on Windows.
+	Chrome HeaderProfile = iota + 1
+	// Firefox is desktop Firefox on Windows.
+	Firefox
+	// Safari is Safari on macOS.
+	Safari
+)
+
+// String returns the profile's name.
+func (p HeaderProfile) String() string {
+	switch p {
+	case Chrome:
+		return "chrome"
+	case Firefox:
+		return "firefox"
+	case Safari:
+		return "safari"
+	}
+	return fmt.Sprintf("HeaderProfile(%d)", int(p))
+}
+
+// headerProfiles are the headers each browser sends when navigating to a
+// page, less Accept-Encoding, which net/http or WithCompression negotiate so
+// that they can decode what comes back.
+var headerProfiles = map[HeaderProfile]http.Header{
+	Chrome: {
+		"User-Agent":                {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36"},
+		"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+		"Accept-Language":           {"en-US,en;q=0.9"},
+		"Sec-Ch-Ua":                 {`"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`},
+		"Sec-Ch-Ua-Mobile":          {"?0"},
+		"Sec-Ch-Ua-Platform":        {`"Windows"`},
+		"Sec-Fetch-Dest":            {"document"},
+		"Sec-Fetch-Mode":            {"navigate"},
+		"Sec-Fetch-Site":            {"none"},
+		"Sec-Fetch-User":            {"?1"},
+		"Upgrade-Insecure-Requests": {"1"},
+	},
+	Firefox: {
+		"User-Agent":                {"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:133.0) Gecko/20100101 Firefox/133.0"},
+		"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		"Accept-Language":           {"en-US,en;q=0.5"},
+		"Sec-Fetch-Dest":            {"document"},
+		"Sec-Fetch-Mode":            {"navigate"},
+		"Sec-Fetch-Site":            {"none"},
+		"Sec-Fetch-User":            {"?1"},
+		"Upgrade-Insecure-Requests": {"1"},
+	},
+	Safari: {
+		"User-Agent":      {"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.1 Safari/605.1.15"},
+		"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		"Accept-Language": {"en-US,en;q=0.9"},
+		"Sec-Fetch-Dest":  {"document"},
+		"Sec-Fetch-Mode":  {"navigate"},
+		"Sec-Fetch-Site":  {"none"},
+	},
+}
+
+// WithHeaderProfile sends the User-Agent, Accept, Accept-Language, and
+// client hint headers profile's browser does, so requests blend in with
+// ambient browser traffic. Pair it with the same browser's WithTLSFingerprint
+// so the handshake tells the same story, and with a HeaderPolicy that omits
+// the identification headers. Headers a request already has, and the
+// HeaderPolicy's Static headers, take precedence. net/http decides the order
+// headers are written in, so only their values match the browser's.
+func WithHeaderProfile(profile HeaderProfile) Option {
+	return func(k *kindling) error {
+		if _, ok := headerProfiles[profile]; !ok {
+			return fmt.Errorf("unknown header profile %d", profile)
+		}
+		k.headerProfile = profile
+		return nil
+	}
+}
+
+// withProfile returns a copy of p that also adds profile's headers to
+// requests without them. A nil p sends only those.
+func (p *HeaderPolicy) withProfile(profile HeaderProfile) *HeaderPolicy {
+	merged := HeaderPolicy{}
+	if p != nil {
+		merged = *p
+	}
+	static := headerProfiles[profile].Clone()
+	for k, v := range merged.Static {
+		static[http.CanonicalHeaderKey(k)] = v
+	}
+	merged.Static = static
+	return &merged
+}
@@ -0,0 +1,69 @@
+package kindling
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHeaderProfile(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithHeaderProfile(0)(&kindling{}))
+	assert.Error(t, WithHeaderProfile(Safari+1)(&kindling{}))
+	assert.Equal(t, "firefox", Firefox.String())
+
+	// send returns the headers the transport saw for one GET.
+	send := func(t *testing.T, reqHeader http.Header, opts ...Option) http.Header {
+		t.Helper()
+		var seen http.Header
+		tr := &mockTransport{name: "mock", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				seen = req.Header.Clone()
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}), nil
+		}}
+		k, err := NewKindling("myapp", append([]Option{WithTransport(tr)}, opts...)...)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		for k, v := range reqHeader {
+			req.Header[k] = v
+		}
+		resp, err := k.NewHTTPClient().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return seen
+	}
+
+	for _, profile := range []HeaderProfile{Chrome, Firefox, Safari} {
+		t.Run(profile.String(), func(t *testing.T) {
+			t.Parallel()
+			h := send(t, nil, WithHeaderProfile(profile), WithHeaderPolicy(HeaderPolicy{}))
+			assert.Equal(t, headerProfiles[profile], h)
+			assert.Empty(t, h.Get("Accept-Encoding"), "left to net/http so it can decode")
+		})
+	}
+
+	t.Run("ChromeClientHints", func(t *testing.T) {
+		t.Parallel()
+		h := send(t, nil, WithHeaderProfile(Chrome))
+		assert.Contains(t, h.Get("User-Agent"), "Chrome/")
+		assert.Equal(t, `"Windows"`, h.Get("Sec-Ch-Ua-Platform"))
+		assert.Equal(t, "myapp", h.Get("X-Kindling-App"), "the header policy still applies")
+	})
+
+	t.Run("RequestAndStaticWin", func(t *testing.T) {
+		t.Parallel()
+		h := send(t, http.Header{"Accept": {"application/json"}},
+			WithHeaderPolicy(HeaderPolicy{Static: http.Header{"accept-language": {"fa-IR"}}}),
+			WithHeaderProfile(Firefox),
+		)
+		assert.Equal(t, "application/json", h.Get("Accept"))
+		assert.Equal(t, []string{"fa-IR"}, h.Values("Accept-Language"))
+		assert.Contains(t, h.Get("User-Agent"), "Firefox/")
+	})
+}
@@ -0,0 +1,58 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds one round of health check probes.
+const healthCheckTimeout = 30 * time.Second
+
+// WithHealthCheck probes every transport with a HEAD request for url in the
+// background, once NewKindling returns and then every interval. Results feed
+// the circuit breaker (see WithCircuitBreaker) just as real requests do, so
+// dead transports are quarantined, and recovered ones let back in, before a
+// user request has to find out. Unless WithCircuitBreaker is also given,
+// the breaker is turned on with 5 failures and a 30 second cooldown.
+// OnNetworkChange probes again right away. url should be cheap to fetch and
+// reachable from every transport.
+func WithHealthCheck(url string, interval time.Duration) Option {
+	return func(k *kindling) error {
+		if interval <= 0 {
+			return fmt.Errorf("health check interval must be positive")
+		}
+		if _, err := http.NewRequest(http.MethodHead, url, nil); err != nil {
+			return fmt.Errorf("invalid health check url: %w", err)
+		}
+		k.deferred = append(k.deferred, func() error {
+			if !k.breakerSet && k.breaker == nil {
+				k.breaker = newCircuitBreaker(defaultBreakerFailures, defaultBreakerCooldown)
+			}
+			return nil
+		})
+		k.background = append(k.background, func(ctx context.Context) { k.runHealthCheck(ctx, url, interval) })
+		return nil
+	}
+}
+
+// runHealthCheck probes immediately, then every interval and after every
+// network change, until ctx is done.
+func (k *kindling) runHealthCheck(ctx context.Context, url string, interval time.Duration) {
+	for {
+		next := k.clock.Now().Add(interval)
+		// Taken before probing, so a network change mid-round probes again.
+		changed := k.networkChanged()
+		probeCtx, cancel := contextWithTimeout(ctx, k.clock, healthCheckTimeout)
+		for name, res := range k.Probe(probeCtx, url) {
+			if res.Err != nil {
+				k.log.Debug("Health check failed", "name", name, "error", res.Err)
+			}
+		}
+		cancel()
+		if !k.sleepUnlessChanged(ctx, changed, next.Sub(k.clock.Now())) {
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	var heads atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+	}))
+	t.Cleanup(origin.Close)
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithHealthCheck(origin.URL, 0))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithHealthCheck("://nope", time.Minute))
+		assert.Error(t, err)
+	})
+
+	t.Run("TurnsOnBreaker", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithHealthCheck(origin.URL, time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		breaker := k.(*kindling).breaker
+		require.NotNil(t, breaker)
+		assert.Equal(t, defaultBreakerFailures, breaker.threshold)
+		assert.Equal(t, defaultBreakerCooldown, breaker.cooldown)
+
+		k, err = NewKindling("test", WithHealthCheck(origin.URL, time.Hour), WithCircuitBreaker(0, 0))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		assert.Nil(t, k.(*kindling).breaker, "an explicitly disabled breaker stays off")
+	})
+
+	t.Run("QuarantinesBeforeFirstRequest", func(t *testing.T) {
+		t.Parallel()
+		broken := &mockTransport{
+			name: "broken",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return nil, errors.New("blocked")
+			},
+		}
+		direct := &mockTransport{
+			name: "direct",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return http.DefaultTransport, nil
+			},
+		}
+		k, err := NewKindling("test",
+			WithTransport(broken),
+			WithTransport(direct),
+			WithCircuitBreaker(2, time.Hour),
+			WithHealthCheck(origin.URL, 10*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			infos := k.Transports()
+			return infos[0].State == TransportQuarantined && !infos[1].LastSuccess.IsZero()
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool { return heads.Load() >= 2 }, 5*time.Second, 10*time.Millisecond,
+			"probes repeat every interval")
+	})
+}
@@ -0,0 +1,68 @@
+package kindling
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHostNotAllowed is returned, wrapped, for requests to a destination that
+// WithAllowedHosts or WithBlockedHosts rules out.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// WithAllowedHosts restricts kindling to carrying requests for the given
+// domains and their subdomains (or exact IP addresses). Requests anywhere
+// else fail with ErrHostNotAllowed before any transport is dialed, which
+// guarantees an embedded client is only ever used for its control-plane
+// hosts. Repeated calls extend the list; redirects and ListenSOCKS5
+// connections are checked too. Resolve's DNS-over-HTTPS server is exempt.
+func WithAllowedHosts(hosts ...string) Option {
+	return func(k *kindling) error {
+		if len(hosts) == 0 {
+			return fmt.Errorf("allowed hosts list is empty")
+		}
+		return addHosts(&k.hostFilter.allowed, hosts)
+	}
+}
+
+// WithBlockedHosts refuses requests for the given domains and their
+// subdomains (or exact IP addresses) with ErrHostNotAllowed. Blocking takes
+// precedence over WithAllowedHosts.
+func WithBlockedHosts(hosts ...string) Option {
+	return func(k *kindling) error {
+		return addHosts(&k.hostFilter.blocked, hosts)
+	}
+}
+
+func addHosts(set *map[string]struct{}, hosts []string) error {
+	if *set == nil {
+		*set = make(map[string]struct{})
+	}
+	for _, h := range hosts {
+		d := normalizeDomain(h)
+		if d == "" {
+			return fmt.Errorf("empty host in host list")
+		}
+		(*set)[d] = struct{}{}
+	}
+	return nil
+}
+
+// hostFilter holds the WithAllowedHosts / WithBlockedHosts lists. It is
+// read-only once NewKindling returns.
+type hostFilter struct {
+	allowed map[string]struct{}
+	blocked map[string]struct{}
+}
+
+// check returns a wrapped ErrHostNotAllowed if host may not be contacted.
+func (f hostFilter) check(host string) error {
+	if _, domain, ok := lookupDomain(f.blocked, host); ok {
+		return fmt.Errorf("%w: %s is blocked by %q", ErrHostNotAllowed, host, domain)
+	}
+	if f.allowed != nil {
+		if _, _, ok := lookupDomain(f.allowed, host); !ok {
+			return fmt.Errorf("%w: %s is not in the allowed hosts", ErrHostNotAllowed, host)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostFilter(t *testing.T) {
+	t.Parallel()
+	var f hostFilter
+	require.NoError(t, addHosts(&f.allowed, []string{"example.com", "192.0.2.1"}))
+	require.NoError(t, addHosts(&f.blocked, []string{"tracker.example.com"}))
+
+	assert.NoError(t, f.check("example.com"))
+	assert.NoError(t, f.check("api.example.com"))
+	assert.NoError(t, f.check("192.0.2.1"))
+	assert.ErrorIs(t, f.check("tracker.example.com"), ErrHostNotAllowed)
+	assert.ErrorIs(t, f.check("a.tracker.example.com"), ErrHostNotAllowed)
+	assert.ErrorIs(t, f.check("example.org"), ErrHostNotAllowed)
+	assert.ErrorIs(t, f.check("badexample.com"), ErrHostNotAllowed)
+
+	assert.NoError(t, hostFilter{}.check("anything.test"), "no lists allows everything")
+}
+
+func TestWithAllowedHosts(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			calls.Add(1)
+			return http.DefaultTransport, nil
+		},
+	}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://elsewhere.test/", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(origin.Close)
+
+	t.Run("InvalidArguments", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithAllowedHosts())
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithBlockedHosts(""))
+		assert.Error(t, err)
+	})
+
+	k, err := NewKindling("test", WithTransport(direct), WithAllowedHosts("127.0.0.1"))
+	require.NoError(t, err)
+
+	t.Run("AllowedHost", func(t *testing.T) {
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+
+	t.Run("OtherHost_RefusedWithoutDialing", func(t *testing.T) {
+		before := calls.Load()
+		_, err := k.NewHTTPClient().Get("http://elsewhere.test/")
+		assert.ErrorIs(t, err, ErrHostNotAllowed)
+		assert.Equal(t, before, calls.Load())
+	})
+
+	t.Run("RedirectToOtherHost_Refused", func(t *testing.T) {
+		_, err := k.NewHTTPClient().Get(origin.URL + "/redirect")
+		assert.ErrorIs(t, err, ErrHostNotAllowed)
+	})
+
+	t.Run("BlockedHost", func(t *testing.T) {
+		kb, err := NewKindling("test", WithTransport(direct), WithBlockedHosts("127.0.0.1"))
+		require.NoError(t, err)
+		_, err = kb.NewHTTPClient().Get(origin.URL)
+		assert.ErrorIs(t, err, ErrHostNotAllowed)
+		_, err = kb.(*kindling).DialStream(context.Background(), origin.Listener.Addr().String())
+		assert.ErrorIs(t, err, ErrHostNotAllowed)
+	})
+}
@@ -0,0 +1,127 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+type sniKey struct{}
+
+// WithHostMapping connects requests for each hostname in mapping to the
+// address it maps to instead, leaving the URL and Host header alone, so a
+// blocked hostname can be reached at a known-good IP or through an
+// alternate front, as mirror deployments do. Targets are an IP address or
+// hostname, with an optional port; without one the request's port is used.
+// The TLS handshake presents the target's name when it is a hostname and the
+// original hostname when it is an IP, unless WithSNI says otherwise.
+//
+// Mappings apply to the transports that connect to origins themselves, such
+// as proxyless dialing, Shadowsocks, and the other stream-based ones, and to
+// DialStream and DialPacket. Domain fronting, AMP caching, and DNS
+// tunneling reach origins their own way. Domain policies and the host
+// filter see the original hostname.
+func WithHostMapping(mapping map[string]string) Option {
+	return func(k *kindling) error {
+		for host, target := range mapping {
+			if host == "" {
+				return fmt.Errorf("host mapping has an empty hostname")
+			}
+			if h, _ := splitTarget(target); h == "" {
+				return fmt.Errorf("host mapping for %q has no target", host)
+			}
+			if k.hostMapping == nil {
+				k.hostMapping = make(hostMapping)
+			}
+			k.hostMapping[normalizeHost(host)] = target
+		}
+		return nil
+	}
+}
+
+// WithSNI returns a context that has requests made with it present
+// serverName in their TLS handshakes in place of the URL's hostname, for
+// example to front a request through another domain on the same CDN. Like
+// WithHostMapping, it applies to the transports that connect to origins
+// themselves. The certificate must be valid for serverName.
+func WithSNI(ctx context.Context, serverName string) context.Context {
+	return context.WithValue(ctx, sniKey{}, serverName)
+}
+
+// sniFrom returns the TLS server name set for ctx, or "" to use the
+// request's hostname.
+func sniFrom(ctx context.Context) string {
+	sni, _ := ctx.Value(sniKey{}).(string)
+	return sni
+}
+
+// withSNI returns cfg, cloned, presenting ctx's TLS server name if it has
+// one.
+func withSNI(ctx context.Context, cfg *tls.Config) *tls.Config {
+	cfg = cfg.Clone()
+	if sni := sniFrom(ctx); sni != "" {
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		cfg.ServerName = sni
+	}
+	return cfg
+}
+
+// hostMapping maps lowercase hostnames to the addresses to connect to in
+// their place (see WithHostMapping).
+type hostMapping map[string]string
+
+// route returns where to connect for addr, a host:port, and the TLS server
+// name to present there, "" meaning the request's own hostname. ctx's
+// WithSNI override, if any, wins.
+func (m hostMapping) route(ctx context.Context, addr string) (dialAddr, sni string) {
+	sni = sniFrom(ctx)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, sni
+	}
+	target, ok := m[normalizeHost(host)]
+	if !ok {
+		return addr, sni
+	}
+	targetHost, targetPort := splitTarget(target)
+	if targetPort == "" {
+		targetPort = port
+	}
+	if sni == "" {
+		sni = targetHost
+		if _, err := netip.ParseAddr(targetHost); err == nil {
+			// Certificates are for names, not addresses.
+			sni = host
+		}
+	}
+	return net.JoinHostPort(targetHost, targetPort), sni
+}
+
+// dialTarget returns where to connect for addr, a host:port, and ctx
+// carrying the TLS server name to present there.
+func (t *raceTransport) dialTarget(ctx context.Context, addr string) (context.Context, string) {
+	addr, sni := t.hostMapping.route(ctx, addr)
+	if sni != sniFrom(ctx) {
+		ctx = WithSNI(ctx, sni)
+	}
+	return ctx, addr
+}
+
+// splitTarget splits a host mapping target into its host and port, which
+// may be empty.
+func splitTarget(target string) (host, port string) {
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		return h, p
+	}
+	// A bare IPv6 address, possibly bracketed, or a hostname.
+	return strings.TrimSuffix(strings.TrimPrefix(target, "["), "]"), ""
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
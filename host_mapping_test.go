@@ -0,0 +1,118 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHostMapping(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithHostMapping(map[string]string{"": "192.0.2.1"})(&kindling{}))
+	assert.Error(t, WithHostMapping(map[string]string{"blocked.example": ""})(&kindling{}))
+
+	k := &kindling{}
+	require.NoError(t, WithHostMapping(map[string]string{"Blocked.Example.": "192.0.2.1"})(k))
+	require.NoError(t, WithHostMapping(map[string]string{"other.example": "front.example:8443"})(k))
+	assert.Equal(t, hostMapping{"blocked.example": "192.0.2.1", "other.example": "front.example:8443"}, k.hostMapping)
+}
+
+func TestHostMappingRoute(t *testing.T) {
+	t.Parallel()
+
+	m := hostMapping{
+		"ip.example":    "192.0.2.1",
+		"port.example":  "192.0.2.1:8443",
+		"front.example": "cdn.example",
+		"v6.example":    "2001:db8::1",
+	}
+	tests := []struct {
+		name, addr, sni string
+		wantAddr        string
+		wantSNI         string
+	}{
+		{name: "Unmapped", addr: "other.example:443", wantAddr: "other.example:443"},
+		{name: "ToIP", addr: "ip.example:443", wantAddr: "192.0.2.1:443", wantSNI: "ip.example"},
+		{name: "CaseInsensitive", addr: "IP.Example:443", wantAddr: "192.0.2.1:443", wantSNI: "IP.Example"},
+		{name: "ToIPAndPort", addr: "port.example:443", wantAddr: "192.0.2.1:8443", wantSNI: "port.example"},
+		{name: "ToFront", addr: "front.example:443", wantAddr: "cdn.example:443", wantSNI: "cdn.example"},
+		{name: "ToIPv6", addr: "v6.example:80", wantAddr: "[2001:db8::1]:80", wantSNI: "v6.example"},
+		{name: "SNIOverride", addr: "front.example:443", sni: "cover.example", wantAddr: "cdn.example:443", wantSNI: "cover.example"},
+		{name: "SNIOverrideUnmapped", addr: "other.example:443", sni: "cover.example", wantAddr: "other.example:443", wantSNI: "cover.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			if tt.sni != "" {
+				ctx = WithSNI(ctx, tt.sni)
+			}
+			addr, sni := m.route(ctx, tt.addr)
+			assert.Equal(t, tt.wantAddr, addr)
+			assert.Equal(t, tt.wantSNI, sni)
+		})
+	}
+}
+
+func TestHostMapping(t *testing.T) {
+	t.Parallel()
+
+	// httptest's certificate is valid for example.com and 127.0.0.1.
+	type seen struct{ host, sni string }
+	seenCh := make(chan seen, 10)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenCh <- seen{r.Host, r.TLS.ServerName}
+		io.WriteString(w, "mirror")
+	}))
+	t.Cleanup(server.Close)
+
+	newRT := func(mapping hostMapping) *raceTransport {
+		direct := newStreamTransport("direct", &transport.TCPDialer{})
+		direct.tlsConfig = &tls.Config{RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{direct})
+		rt.hostMapping = mapping
+		return rt
+	}
+	get := func(t *testing.T, rt *raceTransport, req *http.Request) seen {
+		t.Helper()
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "mirror", string(body))
+		return <-seenCh
+	}
+
+	t.Run("ToKnownGoodIP", func(t *testing.T) {
+		t.Parallel()
+		rt := newRT(hostMapping{"example.com": server.Listener.Addr().String()})
+		got := get(t, rt, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+		assert.Equal(t, seen{host: "example.com", sni: "example.com"}, got)
+	})
+
+	t.Run("WithSNI", func(t *testing.T) {
+		t.Parallel()
+		rt := newRT(hostMapping{"blocked.example": server.Listener.Addr().String()})
+		req := httptest.NewRequest(http.MethodGet, "https://blocked.example/", nil)
+		req = req.WithContext(WithSNI(req.Context(), "example.com"))
+		got := get(t, rt, req)
+		assert.Equal(t, seen{host: "blocked.example", sni: "example.com"}, got)
+	})
+
+	t.Run("PooledApartBySNI", func(t *testing.T) {
+		t.Parallel()
+		key := func(ctx context.Context) poolKey {
+			return poolKeyFor(ctx, newStreamTransport("direct", nil), "192.0.2.1:443")
+		}
+		assert.NotEqual(t, key(context.Background()), key(WithSNI(context.Background(), "cover.example")))
+	})
+}
@@ -0,0 +1,134 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// WithHTTP3 adds a direct HTTP/3 (QUIC) transport. QUIC runs over UDP, so it
+// often slips past the SNI-triggered TCP resets that kill direct TLS
+// connections, which makes it a cheap, fast first option in the race.
+// WithFailClosed rules it out, since the connection is in the clear.
+//
+// Each race attempt completes a QUIC handshake with the origin before the
+// transport counts as connected, and the connection is pooled for later
+// requests like a stream transport's. Hostnames are resolved like the other
+// transports', and the UDP socket gets the instance's socket settings, such
+// as WithInterface and WithDialerControl. WithPacketDialer doesn't apply,
+// since QUIC needs a socket of its own. Origins that don't speak HTTP/3 fail
+// the handshake and leave the race to the other transports.
+func WithHTTP3() Option {
+	return func(k *kindling) error {
+		h3 := &http3.Transport{}
+		k.transports = append(k.transports, &namedTransport{
+			name:         string(TransportHTTP3),
+			isStreamable: true,
+			direct:       true,
+			reusable:     true,
+			newRT: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				rt, err := k.dialHTTP3(ctx, h3, addr)
+				if err != nil {
+					return nil, fmt.Errorf("http3 dial: %w", err)
+				}
+				return rt, nil
+			},
+		})
+		return nil
+	}
+}
+
+// dialHTTP3 completes a QUIC handshake with addr, a host:port, and returns
+// a round-tripper bound to the connection.
+func (k *kindling) dialHTTP3(ctx context.Context, h3 *http3.Transport, addr string) (http.RoundTripper, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		ips, err := k.hostResolver().lookupIP(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses for %s", host)
+		}
+		ip = ips[0]
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	d := k.netDialer("udp")
+	lc := net.ListenConfig{Control: d.Control}
+	local := ""
+	if d.LocalAddr != nil {
+		local = d.LocalAddr.String()
+	}
+	pc, err := lc.ListenPacket(ctx, "udp", local)
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: pc}
+	tlsConfig := k.tlsConfig(&tls.Config{
+		ServerName:   host,
+		RootCAs:      k.rootCAs,
+		Certificates: k.clientCerts,
+		NextProtos:   []string{http3.NextProtoH3},
+	})
+	conn, err := tr.Dial(ctx, udpAddr, withSNI(ctx, tlsConfig), &quic.Config{
+		MaxIdleTimeout:  tunnelIdleTimeout,
+		KeepAlivePeriod: k.keepAlive,
+	})
+	if err != nil {
+		tr.Close()
+		pc.Close()
+		return nil, err
+	}
+	go func() {
+		// The socket is the connection's alone, so it goes when the
+		// connection does, whether closed or timed out.
+		<-conn.Context().Done()
+		tr.Close()
+		pc.Close()
+	}()
+	return &http3RoundTripper{ClientConn: h3.NewClientConn(conn), conn: conn}, nil
+}
+
+// http3RoundTripper carries requests over a single QUIC connection.
+type http3RoundTripper struct {
+	*http3.ClientConn
+	conn *quic.Conn
+}
+
+func (r *http3RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var err error
+	switch {
+	case req.URL.Scheme != "https":
+		err = fmt.Errorf("http3 carries https requests only, not %s", req.URL.Scheme)
+	case r.conn.Context().Err() != nil:
+		// The connection went away while pooled; nothing was sent.
+		err = errTunnelUsed
+	}
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return r.ClientConn.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the QUIC connection, which the pool only
+// does once no request is using it.
+func (r *http3RoundTripper) CloseIdleConnections() {
+	r.conn.CloseWithError(0, "")
+}
@@ -0,0 +1,84 @@
+package kindling
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHTTP3Server starts an HTTP/3 server for handler on the loopback
+// interface and returns its URL and a client TLS config that trusts it.
+func newHTTP3Server(t *testing.T, handler http.Handler) (string, *tls.Config) {
+	t.Helper()
+	// Borrow httptest's certificate, which is valid for 127.0.0.1.
+	certs := httptest.NewTLSServer(handler)
+	certs.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: certs.TLS.Certificates}),
+	}
+	go srv.Serve(pc)
+	t.Cleanup(func() {
+		srv.Close()
+		pc.Close()
+	})
+	return "https://" + pc.LocalAddr().String(), certs.Client().Transport.(*http.Transport).TLSClientConfig
+}
+
+func TestWithHTTP3(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RegistersDirectTransport", func(t *testing.T) {
+		t.Parallel()
+		ki, err := NewKindling("test", WithHTTP3())
+		require.NoError(t, err)
+
+		k := ki.(*kindling)
+		require.Len(t, k.transports, 1)
+		tr := k.transports[0]
+		assert.Equal(t, string(TransportHTTP3), tr.Name())
+		assert.True(t, tr.IsStreamable())
+		assert.Equal(t, 0, tr.MaxLength())
+		assert.True(t, tr.(*namedTransport).direct)
+		assert.True(t, reusable(tr))
+	})
+
+	t.Run("CarriesRequests", func(t *testing.T) {
+		t.Parallel()
+		url, clientTLS := newHTTP3Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto+" from "+r.RemoteAddr)
+		}))
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithHTTP3(), WithRootCAs(clientTLS.RootCAs))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+
+		var bodies []string
+		for range 2 {
+			resp, err := k.NewHTTPClient().Get(url)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+			bodies = append(bodies, string(body))
+		}
+		assert.Contains(t, bodies[0], "HTTP/3.0")
+		assert.Equal(t, bodies[0], bodies[1], "the second request reuses the QUIC connection")
+	})
+
+	t.Run("PlainHTTPRefused", func(t *testing.T) {
+		t.Parallel()
+		rt := &http3RoundTripper{}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		_, err := rt.RoundTrip(req)
+		assert.ErrorContains(t, err, "https requests only")
+	})
+}
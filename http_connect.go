@@ -0,0 +1,149 @@
+package kindling
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// connectDialer is a transport.StreamDialer that tunnels each stream through
+// an HTTP proxy using the CONNECT method (RFC 9110 §9.3.6). The hop to the
+// proxy is made with base and, when tlsConfig is set, wrapped in TLS so the
+// CONNECT exchange itself isn't visible on the wire.
+type connectDialer struct {
+	base      transport.StreamDialer
+	proxyAddr string
+	tlsConfig *tls.Config
+	// header is sent with every CONNECT request, e.g. Proxy-Authorization.
+	header http.Header
+	// auth, when set, answers the proxy's Basic or Digest challenges.
+	auth *proxyAuth
+}
+
+var _ transport.StreamDialer = (*connectDialer)(nil)
+
+func (d *connectDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	dial := func() (transport.StreamConn, error) {
+		return dialHTTPTunnel(ctx, d.base, d.proxyAddr, d.tlsConfig, func(conn net.Conn) (*bufio.Reader, error) {
+			return d.connect(conn, addr)
+		})
+	}
+	conn, err := dial()
+	var authErr *proxyAuthError
+	if d.auth != nil && errors.As(err, &authErr) && d.auth.challenged(authErr.challenges) {
+		// Proxies often close the connection after a 407, so the answer
+		// to the challenge goes out on a new one.
+		return dial()
+	}
+	return conn, err
+}
+
+// dialHTTPTunnel dials serverAddr through base, wraps the connection in TLS
+// when tlsConfig is set, and runs handshake on it to turn it into a tunnel
+// (a CONNECT, an Upgrade, ...). handshake returns the reader it parsed the
+// server's response from, which may already hold tunneled bytes.
+func dialHTTPTunnel(
+	ctx context.Context,
+	base transport.StreamDialer,
+	serverAddr string,
+	tlsConfig *tls.Config,
+	handshake func(net.Conn) (*bufio.Reader, error),
+) (transport.StreamConn, error) {
+	raw, err := base.DialStream(ctx, serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", serverAddr, err)
+	}
+	var conn net.Conn = raw
+	if tlsConfig != nil {
+		tc := tls.Client(raw, tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("tls handshake with %s: %w", serverAddr, err)
+		}
+		conn = tc
+	}
+
+	// Abort the handshake if ctx is done; clear the deadline once the
+	// tunnel is up so it doesn't leak into the caller's use of the stream.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	br, err := handshake(conn)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if !stop() {
+		// AfterFunc already fired, so the deadline is poisoned.
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return &tunnelConn{Conn: conn, raw: raw, r: br}, nil
+}
+
+// connect sends the CONNECT request for addr over conn and reads the proxy's
+// reply. The returned reader holds any tunneled bytes the proxy sent right
+// after its response headers.
+func (d *connectDialer) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: d.header.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	// An explicitly empty User-Agent keeps net/http from announcing
+	// "Go-http-client" to the proxy.
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header["User-Agent"] = []string{""}
+	}
+	d.auth.setProxyAuthorization(req.Header, addr)
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return nil, &proxyAuthError{addr: addr, status: resp.Status, challenges: resp.Header.Values("Proxy-Authenticate")}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy CONNECT %s: %s", addr, resp.Status)
+	}
+	return br, nil
+}
+
+// tunnelConn is an established HTTP tunnel. Reads go through the buffered
+// reader used to parse the proxy's response so no tunneled bytes are lost.
+type tunnelConn struct {
+	net.Conn
+	raw transport.StreamConn
+	r   *bufio.Reader
+}
+
+func (c *tunnelConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *tunnelConn) CloseRead() error { return c.raw.CloseRead() }
+
+func (c *tunnelConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.raw.CloseWrite()
+}
@@ -0,0 +1,149 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxy is an in-process HTTP CONNECT proxy for tests. It records how
+// many tunnels it opened and the Proxy-Authorization it last saw, and rejects
+// requests whose Proxy-Authorization doesn't match wantAuth (when set).
+type connectProxy struct {
+	*httptest.Server
+	wantAuth string
+	lastAuth atomic.Value
+	tunnels  atomic.Int64
+}
+
+func newConnectProxy(t *testing.T, useTLS bool, wantAuth string) *connectProxy {
+	t.Helper()
+	p := &connectProxy{wantAuth: wantAuth}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		auth := r.Header.Get("Proxy-Authorization")
+		p.lastAuth.Store(auth)
+		if p.wantAuth != "" && auth != p.wantAuth {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		p.tunnels.Add(1)
+		io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, client)
+			upstream.Close()
+		}()
+		io.Copy(client, upstream)
+		client.Close()
+	})
+	if useTLS {
+		p.Server = httptest.NewTLSServer(handler)
+	} else {
+		p.Server = httptest.NewServer(handler)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// addr returns the proxy's host:port.
+func (p *connectProxy) addr() string {
+	u, _ := url.Parse(p.URL)
+	return u.Host
+}
+
+// clientTLSConfig trusts the proxy's self-signed test certificate.
+func (p *connectProxy) clientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.Certificate())
+	return &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+}
+
+func TestConnectDialer(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via tunnel")
+	}))
+	t.Cleanup(origin.Close)
+	originAddr := origin.Listener.Addr().String()
+
+	get := func(t *testing.T, d transport.StreamDialer) string {
+		t.Helper()
+		rt, err := newStreamTransport("test", d).NewRoundTripper(context.Background(), originAddr)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		t.Parallel()
+		proxy := newConnectProxy(t, false, "")
+		d := &connectDialer{base: &transport.TCPDialer{}, proxyAddr: proxy.addr()}
+		assert.Equal(t, "via tunnel", get(t, d))
+		assert.Equal(t, int64(1), proxy.tunnels.Load())
+	})
+
+	t.Run("TLSWithAuth", func(t *testing.T) {
+		t.Parallel()
+		proxy := newConnectProxy(t, true, "Bearer secret")
+		d := &connectDialer{
+			base:      &transport.TCPDialer{},
+			proxyAddr: proxy.addr(),
+			tlsConfig: proxy.clientTLSConfig(),
+			header:    http.Header{"Proxy-Authorization": {"Bearer secret"}},
+		}
+		assert.Equal(t, "via tunnel", get(t, d))
+		assert.Equal(t, "Bearer secret", proxy.lastAuth.Load())
+	})
+
+	t.Run("RejectedConnect_ReturnsStatus", func(t *testing.T) {
+		t.Parallel()
+		proxy := newConnectProxy(t, false, "Bearer secret")
+		d := &connectDialer{base: &transport.TCPDialer{}, proxyAddr: proxy.addr()}
+		_, err := d.DialStream(context.Background(), originAddr)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "407")
+		assert.Equal(t, int64(0), proxy.tunnels.Load())
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		t.Parallel()
+		proxy := newConnectProxy(t, false, "")
+		d := &connectDialer{base: &transport.TCPDialer{}, proxyAddr: proxy.addr()}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := d.DialStream(ctx, originAddr)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,59 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// IdempotencyKeyHeader is the header (from the IETF httpapi draft of the
+// same name) that APIs such as Stripe's use to deduplicate retried writes.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyMode declares which requests kindling may send more than once,
+// and whether it may send them on several transports at the same time.
+type IdempotencyMode int
+
+const (
+	// IdempotencyStrict is the default. Only GET, HEAD, and requests
+	// marked with IdempotentHeader fall back to another transport, one
+	// attempt at a time; everything else is sent exactly once.
+	IdempotencyStrict IdempotencyMode = iota
+
+	// IdempotencyKeyed also treats requests carrying an Idempotency-Key
+	// header as safe to replay, trusting the server to deduplicate them.
+	IdempotencyKeyed
+
+	// IdempotencyParallel is IdempotencyKeyed plus parallel sends: a
+	// replay-safe request is sent on every transport in a tier as soon as
+	// it connects, rather than on the next transport only after the
+	// previous one failed. The first usable response wins and the rest are
+	// cancelled. This trades extra load on the origin for latency when
+	// transports are slow or flaky after connecting.
+	IdempotencyParallel
+)
+
+// WithIdempotencyMode sets which requests kindling may duplicate across
+// transports. See IdempotencyMode.
+func WithIdempotencyMode(mode IdempotencyMode) Option {
+	return func(k *kindling) error {
+		if mode < IdempotencyStrict || mode > IdempotencyParallel {
+			return fmt.Errorf("unknown idempotency mode %d", mode)
+		}
+		k.idempotency = mode
+		return nil
+	}
+}
+
+// cancelOnClose runs cancel once the caller is done with a response body,
+// e.g. to release a winning parallel send's context.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
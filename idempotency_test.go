@@ -0,0 +1,118 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithIdempotencyMode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("UnknownMode", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithIdempotencyMode(IdempotencyMode(42)))
+		assert.Error(t, err)
+	})
+
+	// failFirst's first transport answers 502, the second 200.
+	failFirst := func(t *testing.T) []Transport {
+		bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(bad.Close)
+		t.Cleanup(good.Close)
+		return []Transport{
+			&mockTransport{name: "bad", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return &urlRewritingTransport{target: bad.URL}, nil
+			}},
+			&mockTransport{name: "good", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				time.Sleep(30 * time.Millisecond)
+				return &urlRewritingTransport{target: good.URL}, nil
+			}},
+		}
+	}
+	post := func(t *testing.T, rt http.RoundTripper, key string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, "http://example.com/charge", strings.NewReader("amount=1"))
+		require.NoError(t, err)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("Strict_KeyedPostSentOnce", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, failFirst(t))
+		assert.Equal(t, http.StatusBadGateway, post(t, rt, "abc").StatusCode)
+	})
+
+	t.Run("Keyed_KeyedPostFallsBack", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, failFirst(t))
+		rt.idempotency = IdempotencyKeyed
+		assert.Equal(t, http.StatusOK, post(t, rt, "abc").StatusCode)
+		// Without a key the POST is still single-shot.
+		assert.Equal(t, http.StatusBadGateway, post(t, rt, "").StatusCode)
+	})
+
+	t.Run("Parallel_FastestResponseWins", func(t *testing.T) {
+		t.Parallel()
+		var slowCancelled atomic.Bool
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				slowCancelled.Store(true)
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "fast")
+		}))
+		t.Cleanup(slow.Close)
+		t.Cleanup(fast.Close)
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			&mockTransport{name: "slow", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return &urlRewritingTransport{target: slow.URL}, nil
+			}},
+			&mockTransport{name: "fast", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				time.Sleep(20 * time.Millisecond)
+				return &urlRewritingTransport{target: fast.URL}, nil
+			}},
+		})
+		rt.idempotency = IdempotencyParallel
+
+		start := time.Now()
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "fast", string(body))
+		assert.Less(t, time.Since(start), 2*time.Second, "must not wait for the slow transport")
+		assert.Eventually(t, slowCancelled.Load, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Parallel_NonIdempotentStillSingleShot", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, failFirst(t))
+		rt.idempotency = IdempotencyParallel
+		assert.Equal(t, http.StatusBadGateway, post(t, rt, "").StatusCode)
+		assert.Equal(t, http.StatusOK, post(t, rt, "abc").StatusCode)
+	})
+}
@@ -0,0 +1,41 @@
+package kindling
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+)
+
+// WithInterface makes kindling's default dialers send from the network
+// interface name, such as "rmnet_data0" or "en0", whatever the routing table
+// prefers. Multi-homed devices use it to pick a network, and Android apps to
+// stay on cellular while Wi-Fi sits behind a captive portal. It covers the
+// same sockets as WithDialerControl. Linux, Android, macOS, and iOS support
+// it; elsewhere NewKindling fails.
+func WithInterface(name string) Option {
+	return func(k *kindling) error {
+		if name == "" {
+			return fmt.Errorf("interface name is empty")
+		}
+		if !canBindToInterface {
+			return fmt.Errorf("binding to an interface is not supported on %s", runtime.GOOS)
+		}
+		k.iface = name
+		return nil
+	}
+}
+
+// WithLocalAddr makes kindling's default dialers send from ip, which must be
+// one of the device's own addresses, choosing the interface that has it.
+// Destinations of the other IP family can't be reached from it, so Happy
+// Eyeballs falls back to the family that works. It covers the same sockets
+// as WithDialerControl.
+func WithLocalAddr(ip netip.Addr) Option {
+	return func(k *kindling) error {
+		if !ip.IsValid() {
+			return fmt.Errorf("local address is invalid")
+		}
+		k.localAddr = ip.Unmap()
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package kindling
+
+import (
+	"net"
+	"strings"
+	"syscall"
+)
+
+const canBindToInterface = true
+
+// bindToInterface binds the socket to the interface name with IP_BOUND_IF,
+// or IPV6_BOUND_IF for IPv6 sockets.
+func bindToInterface(network string, c syscall.RawConn, name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	level, opt := syscall.IPPROTO_IP, syscall.IP_BOUND_IF
+	if strings.HasSuffix(network, "6") {
+		level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_BOUND_IF
+	}
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), level, opt, iface.Index)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
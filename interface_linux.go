@@ -0,0 +1,17 @@
+package kindling
+
+import "syscall"
+
+const canBindToInterface = true
+
+// bindToInterface binds the socket to the interface name with
+// SO_BINDTODEVICE.
+func bindToInterface(_ string, c syscall.RawConn, name string) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
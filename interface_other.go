@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package kindling
+
+import (
+	"errors"
+	"syscall"
+)
+
+const canBindToInterface = false
+
+func bindToInterface(string, syscall.RawConn, string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,99 @@
+package kindling
+
+import (
+	"net"
+	"net/netip"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithInterface(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithInterface("")(&kindling{}))
+	})
+
+	t.Run("Bound", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS != "linux" {
+			t.Skip("loopback is named lo on linux")
+		}
+		addr := closingListener(t, "127.0.0.1:0")
+
+		k := &kindling{}
+		require.NoError(t, WithInterface("lo")(k))
+		conn, err := k.baseStreamDialer().DialStream(t.Context(), addr)
+		require.NoError(t, err)
+		conn.Close()
+
+		k = &kindling{}
+		require.NoError(t, WithInterface("nosuch0")(k))
+		_, err = k.baseStreamDialer().DialStream(t.Context(), addr)
+		assert.ErrorContains(t, err, "binding to interface nosuch0")
+	})
+}
+
+func TestWithLocalAddr(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithLocalAddr(netip.Addr{})(&kindling{}))
+	})
+
+	t.Run("Bound", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS != "linux" {
+			t.Skip("only linux routes all of 127/8 to loopback")
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		remote := make(chan net.Addr, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			remote <- conn.RemoteAddr()
+			conn.Close()
+		}()
+
+		local := netip.MustParseAddr("127.0.0.2")
+		k := &kindling{}
+		require.NoError(t, WithLocalAddr(local)(k))
+		conn, err := k.baseStreamDialer().DialStream(t.Context(), ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, local, (<-remote).(*net.TCPAddr).AddrPort().Addr())
+
+		packet, err := k.basePacketDialer().DialPacket(t.Context(), "127.0.0.1:53")
+		require.NoError(t, err)
+		defer packet.Close()
+		assert.Equal(t, local, packet.LocalAddr().(*net.UDPAddr).AddrPort().Addr())
+	})
+}
+
+// closingListener accepts and closes connections on addr until the test ends,
+// returning the address it listens on.
+func closingListener(t *testing.T, addr string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
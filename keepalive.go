@@ -0,0 +1,71 @@
+package kindling
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// WithKeepAlive keeps pooled tunnels warm through NATs and middleboxes that
+// silently drop idle connections, by sending something down them whenever
+// they've been quiet for interval. HTTP/2 connections to origins over the
+// stream-based transports send PING frames, as do WithHTTP3's QUIC
+// connections, multiplexed tunnels (see WithMultiplexing) their session's
+// keepalives, and TCP connections kindling dials TCP keepalive probes, which
+// is all an idle HTTP/1.1 connection can have. A connection whose pings go unanswered is closed rather than handed
+// to the next request. Pick an interval shorter than the network's idle
+// timeout; mobile carriers' can be as short as 30 seconds.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(k *kindling) error {
+		if interval <= 0 {
+			return fmt.Errorf("keepalive interval must be positive, got %v", interval)
+		}
+		k.keepAlive = interval
+		return nil
+	}
+}
+
+// applyKeepAlive turns WithKeepAlive on for the stream transports kindling
+// built.
+func (k *kindling) applyKeepAlive() {
+	if k.keepAlive == 0 {
+		return
+	}
+	for _, t := range k.transports {
+		if nt, ok := t.(*namedTransport); ok && nt.dialer != nil {
+			nt.keepAlive = k.keepAlive
+		}
+	}
+}
+
+// http2KeepAlive returns the HTTP/2 settings that ping a connection idle for
+// interval, or nil for net/http's defaults when interval is 0.
+func http2KeepAlive(interval time.Duration) *http.HTTP2Config {
+	if interval == 0 {
+		return nil
+	}
+	return &http.HTTP2Config{SendPingTimeout: interval}
+}
+
+// tcpKeepAlive returns the TCP keepalive settings that probe a connection
+// idle for interval, or the system defaults when interval is 0.
+func tcpKeepAlive(interval time.Duration) net.KeepAliveConfig {
+	if interval == 0 {
+		return net.KeepAliveConfig{}
+	}
+	return net.KeepAliveConfig{Enable: true, Idle: interval, Interval: interval, Count: 3}
+}
+
+// smuxConfig returns the session settings for multiplexed tunnels. smux
+// sends keepalives every 10 seconds already; interval can only shorten that,
+// as the server drops a session it hasn't heard from in 30.
+func smuxConfig(interval time.Duration) *smux.Config {
+	config := smux.DefaultConfig()
+	if interval > 0 && interval < config.KeepAliveInterval {
+		config.KeepAliveInterval = interval
+	}
+	return config
+}
@@ -0,0 +1,122 @@
+package kindling
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithKeepAlive(0)(&kindling{}))
+	assert.Error(t, WithKeepAlive(-time.Second)(&kindling{}))
+
+	k := &kindling{transports: []Transport{
+		newStreamTransport("direct", &transport.TCPDialer{}),
+		&namedTransport{name: "fronted"},
+	}}
+	require.NoError(t, WithKeepAlive(25*time.Second)(k))
+	k.applyKeepAlive()
+	assert.Equal(t, 25*time.Second, k.transports[0].(*namedTransport).keepAlive)
+	assert.Zero(t, k.transports[1].(*namedTransport).keepAlive)
+
+	t.Run("TCP", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{keepAlive: 25 * time.Second}
+		cfg := k.netDialer("tcp").KeepAliveConfig
+		assert.True(t, cfg.Enable)
+		assert.Equal(t, 25*time.Second, cfg.Idle)
+		assert.Zero(t, k.netDialer("udp").KeepAliveConfig)
+		assert.Zero(t, (&kindling{}).netDialer("tcp").KeepAliveConfig)
+	})
+
+	t.Run("Smux", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, 5*time.Second, smuxConfig(5*time.Second).KeepAliveInterval)
+		// Longer than the default would let the server time the session out.
+		assert.Equal(t, 10*time.Second, smuxConfig(time.Minute).KeepAliveInterval)
+		assert.Equal(t, 10*time.Second, smuxConfig(0).KeepAliveInterval)
+	})
+}
+
+// countingListener counts the bytes read from its connections.
+type countingListener struct {
+	net.Listener
+	read *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, l.read}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func TestKeepAlivePings(t *testing.T) {
+	t.Parallel()
+
+	// pooledH2C makes a request over cleartext HTTP/2, leaving its
+	// connection idle in the pool, and returns how many bytes the server has
+	// read.
+	pooledH2C := func(t *testing.T, keepAlive time.Duration) func() int64 {
+		var read atomic.Int64
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		}))
+		server.Listener = countingListener{server.Listener, &read}
+		server.Config.Protocols = new(http.Protocols)
+		server.Config.Protocols.SetUnencryptedHTTP2(true)
+		server.Start()
+		t.Cleanup(server.Close)
+
+		direct := newStreamTransport("direct", &transport.TCPDialer{})
+		direct.h2c = true
+		direct.keepAlive = keepAlive
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{direct})
+		rt.pool = newRoundTripperPool(time.Minute)
+		t.Cleanup(rt.pool.close)
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, "HTTP/2.0", string(body))
+		return read.Load
+	}
+
+	t.Run("PingsIdleConnection", func(t *testing.T) {
+		t.Parallel()
+		read := pooledH2C(t, 20*time.Millisecond)
+		after := read()
+		assert.Eventually(t, func() bool { return read() > after }, 2*time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		t.Parallel()
+		read := pooledH2C(t, 0)
+		time.Sleep(50 * time.Millisecond) // let the handshake's last frames land
+		after := read()
+		assert.Never(t, func() bool { return read() > after }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+}
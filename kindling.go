@@ -2,6 +2,8 @@ package kindling
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"embed"
 	"errors"
 	"fmt"
@@ -9,8 +11,14 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Jigsaw-Code/outline-sdk/transport"
@@ -18,25 +26,42 @@ import (
 	"github.com/getlantern/amp"
 	"github.com/getlantern/dnstt"
 	"github.com/getlantern/domainfront"
+	utls "github.com/refraction-networking/utls"
 )
 
 // TransportName identifies a built-in transport. Custom transports added via
 // WithTransport may use any string for their Name(); the constants below cover
 // the names assigned to the transports configured by WithDomainFronting,
-// WithDNSTunnel, WithAMPCache, and WithProxyless.
+// WithDNSTunnel, WithAMPCache, WithProxyless, WithHTTP3, WithTor,
+// WithShadowsocks, WithWebTunnel, WithTURN, WithWebRTC, WithWireGuard,
+// WithPsiphon, and WithUpstreamProxy.
 type TransportName string
 
 const (
-	TransportDomainfront TransportName = "domainfront"
-	TransportDNSTunnel   TransportName = "dnstt"
-	TransportAMP         TransportName = "amp"
-	TransportSmart       TransportName = "smart"
+	TransportDomainfront   TransportName = "domainfront"
+	TransportDNSTunnel     TransportName = "dnstt"
+	TransportAMP           TransportName = "amp"
+	TransportSmart         TransportName = "smart"
+	TransportHTTP3         TransportName = "http3"
+	TransportTor           TransportName = "tor"
+	TransportShadowsocks   TransportName = "shadowsocks"
+	TransportWebTunnel     TransportName = "webtunnel"
+	TransportTURN          TransportName = "turn"
+	TransportWebRTC        TransportName = "webrtc"
+	TransportWireGuard     TransportName = "wireguard"
+	TransportPsiphon       TransportName = "psiphon"
+	TransportUpstreamProxy TransportName = "upstream"
 )
 
 const (
 	// priorityDefault is the race tier for transports that don't specify one.
 	// They race in parallel, first connection wins.
 	priorityDefault = 0
+	// priorityFallback marks a heavyweight transport, such as a full
+	// userspace VPN, that works well once up but costs more to bring up than
+	// it's worth racing on every request. It joins the race only after the
+	// default tier fails, ahead of the last-resort tunnels.
+	priorityFallback = 50
 	// priorityLastResort marks a transport raced only after every
 	// default-tier transport has failed to produce a usable response. DNS
 	// tunneling uses it: it keeps working under heavy censorship but is slow
@@ -52,9 +77,91 @@ type Kindling interface {
 	// circumvention transports in parallel.
 	NewHTTPClient() *http.Client
 
+	// NewRoundTripper returns the round-tripper NewHTTPClient's clients
+	// use, for callers that build their own http.Client, such as one
+	// instrumented with OpenTelemetry or wrapped by a retry library.
+	NewRoundTripper() http.RoundTripper
+
+	// NewHTTPClientWith is NewHTTPClient with a redirect policy, cookie jar,
+	// or overall timeout set by opts.
+	NewHTTPClientWith(opts ...ClientOption) *http.Client
+
 	// ReplaceTransport swaps the round-tripper generator for the named transport,
-	// preserving its MaxLength and IsStreamable properties.
+	// preserving its other properties: MaxLength, IsStreamable, its priority,
+	// the dialers DialStream and DialPacket use, whether its round-trippers
+	// are pooled, and, for transports that reach origins in the clear, the
+	// WithFailClosed guard.
 	ReplaceTransport(name TransportName, rt func(ctx context.Context, addr string) (http.RoundTripper, error)) error
+
+	// AddTransport adds a transport to the race. Clients already returned by
+	// NewHTTPClient pick it up from their next request.
+	AddTransport(t Transport) error
+
+	// RemoveTransport takes the named transport out of the race. Requests
+	// already racing it are unaffected.
+	RemoveTransport(name TransportName) error
+
+	// Transports describes the configured transports in race order,
+	// including whether each is quarantined by the circuit breaker and when
+	// it last succeeded.
+	Transports() []TransportInfo
+
+	// Probe sends a lightweight request for url through every transport
+	// concurrently and reports reachability and latency per transport name,
+	// for diagnostics screens.
+	Probe(ctx context.Context, url string) map[string]ProbeResult
+
+	// Resolve looks host up over DNS-over-HTTPS through the race, so the
+	// lookup rides the same covert channel as requests and a poisoned local
+	// resolver never sees it.
+	Resolve(ctx context.Context, host string) ([]net.IP, error)
+
+	// FlushDNS empties the DNS cache shared by the transports, and the ECH
+	// configs cached for WithECH, so poisoned or stale answers are looked up
+	// again.
+	FlushDNS()
+
+	// OnNetworkChange flushes the DNS caches, drops pooled connections,
+	// resets the circuit breakers, and runs health checks again, for apps to
+	// call when the device switches networks.
+	OnNetworkChange()
+
+	// Prewarm connects the transports to hosts ahead of time, so the first
+	// requests to them don't pay the full connection latency.
+	Prewarm(ctx context.Context, hosts ...string) error
+
+	// Download fetches url into w with Range requests, resuming where a
+	// transport died mid-body, on another transport, so large files such
+	// as geo databases get through slow, flaky transports like DNS
+	// tunneling.
+	Download(ctx context.Context, url string, w io.Writer) error
+
+	// DumpDiagnostics writes a redacted JSON report of the transports,
+	// recent attempts, smart dialer strategy search, and network
+	// interfaces, for support tickets.
+	DumpDiagnostics(w io.Writer) error
+
+	// Close shuts the instance down: it stops background work, fails
+	// in-flight races, closes pooled connections and SOCKS5 listeners, and
+	// stops any Tor or Psiphon client kindling launched. Requests made after
+	// Close fail with ErrClosed.
+	io.Closer
+
+	// StreamDialer connects TCP streams through whichever stream-capable
+	// transport (proxyless dialing, Tor, Shadowsocks, upstream proxies, and the like)
+	// connects first, so kindling composes with the rest of the Outline SDK,
+	// for example to run another proxy protocol over it.
+	transport.StreamDialer
+
+	// PacketDialer opens UDP associations through whichever packet-capable
+	// transport (WireGuard, TURN, or a custom one) connects first, so
+	// UDP-based protocols such as QUIC can ride kindling as well.
+	transport.PacketDialer
+
+	// ListenSOCKS5 starts a SOCKS5 proxy on addr that lets any TCP client on
+	// the device tunnel through whichever stream-capable transport connects
+	// first. Closing the returned listener stops accepting new clients.
+	ListenSOCKS5(addr string) (net.Listener, error)
 }
 
 // Transport defines a censorship circumvention transport that can be used by Kindling.
@@ -78,6 +185,7 @@ type Transport interface {
 	// RequestTimeout returns the maximum time a single request is allowed to
 	// spend on this transport. Zero means the race transport picks a default
 	// (80 s for requests without a body, 3 min for requests with a body).
+	// A sooner deadline on the request's context takes precedence.
 	RequestTimeout() time.Duration
 }
 
@@ -87,27 +195,151 @@ type Transport interface {
 type Option func(*kindling) error
 
 type kindling struct {
-	mu            sync.Mutex
-	log           *slog.Logger
-	logWriter     io.Writer
+	mu        sync.Mutex
+	log       *slog.Logger
+	logWriter io.Writer
+	// logLevel is shared by the instance's loggers; logLevelSet is true
+	// once WithLogLevel has set it. logSampling is WithLogSampling's rate.
+	logLevel       *slog.LevelVar
+	logLevelSet    bool
+	logSampling    int
+	sampledLog     *slog.Logger
+	sampledLogOnce sync.Once
+	// smartLog keeps the tail of the smart dialer's strategy search output
+	// for DumpDiagnostics.
+	smartLog      *tailBuffer
 	transports    []Transport
 	panicListener func(string)
 	appName       string
 	// streamDialer/packetDialer override the stdlib net.Dialer that the
-	// Outline SDK smart strategy would otherwise use. Set via
-	// WithStreamDialer / WithPacketDialer. nil leaves the smart dialer
-	// on its default TCPDialer{} / UDPDialer{}.
+	// Outline SDK smart strategy and kindling's own proxy transports would
+	// otherwise use. Set via WithStreamDialer / WithPacketDialer. nil leaves
+	// them on the default TCPDialer{} / UDPDialer{}.
 	streamDialer transport.StreamDialer
 	packetDialer transport.PacketDialer
+	// dialerControl is the WithDialerControl hook, run on every socket the
+	// default dialers open.
+	dialerControl func(network, address string, c syscall.RawConn) error
+	// iface and localAddr are the WithInterface and WithLocalAddr settings
+	// for the default dialers.
+	iface     string
+	localAddr netip.Addr
+	// socketOptions is set by WithSocketOptions.
+	socketOptions *SocketOptions
+	// fwmark is the WithFwmark mark; 0 is none.
+	fwmark uint32
 	// smartDialerConfig overrides the embedded smart_dialer_config.yml.
 	// nil falls back to the embedded default.
 	smartDialerConfig []byte
 	// deferred holds option work that must run after every other option
-	// has had a chance to mutate the struct. Used by WithProxyless so it
-	// reads streamDialer/packetDialer after WithStreamDialer /
+	// has had a chance to mutate the struct. Used by WithProxyless and
+	// kindling's own proxy transports (WithShadowsocks, WithUpstreamProxy,
+	// ...) so they read streamDialer/packetDialer after WithStreamDialer /
 	// WithPacketDialer have set them, regardless of option order in the
 	// NewKindling call.
 	deferred []func() error
+	// background holds long-running work, such as config refreshers, that
+	// must not start until NewKindling has finished building the instance.
+	// It runs until ctx is canceled by Close.
+	background []func(ctx context.Context)
+	// ctx is canceled by Close, which then waits on bg and closes closers.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	bg        sync.WaitGroup
+	closers   []io.Closer
+	closeOnce sync.Once
+	closeErr  error
+	// netChanged is closed by OnNetworkChange to wake background work.
+	netChanged chan struct{}
+	// domainPolicy maps a domain to the only transports allowed to carry
+	// requests for it and its subdomains. Set via WithDomainPolicy and
+	// read-only once NewKindling returns.
+	domainPolicy map[string][]string
+	hostFilter   hostFilter
+	// envOverrides is set by WithEnvOverrides.
+	envOverrides bool
+	// clock is set by WithClock.
+	clock Clock
+	// breaker is shared by every client the instance creates. It's nil
+	// unless WithCircuitBreaker or WithHealthCheck turns it on.
+	breaker *circuitBreaker
+	// breakerSet records that WithCircuitBreaker was given, even to turn
+	// the breaker off, so WithHealthCheck leaves it be.
+	breakerSet bool
+	// retry is the WithRetryPolicy override; nil uses the default.
+	retry       *retryPolicy
+	idempotency IdempotencyMode
+	// strategy and weights are set by WithStrategy and
+	// WithTransportWeight. bandit is shared by every client for Adaptive.
+	strategy Strategy
+	weights  map[string]float64
+	bandit   *bandit
+	// headStarts are set by WithHeadStart.
+	headStarts map[string]time.Duration
+	// connectTimeouts are set by WithConnectTimeout.
+	connectTimeouts map[string]time.Duration
+	// dials bounds concurrent connects; set by WithMaxConcurrentDials.
+	dials chan struct{}
+	// inFlight bounds concurrent races; set by WithMaxInFlight.
+	inFlight *inFlight
+	// hostDials is set by WithMaxDialsPerHost.
+	hostDials *hostDials
+	// hostMapping is set by WithHostMapping.
+	hostMapping hostMapping
+	// multiplexed is set by WithMultiplexing.
+	multiplexed map[TransportName]bool
+	chunking    bool
+	// h2c is set by WithH2C.
+	h2c bool
+	// downloadWorkers is set by WithParallelDownload.
+	downloadWorkers int
+	// keepAlive is the WithKeepAlive interval; 0 is off.
+	keepAlive time.Duration
+	// padding and jitter are set by WithObfuscation.
+	padding int
+	jitter  time.Duration
+	// failClosed and leakAudit are set by WithFailClosed and WithLeakAudit.
+	failClosed bool
+	leakAudit  func(Leak)
+	// maxResponseBytes is the WithMaxResponseBytes limit; 0 is unlimited.
+	maxResponseBytes int64
+	verifier         *responseVerifier
+	cache            responseStore
+	flights          *flightGroup
+	auth             AuthProvider
+	compression      []string
+	rateLimit        *rateLimit
+	headerPolicy     *HeaderPolicy
+	headerProfile    HeaderProfile
+	stats            *transportStats
+	// sessionCache is shared by every TLS config kindling builds; nil turns
+	// resumption off (see WithTLSSessionCache).
+	sessionCache tls.ClientSessionCache
+	// pool is shared by every client the instance creates. nil disables it
+	// (see WithRoundTripperPool).
+	pool *roundTripperPool
+	// resolver looks up hostnames for kindling's own transports; nil uses
+	// the system resolver. dohURL is set by WithDoHResolver.
+	resolver hostResolver
+	dohURL   *url.URL
+	// dnsCache is shared by every transport; nil disables it (see
+	// WithDNSCache).
+	dnsCache *dnsCache
+	// echEnabled is set by WithECH; ech caches ECH configs for the smart
+	// transports once the first is built.
+	echEnabled bool
+	ech        *echConfigs
+	// fingerprint is the WithTLSFingerprint ClientHello; nil uses Go's.
+	fingerprint *utls.ClientHelloID
+	// rootCAs and clientCerts are set by WithRootCAs and
+	// WithClientCertificates.
+	rootCAs     *x509.CertPool
+	clientCerts []tls.Certificate
+	postQuantum bool
+	// country is the user's country code, from WithCountryHint or
+	// WithCountryDetection; countryStrategies holds WithCountryStrategy's.
+	country           atomic.Pointer[string]
+	countryStrategies map[string]CountryStrategy
 }
 
 var _ Kindling = (*kindling)(nil)
@@ -118,13 +350,23 @@ var _ Kindling = (*kindling)(nil)
 // dialer in WithProxyless) are logged as warnings and are only fatal when they
 // leave Kindling with no usable transports.
 func NewKindling(name string, options ...Option) (Kindling, error) {
+	level := new(slog.LevelVar)
 	k := &kindling{
 		appName:   name,
 		logWriter: os.Stdout,
-		log:       slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true})),
+		smartLog:  newTailBuffer(maxSmartDialerLog),
+		stats:     newTransportStats(),
+		pool:      newRoundTripperPool(defaultPoolIdleTimeout),
+		dnsCache:  newDNSCache(defaultDNSCacheTTL, defaultDNSCacheNegativeTTL),
+		logLevel:  level,
+		clock:     systemClock,
+		log:       slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: level})),
 	}
+	k.ctx, k.cancel = context.WithCancel(context.Background())
+	k.sessionCache = tls.NewLRUClientSessionCache(defaultSessionCacheSize)
 	for _, opt := range options {
 		if err := opt(k); err != nil {
+			k.Close()
 			return nil, fmt.Errorf("kindling: %w", err)
 		}
 	}
@@ -139,26 +381,98 @@ func NewKindling(name string, options ...Option) (Kindling, error) {
 	// transports. Otherwise the remaining transports can still serve requests,
 	// so we keep going rather than failing the whole instance.
 	if len(deferredErrs) > 0 && len(k.transports) == 0 {
+		k.Close()
 		return nil, fmt.Errorf("kindling: no transports configured: %w", errors.Join(deferredErrs...))
 	}
+	if k.envOverrides {
+		if err := k.applyEnvOverrides(); err != nil {
+			k.Close()
+			return nil, fmt.Errorf("kindling: %w", err)
+		}
+	}
 	if k.panicListener == nil {
 		k.panicListener = func(msg string) { k.log.Error(msg) }
 	}
+	k.applyTLSConfig()
+	k.applyH2C()
+	k.applyKeepAlive()
+	k.applyObfuscation()
+	k.applyFailClosed()
+	if k.breaker != nil {
+		k.breaker.now = k.clock.Now
+	}
+	for _, fn := range k.background {
+		k.bg.Add(1)
+		go func() {
+			defer k.bg.Done()
+			fn(k.ctx)
+		}()
+	}
 
 	return k, nil
 }
 
 // NewHTTPClient returns an HTTP client that races all configured transports.
-// Safe to call concurrently with ReplaceTransport.
+// Each request races the transports configured at the time it's sent, so the
+// client follows AddTransport, RemoveTransport, and ReplaceTransport.
 func (k *kindling) NewHTTPClient() *http.Client {
+	return &http.Client{Transport: k.NewRoundTripper()}
+}
+
+// NewRoundTripper returns the round-tripper behind NewHTTPClient.
+func (k *kindling) NewRoundTripper() http.RoundTripper {
+	rt := k.newRaceTransport(nil)
+	rt.source = k.snapshot
+	return rt
+}
+
+// snapshot returns the current transports. k.transports is copy-on-write
+// once construction is done, so the slice is safe to read without the lock.
+func (k *kindling) snapshot() []Transport {
 	k.mu.Lock()
-	snapshot := make([]Transport, len(k.transports))
-	copy(snapshot, k.transports)
-	k.mu.Unlock()
+	defer k.mu.Unlock()
+	return k.transports
+}
 
-	return &http.Client{
-		Transport: newRaceTransport(k.appName, k.log, k.panicListener, snapshot),
+// newRaceTransport builds a raceTransport over transports with the
+// instance's request-routing settings applied.
+func (k *kindling) newRaceTransport(transports []Transport) *raceTransport {
+	rt := newRaceTransport(k.appName, k.raceLog(), k.panicListener, transports)
+	rt.domainPolicy = k.domainPolicy
+	rt.hostFilter = k.hostFilter
+	rt.breaker = k.breaker
+	rt.retry = k.retry
+	rt.idempotency = k.idempotency
+	rt.strategy = k.strategy
+	rt.weights = k.weights
+	rt.bandit = k.bandit
+	rt.headStarts = k.headStarts
+	rt.connectTimeouts = k.connectTimeouts
+	rt.padding = k.padding
+	rt.dials = k.dials
+	rt.inFlight = k.inFlight
+	rt.hostDials = k.hostDials
+	rt.hostMapping = k.hostMapping
+	rt.chunking = k.chunking
+	rt.maxResponseBytes = k.maxResponseBytes
+	rt.verifier = k.verifier
+	rt.cache = k.cache
+	rt.flights = k.flights
+	rt.auth = k.auth
+	rt.compression = k.compression
+	rt.rateLimit = k.rateLimit
+	rt.stats = k.stats
+	rt.pool = k.pool
+	rt.closed = k.ctx
+	rt.country = k.countryStrategy
+	rt.clock = k.clock
+	if k.headerPolicy != nil {
+		rt.headerPolicy = k.headerPolicy
+	}
+	if k.headerProfile != 0 {
+		rt.headerPolicy = rt.headerPolicy.withProfile(k.headerProfile)
 	}
+	return rt
 }
 
 // ReplaceTransport swaps the round-tripper generator for the named transport.
@@ -170,34 +484,81 @@ func (k *kindling) ReplaceTransport(name TransportName, rt func(ctx context.Cont
 	defer k.mu.Unlock()
 	for i, tr := range k.transports {
 		if tr.Name() == string(name) {
-			k.transports[i] = &namedTransport{
+			replacement := &namedTransport{
 				name:         string(name),
 				maxLength:    tr.MaxLength(),
 				isStreamable: tr.IsStreamable(),
 				reqTimeout:   tr.RequestTimeout(),
 				priority:     priorityOf(tr),
-				newRT:        rt,
+				reusable:     reusable(tr),
 			}
+			if nt, ok := tr.(*namedTransport); ok {
+				// Keep the dialers, TLS and keepalive settings, and the
+				// direct mark along with the rest.
+				copied := *nt
+				replacement = &copied
+			}
+			replacement.newRT = rt
+			if replacement.direct && k.guardsLeaks() {
+				k.guardDirect(replacement)
+			}
+			transports := slices.Clone(k.transports)
+			transports[i] = replacement
+			k.transports = transports
+			k.pool.evict(string(name))
 			return nil
 		}
 	}
 	return fmt.Errorf("transport %q not found", name)
 }
 
+// AddTransport adds t to the race, for transports that only become available
+// after construction. Names must be unique.
+func (k *kindling) AddTransport(t Transport) error {
+	if t == nil {
+		return fmt.Errorf("transport is nil")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if slices.ContainsFunc(k.transports, func(tr Transport) bool { return tr.Name() == t.Name() }) {
+		return fmt.Errorf("transport %q already exists", t.Name())
+	}
+	k.transports = append(slices.Clip(k.transports), t)
+	return nil
+}
+
+// RemoveTransport takes the named transport out of the race.
+func (k *kindling) RemoveTransport(name TransportName) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	i := slices.IndexFunc(k.transports, func(tr Transport) bool { return tr.Name() == string(name) })
+	if i < 0 {
+		return fmt.Errorf("transport %q not found", name)
+	}
+	k.transports = slices.Delete(slices.Clone(k.transports), i, i+1)
+	k.pool.evict(string(name))
+	return nil
+}
+
 // --- Options ---
 
-// WithLogWriter sets the log output destination. By default, logs go to
-// os.Stdout. Specify this first to capture initialization logs from other
-// options like WithProxyless.
+// WithLogWriter sets the log output destination and logs at Debug unless
+// WithLogLevel says otherwise. By default, logs go to os.Stdout at Info.
+// Specify this first to capture initialization logs from other options like
+// WithProxyless.
 func WithLogWriter(w io.Writer) Option {
 	return func(k *kindling) error {
 		if w == nil {
 			return fmt.Errorf("log writer is nil")
 		}
+		level := k.levelVar()
+		if !k.logLevelSet {
+			level.Set(slog.LevelDebug)
+		}
 		k.logWriter = w
 		k.log = slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
 			AddSource: true,
-			Level:     slog.LevelDebug,
+			Level:     level,
 		}))
 		return nil
 	}
@@ -212,12 +573,13 @@ func WithPanicListener(fn func(string)) Option {
 }
 
 // WithStreamDialer overrides the TCP dialer used by smart-dialer-based
-// transports (WithProxyless). When unset, the smart dialer uses Outline
-// SDK's default transport.TCPDialer, which dials via the stdlib net.Dialer
-// and so follows the host's routing table — sending packets through any
-// active VPN TUN. Callers that need their connection attempts to bypass
-// a VPN tunnel they themselves serve (radiance is the motivating case)
-// should pass an alternative here.
+// transports (WithProxyless) and for the first hop of kindling's own proxy
+// transports (WithShadowsocks, WithUpstreamProxy, ...). When unset, they use
+// Outline SDK's default transport.TCPDialer, which dials via the stdlib
+// net.Dialer and so follows the host's routing table — sending packets
+// through any active VPN TUN. Callers that need their connection attempts
+// to bypass a VPN tunnel they themselves serve (radiance is the motivating
+// case) should pass an alternative here.
 func WithStreamDialer(d transport.StreamDialer) Option {
 	return func(k *kindling) error {
 		if d == nil {
@@ -269,18 +631,63 @@ func WithTransport(t Transport) Option {
 	}
 }
 
+// WithStreamDialerTransport adds a transport named name that reaches origins
+// through d, any Outline SDK StreamDialer or chain of them, without writing
+// an http.RoundTripper. Requests are sent over an http.Transport that dials
+// through d, with the TLS settings of options such as WithRootCAs, and the
+// transport carries ListenSOCKS5 and DialStream connections too. Unlike
+// WithStreamDialer, which changes the first hop of the built-in transports,
+// it adds a transport of its own to the race.
+func WithStreamDialerTransport(name string, d transport.StreamDialer) Option {
+	return func(k *kindling) error {
+		if name == "" {
+			return fmt.Errorf("transport name is empty")
+		}
+		if d == nil {
+			return fmt.Errorf("stream dialer is nil")
+		}
+		k.transports = append(k.transports, newStreamTransport(name, d))
+		return nil
+	}
+}
+
 // WithDomainFronting adds domain fronting via the provided domainfront.Client.
 // Each race attempt obtains a pre-connected one-shot RoundTripper via
 // NewConnectedRoundTripper, so the race transport blocks on a real TLS
 // handshake to a working front (not on a cached wrapper that "connects"
 // instantly and always wins the race).
+//
+// To front through several providers, use WithDomainFrontingProvider once
+// per provider instead.
 func WithDomainFronting(c *domainfront.Client) Option {
+	return withDomainFronting(string(TransportDomainfront), c)
+}
+
+// WithDomainFrontingProvider adds domain fronting through one provider, such
+// as a client built from a config holding only the "akamai" provider, as its
+// own transport named "domainfront-" + provider ("domainfront-akamai"). Give
+// each provider its own client and option, so the race can use whichever CDN
+// works and stats and diagnostics tell them apart. Options that take
+// transport names, such as WithDomainPolicy, need the full name.
+func WithDomainFrontingProvider(provider string, c *domainfront.Client) Option {
+	if strings.TrimSpace(provider) == "" {
+		return func(*kindling) error {
+			return fmt.Errorf("domain fronting provider name is empty")
+		}
+	}
+	return withDomainFronting(string(TransportDomainfront)+"-"+provider, c)
+}
+
+func withDomainFronting(name string, c *domainfront.Client) Option {
 	return func(k *kindling) error {
 		if c == nil {
 			return fmt.Errorf("domainfront client is nil")
 		}
+		if slices.ContainsFunc(k.transports, func(t Transport) bool { return t.Name() == name }) {
+			return fmt.Errorf("%s transport already added", name)
+		}
 		k.transports = append(k.transports, &namedTransport{
-			name:         string(TransportDomainfront),
+			name:         name,
 			isStreamable: true,
 			newRT:        c.NewConnectedRoundTripper,
 		})
@@ -314,8 +721,15 @@ func WithDNSTunnel(d dnstt.DNSTT) Option {
 	}
 }
 
+// ampMaxLength is the largest request body an AMP cache relays.
+const ampMaxLength = 6000
+
 // WithAMPCache adds AMP caching via the provided amp.Client.
 // AMP has a 6000-byte request body limit and does not support streaming.
+// AMP caches can transform what they relay, so a response whose body
+// doesn't match its Content-Length or digest headers (Content-Digest,
+// Repr-Digest, or Digest) fails with ErrIntegrity and replay-safe requests
+// retry on another transport.
 func WithAMPCache(c amp.Client) Option {
 	return func(k *kindling) error {
 		if c == nil {
@@ -323,9 +737,13 @@ func WithAMPCache(c amp.Client) Option {
 		}
 		k.transports = append(k.transports, &namedTransport{
 			name:      string(TransportAMP),
-			maxLength: 6000,
+			maxLength: ampMaxLength,
 			newRT: func(ctx context.Context, addr string) (http.RoundTripper, error) {
-				return c.RoundTripper()
+				rt, err := c.RoundTripper()
+				if err != nil {
+					return nil, err
+				}
+				return &integrityRoundTripper{next: rt}, nil
 			},
 		})
 		return nil
@@ -338,23 +756,38 @@ func WithAMPCache(c amp.Client) Option {
 // WithPacketDialer take effect regardless of the order callers pass them
 // to NewKindling.
 func WithProxyless(domains ...string) Option {
+	return withProxyless(nil, domains)
+}
+
+// WithProxylessConfig is WithProxyless with its own strategy YAML, so
+// deployments can ship strategies tuned for their users without forking the
+// embedded smart_dialer_config.yml. Unlike WithSmartDialerConfig, the config
+// applies only to this proxyless transport.
+func WithProxylessConfig(configBytes []byte, domains ...string) Option {
+	if len(configBytes) == 0 {
+		return func(*kindling) error { return fmt.Errorf("proxyless config is empty") }
+	}
+	return withProxyless(configBytes, domains)
+}
+
+// withProxyless registers a proxyless transport using config, or the
+// instance-wide smart dialer config when config is nil.
+func withProxyless(config []byte, domains []string) Option {
 	return func(k *kindling) error {
 		k.deferred = append(k.deferred, func() error {
-			dialer, err := newSmartDialerFn(k.logWriter, k.smartDialerConfig, k.streamDialer, k.packetDialer, domains...)
+			cfg := config
+			if cfg == nil {
+				cfg = k.smartDialerConfig
+			}
+			cfg, stream, err := k.smartDialerBase(cfg)
+			if err != nil {
+				return err
+			}
+			dialer, err := newSmartDialerFn(k.smartLogWriter(), cfg, stream, k.basePacketDialer(), domains...)
 			if err != nil {
 				return fmt.Errorf("creating smart dialer: %w", err)
 			}
-			k.transports = append(k.transports, &namedTransport{
-				name:         string(TransportSmart),
-				isStreamable: true,
-				newRT: func(ctx context.Context, addr string) (http.RoundTripper, error) {
-					conn, err := dialer.DialStream(ctx, addr)
-					if err != nil {
-						return nil, fmt.Errorf("smart dial: %w", err)
-					}
-					return preconnectedTransport(conn), nil
-				},
-			})
+			k.transports = append(k.transports, k.smartTransport(dialer))
 			return nil
 		})
 		return nil
@@ -370,6 +803,25 @@ type namedTransport struct {
 	newRT        func(ctx context.Context, addr string) (http.RoundTripper, error)
 	reqTimeout   time.Duration
 	priority     int
+	// dialer, when set, lets the transport carry arbitrary TCP streams as
+	// well as HTTP requests (see DialStream).
+	dialer transport.StreamDialer
+	// packetDialer, when set, lets the transport carry UDP datagrams too
+	// (see DialPacket).
+	packetDialer transport.PacketDialer
+	// tlsConfig, when set, is the base config for TLS connections to origins
+	// made over dialer (see WithRootCAs).
+	tlsConfig *tls.Config
+	// h2c is set by WithH2C.
+	h2c bool
+	// keepAlive is set by WithKeepAlive.
+	keepAlive time.Duration
+	// direct marks a transport that reaches origins in the clear (see
+	// WithFailClosed).
+	direct bool
+	// reusable marks a transport whose round-trippers can carry request
+	// after request, and so may be pooled (see WithRoundTripperPool).
+	reusable bool
 }
 
 func (t *namedTransport) Name() string                  { return t.name }
@@ -377,11 +829,141 @@ func (t *namedTransport) MaxLength() int                { return t.maxLength }
 func (t *namedTransport) IsStreamable() bool            { return t.isStreamable }
 func (t *namedTransport) RequestTimeout() time.Duration { return t.reqTimeout }
 func (t *namedTransport) Priority() int                 { return t.priority }
+func (t *namedTransport) Reusable() bool                { return t.reusable }
 
 func (t *namedTransport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
 	return t.newRT(ctx, addr)
 }
 
+// newStreamTransport adapts a StreamDialer into a Transport. Each race attempt
+// dials the request's origin through d and hands the connected stream to a
+// single-use http.Transport, so the race blocks on the real tunnel handshake.
+func newStreamTransport(name string, d transport.StreamDialer) *namedTransport {
+	t := &namedTransport{
+		name:         name,
+		isStreamable: true,
+		dialer:       d,
+		reusable:     true,
+	}
+	// A dialer that can carry datagrams as well does so for DialPacket.
+	t.packetDialer, _ = d.(transport.PacketDialer)
+	t.newRT = func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		conn, err := t.dialer.DialStream(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s dial: %w", name, err)
+		}
+		return t.roundTripper(ctx, conn), nil
+	}
+	return t
+}
+
+// smartTransport adapts a proxyless smart dialer into a Transport. Its TLS
+// handshakes use ECH when WithECH is on and the origin publishes a config,
+// or else the WithTLSFingerprint ClientHello.
+func (k *kindling) smartTransport(d transport.StreamDialer) *namedTransport {
+	t := newStreamTransport(string(TransportSmart), d)
+	if !k.echEnabled && k.fingerprint == nil {
+		return t
+	}
+	if k.echEnabled && k.ech == nil {
+		k.ech = k.newECHConfigs()
+	}
+	ech, fingerprint := k.ech, k.fingerprint
+	t.newRT = func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if sni := sniFrom(ctx); sni != "" {
+			host = sni
+		}
+		var list []byte
+		if ech != nil {
+			list = ech.lookup(ctx, host)
+		}
+		conn, err := t.dialer.DialStream(ctx, addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s dial: %w", t.name, err)
+		}
+		switch {
+		case list != nil:
+			return ech.roundTripper(conn, host, list, t.tlsConfig), nil
+		case fingerprint != nil:
+			return fingerprintRoundTripper(ctx, conn, utlsConfig(host, t.tlsConfig), *fingerprint, t.keepAlive)
+		}
+		return t.roundTripper(ctx, conn), nil
+	}
+	return t
+}
+
+// baseStreamDialer returns the dialer kindling-built transports use for their
+// first hop: the WithStreamDialer override, or a stdlib-backed TCPDialer.
+// Hostnames are resolved through the DNS cache and WithDoHResolver, except
+// that a WithStreamDialer override without WithDoHResolver gets them as is,
+// in case it resolves them remotely.
+func (k *kindling) baseStreamDialer() transport.StreamDialer {
+	if k.resolver != nil || k.streamDialer == nil {
+		return &resolvingDialer{base: k.rawStreamDialer(), resolver: k.hostResolver()}
+	}
+	return k.rawStreamDialer()
+}
+
+// smartLogWriter is where smart dialers log their strategy search: the
+// instance's log, with a copy kept for DumpDiagnostics.
+func (k *kindling) smartLogWriter() io.Writer {
+	if k.smartLog == nil {
+		return k.logWriter
+	}
+	return io.MultiWriter(k.logWriter, k.smartLog)
+}
+
+// rawStreamDialer is baseStreamDialer without the resolver.
+func (k *kindling) rawStreamDialer() transport.StreamDialer {
+	if k.streamDialer != nil {
+		return k.streamDialer
+	}
+	var d transport.StreamDialer = &transport.TCPDialer{Dialer: k.netDialer("tcp")}
+	if k.socketOptions != nil && k.socketOptions.TCPNoDelay != nil {
+		d = &socketOptionsDialer{StreamDialer: d, opts: k.socketOptions}
+	}
+	return d
+}
+
+// basePacketDialer returns the WithPacketDialer override, a UDPDialer with
+// the instance's socket settings, or nil for the smart dialer's default.
+func (k *kindling) basePacketDialer() transport.PacketDialer {
+	if k.packetDialer == nil && k.customNetDialer() {
+		return &transport.UDPDialer{Dialer: k.netDialer("udp")}
+	}
+	return k.packetDialer
+}
+
+// smartDialerBase returns the config and base stream dialer for a smart
+// dialer built from cfg. With WithDoHResolver the default config's DNS
+// strategies are replaced by the DoH server, and the base dialer resolves
+// through it. WithFailClosed drops the strategies that leak, and has the
+// base dialer resolve through the guarded resolver.
+func (k *kindling) smartDialerBase(cfg []byte) ([]byte, transport.StreamDialer, error) {
+	if k.dohURL == nil && !k.failClosed {
+		if k.streamDialer == nil && k.customNetDialer() {
+			return cfg, k.rawStreamDialer(), nil
+		}
+		return cfg, k.streamDialer, nil
+	}
+	var err error
+	if cfg == nil && k.dohURL != nil {
+		if cfg, err = dohSmartDialerConfig(k.dohURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	if k.failClosed {
+		if cfg, err = failClosedSmartDialerConfig(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cfg, k.baseStreamDialer(), nil
+}
+
 // --- Smart dialer ---
 
 //go:embed smart_dialer_config.yml
@@ -427,16 +1009,25 @@ func newSmartDialer(
 	return finder.NewDialer(context.Background(), domains, configBytes)
 }
 
+// errTunnelUsed is what a preconnectedTransport's dial returns after the
+// first: its one connection has been handed out, and once that closes there
+// is nothing left to dial.
+var errTunnelUsed = errors.New("tunnel connection already used")
+
 // preconnectedTransport creates an http.Transport that uses an already-established
 // connection. Intended for single-request use within the race transport.
 func preconnectedTransport(conn net.Conn) *http.Transport {
+	var dialed atomic.Bool
 	return &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if dialed.Swap(true) {
+				return nil, errTunnelUsed
+			}
 			return conn, nil
 		},
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
+		IdleConnTimeout:       tunnelIdleTimeout,
 		TLSHandshakeTimeout:   20 * time.Second,
 		ExpectContinueTimeout: 4 * time.Second,
 	}
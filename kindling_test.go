@@ -7,8 +7,12 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -66,6 +70,49 @@ func TestNewKindling(t *testing.T) {
 		}
 	})
 
+	t.Run("FrontingProviders", func(t *testing.T) {
+		t.Parallel()
+		newClient := func(provider string) *domainfront.Client {
+			c, err := domainfront.New(context.Background(), &domainfront.Config{
+				Providers: map[string]*domainfront.Provider{provider: {
+					HostAliases: map[string]string{"example.com": provider + ".example.net"},
+					Masquerades: []*domainfront.Masquerade{{Domain: provider + ".example.net", IpAddress: "192.0.2.1"}},
+				}},
+			})
+			if err != nil {
+				t.Fatalf("domainfront.New() error = %v", err)
+			}
+			t.Cleanup(c.Close)
+			return c
+		}
+		akamai, fastly := newClient("akamai"), newClient("fastly")
+		k := &kindling{}
+		for _, opt := range []Option{
+			WithDomainFrontingProvider("akamai", akamai),
+			WithDomainFrontingProvider("fastly", fastly),
+		} {
+			if err := opt(k); err != nil {
+				t.Fatalf("WithDomainFrontingProvider() error = %v", err)
+			}
+		}
+		var names []string
+		for _, tr := range k.transports {
+			names = append(names, tr.Name())
+		}
+		if want := []string{"domainfront-akamai", "domainfront-fastly"}; !slices.Equal(names, want) {
+			t.Errorf("transports = %v; want %v", names, want)
+		}
+		if err := WithDomainFrontingProvider("akamai", akamai)(k); err == nil {
+			t.Error("WithDomainFrontingProvider(\"akamai\") twice should return error")
+		}
+		if err := WithDomainFrontingProvider("", akamai)(k); err == nil {
+			t.Error("WithDomainFrontingProvider(\"\") should return error")
+		}
+		if err := WithDomainFrontingProvider("cloudfront", nil)(k); err == nil {
+			t.Error("WithDomainFrontingProvider(nil) should return error")
+		}
+	})
+
 	t.Run("NilDNSTT_ReturnsError", func(t *testing.T) {
 		t.Parallel()
 		_, err := NewKindling("test", WithDNSTunnel(nil))
@@ -223,6 +270,46 @@ func TestNewKindling(t *testing.T) {
 		}
 	})
 
+	// WithProxylessConfig's YAML applies to its own transport only; a plain
+	// WithProxyless keeps using the instance-wide config.
+	t.Run("WithProxylessConfig_ScopedToItsTransport", func(t *testing.T) {
+		globalCfg := []byte("dns: []\n")
+		ownCfg := []byte("tls: []\n")
+		gotCfg := map[string]string{}
+		orig := newSmartDialerFn
+		newSmartDialerFn = func(_ io.Writer, cfg []byte, _ transport.StreamDialer, _ transport.PacketDialer, domains ...string) (transport.StreamDialer, error) {
+			gotCfg[strings.Join(domains, ",")] = string(cfg)
+			return stubStreamDialer{}, nil
+		}
+		t.Cleanup(func() { newSmartDialerFn = orig })
+
+		k, err := NewKindling("test",
+			WithProxylessConfig(ownCfg, "a.example.com", "b.example.com"),
+			WithProxyless("c.example.com"),
+			WithSmartDialerConfig(globalCfg),
+		)
+		if err != nil {
+			t.Fatalf("NewKindling() error = %v", err)
+		}
+		want := map[string]string{
+			"a.example.com,b.example.com": string(ownCfg),
+			"c.example.com":               string(globalCfg),
+		}
+		if !reflect.DeepEqual(gotCfg, want) {
+			t.Errorf("newSmartDialer configs = %q; want %q", gotCfg, want)
+		}
+		if n := len(k.(*kindling).transports); n != 2 {
+			t.Errorf("len(transports) = %d; want 2", n)
+		}
+	})
+
+	t.Run("WithProxylessConfig_Empty_ReturnsError", func(t *testing.T) {
+		t.Parallel()
+		if _, err := NewKindling("test", WithProxylessConfig(nil, "example.com")); err == nil {
+			t.Error("NewKindling(WithProxylessConfig(nil)) should return error")
+		}
+	})
+
 	t.Run("WithSmartDialerConfig_Empty_ReturnsError", func(t *testing.T) {
 		t.Parallel()
 		if _, err := NewKindling("test", WithSmartDialerConfig(nil)); err == nil {
@@ -583,6 +670,41 @@ func TestKindling_Suite(t *testing.T) {
 			t.Error("NewHTTPClient() = nil; want non-nil")
 		}
 	})
+
+	t.Run("NewRoundTripper", func(t *testing.T) {
+		t.Parallel()
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer origin.Close()
+		k, err := NewKindling("kindling")
+		if err != nil {
+			t.Fatalf("NewKindling() error = %v", err)
+		}
+		// The caller's own client, wrapping the round-tripper as
+		// instrumentation would.
+		rt := k.NewRoundTripper()
+		var sent int
+		client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent++
+			return rt.RoundTrip(req)
+		})}
+		// The round-tripper follows transports added after it's created.
+		if err := k.AddTransport(&mockTransport{
+			name: "direct",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return http.DefaultTransport, nil
+			},
+		}); err != nil {
+			t.Fatalf("AddTransport() error = %v", err)
+		}
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+		if sent != 1 {
+			t.Errorf("requests through caller's client = %d; want 1", sent)
+		}
+	})
 }
 
 func TestReplaceTransport(t *testing.T) {
@@ -661,3 +783,153 @@ func TestReplaceTransport(t *testing.T) {
 		}
 	})
 }
+
+func TestAddRemoveTransport(t *testing.T) {
+	t.Parallel()
+
+	// respondingTransport answers every request with its own name.
+	respondingTransport := func(name string) Transport {
+		return &namedTransport{
+			name: name,
+			newRT: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{"X-Transport": {name}},
+						Body:       http.NoBody,
+						Request:    req,
+					}, nil
+				}), nil
+			},
+		}
+	}
+	get := func(client *http.Client) (string, error) {
+		resp, err := client.Get("http://example.com/")
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		return resp.Header.Get("X-Transport"), nil
+	}
+
+	t.Run("ExistingClientsFollowChanges", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(respondingTransport("first")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := k.NewHTTPClient()
+
+		if err := k.AddTransport(respondingTransport("second")); err != nil {
+			t.Fatalf("AddTransport() error = %v", err)
+		}
+		if err := k.RemoveTransport("first"); err != nil {
+			t.Fatalf("RemoveTransport() error = %v", err)
+		}
+		if got, err := get(client); err != nil || got != "second" {
+			t.Errorf("get() = %q, %v; want second", got, err)
+		}
+
+		if err := k.RemoveTransport("second"); err != nil {
+			t.Fatalf("RemoveTransport() error = %v", err)
+		}
+		if _, err := get(client); err == nil {
+			t.Error("get() should fail with no transports left")
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(respondingTransport("first")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := k.AddTransport(nil); err == nil {
+			t.Error("AddTransport(nil) should fail")
+		}
+		if err := k.AddTransport(respondingTransport("first")); err == nil {
+			t.Error("AddTransport() should reject a duplicate name")
+		}
+		if err := k.RemoveTransport("nonexistent"); err == nil {
+			t.Error("RemoveTransport() should fail for unknown transport")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(respondingTransport("base")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := k.NewHTTPClient()
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				name := TransportName(fmt.Sprintf("extra-%d", i))
+				if err := k.AddTransport(respondingTransport(string(name))); err != nil {
+					t.Error(err)
+				}
+				if err := k.RemoveTransport(name); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if _, err := get(client); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestWithStreamDialerTransport(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		if err := WithStreamDialerTransport("", &transport.TCPDialer{})(&kindling{}); err == nil {
+			t.Error("WithStreamDialerTransport(\"\") error = nil; want error")
+		}
+		if err := WithStreamDialerTransport("chain", nil)(&kindling{}); err == nil {
+			t.Error("WithStreamDialerTransport(nil) error = nil; want error")
+		}
+	})
+
+	t.Run("Request", func(t *testing.T) {
+		t.Parallel()
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("X-Kindling-Method")))
+		}))
+		defer origin.Close()
+		var dials int
+		var mu sync.Mutex
+		chain := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			mu.Lock()
+			dials++
+			mu.Unlock()
+			return (&transport.TCPDialer{}).DialStream(ctx, addr)
+		})
+		k, err := NewKindling("test", WithStreamDialerTransport("chain", chain))
+		if err != nil {
+			t.Fatalf("NewKindling() error = %v", err)
+		}
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "chain" {
+			t.Errorf("request sent over %q; want chain", body)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if dials != 1 {
+			t.Errorf("dials = %d; want 1", dials)
+		}
+	})
+}
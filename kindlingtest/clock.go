@@ -0,0 +1,120 @@
+package kindlingtest
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/getlantern/kindling"
+)
+
+// Clock is a fake kindling.Clock whose time only moves when Advance is
+// called. Pass it to kindling.WithClock, and to fake Transports with
+// WithClock, to step through timeouts and staggers without real sleeps:
+//
+//	clock := kindlingtest.NewClock(time.Now())
+//	k, _ := kindling.NewKindling("test", kindling.WithClock(clock), ...)
+//	go client.Get(url)
+//	clock.WaitForTimers(ctx, 2)
+//	clock.Advance(80 * time.Second)
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// changed is closed, and replaced, whenever a timer is added.
+	changed chan struct{}
+}
+
+var _ kindling.Clock = (*Clock)(nil)
+
+// NewClock returns a Clock that reads now until it's advanced.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now, changed: make(chan struct{})}
+}
+
+// Now implements kindling.Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer implements kindling.Clock. A timer for d <= 0 fires at once.
+func (c *Clock) NewTimer(d time.Duration) kindling.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, when: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that come due in
+// the order they're due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	slices.SortStableFunc(c.timers, func(a, b *fakeTimer) int { return a.when.Compare(b.when) })
+	var pending []*fakeTimer
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- t.when
+	}
+	c.timers = pending
+}
+
+// Timers returns how many timers are waiting to fire.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// WaitForTimers blocks until at least n timers are waiting to fire, so a
+// test knows the code under test has armed its timeouts before advancing
+// past them. It returns ctx's error if ctx ends first.
+func (c *Clock) WaitForTimers(ctx context.Context, n int) error {
+	for {
+		c.mu.Lock()
+		count, changed := len(c.timers), c.changed
+		c.mu.Unlock()
+		if count >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type fakeTimer struct {
+	clock *Clock
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+	c.timers = slices.Delete(c.timers, i, i+1)
+	return true
+}
@@ -0,0 +1,69 @@
+package kindlingtest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/getlantern/kindling/kindlingtest"
+)
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Advance", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		late := clock.NewTimer(2 * time.Second)
+		early := clock.NewTimer(time.Second)
+		assert.Equal(t, 2, clock.Timers())
+
+		clock.Advance(time.Second)
+		assert.Equal(t, start.Add(time.Second), clock.Now())
+		assert.Equal(t, start.Add(time.Second), <-early.C())
+		assert.Empty(t, late.C())
+		assert.Equal(t, 1, clock.Timers())
+
+		clock.Advance(time.Hour)
+		assert.Equal(t, start.Add(2*time.Second), <-late.C())
+		assert.Zero(t, clock.Timers())
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		timer := clock.NewTimer(time.Second)
+		assert.True(t, timer.Stop())
+		assert.False(t, timer.Stop())
+		clock.Advance(time.Second)
+		assert.Empty(t, timer.C())
+	})
+
+	t.Run("Immediate", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		timer := clock.NewTimer(0)
+		assert.Equal(t, start, <-timer.C())
+		assert.Zero(t, clock.Timers())
+	})
+
+	t.Run("WaitForTimers", func(t *testing.T) {
+		t.Parallel()
+		clock := kindlingtest.NewClock(start)
+		go func() {
+			clock.NewTimer(time.Second)
+			clock.NewTimer(time.Second)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, clock.WaitForTimers(ctx, 2))
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, clock.WaitForTimers(ctx, 3), context.DeadlineExceeded)
+	})
+}
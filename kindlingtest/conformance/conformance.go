@@ -0,0 +1,266 @@
+// Package conformance checks that a kindling Transport carries HTTP
+// faithfully: every method, chunked bodies in both directions, request and
+// response trailers, and Expect: 100-continue. Transport authors call Run
+// from a test; RunRoundTripper checks a whole client stack, such as the
+// Transport of a Kindling's HTTP client.
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/getlantern/kindling"
+)
+
+// Methods are the methods the suite sends. CONNECT is left out: it sets up
+// a tunnel rather than exchanging a request and response.
+var Methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// Run runs the suite against tr. Each request gets a fresh RoundTripper
+// from tr, as in a race, so tr must be able to reach a plain HTTP origin on
+// the loopback interface.
+func Run(t *testing.T, tr kindling.Transport) {
+	t.Helper()
+	RunRoundTripper(t, &transportRoundTripper{tr: tr})
+}
+
+// RunRoundTripper runs the suite against rt.
+func RunRoundTripper(t *testing.T, rt http.RoundTripper) {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(echo))
+	t.Cleanup(origin.Close)
+	client := &http.Client{Transport: rt, Timeout: 30 * time.Second}
+
+	t.Run("Methods", func(t *testing.T) {
+		for _, method := range Methods {
+			t.Run(method, func(t *testing.T) {
+				var body io.Reader
+				if method != http.MethodHead && method != http.MethodTrace {
+					body = strings.NewReader("hello " + method)
+				}
+				req, err := http.NewRequest(method, origin.URL+"/methods", body)
+				require.NoError(t, err)
+				resp := do(t, client, req)
+				assert.Equal(t, method, resp.Header.Get("X-Method"))
+				got, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				switch method {
+				case http.MethodHead:
+					assert.Empty(t, got)
+				case http.MethodTrace:
+				default:
+					assert.Equal(t, "hello "+method, string(got))
+				}
+			})
+		}
+	})
+
+	t.Run("ChunkedRequestBody", func(t *testing.T) {
+		payload := strings.Repeat("chunked request body ", 4096)
+		req, err := http.NewRequest(http.MethodPost, origin.URL+"/chunked", unsized(payload))
+		require.NoError(t, err)
+		resp := do(t, client, req)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, payload, string(got))
+	})
+
+	t.Run("ChunkedResponseBody", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, origin.URL+"/chunked?chunks=64", nil)
+		require.NoError(t, err)
+		resp := do(t, client, req)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat(chunk, 64), string(got))
+	})
+
+	t.Run("RequestTrailers", func(t *testing.T) {
+		payload := "body with trailers"
+		req, err := http.NewRequest(http.MethodPost, origin.URL+"/trailers", unsized(payload))
+		require.NoError(t, err)
+		req.Trailer = http.Header{"X-Checksum": nil}
+		req.Body = &trailerSetter{ReadCloser: req.Body, set: func() {
+			req.Trailer.Set("X-Checksum", checksum(payload))
+		}}
+		resp := do(t, client, req)
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, checksum(payload), resp.Header.Get("X-Request-Trailer"), "request trailer not received")
+	})
+
+	t.Run("ResponseTrailers", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, origin.URL+"/trailers?chunks=8", nil)
+		require.NoError(t, err)
+		resp := do(t, client, req)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, checksum(string(got)), resp.Trailer.Get("X-Checksum"), "response trailer not received")
+	})
+
+	t.Run("ExpectContinue", func(t *testing.T) {
+		for _, accept := range []bool{true, false} {
+			t.Run(strconv.FormatBool(accept), func(t *testing.T) {
+				payload := "expecting to continue"
+				path := "/continue"
+				if !accept {
+					path = "/reject"
+				}
+				req, err := http.NewRequest(http.MethodPut, origin.URL+path, strings.NewReader(payload))
+				require.NoError(t, err)
+				req.Header.Set("Expect", "100-continue")
+				resp := do(t, client, req)
+				got, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, "100-continue", resp.Header.Get("X-Expect"), "Expect header not received")
+				if accept {
+					assert.Equal(t, http.StatusOK, resp.StatusCode)
+					assert.Equal(t, payload, string(got))
+				} else {
+					assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+				}
+			})
+		}
+	})
+}
+
+// do sends req and fails the test on error, closing the response body at
+// cleanup.
+func do(t *testing.T, client *http.Client, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// chunk is what the origin writes, and flushes, per requested chunk.
+const chunk = "0123456789abcdef"
+
+// echo is the origin. It reports the request's method and Expect header in
+// response headers and echoes its body, except that
+//
+//   - /reject refuses an expected body with 417 without reading it,
+//   - ?chunks=n writes n flushed chunks instead, forcing a chunked response,
+//   - /trailers reports the request's X-Checksum trailer as the
+//     X-Request-Trailer header, and sends an X-Checksum trailer of its own.
+func echo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Method", r.Method)
+	w.Header().Set("X-Expect", r.Header.Get("Expect"))
+	if r.URL.Path == "/reject" {
+		w.WriteHeader(http.StatusExpectationFailed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/trailers" {
+		w.Header().Set("X-Request-Trailer", r.Trailer.Get("X-Checksum"))
+		w.Header().Set("Trailer", "X-Checksum")
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("chunks")); err == nil {
+		body = nil
+		for range n {
+			body = append(body, chunk...)
+			io.WriteString(w, chunk)
+			http.NewResponseController(w).Flush()
+		}
+	} else {
+		w.Write(body)
+	}
+	if r.URL.Path == "/trailers" {
+		w.Header().Set("X-Checksum", checksum(string(body)))
+	}
+}
+
+func checksum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// unsized returns a reader over s that http.NewRequest can't size, so the
+// request is sent chunked.
+func unsized(s string) io.Reader {
+	return struct{ io.Reader }{strings.NewReader(s)}
+}
+
+// trailerSetter calls set once the body is drained, the way a streaming
+// caller fills in trailers it only knows at the end.
+type trailerSetter struct {
+	io.ReadCloser
+	set func()
+}
+
+func (s *trailerSetter) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err == io.EOF && s.set != nil {
+		s.set()
+		s.set = nil
+	}
+	return n, err
+}
+
+// transportRoundTripper sends each request over a fresh RoundTripper from
+// tr, closing its idle connections once the response body is closed.
+type transportRoundTripper struct {
+	tr kindling.Transport
+}
+
+func (t *transportRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	rt, err := t.tr.NewRoundTripper(req.Context(), host)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		closeIdle(rt)
+		return nil, err
+	}
+	resp.Body = &closeIdleOnClose{ReadCloser: resp.Body, rt: rt}
+	return resp, nil
+}
+
+type closeIdleOnClose struct {
+	io.ReadCloser
+	rt http.RoundTripper
+}
+
+func (c *closeIdleOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	closeIdle(c.rt)
+	return err
+}
+
+func closeIdle(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,40 @@
+package conformance
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// directTransport connects straight to the origin.
+type directTransport struct{}
+
+func (directTransport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return conn, nil
+		},
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+func (directTransport) MaxLength() int                { return 0 }
+func (directTransport) IsStreamable() bool            { return true }
+func (directTransport) Name() string                  { return "direct" }
+func (directTransport) RequestTimeout() time.Duration { return 0 }
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	Run(t, directTransport{})
+}
+
+func TestRunRoundTripper(t *testing.T) {
+	t.Parallel()
+	RunRoundTripper(t, http.DefaultTransport)
+}
@@ -0,0 +1,183 @@
+package kindlingtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HandlerTransport returns a fake Transport named "handler" that serves
+// requests with h in-process, so integration tests can run the whole race,
+// including the headers kindling adds and its retries, against a real
+// handler without a network. opts can still script connect latencies and,
+// through WithResponses, per-request latencies and errors; a scripted
+// Response's status, headers, and body are ignored. Use
+// NewTransport(name, WithHandler(h)) to name the transport.
+func HandlerTransport(h http.Handler, opts ...Option) *Transport {
+	return NewTransport("handler", append(opts, WithHandler(h))...)
+}
+
+// WithHandler makes the transport answer requests by serving them with h
+// in-process. The response streams as h writes it, and closing its body
+// cancels the request's context.
+func WithHandler(h http.Handler) Option {
+	return func(t *Transport) { t.handler = h }
+}
+
+// serve runs h on a server-side copy of req, whose body has already been
+// read into body, and returns the response once h has written its header.
+func serve(h http.Handler, req *http.Request, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	sreq := req.Clone(ctx)
+	sreq.Body = io.NopCloser(bytes.NewReader(body))
+	sreq.ContentLength = int64(len(body))
+	sreq.RequestURI = req.URL.RequestURI()
+	sreq.RemoteAddr = "192.0.2.1:1234"
+	if sreq.Host == "" {
+		sreq.Host = req.URL.Host
+	}
+	sreq.Proto, sreq.ProtoMajor, sreq.ProtoMinor = "HTTP/1.1", 1, 1
+
+	pr, pw := io.Pipe()
+	w := &responseWriter{
+		req:    req,
+		header: make(http.Header),
+		pw:     pw,
+		ready:  make(chan struct{}),
+	}
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				w.fail(fmt.Errorf("kindlingtest: handler panicked: %v", p))
+				return
+			}
+			w.finish()
+		}()
+		h.ServeHTTP(w, sreq)
+	}()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		cancel()
+		pr.CloseWithError(ctx.Err())
+		return nil, ctx.Err()
+	}
+	if w.err != nil {
+		cancel()
+		return nil, w.err
+	}
+	w.resp.Body = &responseBody{PipeReader: pr, cancel: cancel}
+	return w.resp, nil
+}
+
+// responseWriter streams a handler's response through a pipe. Its header is
+// committed, and serve returns, on the first WriteHeader, Write, or Flush,
+// or when the handler returns.
+type responseWriter struct {
+	req    *http.Request
+	header http.Header
+	pw     *io.PipeWriter
+
+	once  sync.Once
+	ready chan struct{}
+	resp  *http.Response
+	err   error
+}
+
+func (w *responseWriter) Header() http.Header { return w.header }
+
+func (w *responseWriter) WriteHeader(status int) {
+	// Like net/http, informational responses aren't final, so they're
+	// dropped rather than committed.
+	if status >= 100 && status < 200 {
+		return
+	}
+	w.once.Do(func() {
+		contentLength := int64(-1)
+		if cl, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64); err == nil {
+			contentLength = cl
+		}
+		header := w.header.Clone()
+		trailer := make(http.Header)
+		for _, v := range header.Values("Trailer") {
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					trailer[http.CanonicalHeaderKey(name)] = nil
+				}
+			}
+		}
+		header.Del("Trailer")
+		w.resp = &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Trailer:       trailer,
+			ContentLength: contentLength,
+			Request:       w.req,
+		}
+		if w.req.Method == http.MethodHead {
+			w.resp.ContentLength = max(contentLength, 0)
+		}
+		close(w.ready)
+	})
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.req.Method == http.MethodHead {
+		return len(p), nil
+	}
+	return w.pw.Write(p)
+}
+
+// Flush commits the header. Writes reach the reader as they're made, so
+// there's nothing else to flush.
+func (w *responseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// finish ends the response once the handler has returned, filling in the
+// trailers it set.
+func (w *responseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	for name, v := range w.header {
+		switch {
+		case strings.HasPrefix(name, http.TrailerPrefix):
+			w.resp.Trailer[http.CanonicalHeaderKey(strings.TrimPrefix(name, http.TrailerPrefix))] = v
+		default:
+			if _, declared := w.resp.Trailer[name]; declared {
+				w.resp.Trailer[name] = v
+			}
+		}
+	}
+	w.pw.Close()
+}
+
+// fail ends the response with err: serve returns err if the header hasn't
+// been committed yet, and otherwise reading the body does.
+func (w *responseWriter) fail(err error) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.ready)
+	})
+	w.pw.CloseWithError(err)
+}
+
+type responseBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (b *responseBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
@@ -0,0 +1,138 @@
+package kindlingtest_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/getlantern/kindling"
+	"github.com/getlantern/kindling/kindlingtest"
+)
+
+func TestHandlerTransport(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ServesThroughRace", func(t *testing.T) {
+		t.Parallel()
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-App", r.Header.Get("X-Kindling-App"))
+			w.Header().Set("X-Method", r.Header.Get("X-Kindling-Method"))
+			body, _ := io.ReadAll(r.Body)
+			io.WriteString(w, r.Method+" "+r.Host+r.RequestURI+" "+string(body))
+		})
+		tr := kindlingtest.HandlerTransport(h)
+		resp, err := newClient(t, tr).Post("https://example.com/path?q=1", "text/plain", strings.NewReader("hi"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		kindlingtest.AssertServedBy(t, resp, "handler")
+		assert.Equal(t, "POST example.com/path?q=1 hi", string(body))
+		assert.Equal(t, "test", resp.Header.Get("X-App"))
+		assert.Equal(t, "handler", resp.Header.Get("X-Method"))
+	})
+
+	t.Run("Retries", func(t *testing.T) {
+		t.Parallel()
+		var calls int
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		failing := kindlingtest.NewTransport("failing", kindlingtest.WithHandler(h))
+		working := kindlingtest.NewTransport("working",
+			kindlingtest.WithConnectLatency(20*time.Millisecond),
+			kindlingtest.WithHandler(http.NotFoundHandler()),
+		)
+		resp, _ := get(t, newClient(t, failing, working))
+		kindlingtest.AssertServedBy(t, resp, "working")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Streams", func(t *testing.T) {
+		t.Parallel()
+		release := make(chan struct{})
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "first\n")
+			w.(http.Flusher).Flush()
+			<-release
+			io.WriteString(w, "second\n")
+		})
+		resp, err := newClient(t, kindlingtest.HandlerTransport(h)).Get("https://example.com/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		buf := make([]byte, len("first\n"))
+		_, err = io.ReadFull(resp.Body, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "first\n", string(buf))
+		close(release)
+		rest, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "second\n", string(rest))
+	})
+
+	t.Run("Trailers", func(t *testing.T) {
+		t.Parallel()
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "X-Declared")
+			io.WriteString(w, "body")
+			w.Header().Set("X-Declared", "a")
+			w.Header().Set(http.TrailerPrefix+"X-Undeclared", "b")
+		})
+		resp, err := newClient(t, kindlingtest.HandlerTransport(h)).Get("https://example.com/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "a", resp.Trailer.Get("X-Declared"))
+		assert.Equal(t, "b", resp.Trailer.Get("X-Undeclared"))
+	})
+
+	t.Run("CloseCancelsHandler", func(t *testing.T) {
+		t.Parallel()
+		done := make(chan error, 1)
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			<-r.Context().Done()
+			done <- r.Context().Err()
+		})
+		resp, err := newClient(t, kindlingtest.HandlerTransport(h)).Get("https://example.com/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		select {
+		case err := <-done:
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler context not canceled")
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		t.Parallel()
+		h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+		_, err := newClient(t, kindlingtest.HandlerTransport(h)).Get("https://example.com/")
+		assert.ErrorContains(t, err, "boom")
+	})
+
+	t.Run("ScriptedError", func(t *testing.T) {
+		t.Parallel()
+		tr := kindlingtest.HandlerTransport(http.NotFoundHandler(),
+			kindlingtest.WithResponses(kindlingtest.Response{Err: errors.New("reset")}, kindlingtest.Response{}),
+		)
+		k, err := kindling.NewKindling("test", kindling.WithLogWriter(io.Discard), kindling.WithTransport(tr))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		client := k.NewHTTPClient()
+		_, err = client.Get("https://example.com/")
+		assert.ErrorContains(t, err, "reset")
+		resp, _ := get(t, client)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,256 @@
+// Package kindlingtest provides fake kindling Transports for apps that want
+// to unit-test their kindling integration without real networks. A fake
+// never dials anything: it connects and answers after scripted latencies,
+// with scripted errors or canned responses, and stamps each response with
+// its name so tests can assert which transport served a request:
+//
+//	fast := kindlingtest.NewTransport("fast", kindlingtest.WithResponses(
+//		kindlingtest.Response{Latency: 10 * time.Millisecond, Body: "hello"},
+//	))
+//	broken := kindlingtest.NewTransport("broken", kindlingtest.WithConnectError(errors.New("blocked")))
+//	k, _ := kindling.NewKindling("test", kindling.WithTransport(fast), kindling.WithTransport(broken))
+//	resp, _ := k.NewHTTPClient().Get("https://example.com")
+//	kindlingtest.AssertServedBy(t, resp, "fast")
+package kindlingtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/kindling"
+)
+
+// ServedByHeader is the response header a fake Transport sets to its name.
+const ServedByHeader = "Kindlingtest-Served-By"
+
+// Response scripts how a fake Transport answers one request.
+type Response struct {
+	// Latency is how long the transport takes to answer. The request's
+	// context cuts it short.
+	Latency time.Duration
+	// Err, if set, fails the request instead of answering it.
+	Err error
+	// StatusCode defaults to 200.
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+// Transport is a fake kindling.Transport. Create one with NewTransport.
+type Transport struct {
+	name           string
+	maxLength      int
+	connectLatency time.Duration
+	connectErr     error
+	handler        http.Handler
+	clock          kindling.Clock
+
+	mu        sync.Mutex
+	responses []Response
+	connects  int
+	requests  []*http.Request
+}
+
+var _ kindling.Transport = (*Transport)(nil)
+
+// Option configures a fake Transport.
+type Option func(*Transport)
+
+// WithConnectLatency makes NewRoundTripper take d to connect. The race's
+// context cuts it short.
+func WithConnectLatency(d time.Duration) Option {
+	return func(t *Transport) { t.connectLatency = d }
+}
+
+// WithConnectError makes NewRoundTripper fail with err, after any connect
+// latency.
+func WithConnectError(err error) Option {
+	return func(t *Transport) { t.connectErr = err }
+}
+
+// WithResponses scripts the transport's answers: the nth request it gets is
+// answered by the nth Response, and the last Response answers every
+// request after that.
+func WithResponses(responses ...Response) Option {
+	return func(t *Transport) { t.responses = append([]Response(nil), responses...) }
+}
+
+// WithClock times the transport's latencies with clock rather than the
+// system clock, typically the fake Clock also passed to kindling.WithClock.
+func WithClock(clock kindling.Clock) Option {
+	return func(t *Transport) { t.clock = clock }
+}
+
+// WithMaxLength sets the largest request body the transport accepts, so
+// kindling skips it for larger ones.
+func WithMaxLength(n int) Option {
+	return func(t *Transport) { t.maxLength = n }
+}
+
+// NewTransport returns a fake Transport named name. Without WithResponses
+// it answers every request at once with an empty 200.
+func NewTransport(name string, opts ...Option) *Transport {
+	t := &Transport{name: name, responses: []Response{{}}}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if len(t.responses) == 0 {
+		t.responses = []Response{{}}
+	}
+	return t
+}
+
+// Name implements kindling.Transport.
+func (t *Transport) Name() string { return t.name }
+
+// MaxLength implements kindling.Transport.
+func (t *Transport) MaxLength() int { return t.maxLength }
+
+// IsStreamable implements kindling.Transport.
+func (t *Transport) IsStreamable() bool { return true }
+
+// RequestTimeout implements kindling.Transport.
+func (t *Transport) RequestTimeout() time.Duration { return 0 }
+
+// Reusable has kindling pool the transport's round-trippers.
+func (t *Transport) Reusable() bool { return true }
+
+// NewRoundTripper implements kindling.Transport.
+func (t *Transport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
+	t.mu.Lock()
+	t.connects++
+	t.mu.Unlock()
+	if err := t.sleep(ctx, t.connectLatency); err != nil {
+		return nil, err
+	}
+	if t.connectErr != nil {
+		return nil, t.connectErr
+	}
+	return roundTripperFunc(t.roundTrip), nil
+}
+
+// Connects returns how many times kindling has asked the transport to
+// connect. Kindling pools connected transports, so this can be less than
+// the number of requests.
+func (t *Transport) Connects() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connects
+}
+
+// Requests returns the requests that reached the transport, including ones
+// that lost a race, in order. Their bodies have been read and can be read
+// again.
+func (t *Transport) Requests() []*http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*http.Request(nil), t.requests...)
+}
+
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	recorded := req.Clone(context.Background())
+	recorded.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	r := t.responses[min(len(t.requests), len(t.responses)-1)]
+	t.requests = append(t.requests, recorded)
+	t.mu.Unlock()
+
+	if err := t.sleep(req.Context(), r.Latency); err != nil {
+		return nil, err
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if t.handler != nil {
+		resp, err := serve(t.handler, req, body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Set(ServedByHeader, t.name)
+		return resp, nil
+	}
+	status := r.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := r.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(ServedByHeader, t.name)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
+
+// ServedBy returns the name of the fake Transport that served resp, or ""
+// if none did.
+func ServedBy(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	return resp.Header.Get(ServedByHeader)
+}
+
+// AssertServedBy reports a test error unless the fake Transport named name
+// served resp.
+func AssertServedBy(t testing.TB, resp *http.Response, name string) bool {
+	t.Helper()
+	if got := ServedBy(resp); got != name {
+		t.Errorf("response served by %q, want %q", got, name)
+		return false
+	}
+	return true
+}
+
+// sleep waits for d on the transport's clock, or until ctx is done.
+func (t *Transport) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	var fired <-chan time.Time
+	if t.clock != nil {
+		timer := t.clock.NewTimer(d)
+		defer timer.Stop()
+		fired = timer.C()
+	} else {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		fired = timer.C
+	}
+	select {
+	case <-fired:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
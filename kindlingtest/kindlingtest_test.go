@@ -0,0 +1,141 @@
+package kindlingtest_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/getlantern/kindling"
+	"github.com/getlantern/kindling/kindlingtest"
+)
+
+func newClient(t *testing.T, transports ...*kindlingtest.Transport) *http.Client {
+	t.Helper()
+	opts := []kindling.Option{kindling.WithLogWriter(io.Discard)}
+	for _, tr := range transports {
+		opts = append(opts, kindling.WithTransport(tr))
+	}
+	k, err := kindling.NewKindling("test", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	return k.NewHTTPClient()
+}
+
+func get(t *testing.T, client *http.Client) (*http.Response, string) {
+	t.Helper()
+	resp, err := client.Get("https://example.com/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestTransport(t *testing.T) {
+	t.Parallel()
+
+	t.Run("FastestConnectWins", func(t *testing.T) {
+		t.Parallel()
+		fast := kindlingtest.NewTransport("fast",
+			kindlingtest.WithConnectLatency(10*time.Millisecond),
+			kindlingtest.WithResponses(kindlingtest.Response{Body: "fast"}),
+		)
+		slow := kindlingtest.NewTransport("slow",
+			kindlingtest.WithConnectLatency(time.Second),
+			kindlingtest.WithResponses(kindlingtest.Response{Body: "slow"}),
+		)
+		resp, body := get(t, newClient(t, slow, fast))
+		kindlingtest.AssertServedBy(t, resp, "fast")
+		assert.Equal(t, "fast", body)
+		assert.Empty(t, slow.Requests())
+	})
+
+	t.Run("ConnectError", func(t *testing.T) {
+		t.Parallel()
+		broken := kindlingtest.NewTransport("broken", kindlingtest.WithConnectError(errors.New("blocked")))
+		working := kindlingtest.NewTransport("working", kindlingtest.WithConnectLatency(20*time.Millisecond))
+		resp, _ := get(t, newClient(t, broken, working))
+		kindlingtest.AssertServedBy(t, resp, "working")
+		assert.Equal(t, 1, broken.Connects())
+		assert.Empty(t, broken.Requests())
+	})
+
+	t.Run("Script", func(t *testing.T) {
+		t.Parallel()
+		tr := kindlingtest.NewTransport("scripted", kindlingtest.WithResponses(
+			kindlingtest.Response{StatusCode: http.StatusNotFound, Body: "first"},
+			kindlingtest.Response{Header: http.Header{"X-Test": {"yes"}}, Body: "second"},
+		))
+		client := newClient(t, tr)
+		resp, body := get(t, client)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, "first", body)
+		for range 2 {
+			resp, body = get(t, client)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "yes", resp.Header.Get("X-Test"))
+			assert.Equal(t, "second", body)
+		}
+		assert.Len(t, tr.Requests(), 3)
+		assert.Equal(t, 1, tr.Connects(), "kindling should reuse the pooled round tripper")
+	})
+
+	t.Run("RecordsRequests", func(t *testing.T) {
+		t.Parallel()
+		tr := kindlingtest.NewTransport("recorder")
+		resp, err := newClient(t, tr).Post("https://example.com/upload", "text/plain", strings.NewReader("payload"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		requests := tr.Requests()
+		require.Len(t, requests, 1)
+		assert.Equal(t, http.MethodPost, requests[0].Method)
+		assert.Equal(t, "/upload", requests[0].URL.Path)
+		body, err := io.ReadAll(requests[0].Body)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(body))
+	})
+
+	t.Run("MaxLength", func(t *testing.T) {
+		t.Parallel()
+		small := kindlingtest.NewTransport("small", kindlingtest.WithMaxLength(4))
+		large := kindlingtest.NewTransport("large")
+		resp, err := newClient(t, small, large).Post("https://example.com/", "text/plain", strings.NewReader("too long"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		kindlingtest.AssertServedBy(t, resp, "large")
+		assert.Empty(t, small.Requests())
+	})
+
+	t.Run("RequestError", func(t *testing.T) {
+		t.Parallel()
+		tr := kindlingtest.NewTransport("failing", kindlingtest.WithResponses(kindlingtest.Response{Err: errors.New("reset")}))
+		_, err := newClient(t, tr).Get("https://example.com/")
+		assert.ErrorContains(t, err, "reset")
+	})
+}
+
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertServedBy(t *testing.T) {
+	t.Parallel()
+	resp := &http.Response{Header: http.Header{kindlingtest.ServedByHeader: {"a"}}}
+	tb := &recordingTB{TB: t}
+	assert.True(t, kindlingtest.AssertServedBy(tb, resp, "a"))
+	assert.False(t, kindlingtest.AssertServedBy(tb, resp, "b"))
+	assert.Equal(t, []string{`response served by "a", want "b"`}, tb.errors)
+	assert.Equal(t, "", kindlingtest.ServedBy(nil))
+}
@@ -0,0 +1,82 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// WithLogLevel sets the lowest level kindling logs at, in place of Info, or
+// Debug with WithLogWriter. It applies whichever order the two options come
+// in.
+func WithLogLevel(level slog.Level) Option {
+	return func(k *kindling) error {
+		k.levelVar().Set(level)
+		k.logLevelSet = true
+		return nil
+	}
+}
+
+// WithLogSampling logs only every nth occurrence of each debug message from
+// the race loop, such as "Transport connected, sending request", so that
+// debug logging stays affordable in production. The first occurrence is
+// always logged, and messages at Info and above are never sampled.
+func WithLogSampling(every int) Option {
+	return func(k *kindling) error {
+		if every < 1 {
+			return fmt.Errorf("log sampling must be at least 1, got %d", every)
+		}
+		k.logSampling = every
+		return nil
+	}
+}
+
+// levelVar returns the level the instance's loggers share, creating it at
+// Info if needed.
+func (k *kindling) levelVar() *slog.LevelVar {
+	if k.logLevel == nil {
+		k.logLevel = new(slog.LevelVar)
+	}
+	return k.logLevel
+}
+
+// raceLog returns the logger for race transports, sampled by
+// WithLogSampling. Every client of the instance shares its samples.
+func (k *kindling) raceLog() *slog.Logger {
+	if k.logSampling <= 1 {
+		return k.log
+	}
+	k.sampledLogOnce.Do(func() {
+		k.sampledLog = slog.New(&samplingHandler{Handler: k.log.Handler(), every: uint64(k.logSampling), counts: &sync.Map{}})
+	})
+	return k.sampledLog
+}
+
+// samplingHandler passes on one in every `every` debug records with a given
+// message, and every record above Debug.
+type samplingHandler struct {
+	slog.Handler
+	every uint64
+	// counts maps each message to the *atomic.Uint64 count of its records.
+	counts *sync.Map
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level <= slog.LevelDebug {
+		c, _ := h.counts.LoadOrStore(r.Message, new(atomic.Uint64))
+		if (c.(*atomic.Uint64).Add(1)-1)%h.every != 0 {
+			return nil
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), every: h.every, counts: h.counts}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), every: h.every, counts: h.counts}
+}
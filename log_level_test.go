@@ -0,0 +1,102 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLogLevel(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(&mockTransport{name: "m"}))
+		require.NoError(t, err)
+		assert.Equal(t, slog.LevelInfo, k.(*kindling).logLevel.Level())
+
+		k, err = NewKindling("test", WithTransport(&mockTransport{name: "m"}), WithLogWriter(&bytes.Buffer{}))
+		require.NoError(t, err)
+		assert.Equal(t, slog.LevelDebug, k.(*kindling).logLevel.Level())
+	})
+
+	for name, writerFirst := range map[string]bool{"LevelFirst": false, "WriterFirst": true} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var logs syncBuffer
+			opts := []Option{WithLogLevel(slog.LevelWarn), WithLogWriter(&logs)}
+			if writerFirst {
+				opts[0], opts[1] = opts[1], opts[0]
+			}
+			k := &kindling{}
+			for _, opt := range opts {
+				require.NoError(t, opt(k))
+			}
+			k.log.Debug("hidden")
+			k.log.Warn("shown")
+			assert.NotContains(t, logs.String(), "hidden")
+			assert.Contains(t, logs.String(), "shown")
+		})
+	}
+}
+
+func TestWithLogSampling(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithLogSampling(0)(&kindling{}))
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		log := slog.New(&samplingHandler{
+			Handler: slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+			every:   3,
+			counts:  &sync.Map{},
+		})
+		tagged := log.With("requestID", "abc")
+		for range 7 {
+			log.Debug("attempt")
+			tagged.Debug("other")
+			log.Info("info")
+		}
+		// Requests tag their logger, which shares the counts.
+		tagged.Debug("attempt")
+		out := buf.String()
+		assert.Equal(t, 3, strings.Count(out, "msg=attempt"))
+		assert.Equal(t, 3, strings.Count(out, "msg=other"))
+		assert.Equal(t, 7, strings.Count(out, "msg=info"))
+	})
+
+	t.Run("RaceLoop", func(t *testing.T) {
+		t.Parallel()
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(origin.Close)
+		direct := &mockTransport{
+			name: "direct",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return http.DefaultTransport, nil
+			},
+		}
+		var logs syncBuffer
+		k, err := NewKindling("test", WithTransport(direct), WithLogWriter(&logs), WithLogSampling(10))
+		require.NoError(t, err)
+		for range 10 {
+			// Each client shares the instance's samples.
+			resp, err := k.NewHTTPClient().Get(origin.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.Equal(t, 1, strings.Count(logs.String(), "Transport connected, sending request"))
+	})
+}
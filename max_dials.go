@@ -0,0 +1,121 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// WithMaxConcurrentDials caps how many transport connects may be under way
+// at once across every client the instance creates, so dozens of parallel
+// requests, each racing every transport, can't spawn an unbounded number of
+// dials. Connects over the cap wait their turn, within their request's
+// budget; a connect timeout (see WithConnectTimeout) only starts counting
+// once the connect is under way. Reusing a pooled connection isn't a dial
+// and doesn't count. By default there's no cap.
+func WithMaxConcurrentDials(n int) Option {
+	return func(k *kindling) error {
+		if n <= 0 {
+			return fmt.Errorf("max concurrent dials must be positive, got %d", n)
+		}
+		k.dials = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithMaxDialsPerHost caps how many transport connects to any one host may
+// be under way at once, across every transport and every client the
+// instance creates, so an app retrying a control-plane host aggressively
+// doesn't set off a herd of handshakes to it. Connects over the cap wait
+// their turn as with WithMaxConcurrentDials, which applies on top. By
+// default there's no cap.
+func WithMaxDialsPerHost(n int) Option {
+	return func(k *kindling) error {
+		if n <= 0 {
+			return fmt.Errorf("max dials per host must be positive, got %d", n)
+		}
+		k.hostDials = newHostDials(n)
+		return nil
+	}
+}
+
+// hostDials holds a semaphore per host with a connect under way or waiting.
+// Ports don't matter: a host is a host.
+type hostDials struct {
+	n int
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+type hostSlots struct {
+	slots chan struct{}
+	// users counts the connects holding or waiting for a slot, so the entry
+	// can go once there are none.
+	users int
+}
+
+func newHostDials(n int) *hostDials {
+	return &hostDials{n: n, hosts: make(map[string]*hostSlots)}
+}
+
+// acquire waits for a free slot for addr's host, returning a func that
+// frees it, or ctx's error if ctx ends first. A nil hostDials has no cap.
+func (h *hostDials) acquire(ctx context.Context, addr string) (func(), error) {
+	if h == nil {
+		return func() {}, nil
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	h.mu.Lock()
+	s, ok := h.hosts[addr]
+	if !ok {
+		s = &hostSlots{slots: make(chan struct{}, h.n)}
+		h.hosts[addr] = s
+	}
+	s.users++
+	h.mu.Unlock()
+
+	leave := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if s.users--; s.users == 0 {
+			delete(h.hosts, addr)
+		}
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return func() {
+			<-s.slots
+			leave()
+		}, nil
+	case <-ctx.Done():
+		leave()
+		return nil, ctx.Err()
+	}
+}
+
+// acquireDial waits for a free dial slot for addr, first for its host and
+// then for the instance, returning a func that frees both, or ctx's error
+// if ctx ends first.
+func (t *raceTransport) acquireDial(ctx context.Context, addr string) (func(), error) {
+	releaseHost, err := t.hostDials.acquire(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.dials == nil {
+		return releaseHost, nil
+	}
+	select {
+	case t.dials <- struct{}{}:
+		return func() {
+			<-t.dials
+			releaseHost()
+		}, nil
+	case <-ctx.Done():
+		releaseHost()
+		return nil, ctx.Err()
+	}
+}
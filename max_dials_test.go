@@ -0,0 +1,239 @@
+package kindling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialCounter makes transports that take a while to connect, recording the
+// most connects under way at once.
+type dialCounter struct {
+	rt            http.RoundTripper
+	dialing, most atomic.Int32
+}
+
+func (d *dialCounter) transport(name string) Transport {
+	return &mockTransport{
+		name: name,
+		newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+			n := d.dialing.Add(1)
+			defer d.dialing.Add(-1)
+			for {
+				m := d.most.Load()
+				if n <= m || d.most.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return d.rt, nil
+		},
+	}
+}
+
+func TestWithMaxConcurrentDials(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithMaxConcurrentDials(0)(&kindling{}))
+	assert.Error(t, WithMaxConcurrentDials(-1)(&kindling{}))
+	k := &kindling{}
+	require.NoError(t, WithMaxConcurrentDials(3)(k))
+	assert.Equal(t, 3, cap(k.dials))
+}
+
+func TestWithMaxDialsPerHost(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithMaxDialsPerHost(0)(&kindling{}))
+	k := &kindling{}
+	require.NoError(t, WithMaxDialsPerHost(2)(k))
+	assert.Equal(t, 2, k.hostDials.n)
+}
+
+func TestHostDials(t *testing.T) {
+	t.Parallel()
+
+	t.Run("PerHost", func(t *testing.T) {
+		t.Parallel()
+		h := newHostDials(1)
+		ctx := context.Background()
+		release, err := h.acquire(ctx, "a.example.com:443")
+		require.NoError(t, err)
+		// Another host has a budget of its own.
+		releaseB, err := h.acquire(ctx, "b.example.com:443")
+		require.NoError(t, err)
+		releaseB()
+
+		// The same host on another port shares it.
+		short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = h.acquire(short, "a.example.com:80")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		acquired := make(chan func())
+		go func() {
+			r, err := h.acquire(ctx, "a.example.com:443")
+			assert.NoError(t, err)
+			acquired <- r
+		}()
+		release()
+		(<-acquired)()
+		assert.Empty(t, h.hosts, "idle hosts are forgotten")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		var h *hostDials
+		release, err := h.acquire(context.Background(), "a.example.com:443")
+		require.NoError(t, err)
+		release()
+	})
+}
+
+func TestMaxDialsPerHost(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	dials := &dialCounter{rt: server.Client().Transport}
+	rt := newRaceTransport("test", testLog, func(string) {}, []Transport{dials.transport("a"), dials.transport("b"), dials.transport("c")})
+	rt.hostDials = newHostDials(2)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, dials.most.Load(), int32(2))
+}
+
+func TestMaxConcurrentDials(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	t.Run("Capped", func(t *testing.T) {
+		t.Parallel()
+		dials := &dialCounter{rt: server.Client().Transport}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{dials.transport("a"), dials.transport("b"), dials.transport("c")})
+		rt.dials = make(chan struct{}, 2)
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+				if assert.NoError(t, err) {
+					resp.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, dials.most.Load(), int32(2))
+		// Losing connects may still be finishing.
+		assert.Eventually(t, func() bool { return len(rt.dials) == 0 }, 5*time.Second, 10*time.Millisecond, "a dial slot leaked")
+	})
+
+	t.Run("WaitingIsBoundedByTheRequest", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			&mockTransport{name: "a", newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+				return server.Client().Transport, nil
+			}},
+		})
+		rt.dials = make(chan struct{}, 1)
+		rt.dials <- struct{}{}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil).WithContext(ctx))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("PooledReuseIsNotADial", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			&mockTransport{name: "a", reusable: true, newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+				t.Error("dialed instead of reusing the pooled round-tripper")
+				return nil, nil
+			}},
+		})
+		rt.pool = newRoundTripperPool(time.Minute)
+		rt.pool.put(poolKey{name: "a", addr: hostWithPort(server.Listener.Addr().String(), "http")}, server.Client().Transport)
+		rt.dials = make(chan struct{}, 1)
+		rt.dials <- struct{}{}
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+}
+
+func TestLosingConnects(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+	addr := hostWithPort(server.Listener.Addr().String(), "http")
+
+	for name, mode := range map[string]IdempotencyMode{"Serial": IdempotencyStrict, "Parallel": IdempotencyParallel} {
+		t.Run("CancelledOnFirstSuccess/"+name, func(t *testing.T) {
+			t.Parallel()
+			cancelled := make(chan struct{})
+			rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+				&mockTransport{name: "fast", newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+					return server.Client().Transport, nil
+				}},
+				&mockTransport{name: "hung", newRoundTripper: func(ctx context.Context, _ string) (http.RoundTripper, error) {
+					<-ctx.Done()
+					close(cancelled)
+					return nil, ctx.Err()
+				}},
+			})
+			rt.idempotency = mode
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+			require.NoError(t, err)
+			// The loser is let go before the winner's body is read.
+			defer resp.Body.Close()
+			select {
+			case <-cancelled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("losing connect wasn't cancelled")
+			}
+		})
+	}
+
+	t.Run("LateConnectIsPooled", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			&mockTransport{name: "fast", newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+				return server.Client().Transport, nil
+			}},
+			&mockTransport{name: "late", reusable: true, newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+				// Ignores cancellation, as a handshake under way might.
+				time.Sleep(50 * time.Millisecond)
+				return server.Client().Transport, nil
+			}},
+		})
+		rt.pool = newRoundTripperPool(time.Minute)
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Eventually(t, func() bool {
+			_, ok := rt.pool.get(poolKey{name: "late", addr: addr})
+			return ok
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
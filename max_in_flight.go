@@ -0,0 +1,66 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrTooManyRequests is returned for requests made while WithMaxInFlight's
+// limit is reached and its queue is full.
+var ErrTooManyRequests = errors.New("too many requests in flight")
+
+// WithMaxInFlight caps how many requests may be racing at once across every
+// client the instance creates, so a burst of control-plane calls doesn't
+// spawn a full transport race, with its goroutines and sockets, per call.
+// Up to queue more requests wait their turn, within their own deadlines,
+// and requests beyond that fail at once with ErrTooManyRequests. A request
+// gives up its slot once its race is decided, while its response body is
+// still being read, and requests coalesced into another's race (see
+// WithRequestCoalescing) don't take one. By default there's no cap.
+func WithMaxInFlight(n, queue int) Option {
+	return func(k *kindling) error {
+		if n <= 0 {
+			return fmt.Errorf("max in-flight requests must be positive, got %d", n)
+		}
+		if queue < 0 {
+			return fmt.Errorf("in-flight queue length must not be negative, got %d", queue)
+		}
+		k.inFlight = &inFlight{slots: make(chan struct{}, n), queue: int64(queue)}
+		return nil
+	}
+}
+
+// inFlight is the WithMaxInFlight semaphore and its queue.
+type inFlight struct {
+	slots   chan struct{}
+	queue   int64
+	waiting atomic.Int64
+}
+
+// acquire waits for a free slot, returning a func that frees it. It fails
+// with ErrTooManyRequests if the queue is full, or with ctx's error if ctx
+// ends first. A nil inFlight has no cap.
+func (f *inFlight) acquire(ctx context.Context) (func(), error) {
+	if f == nil {
+		return func() {}, nil
+	}
+	release := func() { <-f.slots }
+	select {
+	case f.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if f.waiting.Add(1) > f.queue {
+		f.waiting.Add(-1)
+		return nil, ErrTooManyRequests
+	}
+	defer f.waiting.Add(-1)
+	select {
+	case f.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,91 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMaxInFlight(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithMaxInFlight(0, 0)(&kindling{}))
+	assert.Error(t, WithMaxInFlight(1, -1)(&kindling{}))
+
+	var serving, most atomic.Int32
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := serving.Add(1)
+		defer serving.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+	}))
+	t.Cleanup(origin.Close)
+	direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		return http.DefaultTransport, nil
+	}}
+	k, err := NewKindling("test", WithLogWriter(io.Discard), WithTransport(direct), WithMaxInFlight(2, 2))
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	client := k.NewHTTPClient()
+
+	var wg sync.WaitGroup
+	var ok atomic.Int32
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(origin.URL)
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				ok.Add(1)
+			}
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return serving.Load() == 2 && k.(*kindling).inFlight.waiting.Load() == 2
+	}, 5*time.Second, time.Millisecond)
+
+	_, err = client.Get(origin.URL)
+	assert.True(t, errors.Is(err, ErrTooManyRequests), "the queue is full, got %v", err)
+
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 4, ok.Load(), "queued requests go through")
+	assert.EqualValues(t, 2, most.Load())
+}
+
+func TestInFlightCanceled(t *testing.T) {
+	t.Parallel()
+
+	f := &inFlight{slots: make(chan struct{}, 1), queue: 1}
+	release, err := f.acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = f.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+	release, err = f.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+
+	var unbounded *inFlight
+	release, err = unbounded.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
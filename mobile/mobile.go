@@ -0,0 +1,662 @@
+// Package mobile wraps kindling in an API that gomobile can bind, so Android
+// and iOS apps can embed it without writing their own bridge:
+//
+//	gomobile bind -target=android github.com/getlantern/kindling/mobile
+//
+// gomobile can't bind variadic functions, function values, or most Go
+// types, so the API here is flattened: options are methods on Options,
+// lists are comma-separated strings, durations are whole seconds, and
+// requests and responses carry their bodies as byte slices.
+package mobile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/getlantern/kindling"
+	utls "github.com/refraction-networking/utls"
+)
+
+// Logger receives kindling's log output, one line per call.
+type Logger interface {
+	Log(line string)
+}
+
+// SocketProtector keeps kindling's sockets out of the app's own VPN tunnel.
+// Android apps implement Protect with VpnService.protect.
+type SocketProtector interface {
+	// Protect is called with each socket's file descriptor before it
+	// connects, and returns whether it was protected.
+	Protect(fd int) bool
+}
+
+// AuthProvider supplies the Authorization header for the app's requests.
+type AuthProvider interface {
+	// Authorization returns the header's value. refresh is true when the
+	// server rejected the last one, which should then be renewed.
+	Authorization(refresh bool) (string, error)
+}
+
+// Options collects the settings New builds a Kindling with. Each method
+// mirrors the kindling option of the same name; see its documentation for
+// details. Invalid settings are reported by New.
+type Options struct {
+	logger kindling.Option
+	opts   []kindling.Option
+	err    error
+}
+
+// NewOptions returns an empty set of options.
+func NewOptions() *Options {
+	return &Options{}
+}
+
+func (o *Options) add(opt kindling.Option) {
+	o.opts = append(o.opts, opt)
+}
+
+// SetLogger sends kindling's logs to l instead of standard output.
+func (o *Options) SetLogger(l Logger) {
+	if l == nil {
+		o.logger = nil
+		return
+	}
+	o.logger = kindling.WithLogWriter(logWriter{l})
+}
+
+// LogLevel sets the lowest level logged: "debug", "info", "warn", or
+// "error".
+func (o *Options) LogLevel(level string) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		o.err = errors.Join(o.err, fmt.Errorf("parsing log level: %w", err))
+		return
+	}
+	o.add(kindling.WithLogLevel(l))
+}
+
+// LogSampling logs only every nth occurrence of each race debug message.
+func (o *Options) LogSampling(every int) {
+	o.add(kindling.WithLogSampling(every))
+}
+
+// Proxyless adds the proxyless smart transport for a comma-separated list
+// of domains.
+func (o *Options) Proxyless(domains string) {
+	o.add(kindling.WithProxyless(splitList(domains)...))
+}
+
+// ProxylessConfig is Proxyless with its own strategy YAML.
+func (o *Options) ProxylessConfig(config []byte, domains string) {
+	o.add(kindling.WithProxylessConfig(config, splitList(domains)...))
+}
+
+// ProxylessConfigURL is Proxyless with strategies kept up to date from
+// configURL, fetched every intervalSeconds.
+func (o *Options) ProxylessConfigURL(configURL string, intervalSeconds int64, domains string) {
+	o.add(kindling.WithProxylessConfigURL(configURL, seconds(intervalSeconds), splitList(domains)...))
+}
+
+// SmartDialerConfig replaces the embedded smart dialer strategy YAML.
+func (o *Options) SmartDialerConfig(config []byte) {
+	o.add(kindling.WithSmartDialerConfig(config))
+}
+
+// Shadowsocks adds a Shadowsocks transport from an ss:// access key.
+func (o *Options) Shadowsocks(accessKey string) {
+	o.add(kindling.WithShadowsocks(accessKey))
+}
+
+// TURN adds a transport relayed through a TURN server.
+func (o *Options) TURN(server, username, credential string) {
+	o.add(kindling.WithTURN(server, username, credential))
+}
+
+// UpstreamProxy adds a transport through an HTTP, HTTPS, or SOCKS5 proxy.
+func (o *Options) UpstreamProxy(proxyURL string) {
+	o.add(kindling.WithUpstreamProxy(proxyURL))
+}
+
+// WebTunnel adds a WebTunnel transport.
+func (o *Options) WebTunnel(serverURL, path, serverPubKey string) {
+	o.add(kindling.WithWebTunnel(serverURL, path, serverPubKey))
+}
+
+// Multiplexing runs WebTunnel and WebRTC connections as streams over one
+// established tunnel.
+func (o *Options) Multiplexing() {
+	o.add(kindling.WithMultiplexing())
+}
+
+// H2C sends http:// requests as cleartext HTTP/2 with prior knowledge.
+func (o *Options) H2C() {
+	o.add(kindling.WithH2C())
+}
+
+// KeepAlive pings pooled connections idle for intervalSeconds.
+func (o *Options) KeepAlive(intervalSeconds int64) {
+	o.add(kindling.WithKeepAlive(time.Duration(intervalSeconds) * time.Second))
+}
+
+// TLSSessionCache sets how many TLS sessions are kept to resume; 0 turns
+// resumption off.
+func (o *Options) TLSSessionCache(capacity int) {
+	o.add(kindling.WithTLSSessionCache(capacity))
+}
+
+// Obfuscation pads requests with up to padding random bytes and delays
+// writes on stream tunnels by up to jitterMillis.
+func (o *Options) Obfuscation(padding int, jitterMillis int64) {
+	o.add(kindling.WithObfuscation(padding, time.Duration(jitterMillis)*time.Millisecond))
+}
+
+// DecoyTraffic fetches the comma-separated urls through idle transports
+// every intervalSeconds on average.
+func (o *Options) DecoyTraffic(intervalSeconds int64, urls string) {
+	o.add(kindling.WithDecoyTraffic(time.Duration(intervalSeconds)*time.Second, splitList(urls)...))
+}
+
+// FailClosed blocks lookups through the system resolver and plain, direct
+// connections instead of letting them leak.
+func (o *Options) FailClosed() {
+	o.add(kindling.WithFailClosed())
+}
+
+// RequestCoalescing has concurrent identical GETs share one race.
+func (o *Options) RequestCoalescing() {
+	o.add(kindling.WithRequestCoalescing())
+}
+
+// ParallelDownload has Download fetch up to n chunks at once over different
+// transports.
+func (o *Options) ParallelDownload(n int) {
+	o.add(kindling.WithParallelDownload(n))
+}
+
+// Psiphon adds a last-resort Psiphon transport.
+func (o *Options) Psiphon(configJSON []byte) {
+	o.add(kindling.WithPsiphon(configJSON))
+}
+
+// DoHResolver resolves hostnames over DNS-over-HTTPS at serverURL.
+func (o *Options) DoHResolver(serverURL string) {
+	o.add(kindling.WithDoHResolver(serverURL))
+}
+
+// DNSCache sets how long lookups are cached; a zero ttl disables the cache.
+func (o *Options) DNSCache(ttlSeconds, negativeTTLSeconds int64) {
+	o.add(kindling.WithDNSCache(seconds(ttlSeconds), seconds(negativeTTLSeconds)))
+}
+
+// ECH turns Encrypted Client Hello on or off for the smart transport.
+func (o *Options) ECH(enabled bool) {
+	o.add(kindling.WithECH(enabled))
+}
+
+// TLSFingerprint makes the smart transport's TLS handshakes look like a
+// browser's: one of "chrome", "firefox", "safari", "ios", "edge", or
+// "randomized".
+func (o *Options) TLSFingerprint(browser string) {
+	id, ok := fingerprints[strings.ToLower(browser)]
+	if !ok {
+		o.err = errors.Join(o.err, fmt.Errorf("unknown tls fingerprint %q", browser))
+		return
+	}
+	o.add(kindling.WithTLSFingerprint(id))
+}
+
+var fingerprints = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"safari":     utls.HelloSafari_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"edge":       utls.HelloEdge_Auto,
+	"randomized": utls.HelloRandomized,
+}
+
+// PostQuantumTLS offers hybrid post-quantum key exchange first.
+func (o *Options) PostQuantumTLS() {
+	o.add(kindling.WithPostQuantumTLS())
+}
+
+// SocketProtector runs p on every socket kindling opens; see
+// kindling.WithDialerControl for which those are.
+func (o *Options) SocketProtector(p SocketProtector) {
+	if p == nil {
+		o.add(kindling.WithDialerControl(nil))
+		return
+	}
+	o.add(kindling.WithDialerControl(func(network, address string, c syscall.RawConn) error {
+		var protected bool
+		if err := c.Control(func(fd uintptr) { protected = p.Protect(int(fd)) }); err != nil {
+			return err
+		}
+		if !protected {
+			return fmt.Errorf("protecting socket for %s", address)
+		}
+		return nil
+	}))
+}
+
+// AuthProvider sets every request's Authorization header to the value p
+// returns; see kindling.WithAuthProvider.
+func (o *Options) AuthProvider(p AuthProvider) {
+	if p == nil {
+		o.add(kindling.WithAuthProvider(nil))
+		return
+	}
+	o.add(kindling.WithAuthProvider(func(ctx context.Context) (http.Header, error) {
+		v, err := p.Authorization(kindling.AuthRejected(ctx))
+		if err != nil {
+			return nil, err
+		}
+		return http.Header{"Authorization": {v}}, nil
+	}))
+}
+
+// Interface sends kindling's traffic from the network interface name.
+func (o *Options) Interface(name string) {
+	o.add(kindling.WithInterface(name))
+}
+
+// Fwmark marks kindling's sockets with mark (SO_MARK) so they can be routed
+// around the app's own VPN tunnel. It works on Android and needs
+// CAP_NET_ADMIN.
+func (o *Options) Fwmark(mark int64) {
+	if mark < 0 || mark > math.MaxUint32 {
+		o.err = errors.Join(o.err, fmt.Errorf("fwmark %d is out of range", mark))
+		return
+	}
+	o.add(kindling.WithFwmark(uint32(mark)))
+}
+
+// LocalAddr sends kindling's traffic from ip, one of the device's addresses.
+func (o *Options) LocalAddr(ip string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		o.err = errors.Join(o.err, fmt.Errorf("parsing local address: %w", err))
+		return
+	}
+	o.add(kindling.WithLocalAddr(addr))
+}
+
+// SocketOptions tunes the sockets kindling dials: noDelay sets TCP_NODELAY,
+// keepAliveSeconds the TCP keepalive interval (0 for the default, negative
+// for none), and sendBuf and recvBuf the buffer sizes in bytes (0 for the
+// system's).
+func (o *Options) SocketOptions(noDelay bool, keepAliveSeconds int64, sendBuf, recvBuf int) {
+	o.add(kindling.WithSocketOptions(kindling.SocketOptions{
+		TCPNoDelay: &noDelay,
+		KeepAlive:  time.Duration(keepAliveSeconds) * time.Second,
+		SendBuf:    sendBuf,
+		RecvBuf:    recvBuf,
+	}))
+}
+
+// RateLimit holds kindling's bandwidth to bytesPerSec in each direction.
+func (o *Options) RateLimit(bytesPerSec int64) {
+	o.add(kindling.WithRateLimit(bytesPerSec))
+}
+
+// CountryHint applies the strategy for the user's country, an ISO 3166-1
+// alpha-2 code.
+func (o *Options) CountryHint(iso2 string) {
+	o.add(kindling.WithCountryHint(iso2))
+}
+
+// CountryDetection looks the user's country up at geoURL.
+func (o *Options) CountryDetection(geoURL string) {
+	o.add(kindling.WithCountryDetection(geoURL))
+}
+
+// DomainPolicy limits requests for domain and its subdomains to a
+// comma-separated list of transports.
+func (o *Options) DomainPolicy(domain, transports string) {
+	o.add(kindling.WithDomainPolicy(domain, splitList(transports)...))
+}
+
+// HostMapping connects requests for host to target, an IP address or
+// hostname with an optional port, keeping the Host header.
+func (o *Options) HostMapping(host, target string) {
+	o.add(kindling.WithHostMapping(map[string]string{host: target}))
+}
+
+// AllowedHosts limits requests to a comma-separated list of hosts.
+func (o *Options) AllowedHosts(hosts string) {
+	o.add(kindling.WithAllowedHosts(splitList(hosts)...))
+}
+
+// BlockedHosts refuses requests to a comma-separated list of hosts.
+func (o *Options) BlockedHosts(hosts string) {
+	o.add(kindling.WithBlockedHosts(splitList(hosts)...))
+}
+
+// MaxConcurrentDials caps how many transport connects may run at once.
+func (o *Options) MaxConcurrentDials(n int) {
+	o.add(kindling.WithMaxConcurrentDials(n))
+}
+
+// MaxInFlight caps how many requests may race at once, with up to queue
+// more waiting their turn.
+func (o *Options) MaxInFlight(n, queue int) {
+	o.add(kindling.WithMaxInFlight(n, queue))
+}
+
+// MaxDialsPerHost caps how many transport connects to one host may run at
+// once.
+func (o *Options) MaxDialsPerHost(n int) {
+	o.add(kindling.WithMaxDialsPerHost(n))
+}
+
+// ConnectionPool sizes the pool of idle connections kept for reuse. Zero
+// keeps a setting's default.
+func (o *Options) ConnectionPool(maxIdle, maxIdlePerHost int, idleTimeoutSeconds int64) {
+	o.add(kindling.WithConnectionPoolConfig(kindling.ConnectionPoolConfig{
+		MaxIdleConns:        maxIdle,
+		MaxIdleConnsPerHost: maxIdlePerHost,
+		IdleConnTimeout:     time.Duration(idleTimeoutSeconds) * time.Second,
+	}))
+}
+
+// CircuitBreaker turns on the circuit breaker, which is off by default: it
+// sets how many failures in a row quarantine a transport, and for how long
+// at first.
+func (o *Options) CircuitBreaker(failures int, cooldownSeconds int64) {
+	o.add(kindling.WithCircuitBreaker(failures, seconds(cooldownSeconds)))
+}
+
+// HealthCheck probes every transport with url every intervalSeconds.
+func (o *Options) HealthCheck(url string, intervalSeconds int64) {
+	o.add(kindling.WithHealthCheck(url, seconds(intervalSeconds)))
+}
+
+// ResponseCache keeps successful GET responses in dir, up to maxBytes.
+func (o *Options) ResponseCache(dir string, maxBytes int64) {
+	o.add(kindling.WithResponseCache(dir, maxBytes))
+}
+
+// MaxResponseBytes caps response bodies.
+func (o *Options) MaxResponseBytes(n int64) {
+	o.add(kindling.WithMaxResponseBytes(n))
+}
+
+// Compression negotiates compressed responses, with a comma-separated list
+// of algorithms, or all of them if it's empty.
+func (o *Options) Compression(algorithms string) {
+	o.add(kindling.WithCompression(splitList(algorithms)...))
+}
+
+// RequestChunking lets large bodies be sent in chunks over length-limited
+// transports.
+func (o *Options) RequestChunking() {
+	o.add(kindling.WithRequestChunking())
+}
+
+// Strategy sets how the transports in each tier are attempted: "race",
+// "sequential", "weighted", or "adaptive".
+func (o *Options) Strategy(name string) {
+	for _, s := range []kindling.Strategy{kindling.Race, kindling.Sequential, kindling.WeightedRandom, kindling.Adaptive} {
+		if strings.EqualFold(name, s.String()) {
+			o.add(kindling.WithStrategy(s))
+			return
+		}
+	}
+	o.err = errors.Join(o.err, fmt.Errorf("unknown strategy %q", name))
+}
+
+// HeaderProfile sends the request headers of a browser: "chrome",
+// "firefox", or "safari".
+func (o *Options) HeaderProfile(name string) {
+	for _, p := range []kindling.HeaderProfile{kindling.Chrome, kindling.Firefox, kindling.Safari} {
+		if strings.EqualFold(name, p.String()) {
+			o.add(kindling.WithHeaderProfile(p))
+			return
+		}
+	}
+	o.err = errors.Join(o.err, fmt.Errorf("unknown header profile %q", name))
+}
+
+// TransportWeight sets the named transport's weight for the "weighted"
+// strategy.
+func (o *Options) TransportWeight(name string, weight float64) {
+	o.add(kindling.WithTransportWeight(name, weight))
+}
+
+// HeadStart gives the named transport millis milliseconds alone before the
+// others in its tier start connecting.
+func (o *Options) HeadStart(transport string, millis int64) {
+	o.add(kindling.WithHeadStart(transport, time.Duration(millis)*time.Millisecond))
+}
+
+// ConnectTimeout bounds how long the named transport, or every transport
+// without its own if transport is empty, may take to connect.
+func (o *Options) ConnectTimeout(transport string, timeoutSeconds int64) {
+	o.add(kindling.WithConnectTimeout(transport, seconds(timeoutSeconds)))
+}
+
+// DomainFrontingConfigURL adds domain fronting that starts from
+// embeddedFallback, a gzipped fronting config shipped with the app, and
+// keeps it current from configURL.
+func (o *Options) DomainFrontingConfigURL(configURL string, embeddedFallback []byte) {
+	o.add(kindling.WithDomainFrontingConfigURL(configURL, embeddedFallback))
+}
+
+// Kindling is a kindling instance for mobile apps.
+type Kindling struct {
+	k      kindling.Kindling
+	client *http.Client
+}
+
+// New creates a Kindling for appName with opts, which may be nil.
+func New(appName string, opts *Options) (*Kindling, error) {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	if opts.err != nil {
+		return nil, opts.err
+	}
+	var all []kindling.Option
+	if opts.logger != nil {
+		// First, to capture the other options' initialization logs.
+		all = append(all, opts.logger)
+	}
+	k, err := kindling.NewKindling(appName, append(all, opts.opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Kindling{k: k, client: k.NewHTTPClient()}, nil
+}
+
+// Request is an HTTP request for Do.
+type Request struct {
+	Method string
+	URL    string
+	Body   []byte
+	// TimeoutSeconds bounds the whole request, including reading the
+	// response body; 0 means no limit.
+	TimeoutSeconds int64
+	// SNI, if set, is the TLS server name to present in place of the URL's
+	// hostname.
+	SNI string
+
+	header http.Header
+}
+
+// NewRequest returns a request with no headers or body.
+func NewRequest(method, url string) *Request {
+	return &Request{Method: method, URL: url, header: make(http.Header)}
+}
+
+// SetHeader sets a request header, replacing any values it had.
+func (r *Request) SetHeader(name, value string) {
+	if r.header == nil {
+		r.header = make(http.Header)
+	}
+	r.header.Set(name, value)
+}
+
+// Response is the response to a Request, with its body read in full.
+type Response struct {
+	StatusCode int
+	Body       []byte
+
+	header http.Header
+}
+
+// Header returns the first value of a response header, or "" if there is
+// none.
+func (r *Response) Header(name string) string {
+	return r.header.Get(name)
+}
+
+// Transport returns the name of the transport that carried the response, or
+// "" for a stale cached copy.
+func (r *Response) Transport() string {
+	return r.header.Get(kindling.TransportHeader)
+}
+
+// Do sends req through kindling and reads the response.
+func (k *Kindling) Do(req *Request) (*Response, error) {
+	ctx := context.Background()
+	if req.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, seconds(req.TimeoutSeconds))
+		defer cancel()
+	}
+	if req.SNI != "" {
+		ctx = kindling.WithSNI(ctx, req.SNI)
+	}
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		hreq.Header[name] = values
+	}
+	resp, err := k.client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Body: respBody, header: resp.Header}, nil
+}
+
+// Get fetches url.
+func (k *Kindling) Get(url string) (*Response, error) {
+	return k.Do(NewRequest(http.MethodGet, url))
+}
+
+// ListenSOCKS5 starts a SOCKS5 proxy on addr, such as "127.0.0.1:0", that
+// carries connections over kindling's transports, and returns the address it
+// listens on. It stops when the Kindling is closed.
+func (k *Kindling) ListenSOCKS5(addr string) (string, error) {
+	l, err := k.k.ListenSOCKS5(addr)
+	if err != nil {
+		return "", err
+	}
+	return l.Addr().String(), nil
+}
+
+// Prewarm connects to a comma-separated list of hosts ahead of first use.
+func (k *Kindling) Prewarm(hosts string) error {
+	return k.k.Prewarm(context.Background(), splitList(hosts)...)
+}
+
+// Download fetches url into the file at path, resuming on another transport
+// if one dies partway through. A failed download leaves a partial file.
+func (k *Kindling) Download(url, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := k.k.Download(context.Background(), url, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Resolve looks host up through kindling's transports and returns its
+// addresses, comma-separated, IPv4 first.
+func (k *Kindling) Resolve(host string) (string, error) {
+	ips, err := k.k.Resolve(context.Background(), host)
+	if err != nil {
+		return "", err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return strings.Join(addrs, ","), nil
+}
+
+// FlushDNS empties the DNS cache, say after the device changes networks.
+func (k *Kindling) FlushDNS() {
+	k.k.FlushDNS()
+}
+
+// OnNetworkChange flushes the DNS cache, drops pooled connections, and
+// resets the circuit breakers. Call it from the app's connectivity callback
+// when the device switches networks, such as from Wi-Fi to cellular.
+func (k *Kindling) OnNetworkChange() {
+	k.k.OnNetworkChange()
+}
+
+// Diagnostics returns a redacted JSON report of kindling's state, for
+// support tickets.
+func (k *Kindling) Diagnostics() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := k.k.DumpDiagnostics(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Close stops kindling's background work and releases its resources.
+func (k *Kindling) Close() error {
+	return k.k.Close()
+}
+
+// logWriter adapts a Logger to the io.Writer kindling logs to.
+type logWriter struct {
+	l Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.l.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func seconds(n int64) time.Duration {
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,140 @@
+package mobile
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/kindling"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// directTransport sends requests straight to their origin.
+type directTransport struct{}
+
+func (directTransport) Name() string                  { return "direct" }
+func (directTransport) MaxLength() int                { return 0 }
+func (directTransport) IsStreamable() bool            { return true }
+func (directTransport) RequestTimeout() time.Duration { return 0 }
+func (directTransport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
+	return http.DefaultTransport, nil
+}
+
+type lines struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *lines) Log(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func TestSplitList(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{"a.com", "b.com"}, splitList(" a.com, ,b.com,"))
+	assert.Nil(t, splitList(""))
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+		opts := NewOptions()
+		opts.TLSFingerprint("netscape")
+		_, err := New("test", opts)
+		assert.ErrorContains(t, err, "netscape")
+
+		opts = NewOptions()
+		opts.CountryHint("Iran")
+		_, err = New("test", opts)
+		assert.Error(t, err)
+
+		opts = NewOptions()
+		opts.SocketProtector(nil)
+		_, err = New("test", opts)
+		assert.Error(t, err)
+
+		opts = NewOptions()
+		opts.LocalAddr("10.0.0")
+		_, err = New("test", opts)
+		assert.ErrorContains(t, err, "local address")
+
+		opts = NewOptions()
+		opts.LogLevel("chatty")
+		_, err = New("test", opts)
+		assert.ErrorContains(t, err, "log level")
+
+		opts = NewOptions()
+		opts.Strategy("roulette")
+		_, err = New("test", opts)
+		assert.ErrorContains(t, err, "roulette")
+	})
+
+	t.Run("Do", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		}))
+		t.Cleanup(srv.Close)
+
+		log := &lines{}
+		opts := NewOptions()
+		opts.SetLogger(log)
+		opts.TLSFingerprint("Chrome")
+		opts.Strategy("Sequential")
+		opts.add(kindling.WithTransport(directTransport{}))
+		k, err := New("test", opts)
+		require.NoError(t, err)
+		defer k.Close()
+
+		req := NewRequest(http.MethodPost, srv.URL)
+		req.SetHeader("X-Test", "hello")
+		req.Body = []byte("body")
+		req.TimeoutSeconds = 10
+		resp, err := k.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "body", string(resp.Body))
+		assert.Equal(t, "hello", resp.Header("X-Echo"))
+
+		resp, err = k.Get(srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		log.mu.Lock()
+		assert.NotEmpty(t, log.lines, "logs go to the logger")
+		for _, line := range log.lines {
+			assert.False(t, strings.HasSuffix(line, "\n"))
+		}
+		log.mu.Unlock()
+	})
+
+	t.Run("ListenSOCKS5", func(t *testing.T) {
+		t.Parallel()
+		opts := NewOptions()
+		opts.add(kindling.WithTransport(directTransport{}))
+		k, err := New("test", opts)
+		require.NoError(t, err)
+		addr, err := k.ListenSOCKS5("127.0.0.1:0")
+		require.NoError(t, err)
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		conn.Close()
+		require.NoError(t, k.Close())
+		_, err = net.Dial("tcp", addr)
+		assert.Error(t, err, "the proxy stops with Close")
+	})
+}
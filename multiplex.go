@@ -0,0 +1,140 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/xtaci/smux"
+)
+
+// multiplexable lists the transports WithMultiplexing can apply to: those
+// that open an expensive tunnel per connection. DNS tunnels need no help, as
+// the dnstt client already runs its streams over one session.
+var multiplexable = []TransportName{TransportWebTunnel, TransportWebRTC}
+
+// WithMultiplexing runs the named transports' connections as smux streams
+// over one established tunnel, instead of setting up a fresh tunnel (a TLS
+// handshake and WebSocket upgrade for WebTunnel, a broker round trip and ICE
+// for WebRTC) for every race attempt. With no names it applies to every
+// transport that supports it. A tunnel that fails is replaced on the next
+// connection.
+//
+// The server must speak smux (github.com/xtaci/smux with its default
+// configuration) on the tunnel and treat each stream as it would an
+// unmultiplexed tunnel, handing it to its SOCKS5 proxy.
+func WithMultiplexing(names ...TransportName) Option {
+	return func(k *kindling) error {
+		if len(names) == 0 {
+			names = multiplexable
+		}
+		for _, name := range names {
+			if !slices.Contains(multiplexable, name) {
+				return fmt.Errorf("transport %q does not support multiplexing", name)
+			}
+			if k.multiplexed == nil {
+				k.multiplexed = make(map[TransportName]bool)
+			}
+			k.multiplexed[name] = true
+		}
+		return nil
+	}
+}
+
+// muxEndpoint is a transport.StreamEndpoint whose streams share one smux
+// session over a connection from endpoint, if WithMultiplexing asked for it
+// for the named transport. Otherwise each stream is a connection of its own.
+type muxEndpoint struct {
+	k        *kindling
+	name     TransportName
+	endpoint transport.StreamEndpoint
+
+	mu      sync.Mutex
+	session *smux.Session
+	// dialing is closed once an in-progress session dial finishes.
+	dialing chan struct{}
+}
+
+var _ transport.StreamEndpoint = (*muxEndpoint)(nil)
+
+// multiplexedEndpoint wraps endpoint, the tunnel endpoint of the transport
+// called name, for WithMultiplexing. Whether to multiplex is decided at
+// connect time, so the option may come before or after the transport's.
+func (k *kindling) multiplexedEndpoint(name TransportName, endpoint transport.StreamEndpoint) transport.StreamEndpoint {
+	return &muxEndpoint{k: k, name: name, endpoint: endpoint}
+}
+
+func (e *muxEndpoint) ConnectStream(ctx context.Context) (transport.StreamConn, error) {
+	if !e.k.multiplexed[e.name] {
+		return e.endpoint.ConnectStream(ctx)
+	}
+	session, err := e.sessionFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := session.OpenStream()
+	if err != nil {
+		// The tunnel died since it was last used; start another.
+		session.Close()
+		if session, err = e.sessionFor(ctx); err != nil {
+			return nil, err
+		}
+		if stream, err = session.OpenStream(); err != nil {
+			return nil, fmt.Errorf("opening %s stream: %w", e.name, err)
+		}
+	}
+	return asStreamConn(stream), nil
+}
+
+// sessionFor returns the live session, dialing a new one if there is none.
+// Concurrent callers wait for a single dial rather than each starting one.
+func (e *muxEndpoint) sessionFor(ctx context.Context) (*smux.Session, error) {
+	for {
+		e.mu.Lock()
+		if e.session != nil && !e.session.IsClosed() {
+			session := e.session
+			e.mu.Unlock()
+			return session, nil
+		}
+		if dialing := e.dialing; dialing != nil {
+			e.mu.Unlock()
+			select {
+			case <-dialing:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		dialing := make(chan struct{})
+		e.dialing = dialing
+		e.mu.Unlock()
+
+		session, err := e.dial(ctx)
+		e.mu.Lock()
+		e.dialing = nil
+		if err == nil {
+			e.session = session
+		}
+		e.mu.Unlock()
+		close(dialing)
+		return session, err
+	}
+}
+
+func (e *muxEndpoint) dial(ctx context.Context) (*smux.Session, error) {
+	conn, err := e.endpoint.ConnectStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := smux.Client(conn, smuxConfig(e.k.keepAlive))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("starting %s session: %w", e.name, err)
+	}
+	if err := e.k.onClose(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
@@ -0,0 +1,169 @@
+package kindling
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xtaci/smux"
+)
+
+// serveSmux runs an smux server on conn, handing each stream to handle.
+func serveSmux(conn net.Conn, handle func(net.Conn)) {
+	session, err := smux.Server(conn, smux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer session.Close()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go handle(stream)
+	}
+}
+
+func TestWithMultiplexing(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithMultiplexing(TransportDNSTunnel)(&kindling{}))
+
+	k := &kindling{}
+	require.NoError(t, WithMultiplexing(TransportWebTunnel)(k))
+	assert.Equal(t, map[TransportName]bool{TransportWebTunnel: true}, k.multiplexed)
+
+	k = &kindling{}
+	require.NoError(t, WithMultiplexing()(k))
+	assert.Equal(t, map[TransportName]bool{TransportWebTunnel: true, TransportWebRTC: true}, k.multiplexed)
+}
+
+func TestMuxEndpoint(t *testing.T) {
+	t.Parallel()
+
+	// newTunnels returns an endpoint whose connections reach an smux echo
+	// server, and the server-side ends of them.
+	newTunnels := func(t *testing.T) (transport.StreamEndpoint, chan net.Conn) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		accepted := make(chan net.Conn, 10)
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+				go serveSmux(conn, func(stream net.Conn) {
+					defer stream.Close()
+					io.Copy(stream, stream)
+				})
+			}
+		}()
+		return &transport.StreamDialerEndpoint{Dialer: &transport.TCPDialer{}, Address: ln.Addr().String()}, accepted
+	}
+	newEndpoint := func(t *testing.T, multiplexed bool) (transport.StreamEndpoint, chan net.Conn) {
+		endpoint, accepted := newTunnels(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		k := &kindling{ctx: ctx, cancel: cancel}
+		if multiplexed {
+			require.NoError(t, WithMultiplexing(TransportWebTunnel)(k))
+		}
+		t.Cleanup(func() { k.Close() })
+		return k.multiplexedEndpoint(TransportWebTunnel, endpoint), accepted
+	}
+	echo := func(t *testing.T, conn transport.StreamConn, msg string) {
+		t.Helper()
+		_, err := io.WriteString(conn, msg)
+		require.NoError(t, err)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(buf))
+	}
+
+	t.Run("SharesOneTunnel", func(t *testing.T) {
+		t.Parallel()
+		endpoint, accepted := newEndpoint(t, true)
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Go(func() {
+				conn, err := endpoint.ConnectStream(context.Background())
+				require.NoError(t, err)
+				defer conn.Close()
+				echo(t, conn, "hello")
+			})
+		}
+		wg.Wait()
+		assert.Len(t, accepted, 1)
+	})
+
+	t.Run("ReplacesDeadTunnel", func(t *testing.T) {
+		t.Parallel()
+		endpoint, accepted := newEndpoint(t, true)
+		conn, err := endpoint.ConnectStream(context.Background())
+		require.NoError(t, err)
+		echo(t, conn, "first")
+		(<-accepted).Close()
+		_, err = conn.Read(make([]byte, 1))
+		require.Error(t, err)
+
+		conn, err = endpoint.ConnectStream(context.Background())
+		require.NoError(t, err)
+		defer conn.Close()
+		echo(t, conn, "second")
+		assert.Len(t, accepted, 1)
+	})
+
+	t.Run("NotMultiplexed", func(t *testing.T) {
+		t.Parallel()
+		endpoint, accepted := newEndpoint(t, false)
+		for range 3 {
+			conn, err := endpoint.ConnectStream(context.Background())
+			require.NoError(t, err)
+			conn.Close()
+		}
+		assert.Eventually(t, func() bool { return len(accepted) == 3 }, 2*time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestMultiplexedWebTunnel(t *testing.T) {
+	t.Parallel()
+
+	var upgrades atomic.Int32
+	srv := newWebTunnelServerWith(t, "/secret", func(conn net.Conn) {
+		upgrades.Add(1)
+		serveSmux(conn, handleTestSOCKS5)
+	})
+	pin := base64.StdEncoding.EncodeToString(srv.Certificate().RawSubjectPublicKeyInfo)
+	k, err := NewKindling("test", WithWebTunnel(srv.URL, "/secret", pin), WithMultiplexing())
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+
+	// Separate origins so each request needs a connection of its own.
+	for range 3 {
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "through the tunnel")
+		}))
+		t.Cleanup(origin.Close)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "through the tunnel", string(body))
+	}
+	assert.EqualValues(t, 1, upgrades.Load())
+}
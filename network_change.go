@@ -0,0 +1,56 @@
+package kindling
+
+import (
+	"context"
+	"time"
+)
+
+// OnNetworkChange resets what kindling learned about the network it was on:
+// it flushes the DNS and ECH caches, closes idle pooled connections, closes
+// every circuit breaker, and runs WithHealthCheck probes right away rather
+// than at their next interval. Mobile apps call it from their connectivity
+// callbacks, such as when the device moves from Wi-Fi to cellular, since
+// addresses, blocking, and broken connections on the old network say
+// little about the new one. Requests in flight are left alone.
+func (k *kindling) OnNetworkChange() {
+	k.FlushDNS()
+	k.pool.drain()
+	k.breaker.reset()
+
+	k.mu.Lock()
+	if k.netChanged != nil {
+		close(k.netChanged)
+		k.netChanged = nil
+	}
+	k.mu.Unlock()
+	k.log.Info("Network changed, reset caches and circuit breakers")
+}
+
+// networkChanged returns a channel that is closed by the next
+// OnNetworkChange.
+func (k *kindling) networkChanged() <-chan struct{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.netChanged == nil {
+		k.netChanged = make(chan struct{})
+	}
+	return k.netChanged
+}
+
+// sleepUnlessChanged is sleepContext, cut short when changed is closed. It
+// reports false once ctx is done.
+func (k *kindling) sleepUnlessChanged(ctx context.Context, changed <-chan struct{}, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := k.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-changed:
+		return ctx.Err() == nil
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,78 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnNetworkChange(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ResetsState", func(t *testing.T) {
+		t.Parallel()
+		ki, err := NewKindling("test", WithLogWriter(io.Discard), WithCircuitBreaker(1, time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { ki.Close() })
+		k := ki.(*kindling)
+
+		k.dnsCache.put("a.test", []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil)
+		key := poolKey{name: "a", addr: "example.com:443"}
+		k.pool.put(key, &http.Transport{})
+		k.breaker.failure("a")
+		_, quarantined := k.breaker.quarantinedUntil("a")
+		require.True(t, quarantined)
+
+		ki.OnNetworkChange()
+		_, _, cached := k.dnsCache.get("a.test")
+		assert.False(t, cached, "dns cache flushed")
+		_, pooled := k.pool.get(key)
+		assert.False(t, pooled, "idle connections dropped")
+		_, quarantined = k.breaker.quarantinedUntil("a")
+		assert.False(t, quarantined, "breaker reset")
+
+		k.pool.put(key, &http.Transport{})
+		_, pooled = k.pool.get(key)
+		assert.True(t, pooled, "the pool stays open")
+	})
+
+	t.Run("RerunsHealthCheck", func(t *testing.T) {
+		t.Parallel()
+		var heads atomic.Int32
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			heads.Add(1)
+		}))
+		t.Cleanup(origin.Close)
+		direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		}}
+		k, err := NewKindling("test",
+			WithLogWriter(io.Discard),
+			WithTransport(direct),
+			WithHealthCheck(origin.URL, time.Hour),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		require.Eventually(t, func() bool { return heads.Load() == 1 }, 5*time.Second, 5*time.Millisecond)
+
+		k.OnNetworkChange()
+		require.Eventually(t, func() bool { return heads.Load() == 2 }, 5*time.Second, 5*time.Millisecond,
+			"a network change probes without waiting out the interval")
+	})
+
+	t.Run("AfterClose", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithCircuitBreaker(0, 0))
+		require.NoError(t, err)
+		require.NoError(t, k.Close())
+		assert.NotPanics(t, k.OnNetworkChange)
+	})
+}
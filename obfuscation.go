@@ -0,0 +1,104 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// paddingHeader carries WithObfuscation's request padding. Inside TLS its
+// name is as hidden as its value.
+const paddingHeader = "X-Padding"
+
+// paddingAlphabet is the characters padding is drawn from. HPACK's Huffman
+// code makes none of them shorter, so padding keeps its length over HTTP/2.
+const paddingAlphabet = "!#$()+<>?@[]^`{}"
+
+// WithObfuscation blurs the lengths and timing that classifiers use to
+// pick out tunneled traffic. Every request carries a header of between 0 and
+// padding random bytes, so small requests such as config fetches don't all
+// have the same telltale size; responses are the origin's to pad. On the
+// transports that tunnel streams, such as Shadowsocks, WebTunnel, and
+// WireGuard, every write to the tunnel waits a random delay of up to jitter,
+// which breaks up the timing of request/response exchanges at the cost of
+// that much added latency per write. Zero turns either off.
+func WithObfuscation(padding int, jitter time.Duration) Option {
+	return func(k *kindling) error {
+		if padding < 0 {
+			return fmt.Errorf("padding must not be negative, got %d", padding)
+		}
+		if jitter < 0 {
+			return fmt.Errorf("jitter must not be negative, got %v", jitter)
+		}
+		k.padding = padding
+		k.jitter = jitter
+		return nil
+	}
+}
+
+// applyObfuscation adds WithObfuscation's jitter to the stream transports
+// kindling built.
+func (k *kindling) applyObfuscation() {
+	if k.jitter == 0 {
+		return
+	}
+	for _, t := range k.transports {
+		if nt, ok := t.(*namedTransport); ok && nt.dialer != nil {
+			nt.dialer = &jitterDialer{StreamDialer: nt.dialer, jitter: k.jitter}
+		}
+	}
+}
+
+// withPadding wraps rt to pad requests when WithObfuscation asks for it.
+func (t *raceTransport) withPadding(rt http.RoundTripper) http.RoundTripper {
+	if t.padding == 0 {
+		return rt
+	}
+	return &paddingRoundTripper{rt: rt, max: t.padding}
+}
+
+type paddingRoundTripper struct {
+	rt  http.RoundTripper
+	max int
+}
+
+// RoundTrip adds a random amount of padding to req's headers.
+func (p *paddingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	pad := make([]byte, rand.IntN(p.max+1))
+	for i := range pad {
+		pad[i] = paddingAlphabet[rand.IntN(len(paddingAlphabet))]
+	}
+	req.Header.Set(paddingHeader, string(pad))
+	return p.rt.RoundTrip(req)
+}
+
+// jitterDialer delays every write on the connections it dials by up to
+// jitter.
+type jitterDialer struct {
+	transport.StreamDialer
+	jitter time.Duration
+}
+
+func (d *jitterDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	conn, err := d.StreamDialer.DialStream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &jitterConn{StreamConn: conn, delay: func() time.Duration {
+		return rand.N(d.jitter)
+	}}, nil
+}
+
+type jitterConn struct {
+	transport.StreamConn
+	delay func() time.Duration
+}
+
+func (c *jitterConn) Write(b []byte) (int, error) {
+	time.Sleep(c.delay())
+	return c.StreamConn.Write(b)
+}
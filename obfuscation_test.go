@@ -0,0 +1,119 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithObfuscation(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithObfuscation(-1, 0)(&kindling{}))
+	assert.Error(t, WithObfuscation(0, -time.Millisecond)(&kindling{}))
+
+	k := &kindling{transports: []Transport{
+		newStreamTransport("direct", &transport.TCPDialer{}),
+		&namedTransport{name: "fronted"},
+	}}
+	require.NoError(t, WithObfuscation(64, 5*time.Millisecond)(k))
+	k.applyObfuscation()
+	assert.IsType(t, &jitterDialer{}, k.transports[0].(*namedTransport).dialer)
+	assert.Nil(t, k.transports[1].(*namedTransport).dialer)
+}
+
+func TestPadding(t *testing.T) {
+	t.Parallel()
+
+	// send returns the padding header of each of n requests.
+	send := func(t *testing.T, n int, opts ...Option) []string {
+		t.Helper()
+		var pads []string
+		tr := &mockTransport{name: "mock", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				pads = append(pads, req.Header.Get(paddingHeader))
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}), nil
+		}}
+		k, err := NewKindling("test", append([]Option{WithTransport(tr), WithLogWriter(io.Discard)}, opts...)...)
+		require.NoError(t, err)
+		client := k.NewHTTPClient()
+		for range n {
+			resp, err := client.Get("http://example.com/")
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		return pads
+	}
+
+	t.Run("Padded", func(t *testing.T) {
+		t.Parallel()
+		lengths := make(map[int]bool)
+		for _, pad := range send(t, 20, WithObfuscation(32, 0)) {
+			assert.LessOrEqual(t, len(pad), 32)
+			assert.Empty(t, strings.Trim(pad, paddingAlphabet))
+			lengths[len(pad)] = true
+		}
+		assert.Greater(t, len(lengths), 1, "padding lengths vary")
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		t.Parallel()
+		for _, pad := range send(t, 3) {
+			assert.Empty(t, pad)
+		}
+	})
+}
+
+func TestJitter(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	var delays int
+	conn := &jitterConn{StreamConn: asStreamConn(client), delay: func() time.Duration {
+		delays++
+		return 20 * time.Millisecond
+	}}
+	go io.Copy(io.Discard, server)
+
+	start := time.Now()
+	for range 3 {
+		_, err := conn.Write([]byte("packet"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, delays)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	t.Run("Dialer", func(t *testing.T) {
+		t.Parallel()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				io.Copy(conn, conn)
+				conn.Close()
+			}
+		}()
+		d := &jitterDialer{StreamDialer: &transport.TCPDialer{}, jitter: time.Millisecond}
+		conn, err := d.DialStream(context.Background(), ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, "echo")
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "echo", string(buf))
+	})
+}
@@ -0,0 +1,222 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// WithParallelDownload has Download fetch up to n chunks of a file at once
+// and reassemble them in order, each worker sticking to a transport the
+// others aren't using where it can. When every transport is slow, as under
+// heavy throttling or on DNS tunneling, their throughput adds up. Chunks
+// that arrive ahead of their turn are held in memory, up to 2n MiB. n of 1,
+// the default, downloads a chunk at a time.
+func WithParallelDownload(n int) Option {
+	return func(k *kindling) error {
+		if n <= 0 {
+			return fmt.Errorf("parallel downloads must be positive, got %d", n)
+		}
+		k.downloadWorkers = n
+		return nil
+	}
+}
+
+// chunkResult is a fetched chunk, or why it couldn't be fetched.
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// parallel fetches the rest of the file, from d.offset to d.size, with n
+// workers and writes it out in order.
+func (d *download) parallel(ctx context.Context, n int, log *slog.Logger) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	start := d.offset
+	count := int((d.size - start + downloadChunkSize - 1) / downloadChunkSize)
+	results := make([]chan chunkResult, count)
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+	// window holds a slot for every chunk being fetched or waiting its turn
+	// to be written, which bounds the memory held by early chunks.
+	window := make(chan struct{}, 2*n)
+	next := make(chan int)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(next)
+		for i := range count {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	claims := &transportClaims{claimed: make(map[string]int)}
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := &chunkWorker{d: d, claims: claims, log: log}
+			defer w.release()
+			for i := range next {
+				from := start + int64(i)*downloadChunkSize
+				to := min(from+downloadChunkSize, d.size) - 1
+				data, err := w.fetch(ctx, from, to)
+				results[i] <- chunkResult{data: data, err: err}
+			}
+		}()
+	}
+
+	for i := range count {
+		var r chunkResult
+		select {
+		case r = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if _, err := d.w.Write(r.data); err != nil {
+			return err
+		}
+		d.offset += int64(len(r.data))
+		<-window
+	}
+	return nil
+}
+
+// transportClaims counts the workers of a parallel download using each
+// transport.
+type transportClaims struct {
+	mu      sync.Mutex
+	claimed map[string]int
+}
+
+// chunkWorker fetches chunks for a parallel download, sticking to the
+// transport that last delivered one.
+type chunkWorker struct {
+	d      *download
+	claims *transportClaims
+	log    *slog.Logger
+	// transport is the transport the worker has claimed, if any.
+	transport string
+}
+
+// fetch returns bytes from to to of the file, inclusive, resuming where a
+// transport dies mid-body.
+func (w *chunkWorker) fetch(ctx context.Context, from, to int64) ([]byte, error) {
+	data := make([]byte, 0, to-from+1)
+	failures := 0
+	for {
+		reqCtx := ctx
+		if w.transport != "" {
+			reqCtx = WithTransportHint(ctx, w.transport)
+		} else if others := w.others(); len(others) > 0 && failures == 0 {
+			// Left to race freely after a failure, in case the other
+			// workers' transports are the only ones that work.
+			reqCtx = ExcludeTransports(ctx, others...)
+		}
+		before := len(data)
+		name, retry, err := w.get(reqCtx, from+int64(len(data)), to, &data)
+		if err == nil {
+			w.claim(name)
+			return data, nil
+		}
+		w.release()
+		if !retry || ctx.Err() != nil {
+			return nil, err
+		}
+		if len(data) > before {
+			failures = 0
+		}
+		failures++
+		if failures >= maxDownloadFailures {
+			return nil, fmt.Errorf("giving up on bytes %d-%d after %d failures: %w", from, to, failures, err)
+		}
+		w.log.Debug("Download chunk interrupted, resuming", "offset", from+int64(len(data)), "name", name, "error", err)
+	}
+}
+
+// get appends bytes start to end of the file to data. It returns the
+// transport that carried them and whether a failure is worth retrying.
+func (w *chunkWorker) get(ctx context.Context, start, end int64, data *[]byte) (name string, retry bool, err error) {
+	resp, err := w.d.get(ctx, start, end)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	name = TransportFromResponse(resp)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The first chunk came back partial, so a whole file means it
+		// changed since.
+		return name, false, errDownloadChanged
+	default:
+		return name, resp.StatusCode >= 500, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if first, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || first != start {
+		return name, false, fmt.Errorf("asked for bytes from %d, got %q", start, resp.Header.Get("Content-Range"))
+	}
+	buf := bytes.NewBuffer(*data)
+	n, err := io.Copy(buf, io.LimitReader(resp.Body, end-start+1))
+	*data = buf.Bytes()
+	if err == nil && n < end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	return name, err != nil, err
+}
+
+// claim records that the worker is using the named transport.
+func (w *chunkWorker) claim(name string) {
+	w.release()
+	if name == "" {
+		return
+	}
+	w.claims.mu.Lock()
+	defer w.claims.mu.Unlock()
+	w.claims.claimed[name]++
+	w.transport = name
+}
+
+// release gives up the worker's claim, if it has one.
+func (w *chunkWorker) release() {
+	if w.transport == "" {
+		return
+	}
+	w.claims.mu.Lock()
+	defer w.claims.mu.Unlock()
+	w.claims.claimed[w.transport]--
+	if w.claims.claimed[w.transport] <= 0 {
+		delete(w.claims.claimed, w.transport)
+	}
+	w.transport = ""
+}
+
+// others returns the transports other workers have claimed.
+func (w *chunkWorker) others() []string {
+	w.claims.mu.Lock()
+	defer w.claims.mu.Unlock()
+	var names []string
+	for name := range w.claims.claimed {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,174 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelDownload(t *testing.T) {
+	t.Parallel()
+
+	file := make([]byte, 4*downloadChunkSize+99)
+	for i := range file {
+		file[i] = byte(rand.IntN(256))
+	}
+	// chunk returns the index of the chunk a Range request starts at.
+	chunk := func(r *http.Request) int {
+		var start int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+		return start / downloadChunkSize
+	}
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v1"`)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(file))
+	}
+	// serveRange answers with bytes from to to of file, inclusive,
+	// whatever was asked for.
+	serveRange := func(w http.ResponseWriter, from, to int) {
+		w.Header().Set("Etag", `"v1"`)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", from, to, len(file)))
+		w.Header().Set("Content-Length", strconv.Itoa(to-from+1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(file[from : to+1])
+	}
+	// download fetches file from an origin served by handler, with three
+	// workers, and returns what was written and how many requests were
+	// made.
+	download := func(t *testing.T, handler http.HandlerFunc) ([]byte, int32, error) {
+		var requests atomic.Int32
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			handler(w, r)
+		}))
+		t.Cleanup(origin.Close)
+		direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		}}
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithTransport(direct), WithParallelDownload(3))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		var buf bytes.Buffer
+		err = k.Download(context.Background(), origin.URL, &buf)
+		return buf.Bytes(), requests.Load(), err
+	}
+
+	t.Run("ServerIgnoresRange", func(t *testing.T) {
+		t.Parallel()
+		got, requests, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(file)
+		})
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(file, got), "got %d bytes, want %d", len(got), len(file))
+		assert.EqualValues(t, 1, requests, "the whole file came in the first response")
+	})
+
+	t.Run("WholeFileMidway", func(t *testing.T) {
+		t.Parallel()
+		_, _, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			if chunk(r) > 0 {
+				w.Header().Set("Etag", `"v1"`)
+				w.Write(file)
+				return
+			}
+			serve(w, r)
+		})
+		assert.ErrorIs(t, err, errDownloadChanged)
+	})
+
+	t.Run("MismatchedContentRange", func(t *testing.T) {
+		t.Parallel()
+		got, _, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			if chunk(r) == 2 {
+				serveRange(w, 0, 99)
+				return
+			}
+			serve(w, r)
+		})
+		assert.ErrorContains(t, err, fmt.Sprintf("asked for bytes from %d", 2*downloadChunkSize))
+		assert.True(t, bytes.Equal(file[:2*downloadChunkSize], got), "chunks before the bad one are written")
+	})
+
+	t.Run("ShortContentRange", func(t *testing.T) {
+		t.Parallel()
+		const most = 300 << 10
+		got, requests, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			var start, end int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+			if start == 0 {
+				serve(w, r)
+				return
+			}
+			serveRange(w, start, min(end, start+most-1))
+		})
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(file, got), "got %d bytes, want %d", len(got), len(file))
+		assert.Greater(t, requests, int32(5), "short chunks are resumed")
+	})
+
+	t.Run("FailedChunkRetried", func(t *testing.T) {
+		t.Parallel()
+		var failed atomic.Bool
+		got, requests, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			if chunk(r) == 2 && failed.CompareAndSwap(false, true) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			serve(w, r)
+		})
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(file, got), "got %d bytes, want %d", len(got), len(file))
+		assert.EqualValues(t, 6, requests, "five chunks and one retry")
+	})
+
+	t.Run("FailedChunkAborts", func(t *testing.T) {
+		t.Parallel()
+		var chunk2 atomic.Int32
+		got, _, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			if chunk(r) == 2 {
+				chunk2.Add(1)
+				http.NotFound(w, r)
+				return
+			}
+			serve(w, r)
+		})
+		assert.ErrorContains(t, err, "404")
+		assert.EqualValues(t, 1, chunk2.Load(), "a 404 isn't retried")
+		assert.True(t, bytes.Equal(file[:2*downloadChunkSize], got), "chunks before the failed one are written")
+	})
+
+	t.Run("ReassemblesInOrder", func(t *testing.T) {
+		t.Parallel()
+		// Chunk 1 is held back until chunk 3 has been served, so later
+		// chunks arrive first.
+		later := make(chan struct{})
+		var served sync.Once
+		got, _, err := download(t, func(w http.ResponseWriter, r *http.Request) {
+			switch chunk(r) {
+			case 1:
+				select {
+				case <-later:
+				case <-time.After(5 * time.Second):
+					t.Error("chunk 3 wasn't fetched alongside chunk 1")
+				}
+			case 3:
+				defer served.Do(func() { close(later) })
+			}
+			serve(w, r)
+		})
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(file, got), "got %d bytes, want %d", len(got), len(file))
+	})
+}
@@ -0,0 +1,338 @@
+package kindling
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+// WithPluggableTransport launches binary, an external Pluggable Transports
+// client such as obfs4proxy or snowflake-client, with args, and adds a
+// transport that reaches origins through the bridge described by
+// bridgeLine, in torrc format with or without the "Bridge" keyword:
+//
+//	obfs4 192.0.2.1:443 4D6C0F8F6E1C... cert=AAAA... iat-mode=0
+//
+// The transport is named after the bridge's method, "obfs4" above. kindling
+// speaks the managed-proxy side of the PT spec (version 1, used by PT 2.x
+// and by the IPC of PT 3.0): it asks the client for that one method,
+// connects through the SOCKS5 port it reports and passes the bridge's
+// arguments in the SOCKS handshake. The bridge must forward its tunnel to a
+// SOCKS5 proxy, which kindling then asks for each origin, so a Tor bridge,
+// whose tunnel leads to a Tor relay, can't be used this way; use WithTor
+// with such bridges instead.
+//
+// As with WithTor, race attempts made before the client has reported its
+// SOCKS port wait for it. The client is stopped by Close, and exits on its
+// own if this process dies. Its sockets are its own, so WithDialerControl
+// and WithInterface don't reach them.
+func WithPluggableTransport(binary string, args []string, bridgeLine string) Option {
+	return func(k *kindling) error {
+		if binary == "" {
+			return fmt.Errorf("pluggable transport binary is empty")
+		}
+		bridge, err := parseBridgeLine(bridgeLine)
+		if err != nil {
+			return err
+		}
+		args := append([]string(nil), args...)
+		k.deferred = append(k.deferred, func() error {
+			d, err := launchPluggableTransport(k.log, binary, args, bridge)
+			if err != nil {
+				return fmt.Errorf("starting pluggable transport %s: %w", bridge.method, err)
+			}
+			if err := k.onClose(d); err != nil {
+				return err
+			}
+			k.transports = append(k.transports, newStreamTransport(bridge.method, d))
+			return nil
+		})
+		return nil
+	}
+}
+
+// NewPluggableTransport launches binary for bridgeLine as
+// WithPluggableTransport does and returns the transport, for adding to a
+// running instance with AddTransport. The transport is an io.Closer that
+// stops the client; kindling doesn't close it, so the caller must.
+func NewPluggableTransport(binary string, args []string, bridgeLine string) (Transport, error) {
+	if binary == "" {
+		return nil, fmt.Errorf("pluggable transport binary is empty")
+	}
+	bridge, err := parseBridgeLine(bridgeLine)
+	if err != nil {
+		return nil, err
+	}
+	d, err := launchPluggableTransport(slog.New(slog.DiscardHandler), binary, args, bridge)
+	if err != nil {
+		return nil, fmt.Errorf("starting pluggable transport %s: %w", bridge.method, err)
+	}
+	return &pluggableTransport{namedTransport: newStreamTransport(bridge.method, d), client: d}, nil
+}
+
+// pluggableTransport is the transport of a PT client launched by
+// NewPluggableTransport.
+type pluggableTransport struct {
+	*namedTransport
+	client *ptDialer
+}
+
+func (t *pluggableTransport) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	return t.client.DialStream(ctx, addr)
+}
+
+// Close stops the client.
+func (t *pluggableTransport) Close() error { return t.client.Close() }
+
+// bridge is a parsed bridge line.
+type bridge struct {
+	method string
+	addr   string
+	// args are the bridge's key=value arguments, in order.
+	args [][2]string
+}
+
+// parseBridgeLine parses "method addr [fingerprint] [key=value ...]". A
+// leading "Bridge" keyword, as in a torrc, is allowed. The fingerprint
+// identifies a Tor relay and isn't needed by the transport, so it's dropped.
+func parseBridgeLine(line string) (bridge, error) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && strings.EqualFold(fields[0], "Bridge") {
+		fields = fields[1:]
+	}
+	if len(fields) < 2 {
+		return bridge{}, fmt.Errorf("bridge line %q needs a method and an address", line)
+	}
+	b := bridge{method: fields[0], addr: fields[1]}
+	if _, _, err := net.SplitHostPort(b.addr); err != nil {
+		return bridge{}, fmt.Errorf("bridge address %q: %w", b.addr, err)
+	}
+	rest := fields[2:]
+	if len(rest) > 0 && !strings.Contains(rest[0], "=") {
+		rest = rest[1:]
+	}
+	for _, f := range rest {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || key == "" {
+			return bridge{}, fmt.Errorf("bridge argument %q isn't key=value", f)
+		}
+		b.args = append(b.args, [2]string{key, value})
+	}
+	return b, nil
+}
+
+// socksCredentials encodes the bridge's arguments as the SOCKS5 username
+// and password the PT spec uses to pass them to the client: key=value pairs
+// joined by ";", with "=", ";" and "\" escaped. Up to 255 bytes go in the
+// username, with a password of a single NUL; longer ones spill into the
+// password. It returns nil credentials for a bridge without arguments.
+func (b bridge) socksCredentials() (user, pass []byte, err error) {
+	if len(b.args) == 0 {
+		return nil, nil, nil
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `=`, `\=`)
+	pairs := make([]string, len(b.args))
+	for i, kv := range b.args {
+		pairs[i] = escape.Replace(kv[0]) + "=" + escape.Replace(kv[1])
+	}
+	encoded := []byte(strings.Join(pairs, ";"))
+	switch {
+	case len(encoded) <= 255:
+		return encoded, []byte{0}, nil
+	case len(encoded) <= 510:
+		return encoded[:255], encoded[255:], nil
+	default:
+		return nil, nil, fmt.Errorf("bridge arguments are %d bytes, more than SOCKS5 can carry", len(encoded))
+	}
+}
+
+// ptDialer dials through a launched PT client once it has reported its
+// SOCKS port.
+type ptDialer struct {
+	bridge bridge
+	// ready is closed once the client reports its SOCKS port, after which
+	// dialer is set. failed is closed if the client couldn't provide the
+	// method or exited; err then holds the reason.
+	ready  chan struct{}
+	failed chan struct{}
+	err    error
+	dialer transport.StreamDialer
+
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   io.ReadCloser
+	stateDir string
+	// exited is closed once the client process has been waited on.
+	exited chan struct{}
+	once   sync.Once
+}
+
+// launchPluggableTransport starts binary as a managed PT client for the
+// bridge's method and watches its output for the SOCKS port.
+func launchPluggableTransport(log *slog.Logger, binary string, args []string, b bridge) (*ptDialer, error) {
+	user, pass, err := b.socksCredentials()
+	if err != nil {
+		return nil, err
+	}
+	stateDir, err := os.MkdirTemp("", "kindling-pt-")
+	if err != nil {
+		return nil, fmt.Errorf("creating state dir: %w", err)
+	}
+	d := &ptDialer{
+		bridge:   b,
+		ready:    make(chan struct{}),
+		failed:   make(chan struct{}),
+		exited:   make(chan struct{}),
+		stateDir: stateDir,
+	}
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(),
+		"TOR_PT_MANAGED_TRANSPORT_VER=1",
+		"TOR_PT_CLIENT_TRANSPORTS="+b.method,
+		"TOR_PT_STATE_LOCATION="+stateDir,
+		// The client exits when its stdin closes, which happens when this
+		// process dies, however it dies.
+		"TOR_PT_EXIT_ON_STDIN_CLOSE=1",
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		os.RemoveAll(stateDir)
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(stateDir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(stateDir)
+		return nil, err
+	}
+	d.cmd = cmd
+	d.stdin = stdin
+	d.stdout = stdout
+
+	go func() {
+		d.watch(log, stdout, user, pass)
+		err := cmd.Wait()
+		if err == nil {
+			err = errors.New("exited")
+		}
+		d.fail(fmt.Errorf("%s client %w", b.method, err))
+		close(d.exited)
+	}()
+	return d, nil
+}
+
+// watch reads the client's stdout until it closes, handling the PT spec's
+// status lines.
+func (d *ptDialer) watch(log *slog.Logger, r io.Reader, user, pass []byte) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		keyword, rest, _ := strings.Cut(scanner.Text(), " ")
+		switch keyword {
+		case "VERSION-ERROR", "ENV-ERROR":
+			d.fail(fmt.Errorf("%s: %s", strings.ToLower(keyword), rest))
+		case "CMETHOD-ERROR":
+			method, msg, _ := strings.Cut(rest, " ")
+			if method == d.bridge.method {
+				d.fail(fmt.Errorf("method %s: %s", method, msg))
+			}
+		case "CMETHOD":
+			if err := d.connect(rest, user, pass); err != nil {
+				d.fail(err)
+			}
+		case "CMETHODS":
+			select {
+			case <-d.ready:
+			default:
+				d.fail(fmt.Errorf("client doesn't offer method %s", d.bridge.method))
+			}
+		case "LOG", "STATUS":
+			log.Debug("Pluggable transport "+strings.ToLower(keyword), "method", d.bridge.method, "message", rest)
+		}
+	}
+	// Keep draining so the client never blocks on a full stdout pipe.
+	io.Copy(io.Discard, r)
+}
+
+// connect handles a "CMETHOD <method> <proto> <addr>" line, layering a
+// SOCKS5 client for the bridge-side proxy over the client's SOCKS port.
+func (d *ptDialer) connect(line string, user, pass []byte) error {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != d.bridge.method {
+		return nil
+	}
+	if fields[1] != "socks5" {
+		return fmt.Errorf("method %s offers %s, only socks5 is supported", fields[0], fields[1])
+	}
+	// The client's SOCKS port is on loopback, so it's dialed directly
+	// rather than through WithStreamDialer.
+	pt, err := socks5.NewClient(&transport.StreamDialerEndpoint{
+		Dialer:  &transport.TCPDialer{},
+		Address: fields[2],
+	})
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		if err := pt.SetCredentials(user, pass); err != nil {
+			return err
+		}
+	}
+	proxy, err := socks5.NewClient(&transport.StreamDialerEndpoint{
+		Dialer:  pt,
+		Address: d.bridge.addr,
+	})
+	if err != nil {
+		return err
+	}
+	d.once.Do(func() {
+		d.dialer = proxy
+		close(d.ready)
+	})
+	return nil
+}
+
+// fail records the first reason the client is unusable.
+func (d *ptDialer) fail(err error) {
+	d.once.Do(func() {
+		d.err = err
+		close(d.failed)
+	})
+}
+
+func (d *ptDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	select {
+	case <-d.ready:
+	case <-d.failed:
+		return nil, fmt.Errorf("pluggable transport not running: %w", d.err)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for pluggable transport: %w", ctx.Err())
+	}
+	return d.dialer.DialStream(ctx, addr)
+}
+
+// Close stops the client and removes its state directory.
+func (d *ptDialer) Close() error {
+	// Closing stdin asks the client to exit; the kill makes sure it does.
+	d.stdin.Close()
+	if err := d.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("stopping %s client: %w", d.bridge.method, err)
+	}
+	// A child of the client may still hold stdout open; stop reading it.
+	d.stdout.Close()
+	<-d.exited
+	return os.RemoveAll(d.stateDir)
+}
@@ -0,0 +1,267 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBridgeLine(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		line    string
+		want    bridge
+		wantErr bool
+	}{
+		{
+			name: "Obfs4",
+			line: "obfs4 192.0.2.1:443 4D6C0F8F6E1C7A3B3D1E2F4A5B6C7D8E9F0A1B2C cert=abc+/= iat-mode=0",
+			want: bridge{method: "obfs4", addr: "192.0.2.1:443", args: [][2]string{{"cert", "abc+/="}, {"iat-mode", "0"}}},
+		},
+		{
+			name: "BridgeKeyword_NoFingerprint",
+			line: "Bridge snowflake 192.0.2.3:80 url=https://snowflake.example/",
+			want: bridge{method: "snowflake", addr: "192.0.2.3:80", args: [][2]string{{"url", "https://snowflake.example/"}}},
+		},
+		{name: "NoArgs", line: "meek 192.0.2.2:80", want: bridge{method: "meek", addr: "192.0.2.2:80"}},
+		{name: "MissingAddress", line: "obfs4", wantErr: true},
+		{name: "BadAddress", line: "obfs4 192.0.2.1", wantErr: true},
+		{name: "BadArgument", line: "obfs4 192.0.2.1:443 FINGERPRINT cert", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseBridgeLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBridgeSOCKSCredentials(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Escaped", func(t *testing.T) {
+		t.Parallel()
+		b := bridge{args: [][2]string{{"cert", `a=b;c\d`}, {"iat-mode", "0"}}}
+		user, pass, err := b.socksCredentials()
+		require.NoError(t, err)
+		assert.Equal(t, `cert=a\=b\;c\\d;iat-mode=0`, string(user))
+		assert.Equal(t, []byte{0}, pass)
+	})
+
+	t.Run("NoArgs", func(t *testing.T) {
+		t.Parallel()
+		user, pass, err := bridge{}.socksCredentials()
+		require.NoError(t, err)
+		assert.Nil(t, user)
+		assert.Nil(t, pass)
+	})
+
+	t.Run("SpillsIntoPassword", func(t *testing.T) {
+		t.Parallel()
+		b := bridge{args: [][2]string{{"cert", strings.Repeat("x", 300)}}}
+		user, pass, err := b.socksCredentials()
+		require.NoError(t, err)
+		assert.Len(t, user, 255)
+		assert.Equal(t, "cert="+strings.Repeat("x", 300), string(user)+string(pass))
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		t.Parallel()
+		b := bridge{args: [][2]string{{"cert", strings.Repeat("x", 600)}}}
+		_, _, err := b.socksCredentials()
+		assert.Error(t, err)
+	})
+}
+
+func TestWithPluggableTransport(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithPluggableTransport("", nil, "obfs4 192.0.2.1:443")(&kindling{}))
+		assert.Error(t, WithPluggableTransport("obfs4proxy", nil, "obfs4")(&kindling{}))
+	})
+
+	t.Run("LaunchFailure_IsDeferredError", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithPluggableTransport(filepath.Join(t.TempDir(), "no-such-pt"), nil, "obfs4 192.0.2.1:443"))
+		assert.ErrorContains(t, err, "starting pluggable transport obfs4")
+	})
+
+	t.Run("Request", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake PT client is a shell script")
+		}
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello from obfs4")
+		}))
+		defer origin.Close()
+
+		// The fake client's SOCKS port checks that the bridge arguments
+		// arrive as credentials, then connects to the bridge, itself a
+		// SOCKS5 proxy.
+		bridgeAddr := serveSOCKS5(t)
+		ptAddr := serveTestPTSOCKS(t, "cert=abc;iat-mode=0", "\x00")
+		script := fakePTClient(t, `[ "$TOR_PT_CLIENT_TRANSPORTS" = obfs4 ] || { echo "ENV-ERROR wrong transports"; exit 1; }
+[ -d "$TOR_PT_STATE_LOCATION" ] || { echo "ENV-ERROR no state dir"; exit 1; }
+echo "VERSION 1"
+echo "CMETHOD obfs4 socks5 `+ptAddr+`"
+echo "CMETHODS DONE"
+cat >/dev/null
+`)
+
+		ki, err := NewKindling("test", WithPluggableTransport(script, nil, "obfs4 "+bridgeAddr+" cert=abc iat-mode=0"))
+		require.NoError(t, err)
+		k := ki.(*kindling)
+		require.Len(t, k.transports, 1)
+		assert.Equal(t, "obfs4", k.transports[0].Name())
+
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "hello from obfs4", string(body))
+		require.NoError(t, k.Close())
+	})
+
+	t.Run("NewPluggableTransport", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake PT client is a shell script")
+		}
+		_, err := NewPluggableTransport("", nil, "obfs4 192.0.2.1:443")
+		assert.Error(t, err)
+
+		echo := serveEcho(t)
+		bridgeAddr := serveSOCKS5(t)
+		script := fakePTClient(t, `echo "CMETHOD obfs4 socks5 `+serveSOCKS5(t)+`"
+echo "CMETHODS DONE"
+cat >/dev/null
+`)
+		tr, err := NewPluggableTransport(script, nil, "obfs4 "+bridgeAddr)
+		require.NoError(t, err)
+		assert.Equal(t, "obfs4", tr.Name())
+		closer, ok := tr.(io.Closer)
+		require.True(t, ok, "transport isn't an io.Closer")
+		defer closer.Close()
+
+		k, err := NewKindling("test")
+		require.NoError(t, err)
+		defer k.Close()
+		require.NoError(t, k.AddTransport(tr))
+		conn, err := k.DialStream(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("MethodError", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake PT client is a shell script")
+		}
+		script := fakePTClient(t, `echo "VERSION 1"
+echo "CMETHOD-ERROR obfs4 no such bridge"
+echo "CMETHODS DONE"
+cat >/dev/null
+`)
+		d, err := launchPluggableTransport(testLog, script, nil, bridge{method: "obfs4", addr: "192.0.2.1:443"})
+		require.NoError(t, err)
+		defer d.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = d.DialStream(ctx, "example.com:443")
+		assert.ErrorContains(t, err, "no such bridge")
+	})
+
+	t.Run("MethodNotOffered", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake PT client is a shell script")
+		}
+		script := fakePTClient(t, `echo "VERSION 1"
+echo "CMETHOD meek socks5 127.0.0.1:1"
+echo "CMETHODS DONE"
+cat >/dev/null
+`)
+		d, err := launchPluggableTransport(testLog, script, nil, bridge{method: "obfs4", addr: "192.0.2.1:443"})
+		require.NoError(t, err)
+		defer d.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = d.DialStream(ctx, "example.com:443")
+		assert.ErrorContains(t, err, "doesn't offer method obfs4")
+	})
+
+	t.Run("CloseStopsClient", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake PT client is a shell script")
+		}
+		script := fakePTClient(t, "sleep 60\n")
+		d, err := launchPluggableTransport(testLog, script, nil, bridge{method: "obfs4", addr: "192.0.2.1:443"})
+		require.NoError(t, err)
+		require.NoError(t, d.Close())
+		_, err = os.Stat(d.stateDir)
+		assert.True(t, os.IsNotExist(err), "state dir left behind")
+		_, err = d.DialStream(context.Background(), "example.com:443")
+		assert.ErrorContains(t, err, "not running")
+	})
+
+	t.Run("DialWaitsForClient", func(t *testing.T) {
+		t.Parallel()
+		d := &ptDialer{ready: make(chan struct{}), failed: make(chan struct{})}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := d.DialStream(ctx, "example.com:443")
+		assert.ErrorContains(t, err, "waiting for pluggable transport")
+	})
+}
+
+// fakePTClient writes a shell script standing in for a PT client binary.
+func fakePTClient(t *testing.T, body string) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "pt-client")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n"+body), 0o755))
+	return script
+}
+
+// serveTestPTSOCKS starts a SOCKS5 server requiring user and pass, like a PT
+// client's SOCKS port given bridge arguments.
+func serveTestPTSOCKS(t *testing.T, user, pass string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleTestSOCKS5Auth(conn, user, pass)
+		}
+	}()
+	return l.Addr().String()
+}
@@ -0,0 +1,36 @@
+package kindling
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// postQuantumCurves offers the hybrid ML-KEM group first, followed by the
+// classical ones, as current Chrome does.
+var postQuantumCurves = []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384}
+
+// WithPostQuantumTLS offers the X25519MLKEM768 hybrid post-quantum key
+// exchange first in every TLS config kindling builds: connections to origins,
+// tunnel hops such as WithUpstreamProxy and WithWebTunnel, and DoH lookups. That
+// keeps the handshakes looking like modern Chrome's, and keeps recorded
+// traffic safe from a future quantum computer, even where GODEBUG has turned
+// Go's own post-quantum default off. WithTLSFingerprint handshakes offer the
+// groups of the browser they copy.
+func WithPostQuantumTLS() Option {
+	return func(k *kindling) error {
+		k.postQuantum = true
+		return nil
+	}
+}
+
+// applyTLSConfig applies k's TLS settings to the resolver's client.
+func (r *dohResolver) applyTLSConfig(k *kindling) {
+	t, ok := r.client.Transport.(*http.Transport)
+	if !ok || (!k.postQuantum && k.sessionCache == nil) {
+		return
+	}
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	k.tlsConfig(t.TLSClientConfig)
+}
@@ -0,0 +1,68 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPostQuantumTLS(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Off", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{}
+		assert.Nil(t, k.tlsConfig(&tls.Config{}).CurvePreferences)
+	})
+
+	t.Run("DoHResolver", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{}
+		require.NoError(t, WithPostQuantumTLS()(k))
+		require.NoError(t, WithDoHResolver("https://1.1.1.1/dns-query")(k))
+		k.applyTLSConfig()
+		config := k.resolver.(*dohResolver).client.Transport.(*http.Transport).TLSClientConfig
+		assert.Equal(t, postQuantumCurves, config.CurvePreferences)
+	})
+
+	t.Run("OffersMLKEMFirst", func(t *testing.T) {
+		t.Parallel()
+		offered := make(chan []tls.CurveID, 1)
+		srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+		srv.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			offered <- hello.SupportedCurves
+			return nil, nil
+		}}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		dialer := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return conn.(*net.TCPConn), nil
+		})
+		k := &kindling{
+			postQuantum: true,
+			rootCAs:     srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+			transports:  []Transport{newStreamTransport("tunnel", dialer)},
+		}
+		k.applyTLSConfig()
+		rt, err := k.transports[0].NewRoundTripper(t.Context(), "example.com:443")
+		require.NoError(t, err)
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, tls.X25519MLKEM768, (<-offered)[0])
+		assert.Equal(t, tls.X25519MLKEM768, resp.TLS.CurveID)
+	})
+}
@@ -0,0 +1,92 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+)
+
+// Prewarm connects every eligible pooled transport to each of hosts and
+// keeps the connections in the round-tripper pool (see
+// WithRoundTripperPool), so the first real requests to those hosts don't
+// wait on tunnel handshakes. Call it early, say behind a splash screen.
+// hosts are "host" or "host:port", with port 443 assumed. Domain policies,
+// the host filter, and the circuit breaker apply as they do to requests.
+//
+// Prewarm returns once every connection attempt has finished or ctx is done.
+// It returns an error for each host no transport could connect to.
+// Connections nothing uses within the pool's idle timeout are closed.
+func (k *kindling) Prewarm(ctx context.Context, hosts ...string) error {
+	if k.pool == nil {
+		return errors.New("round-tripper pool is disabled")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer stopOnClose(k.ctx, cancel)()
+	t := k.newRaceTransport(nil)
+	transports := k.snapshot()
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := t.prewarm(ctx, transports, host); err != nil {
+				errs[i] = fmt.Errorf("prewarming %s: %w", host, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// prewarm connects each eligible transport to host and pools the results.
+func (t *raceTransport) prewarm(ctx context.Context, transports []Transport, host string) error {
+	addr := hostWithPort(host, "https")
+	hostname, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if err := t.hostFilter.check(hostname); err != nil {
+		return err
+	}
+	// Only transports whose round-trippers are pooled are worth connecting
+	// ahead of time.
+	transports = slices.DeleteFunc(slices.Clone(transports), func(tr Transport) bool {
+		return !reusable(tr)
+	})
+	if allowed, _, ok := policyFor(t.domainPolicy, hostname); ok {
+		transports = slices.DeleteFunc(transports, func(tr Transport) bool {
+			return !slices.Contains(allowed, tr.Name())
+		})
+	}
+	transports = t.skipTripped(ctx, transports)
+	if len(transports) == 0 {
+		return errors.New("no eligible transports")
+	}
+
+	ctx, addr = t.dialTarget(ctx, addr)
+	errs := make([]error, len(transports))
+	var wg sync.WaitGroup
+	for i, tr := range transports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rt, err := t.newRoundTripper(ctx, tr, addr)
+			if err != nil {
+				t.recordFailure(ctx, tr.Name())
+				errs[i] = &AttemptError{Transport: tr.Name(), Phase: PhaseConnect, Err: err}
+				return
+			}
+			t.pool.put(poolKeyFor(ctx, tr, addr), rt)
+		}()
+	}
+	wg.Wait()
+	if slices.ContainsFunc(errs, func(err error) bool { return err == nil }) {
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,79 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrewarm(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(origin.Close)
+	host := origin.Listener.Addr().String()
+
+	tunnel := func(dials *atomic.Int32) Transport {
+		return newStreamTransport("tunnel", transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			dials.Add(1)
+			return (&transport.TCPDialer{}).DialStream(ctx, addr)
+		}))
+	}
+	broken := &mockTransport{
+		name:     "broken",
+		reusable: true,
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return nil, errors.New("blocked")
+		},
+	}
+
+	t.Run("FirstRequestReusesConnection", func(t *testing.T) {
+		t.Parallel()
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(tunnel(&dials)), WithTransport(broken))
+		require.NoError(t, err)
+		require.NoError(t, k.Prewarm(context.Background(), host))
+		require.EqualValues(t, 1, dials.Load())
+
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.EqualValues(t, 1, dials.Load())
+	})
+
+	t.Run("NoTransportConnects", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(broken))
+		require.NoError(t, err)
+		err = k.Prewarm(context.Background(), host)
+		assert.ErrorContains(t, err, "broken: blocked")
+	})
+
+	t.Run("BlockedHost", func(t *testing.T) {
+		t.Parallel()
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(tunnel(&dials)), WithBlockedHosts("127.0.0.1"))
+		require.NoError(t, err)
+		assert.Error(t, k.Prewarm(context.Background(), host))
+		assert.Zero(t, dials.Load())
+	})
+
+	t.Run("PoolDisabled", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(broken), WithRoundTripperPool(0))
+		require.NoError(t, err)
+		assert.Error(t, k.Prewarm(context.Background(), host))
+	})
+}
@@ -0,0 +1,104 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProbeResult is the outcome of probing a URL through one transport.
+type ProbeResult struct {
+	// Reachable is true if the transport got an HTTP response of any
+	// status.
+	Reachable  bool
+	StatusCode int
+	// Latency covers connecting the transport and receiving the response
+	// headers.
+	Latency time.Duration
+	Err     error
+}
+
+// Probe sends a HEAD request for url through every configured transport
+// concurrently and reports the result for each, keyed by transport name. It
+// ignores domain policies, transport hints, and the circuit breaker, so a
+// quarantined transport can be checked too, but its outcome is recorded like
+// any other request's, scoring the transport for the Adaptive strategy, and
+// shows up in Transports. Probe returns once every
+// transport has answered, failed, or run out its request timeout, or ctx is
+// done.
+func (k *kindling) Probe(ctx context.Context, url string) map[string]ProbeResult {
+	transports := k.snapshot()
+	results := make(map[string]ProbeResult, len(transports))
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, http.NoBody)
+	if err == nil {
+		err = k.hostFilter.check(req.URL.Hostname())
+	}
+	if err != nil {
+		for _, tr := range transports {
+			results[tr.Name()] = ProbeResult{Err: err}
+		}
+		return results
+	}
+
+	t := k.newRaceTransport(nil)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, tr := range transports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := t.probe(req, tr)
+			mu.Lock()
+			results[tr.Name()] = res
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// probe sends req over tr alone.
+func (t *raceTransport) probe(req *http.Request, tr Transport) ProbeResult {
+	ctx, cancel := raceContext(req.Context(), t.clock, t.requestTimeout(req, []Transport{tr}), t.logFor(req.Context()))
+	defer cancel()
+	defer stopOnClose(t.closed, cancel)()
+
+	start := t.clock.Now()
+	results := make(chan connectResult, 1)
+	ctx, addr := t.dialTarget(ctx, hostWithPort(req.URL.Host, req.URL.Scheme))
+	t.connect(ctx, tr, addr, results)
+	result := <-results
+	if result.err != nil {
+		t.recordFailure(ctx, tr.Name())
+		latency := t.clock.Now().Sub(start)
+		t.observeProbe(req, tr, false, latency)
+		return ProbeResult{Latency: latency, Err: fmt.Errorf("connecting: %w", result.err)}
+	}
+	clone, err := cloneRequest(req.WithContext(ctx), t.headerPolicy, t.appName, tr.Name(), nil)
+	if err != nil {
+		return ProbeResult{Err: err}
+	}
+	resp, err := result.rt.RoundTrip(clone)
+	latency := t.clock.Now().Sub(start)
+	t.observeProbe(req, tr, err == nil, latency)
+	if err != nil {
+		t.recordFailure(ctx, tr.Name())
+		drainAndClose(resp)
+		return ProbeResult{Latency: latency, Err: err}
+	}
+	drainAndClose(resp)
+	t.recordSuccess(tr.Name())
+	return ProbeResult{Reachable: true, StatusCode: resp.StatusCode, Latency: latency}
+}
+
+// observeProbe scores a probe of tr for the Adaptive strategy, as the race
+// scores a request, so a transport that fails its health check drops down
+// the order before a user request tries it.
+func (t *raceTransport) observeProbe(req *http.Request, tr Transport, ok bool, latency time.Duration) {
+	// A probe the caller gave up on says nothing about the transport.
+	if t.bandit != nil && req.Context().Err() == nil {
+		t.bandit.observe(tr.Name(), ok, latency)
+	}
+}
@@ -0,0 +1,103 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(origin.Close)
+
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+	broken := &mockTransport{
+		name: "broken",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return nil, errors.New("blocked")
+		},
+	}
+	hanging := &mockTransport{
+		name: "hanging",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	t.Run("PerTransport", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(direct), WithTransport(broken), WithTransport(hanging))
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		results := k.Probe(ctx, origin.URL)
+		require.Len(t, results, 3)
+
+		assert.True(t, results["direct"].Reachable)
+		assert.Equal(t, http.StatusNoContent, results["direct"].StatusCode)
+		assert.Greater(t, results["direct"].Latency, time.Duration(0))
+		assert.NoError(t, results["direct"].Err)
+
+		assert.False(t, results["broken"].Reachable)
+		assert.ErrorContains(t, results["broken"].Err, "blocked")
+
+		assert.False(t, results["hanging"].Reachable)
+		assert.ErrorIs(t, results["hanging"].Err, context.DeadlineExceeded)
+	})
+
+	t.Run("ProbesQuarantined", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(direct), WithCircuitBreaker(1, time.Hour))
+		require.NoError(t, err)
+		k.(*kindling).breaker.failure("direct")
+		require.Equal(t, TransportQuarantined, k.Transports()[0].State)
+
+		results := k.Probe(context.Background(), origin.URL)
+		assert.True(t, results["direct"].Reachable)
+		info := k.Transports()[0]
+		assert.Equal(t, TransportEnabled, info.State, "a successful probe closes the breaker")
+		assert.False(t, info.LastSuccess.IsZero())
+	})
+
+	t.Run("FailedProbeDemotes", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(broken), WithTransport(direct), WithStrategy(Adaptive))
+		require.NoError(t, err)
+		kk := k.(*kindling)
+		require.Equal(t, []string{"broken", "direct"}, names(kk.bandit.order(kk.snapshot())))
+
+		results := k.Probe(context.Background(), origin.URL)
+		require.Error(t, results["broken"].Err)
+		assert.Equal(t, []string{"direct", "broken"}, names(kk.bandit.order(kk.snapshot())),
+			"a failed probe moves the transport down the Adaptive order")
+	})
+
+	t.Run("BadURL", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(direct))
+		require.NoError(t, err)
+		results := k.Probe(context.Background(), "://nope")
+		assert.Error(t, results["direct"].Err)
+	})
+}
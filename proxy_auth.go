@@ -0,0 +1,216 @@
+package kindling
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// proxyAuth authenticates CONNECT requests to an upstream proxy. It sends
+// Basic credentials until the proxy challenges for Digest (RFC 7616), and
+// from then on answers the latest Digest challenge, so later tunnels
+// authenticate without a round trip for a fresh 407.
+type proxyAuth struct {
+	user, pass string
+
+	mu     sync.Mutex
+	digest *digestChallenge
+}
+
+// digestChallenge is a Digest challenge the proxy sent. Only nc changes
+// once it's made, under proxyAuth.mu.
+type digestChallenge struct {
+	realm, nonce, opaque, algorithm string
+	// qop is "auth", or empty for the legacy RFC 2069 exchange.
+	qop string
+	nc  int
+}
+
+// proxyAuthError is a 407 from the proxy, with the challenges it sent.
+type proxyAuthError struct {
+	addr       string
+	status     string
+	challenges []string
+}
+
+func (e *proxyAuthError) Error() string {
+	return fmt.Sprintf("proxy CONNECT %s: %s", e.addr, e.status)
+}
+
+// authorization returns the Proxy-Authorization value for a request.
+func (a *proxyAuth) authorization(method, uri string) string {
+	a.mu.Lock()
+	d := a.digest
+	if d == nil {
+		a.mu.Unlock()
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.user+":"+a.pass))
+	}
+	d.nc++
+	nc := d.nc
+	a.mu.Unlock()
+	return d.response(a.user, a.pass, method, uri, nc)
+}
+
+// challenged takes in the challenges of a 407 and reports whether the
+// request is worth retrying with what they ask for. It isn't when the proxy
+// rejected the credentials themselves rather than their form or a stale
+// nonce.
+func (a *proxyAuth) challenged(challenges []string) bool {
+	for _, c := range parseAuthChallenges(challenges) {
+		if c.scheme != "digest" || newDigestHash(c.params["algorithm"]) == nil {
+			continue
+		}
+		qop := ""
+		if qops := c.params["qop"]; qops != "" {
+			if !slices.Contains(strings.Split(strings.ReplaceAll(qops, " ", ""), ","), "auth") {
+				continue
+			}
+			qop = "auth"
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.digest != nil && a.digest.nonce == c.params["nonce"] && !strings.EqualFold(c.params["stale"], "true") {
+			return false
+		}
+		a.digest = &digestChallenge{
+			realm:     c.params["realm"],
+			nonce:     c.params["nonce"],
+			opaque:    c.params["opaque"],
+			algorithm: c.params["algorithm"],
+			qop:       qop,
+		}
+		return true
+	}
+	return false
+}
+
+// response returns the Digest Proxy-Authorization value for the nc'th
+// request made with the challenge.
+func (d *digestChallenge) response(user, pass, method, uri string, nc int) string {
+	newHash := newDigestHash(d.algorithm)
+	h := func(parts ...string) string {
+		hh := newHash()
+		hh.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	cnonce := newRequestID()
+	ncs := fmt.Sprintf("%08x", nc)
+	ha1 := h(user, d.realm, pass)
+	if strings.HasSuffix(strings.ToLower(d.algorithm), "-sess") {
+		ha1 = h(ha1, d.nonce, cnonce)
+	}
+	ha2 := h(method, uri)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q`, user, d.realm, d.nonce, uri)
+	if d.qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce=%q, response=%q`, d.qop, ncs, cnonce, h(ha1, d.nonce, ncs, cnonce, d.qop, ha2))
+	} else {
+		fmt.Fprintf(&b, `, response=%q`, h(ha1, d.nonce, ha2))
+	}
+	if d.algorithm != "" {
+		fmt.Fprintf(&b, `, algorithm=%s`, d.algorithm)
+	}
+	if d.opaque != "" {
+		fmt.Fprintf(&b, `, opaque=%q`, d.opaque)
+	}
+	return b.String()
+}
+
+// newDigestHash returns the hash a Digest algorithm uses, or nil if it's
+// unsupported.
+func newDigestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5", "MD5-SESS":
+		return md5.New
+	case "SHA-256", "SHA-256-SESS":
+		return sha256.New
+	}
+	return nil
+}
+
+// authChallenge is one challenge from a Proxy-Authenticate header.
+type authChallenge struct {
+	// scheme is lowercased, as are the param names.
+	scheme string
+	params map[string]string
+}
+
+// parseAuthChallenges parses Proxy-Authenticate values, each of which may
+// hold several challenges (RFC 9110 §11.6.1). token68 credentials, which
+// neither Basic nor Digest challenges use, aren't supported.
+func parseAuthChallenges(values []string) []authChallenge {
+	var out []authChallenge
+	for _, s := range values {
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if s == "" {
+				break
+			}
+			i := strings.IndexAny(s, " \t,")
+			if i < 0 {
+				i = len(s)
+			}
+			c := authChallenge{scheme: strings.ToLower(s[:i]), params: make(map[string]string)}
+			s = s[i:]
+			for {
+				rest := strings.TrimLeft(s, " \t,")
+				eq := strings.IndexByte(rest, '=')
+				if sp := strings.IndexAny(rest, " \t,"); eq <= 0 || (sp >= 0 && sp < eq) {
+					// The next token starts another challenge.
+					s = rest
+					break
+				}
+				key := strings.ToLower(rest[:eq])
+				rest = strings.TrimLeft(rest[eq+1:], " \t")
+				var val string
+				if strings.HasPrefix(rest, `"`) {
+					val, rest = unquoteAuthParam(rest)
+				} else {
+					j := strings.IndexAny(rest, " \t,")
+					if j < 0 {
+						j = len(rest)
+					}
+					val, rest = rest[:j], rest[j:]
+				}
+				c.params[key] = val
+				s = rest
+			}
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// unquoteAuthParam splits the quoted string s starts with from the rest of
+// s, undoing backslash escapes.
+func unquoteAuthParam(s string) (val, rest string) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), ""
+}
+
+// setProxyAuthorization sets h's Proxy-Authorization for a CONNECT to addr,
+// if a is set.
+func (a *proxyAuth) setProxyAuthorization(h http.Header, addr string) {
+	if a != nil {
+		h.Set("Proxy-Authorization", a.authorization(http.MethodConnect, addr))
+	}
+}
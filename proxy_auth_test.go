@@ -0,0 +1,136 @@
+package kindling
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDigestProxy starts a CONNECT proxy that requires SHA-256 Digest auth
+// as user with pass, and counts the 407s it sends.
+func newDigestProxy(t *testing.T, user, pass string) (addr string, challenges *atomic.Int32) {
+	t.Helper()
+	const realm, nonce = "proxy", "abc123"
+	sum := func(parts ...string) string {
+		h := sha256.Sum256([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(h[:])
+	}
+	challenges = new(atomic.Int32)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := parseAuthChallenges([]string{r.Header.Get("Proxy-Authorization")})
+		ok := len(auth) == 1 && auth[0].scheme == "digest"
+		if ok {
+			p := auth[0].params
+			want := sum(sum(user, realm, pass), nonce, p["nc"], p["cnonce"], "auth", sum(r.Method, p["uri"]))
+			ok = p["username"] == user && p["nonce"] == nonce && p["uri"] == r.Host && p["response"] == want
+		}
+		if !ok {
+			challenges.Add(1)
+			w.Header().Add("Proxy-Authenticate", `Basic realm="proxy", Digest realm="proxy", nonce="abc123", qop="auth,auth-int", algorithm=SHA-256`)
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, client)
+			upstream.Close()
+		}()
+		io.Copy(client, upstream)
+		client.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy.Listener.Addr().String(), challenges
+}
+
+func TestUpstreamProxyDigestAuth(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via upstream")
+	}))
+	t.Cleanup(origin.Close)
+	dial := func(t *testing.T, proxyURL string) error {
+		u, err := url.Parse(proxyURL)
+		require.NoError(t, err)
+		d, err := (&kindling{}).newUpstreamProxyDialer(&transport.TCPDialer{}, u)
+		require.NoError(t, err)
+		conn, err := d.DialStream(t.Context(), origin.Listener.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	t.Run("AnswersChallenge", func(t *testing.T) {
+		t.Parallel()
+		addr, challenges := newDigestProxy(t, "user", "secret")
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithUpstreamProxy("http://user:secret@"+addr))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "via upstream", string(body))
+		assert.EqualValues(t, 1, challenges.Load())
+	})
+
+	t.Run("LaterTunnelsAnswerUpFront", func(t *testing.T) {
+		t.Parallel()
+		addr, challenges := newDigestProxy(t, "user", "secret")
+		u, err := url.Parse("http://user:secret@" + addr)
+		require.NoError(t, err)
+		d, err := (&kindling{}).newUpstreamProxyDialer(&transport.TCPDialer{}, u)
+		require.NoError(t, err)
+		for range 3 {
+			conn, err := d.DialStream(t.Context(), origin.Listener.Addr().String())
+			require.NoError(t, err)
+			conn.Close()
+		}
+		assert.EqualValues(t, 1, challenges.Load())
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		t.Parallel()
+		addr, challenges := newDigestProxy(t, "user", "secret")
+		assert.Error(t, dial(t, "http://user:wrong@"+addr))
+		assert.EqualValues(t, 2, challenges.Load(), "Basic, then Digest, and no more")
+	})
+}
+
+func TestParseAuthChallenges(t *testing.T) {
+	t.Parallel()
+
+	got := parseAuthChallenges([]string{
+		`Basic realm="a \"b\"", Digest realm=proxy, nonce="n,1", qop="auth"`,
+		`Negotiate`,
+	})
+	require.Len(t, got, 3)
+	assert.Equal(t, "basic", got[0].scheme)
+	assert.Equal(t, `a "b"`, got[0].params["realm"])
+	assert.Equal(t, "digest", got[1].scheme)
+	assert.Equal(t, map[string]string{"realm": "proxy", "nonce": "n,1", "qop": "auth"}, got[1].params)
+	assert.Equal(t, "negotiate", got[2].scheme)
+}
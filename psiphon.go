@@ -0,0 +1,197 @@
+package kindling
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+// psiphonBinary is the psiphon-tunnel-core console client WithPsiphon
+// launches, looked up on PATH.
+const psiphonBinary = "psiphon-tunnel-core"
+
+// WithPsiphon adds a last-resort transport through the Psiphon network. It
+// launches the psiphon-tunnel-core console client (which must be on PATH)
+// with configJSON, a standard Psiphon client config that must at least set
+// PropagationChannelId and SponsorId, and dials through the client's local
+// SOCKS proxy once it has established a tunnel.
+//
+// Kindling picks the proxy port itself, overriding LocalSocksProxyPort.
+// DataRootDirectory defaults to a fresh temporary directory; reusing one
+// across runs keeps Psiphon's server list and makes connecting much faster.
+func WithPsiphon(configJSON []byte) Option {
+	return func(k *kindling) error {
+		var cfg map[string]any
+		if err := json.Unmarshal(configJSON, &cfg); err != nil {
+			return fmt.Errorf("parsing psiphon config: %w", err)
+		}
+		for _, key := range []string{"PropagationChannelId", "SponsorId"} {
+			if s, _ := cfg[key].(string); s == "" {
+				return fmt.Errorf("psiphon config is missing %s", key)
+			}
+		}
+		k.deferred = append(k.deferred, func() error {
+			d, err := newPsiphonDialer(k.log, psiphonBinary, maps.Clone(cfg))
+			if err != nil {
+				return fmt.Errorf("starting psiphon: %w", err)
+			}
+			if err := k.onClose(d); err != nil {
+				return err
+			}
+			nt := newStreamTransport(string(TransportPsiphon), d)
+			nt.priority = priorityLastResort
+			k.transports = append(k.transports, nt)
+			return nil
+		})
+		return nil
+	}
+}
+
+// psiphonDialer dials through the Psiphon client's SOCKS proxy once the
+// client reports a tunnel.
+type psiphonDialer struct {
+	// socks is set before ready is closed. exited is closed if the client
+	// dies; exitErr then holds the reason.
+	socks   transport.StreamDialer
+	ready   chan struct{}
+	exited  chan struct{}
+	exitErr error
+	cmd     *exec.Cmd
+	// tempDir is the data directory, if kindling created it.
+	tempDir string
+}
+
+func newPsiphonDialer(log *slog.Logger, bin string, cfg map[string]any) (*psiphonDialer, error) {
+	socksAddr, err := freeLoopbackAddr()
+	if err != nil {
+		return nil, err
+	}
+	var tempDir string
+	_, port, _ := net.SplitHostPort(socksAddr)
+	cfg["LocalSocksProxyPort"], _ = strconv.Atoi(port)
+	dataDir, _ := cfg["DataRootDirectory"].(string)
+	if dataDir == "" {
+		if dataDir, err = os.MkdirTemp("", "kindling-psiphon-"); err != nil {
+			return nil, fmt.Errorf("creating data dir: %w", err)
+		}
+		tempDir = dataDir
+		cfg["DataRootDirectory"] = dataDir
+	}
+	configPath := filepath.Join(dataDir, "kindling-psiphon.config")
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(configPath, b, 0o600); err != nil {
+		return nil, fmt.Errorf("writing config: %w", err)
+	}
+
+	cmd := exec.Command(bin, "-config", configPath)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	d := &psiphonDialer{
+		ready:   make(chan struct{}),
+		exited:  make(chan struct{}),
+		cmd:     cmd,
+		tempDir: tempDir,
+	}
+	go func() {
+		d.watchNotices(log, stderr, socksAddr)
+		err := cmd.Wait()
+		if err == nil {
+			err = errors.New("psiphon exited")
+		}
+		d.exitErr = err
+		close(d.exited)
+	}()
+	return d, nil
+}
+
+// psiphonNotice is the JSON line format of Psiphon's diagnostic notices.
+type psiphonNotice struct {
+	NoticeType string          `json:"noticeType"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// watchNotices reads the client's notices until they close, tracking the
+// SOCKS port it actually listens on and closing ready once it reports an
+// established tunnel.
+func (d *psiphonDialer) watchNotices(log *slog.Logger, r io.Reader, socksAddr string) {
+	var once sync.Once
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var n psiphonNotice
+		if json.Unmarshal(scanner.Bytes(), &n) != nil {
+			continue
+		}
+		switch n.NoticeType {
+		case "ListeningSocksProxyPort":
+			var data struct{ Port int }
+			if json.Unmarshal(n.Data, &data) == nil && data.Port > 0 {
+				socksAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(data.Port))
+			}
+		case "Tunnels":
+			var data struct{ Count int }
+			if json.Unmarshal(n.Data, &data) != nil {
+				continue
+			}
+			log.Debug("Psiphon tunnels", "count", data.Count)
+			if data.Count > 0 {
+				once.Do(func() {
+					// The proxy is on loopback, so it's dialed directly
+					// rather than through WithStreamDialer.
+					d.socks, _ = socks5.NewClient(&transport.StreamDialerEndpoint{
+						Dialer:  &transport.TCPDialer{},
+						Address: socksAddr,
+					})
+					close(d.ready)
+				})
+			}
+		}
+	}
+	// Keep draining so the client never blocks on a full stderr pipe.
+	io.Copy(io.Discard, r)
+}
+
+func (d *psiphonDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	select {
+	case <-d.ready:
+	case <-d.exited:
+		return nil, fmt.Errorf("psiphon not running: %w", d.exitErr)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for psiphon tunnel: %w", ctx.Err())
+	}
+	return d.socks.DialStream(ctx, addr)
+}
+
+// Close stops the client and removes the data directory kindling created
+// for it.
+func (d *psiphonDialer) Close() error {
+	if err := d.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("stopping psiphon: %w", err)
+	}
+	<-d.exited
+	if d.tempDir != "" {
+		return os.RemoveAll(d.tempDir)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package kindling
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPsiphon(t *testing.T) {
+	t.Parallel()
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithPsiphon([]byte("not json")))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithPsiphon([]byte(`{"PropagationChannelId": "FFFF"}`)))
+		assert.ErrorContains(t, err, "SponsorId")
+	})
+
+	t.Run("LaunchedClient_DialsOnceTunneled", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake psiphon binary is a shell script")
+		}
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello from psiphon")
+		}))
+		t.Cleanup(origin.Close)
+		_, port, err := net.SplitHostPort(serveSOCKS5(t))
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		script := filepath.Join(dir, "psiphon-tunnel-core")
+		require.NoError(t, os.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+cp "$2" %q
+echo '{"noticeType":"ListeningSocksProxyPort","data":{"port":%s}}' >&2
+echo 'not a notice' >&2
+echo '{"noticeType":"Tunnels","data":{"count":1}}' >&2
+sleep 5
+`, filepath.Join(dir, "seen.json"), port)), 0o755))
+
+		d, err := newPsiphonDialer(testLog, script, map[string]any{
+			"PropagationChannelId": "FFFF",
+			"SponsorId":            "FFFF",
+			"DataRootDirectory":    dir,
+		})
+		require.NoError(t, err)
+		select {
+		case <-d.ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("psiphon never reported a tunnel")
+		}
+
+		seen, err := os.ReadFile(filepath.Join(dir, "seen.json"))
+		require.NoError(t, err)
+		var cfg map[string]any
+		require.NoError(t, json.Unmarshal(seen, &cfg))
+		assert.Equal(t, "FFFF", cfg["SponsorId"])
+		assert.NotZero(t, cfg["LocalSocksProxyPort"])
+
+		conn, err := d.DialStream(t.Context(), strings.TrimPrefix(origin.URL, "http://"))
+		require.NoError(t, err)
+		client := &http.Client{Transport: preconnectedTransport(conn)}
+		resp, err := client.Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello from psiphon", string(body))
+	})
+
+	t.Run("ClientExit_FailsDial", func(t *testing.T) {
+		t.Parallel()
+		_, err := newPsiphonDialer(testLog, filepath.Join(t.TempDir(), "missing"), map[string]any{})
+		assert.Error(t, err)
+
+		if runtime.GOOS == "windows" {
+			return
+		}
+		script := filepath.Join(t.TempDir(), "psiphon-tunnel-core")
+		require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nexit 1\n"), 0o755))
+		d, err := newPsiphonDialer(testLog, script, map[string]any{"DataRootDirectory": t.TempDir()})
+		require.NoError(t, err)
+		_, err = d.DialStream(t.Context(), "example.com:443")
+		assert.ErrorContains(t, err, "psiphon not running")
+	})
+
+	t.Run("Close_StopsClient", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake psiphon binary is a shell script")
+		}
+		script := filepath.Join(t.TempDir(), "psiphon-tunnel-core")
+		require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755))
+		d, err := newPsiphonDialer(testLog, script, map[string]any{})
+		require.NoError(t, err)
+		_, err = os.Stat(d.tempDir)
+		require.NoError(t, err)
+
+		require.NoError(t, d.Close())
+		select {
+		case <-d.exited:
+		default:
+			t.Fatal("psiphon still running after Close")
+		}
+		_, err = os.Stat(d.tempDir)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,74 @@
+package kindling
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Phases of a transport attempt reported in AttemptError.
+const (
+	// PhaseConnect is a failure to establish the transport.
+	PhaseConnect = "connect"
+	// PhaseRequest is a failure sending the request or reading its
+	// response headers over an established transport, or a response the
+	// retry policy rejected.
+	PhaseRequest = "request"
+)
+
+// AttemptError is one transport's failure during a race.
+type AttemptError struct {
+	// Transport is the name of the transport that failed.
+	Transport string
+	// Phase is PhaseConnect or PhaseRequest.
+	Phase string
+	Err   error
+}
+
+func (e *AttemptError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Transport, e.Err)
+}
+
+func (e *AttemptError) Unwrap() error { return e.Err }
+
+// RaceError is returned when no transport produced a usable response or
+// connection. It wraps every attempt's error, so errors.Is and errors.As see
+// through to them: callers can tell a *net.DNSError from a TLS failure from a
+// context.DeadlineExceeded without parsing messages.
+type RaceError struct {
+	// Err says why the race ended, typically wrapping the last failure.
+	Err error
+	// RequestID is the request's ID (see WithRequestID).
+	RequestID string
+	attempts  []AttemptError
+	// ctxErr is the context error if the race ran out of time.
+	ctxErr error
+}
+
+// Attempts returns each transport failure in the order they happened.
+func (e *RaceError) Attempts() []AttemptError {
+	return slices.Clone(e.attempts)
+}
+
+func (e *RaceError) Error() string {
+	if len(e.attempts) < 2 {
+		return e.Err.Error()
+	}
+	tried := make([]string, len(e.attempts))
+	for i := range e.attempts {
+		tried[i] = e.attempts[i].Error()
+	}
+	return fmt.Sprintf("%v (tried %s)", e.Err, strings.Join(tried, "; "))
+}
+
+func (e *RaceError) Unwrap() []error {
+	errs := make([]error, 0, len(e.attempts)+2)
+	errs = append(errs, e.Err)
+	for i := range e.attempts {
+		errs = append(errs, &e.attempts[i])
+	}
+	if e.ctxErr != nil {
+		errs = append(errs, e.ctxErr)
+	}
+	return errs
+}
@@ -0,0 +1,91 @@
+package kindling
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaceError(t *testing.T) {
+	t.Parallel()
+
+	t.Run("WrapsEveryAttempt", func(t *testing.T) {
+		t.Parallel()
+		dnsErr := &net.DNSError{Err: "no such host", Name: "front.example", IsNotFound: true}
+		tlsErr := tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			&mockTransport{name: "dns", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return nil, dnsErr
+			}},
+			&mockTransport{name: "tls", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				time.Sleep(20 * time.Millisecond)
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					return nil, tlsErr
+				}), nil
+			}},
+		})
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		_, err = rt.RoundTrip(req)
+		require.Error(t, err)
+
+		var raceErr *RaceError
+		require.True(t, errors.As(err, &raceErr))
+		attempts := raceErr.Attempts()
+		require.Len(t, attempts, 2)
+		assert.Equal(t, "dns", attempts[0].Transport)
+		assert.Equal(t, PhaseConnect, attempts[0].Phase)
+		assert.Equal(t, "tls", attempts[1].Transport)
+		assert.Equal(t, PhaseRequest, attempts[1].Phase)
+
+		var gotDNS *net.DNSError
+		assert.True(t, errors.As(err, &gotDNS))
+		var gotTLS tls.RecordHeaderError
+		assert.True(t, errors.As(err, &gotTLS))
+		assert.False(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Contains(t, err.Error(), "all transports failed")
+		assert.Contains(t, err.Error(), "dns: ")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			&mockTransport{name: "hang", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				<-ctx.Done()
+				return nil, errors.New("gave up")
+			}},
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		_, err = rt.RoundTrip(req)
+		var raceErr *RaceError
+		require.True(t, errors.As(err, &raceErr), "got %v", err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("DialStream", func(t *testing.T) {
+		t.Parallel()
+		refused := errors.New("connection refused")
+		k := &kindling{transports: []Transport{
+			newStreamTransport("a", transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+				return nil, refused
+			})),
+		}}
+		_, err := k.DialStream(context.Background(), "127.0.0.1:1")
+		var raceErr *RaceError
+		require.True(t, errors.As(err, &raceErr))
+		require.Len(t, raceErr.Attempts(), 1)
+		assert.Equal(t, PhaseConnect, raceErr.Attempts()[0].Phase)
+		assert.True(t, errors.Is(err, refused))
+	})
+}
@@ -1,7 +1,6 @@
 package kindling
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,6 +8,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"slices"
 	"sort"
 	"time"
 )
@@ -42,6 +42,8 @@ const IdempotentHeader = "X-Kindling-Idempotent"
 //     produced the 5xx — common when one fronting CDN is being blocked.
 //     4xx responses are NOT retried even for idempotent methods: a 4xx is
 //     the server's verdict on the request itself, so retrying won't help.
+//     WithRetryPolicy can change which outcomes fall back, cap the number
+//     of attempts, and add a backoff between them.
 //
 //   - For non-idempotent methods (POST/PUT/DELETE/PATCH/etc.), exactly one
 //     request is sent once any transport connects, and the response is
@@ -60,6 +62,10 @@ const IdempotentHeader = "X-Kindling-Idempotent"
 //     wrap idempotent reads (config fetches, status polls) where having
 //     one fronting transport blocked shouldn't cause the call to fail.
 //
+//   - WithIdempotencyMode can additionally treat requests carrying an
+//     Idempotency-Key as safe to replay, and can send replay-safe requests
+//     on every connected transport at once instead of one after another.
+//
 // Connections that fail to establish always fall back to the next transport
 // regardless of method — no body has been transmitted on a connection that
 // never came up, so replay risk is zero.
@@ -77,6 +83,115 @@ type raceTransport struct {
 	panicListener func(string)
 	appName       string
 	log           *slog.Logger
+	// domainPolicy restricts requests for a domain and its subdomains to
+	// the named transports (see WithDomainPolicy). nil allows every
+	// transport for every host.
+	domainPolicy map[string][]string
+	// hostFilter refuses requests to hosts outside WithAllowedHosts or in
+	// WithBlockedHosts.
+	hostFilter hostFilter
+	// breaker, when set, keeps transports that keep failing out of the race
+	// for a cooldown.
+	breaker *circuitBreaker
+	// retry overrides the default retry policy (see WithRetryPolicy).
+	retry *retryPolicy
+	// idempotency widens which requests may be replayed and enables
+	// parallel sends (see WithIdempotencyMode).
+	idempotency IdempotencyMode
+
+	// strategy decides how transports within a tier are attempted, with
+	// weights for WeightedRandom and the bandit for Adaptive (see
+	// WithStrategy).
+	strategy Strategy
+	weights  map[string]float64
+	bandit   *bandit
+	// headStarts hold the other transports of a tier back while the named
+	// ones connect (see WithHeadStart).
+	headStarts map[string]time.Duration
+
+	// connectTimeouts bound each transport's connect, by name, with "" for
+	// the rest (see WithConnectTimeout).
+	connectTimeouts map[string]time.Duration
+
+	// padding is the most random bytes added to each request; 0 adds none
+	// (see WithObfuscation).
+	padding int
+
+	// dials holds a slot per connect under way; nil is unbounded (see
+	// WithMaxConcurrentDials).
+	dials chan struct{}
+
+	// hostDials caps concurrent connects per host; nil is unbounded (see
+	// WithMaxDialsPerHost).
+	hostDials *hostDials
+
+	// hostMapping redirects connects for some hostnames elsewhere (see
+	// WithHostMapping).
+	hostMapping hostMapping
+
+	// chunking keeps transports whose MaxLength the body exceeds, sending
+	// the body in framed chunks instead (see WithRequestChunking).
+	chunking bool
+
+	// maxResponseBytes caps the winning response body; 0 is unlimited.
+	maxResponseBytes int64
+
+	// verifier checks response signatures; nil skips verification (see
+	// WithResponseVerification).
+	verifier *responseVerifier
+
+	// cache keeps GET responses for conditional requests and to serve
+	// when every transport fails (see WithResponseCache and
+	// WithResponseStore).
+	cache responseStore
+
+	// inFlight caps concurrent races; nil is unbounded (see
+	// WithMaxInFlight).
+	inFlight *inFlight
+
+	// flights shares one race among concurrent identical GETs; nil races
+	// each (see WithRequestCoalescing).
+	flights *flightGroup
+
+	// auth supplies each request's auth headers; nil adds none (see
+	// WithAuthProvider).
+	auth AuthProvider
+
+	// compression lists the content codings to negotiate, most preferred
+	// first; empty leaves Accept-Encoding alone (see WithCompression).
+	compression []string
+
+	// headerPolicy names the identification headers added to each request
+	// (see WithHeaderPolicy).
+	headerPolicy *HeaderPolicy
+
+	// clock times the race's deadline, connect timeouts, head starts, and
+	// retry backoff (see WithClock).
+	clock Clock
+
+	// source, when set, supplies the transports for each request in place
+	// of the fixed transports list, so a client follows AddTransport and
+	// RemoveTransport.
+	source func() []Transport
+
+	// rateLimit holds bodies to the instance's bandwidth cap; nil is
+	// unlimited (see WithRateLimit).
+	rateLimit *rateLimit
+
+	// stats records per-transport outcomes for Transports; nil skips it.
+	stats *transportStats
+
+	// pool keeps connected round-trippers for reuse; nil connects afresh
+	// for every request.
+	pool *roundTripperPool
+
+	// closed is done once the owning Kindling is closed; nil if there is
+	// none.
+	closed context.Context
+
+	// country returns the strategy for the user's country, or nil; nil
+	// skips it (see WithCountryHint).
+	country func() *CountryStrategy
 }
 
 func newRaceTransport(appName string, log *slog.Logger, panicListener func(string), transports []Transport) *raceTransport {
@@ -85,6 +200,8 @@ func newRaceTransport(appName string, log *slog.Logger, panicListener func(strin
 		panicListener: panicListener,
 		appName:       appName,
 		log:           log,
+		headerPolicy:  &defaultHeaderPolicy,
+		clock:         systemClock,
 	}
 }
 
@@ -93,24 +210,101 @@ type connectResult struct {
 	rt   http.RoundTripper
 	name string
 	err  error
+	// unused hands a connected rt that won't carry a request back to the
+	// pool.
+	unused func()
 }
 
 func (t *raceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	bodyBytes, err := drainRequestBody(req)
+	err := t.hostFilter.check(req.URL.Hostname())
+	if err == nil && t.closed != nil && t.closed.Err() != nil {
+		err = ErrClosed
+	}
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	req = t.withRequestID(req)
+	if t.auth != nil {
+		return t.authorized(req)
+	}
+	return t.dispatch(req)
+}
+
+// dispatch races req, or shares the race of an identical request already
+// under way.
+func (t *raceTransport) dispatch(req *http.Request) (*http.Response, error) {
+	if t.flights != nil {
+		if key, ok := coalesceKey(req); ok {
+			return t.flights.do(key, req, t.roundTrip)
+		}
+	}
+	return t.roundTrip(req)
+}
+
+// roundTrip answers req from the cache or races it.
+func (t *raceTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.inFlight.acquire(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	defer release()
+	if t.cache != nil && cacheable(req) {
+		return t.cachedRoundTrip(req)
+	}
+	return t.race(req)
+}
+
+// race sends req over the eligible transports, tier by tier.
+func (t *raceTransport) race(req *http.Request) (resp *http.Response, err error) {
+	body, err := newRequestBody(req, bodySpillThreshold)
 	if err != nil {
 		return nil, fmt.Errorf("reading request body: %w", err)
 	}
+	defer func() { resp = body.releaseAfter(limitResponse(resp, t.maxResponseBytes)) }()
 
-	eligible := t.filterTransports(req, bodyBytes)
+	eligible := t.filterTransports(req, body.Len())
 	if len(eligible) == 0 {
 		return nil, errors.New("no eligible transports for request")
 	}
+	if allowed, domain, ok := policyFor(t.domainPolicy, req.URL.Hostname()); ok {
+		eligible = slices.DeleteFunc(eligible, func(tr Transport) bool {
+			return !slices.Contains(allowed, tr.Name())
+		})
+		if len(eligible) == 0 {
+			return nil, fmt.Errorf("no eligible transports for request: domain policy for %q allows only %v", domain, allowed)
+		}
+	}
+	if eligible, err = applyTransportHint(req.Context(), eligible); err != nil {
+		return nil, err
+	}
+	var country *CountryStrategy
+	if t.country != nil {
+		country = t.country()
+	}
+	eligible = t.skipTripped(req.Context(), country.skip(eligible))
 
-	ctx, cancel := context.WithTimeout(req.Context(), t.requestTimeout(req, eligible))
+	log := t.logFor(req.Context())
+	ctx, cancel := raceContext(req.Context(), t.clock, t.requestTimeout(req, eligible), log)
 	defer cancel()
-
-	idempotent := isRetryableMethod(req.Method) || req.Header.Get(IdempotentHeader) != ""
-	tiers := groupByPriority(eligible)
+	defer stopOnClose(t.closed, cancel)()
+	ctx, addr := t.dialTarget(ctx, hostWithPort(req.URL.Host, req.URL.Scheme))
+
+	rr := &raceRequest{
+		req:   req,
+		body:  body,
+		log:   log,
+		addr:  addr,
+		stats: t.stats,
+		idempotent: isRetryableMethod(req.Method) || req.Header.Get(IdempotentHeader) != "" ||
+			(t.idempotency >= IdempotencyKeyed && req.Header.Get(IdempotencyKeyHeader) != ""),
+	}
+	tiers := t.applyStrategy(country.prefer(groupByPriority(eligible)))
 
 	// Race each priority tier in turn. A tier that produces a usable response
 	// (final) returns immediately; otherwise we hold its best fallback (a 5xx
@@ -120,18 +314,28 @@ func (t *raceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	var heldResp *http.Response
 	var heldErr error
 	for i, tier := range tiers {
-		// All transports in a tier share a priority, so the first reports it.
+		// Transports in a tier share a priority, so the first reports it
+		// (a country's preferred tier may mix priorities).
 		// "tier" is the 0-based race order; "priority" is the Priority() value.
-		t.log.Debug("Racing transport tier",
+		rr.log.Debug("Racing transport tier",
 			"tier", i,
 			"priority", priorityOf(tier[0]),
 			"count", len(tier),
-			"bodyLength", len(bodyBytes),
+			"bodyLength", body.Len(),
 		)
-		res := t.raceTier(ctx, req, tier, bodyBytes, idempotent)
+		start := t.clock.Now()
+		res := t.raceTier(ctx, rr, tier)
+		if t.bandit != nil && len(tier) == 1 && req.Context().Err() == nil {
+			// A request the caller gave up on says nothing about the
+			// transport.
+			t.bandit.observe(tier[0].Name(), res.final && res.err == nil, t.clock.Now().Sub(start))
+		}
 		if res.final {
 			drainAndClose(heldResp)
-			return res.resp, res.err
+			if res.err != nil {
+				return nil, rr.raceError(res.err, nil)
+			}
+			return res.resp, nil
 		}
 		// A 5xx held by this tier supersedes an earlier tier's fallback; an
 		// empty resp leaves the earlier one in place.
@@ -161,16 +365,29 @@ func (t *raceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		// ctx.Err()); surface it directly rather than claiming every
 		// transport failed.
 		if heldErr != nil {
-			return nil, heldErr
+			return nil, rr.raceError(heldErr, ctx.Err())
 		}
-		return nil, ctx.Err()
+		return nil, rr.raceError(ctx.Err(), nil)
 	}
 	if heldErr != nil {
-		return nil, fmt.Errorf("all transports failed: %w", heldErr)
+		return nil, rr.raceError(fmt.Errorf("all transports failed: %w", heldErr), nil)
 	}
 	return nil, errors.New("no transports produced a response")
 }
 
+// raceContext bounds a race by budget, kindling's own timeout for the
+// request, unless the caller's context ends sooner, in which case the
+// caller's deadline stands alone. The deadline chosen is logged.
+func raceContext(parent context.Context, clock Clock, budget time.Duration, log *slog.Logger) (context.Context, context.CancelFunc) {
+	deadline := clock.Now().Add(budget)
+	if d, ok := parent.Deadline(); ok && d.Before(deadline) {
+		log.Debug("Using caller's deadline", "deadline", d, "remaining", d.Sub(clock.Now()), "budget", budget)
+		return context.WithCancel(parent)
+	}
+	log.Debug("Using kindling's deadline", "deadline", deadline, "budget", budget)
+	return contextWithDeadline(parent, clock, deadline)
+}
+
 // tierResult is the outcome of racing a single priority tier. When final is
 // true, resp/err are exactly what RoundTrip should return — either a usable
 // response or a single-shot non-idempotent result. When final is false the
@@ -185,46 +402,148 @@ type tierResult struct {
 	final bool
 }
 
+// raceRequest carries one RoundTrip's state across its priority tiers.
+type raceRequest struct {
+	req        *http.Request
+	body       *requestBody
+	idempotent bool
+	// log tags each line with the request's ID.
+	log *slog.Logger
+	// addr is where transports connect for the request, after any host
+	// mapping.
+	addr string
+	// stats keeps the outcomes for DumpDiagnostics; nil skips them.
+	stats *transportStats
+	// attempts counts requests sent so far, across all tiers.
+	attempts int
+	// failures records every transport failure, for RaceError.
+	failures []AttemptError
+}
+
+// fail records a transport failure.
+func (rr *raceRequest) fail(name, phase string, err error) {
+	rr.failures = append(rr.failures, AttemptError{Transport: name, Phase: phase, Err: err})
+	rr.stats.attempt(requestIDFrom(rr.req.Context()), name, phase, err)
+}
+
+// succeed records that the named transport delivered a response.
+func (rr *raceRequest) succeed(name string) {
+	rr.stats.attempt(requestIDFrom(rr.req.Context()), name, "", nil)
+}
+
+// raceError wraps err, the reason the race ended, with every failure so far.
+func (rr *raceRequest) raceError(err, ctxErr error) *RaceError {
+	return &RaceError{
+		Err:       err,
+		RequestID: requestIDFrom(rr.req.Context()),
+		attempts:  slices.Clone(rr.failures),
+		ctxErr:    ctxErr,
+	}
+}
+
 // raceTier connects every transport in a single priority tier in parallel and
 // applies the method-aware retry policy within that tier. See [raceTransport]
 // for the retry semantics; the only addition is that an exhausted tier returns
 // final=false so RoundTrip can advance to the next tier.
-func (t *raceTransport) raceTier(ctx context.Context, req *http.Request, tier []Transport, bodyBytes []byte, idempotent bool) tierResult {
+func (t *raceTransport) raceTier(ctx context.Context, rr *raceRequest, tier []Transport) tierResult {
+	if rr.idempotent && t.idempotency == IdempotencyParallel {
+		return t.raceTierParallel(ctx, rr, tier)
+	}
+	req := rr.req
+	policy := t.retryPolicy()
 	// Each goroutine sends exactly one result, so the channel receives
 	// len(tier) messages.
 	results := make(chan connectResult, len(tier))
-	addr := hostWithPort(req.URL.Host, req.URL.Scheme)
-	for _, tr := range tier {
-		go t.connect(ctx, tr, addr, results)
-	}
+	early, due, release, stop := t.startConnects(ctx, tier, rr.addr, results)
+	received := 0
+	// Whatever the tier returns, the transports still connecting have lost.
+	defer func() { stop(received) }()
 
 	var heldResp *http.Response
 	var heldErr error
+	// timedOut hands back whatever this tier held (if anything) as non-final
+	// so RoundTrip can prefer it or an earlier tier's fallback; RoundTrip
+	// stops iterating because ctx is now done.
+	timedOut := func() tierResult {
+		err := heldErr
+		if err != nil {
+			err = fmt.Errorf("timed out, last error: %w", err)
+		} else if heldResp == nil {
+			err = ctx.Err()
+		}
+		return tierResult{resp: heldResp, err: err}
+	}
 
-	for remaining := len(tier); remaining > 0; remaining-- {
+	for remaining := len(tier); remaining > 0; {
+		if release != nil && received == early {
+			// Every transport with a head start came up empty.
+			release()
+			release, due = nil, nil
+		}
 		select {
+		case <-due:
+			release()
+			release, due = nil, nil
+
 		case result := <-results:
+			remaining--
+			received++
 			if result.err != nil {
-				t.log.Error("Transport connection failed",
+				rr.log.Error("Transport connection failed",
 					"name", result.name,
 					"error", result.err,
 				)
+				t.recordFailure(ctx, result.name)
+				rr.fail(result.name, PhaseConnect, result.err)
 				heldErr = result.err
 				continue
 			}
 
-			t.log.Debug("Transport connected, sending request", "name", result.name, "method", req.Method)
-			clone := cloneRequest(req, t.appName, result.name, bodyBytes)
-			resp, err := result.rt.RoundTrip(clone)
+			if rr.attempts > 0 && policy.backoff != nil {
+				if !sleepContext(ctx, t.clock, policy.backoff(rr.attempts)) {
+					result.unused()
+					return timedOut()
+				}
+			}
+			rr.log.Debug("Transport connected, sending request", "name", result.name, "method", req.Method)
+			clone, err := cloneRequest(req, t.headerPolicy, t.appName, result.name, rr.body)
+			if err != nil {
+				// The body can't be replayed, so no transport can send it.
+				result.unused()
+				drainAndClose(heldResp)
+				rr.fail(result.name, PhaseRequest, err)
+				return tierResult{err: fmt.Errorf("replaying request body: %w", err), final: true}
+			}
+			resp, err := t.send(result.name, result.rt, clone)
+			rr.attempts++
+			if err != nil {
+				t.recordFailure(ctx, result.name)
+				rr.fail(result.name, PhaseRequest, err)
+			} else {
+				t.recordSuccess(result.name)
+				rr.succeed(result.name)
+			}
 
-			if !idempotent {
+			if !rr.idempotent {
 				// Single-shot: return whatever happened. Retrying on a non-
 				// idempotent method risks replaying side effects.
 				return tierResult{resp: resp, err: err, final: true}
 			}
 
+			if !policy.retryOn(resp, err) {
+				// Usable by the policy's lights. With the default policy
+				// that's a 2xx, 3xx, or 4xx: 4xx is the server's verdict on
+				// the request itself, retry won't help.
+				drainAndClose(heldResp)
+				if err != nil {
+					drainAndClose(resp)
+					return tierResult{err: err, final: true}
+				}
+				return tierResult{resp: resp, final: true}
+			}
+
 			if err != nil {
-				t.log.Warn("HTTP request failed on idempotent method, falling back",
+				rr.log.Warn("HTTP request failed on idempotent method, falling back",
 					"name", result.name,
 					"method", req.Method,
 					"error", err,
@@ -235,14 +554,12 @@ func (t *raceTransport) raceTier(ctx context.Context, req *http.Request, tier []
 				// defensively so we don't leak the body / connection.
 				drainAndClose(resp)
 				heldErr = err
-				continue
-			}
-
-			if resp.StatusCode >= 500 {
-				// 5xx on an idempotent method — the response may be from a
-				// blocked intermediary rather than the origin. Try the next
-				// transport. Hold this response in case nothing else works.
-				t.log.Warn("Retryable 5xx on idempotent method, falling back",
+			} else {
+				// Typically a 5xx on an idempotent method — the response may
+				// be from a blocked intermediary rather than the origin. Try
+				// the next transport. Hold this response in case nothing else
+				// works.
+				rr.log.Warn("Retryable response on idempotent method, falling back",
 					"name", result.name,
 					"method", req.Method,
 					"status", resp.StatusCode,
@@ -250,18 +567,151 @@ func (t *raceTransport) raceTier(ctx context.Context, req *http.Request, tier []
 				drainAndClose(heldResp)
 				heldResp = resp
 				heldErr = fmt.Errorf("transport %s: http status %d", result.name, resp.StatusCode)
-				continue
+				rr.fail(result.name, PhaseRequest, fmt.Errorf("http status %d", resp.StatusCode))
+			}
+
+			if policy.maxAttempts > 0 && rr.attempts >= policy.maxAttempts {
+				// Out of attempts: the best held result is the answer.
+				if heldResp != nil {
+					return tierResult{resp: heldResp, final: true}
+				}
+				return tierResult{err: fmt.Errorf("gave up after %d attempts: %w", rr.attempts, heldErr), final: true}
 			}
 
-			// 2xx, 3xx, or 4xx on an idempotent method: 4xx is the server's
-			// verdict on the request itself, retry won't help. Return.
+		case <-ctx.Done():
+			return timedOut()
+		}
+	}
+
+	// Tier exhausted without a usable response; bubble up the best fallback so
+	// RoundTrip can try the next tier (or return it if none succeed).
+	return tierResult{resp: heldResp, err: heldErr}
+}
+
+// raceTierParallel is raceTier for IdempotencyParallel: the request is sent
+// on each transport as soon as it connects instead of waiting for the
+// previous send to fail. The retry policy still decides which responses are
+// usable and caps the number of sends; backoff doesn't apply.
+func (t *raceTransport) raceTierParallel(ctx context.Context, rr *raceRequest, tier []Transport) tierResult {
+	req := rr.req
+	policy := t.retryPolicy()
+	connects := make(chan connectResult, len(tier))
+	early, due, release, stop := t.startConnects(ctx, tier, rr.addr, connects)
+	// done counts transports that came up empty, to release those held
+	// back for a head start once every early one has.
+	done := 0
+	pending := len(tier)
+	defer func() { stop(len(tier) - pending) }()
+
+	type sendResult struct {
+		name string
+		resp *http.Response
+		err  error
+		id   int
+	}
+	sends := make(chan sendResult, len(tier))
+	cancels := make(map[int]context.CancelFunc)
+	// abandon cancels every send still in flight and closes whatever they
+	// return, in the background so the winner isn't held up.
+	abandon := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		if n := len(cancels); n > 0 {
+			go func() {
+				for range n {
+					if s := <-sends; s.resp != nil {
+						s.resp.Body.Close()
+					}
+				}
+			}()
+		}
+	}
+
+	var heldResp *http.Response
+	var heldErr error
+	for pending > 0 || len(cancels) > 0 {
+		if release != nil && done == early {
+			release()
+			release, due = nil, nil
+		}
+		select {
+		case <-due:
+			release()
+			release, due = nil, nil
+
+		case result := <-connects:
+			pending--
+			if result.err != nil {
+				rr.log.Error("Transport connection failed", "name", result.name, "error", result.err)
+				t.recordFailure(ctx, result.name)
+				rr.fail(result.name, PhaseConnect, result.err)
+				heldErr = result.err
+				done++
+				continue
+			}
+			if policy.maxAttempts > 0 && rr.attempts >= policy.maxAttempts {
+				result.unused()
+				done++
+				continue
+			}
+			id := rr.attempts
+			rr.attempts++
+			sendCtx, cancel := context.WithCancel(req.Context())
+			cancels[id] = cancel
+			clone, err := cloneRequest(req.WithContext(sendCtx), t.headerPolicy, t.appName, result.name, rr.body)
+			if err != nil {
+				cancel()
+				delete(cancels, id)
+				result.unused()
+				rr.fail(result.name, PhaseRequest, err)
+				heldErr = fmt.Errorf("replaying request body: %w", err)
+				done++
+				continue
+			}
+			rr.log.Debug("Transport connected, sending request in parallel", "name", result.name, "method", req.Method)
+			go func() {
+				resp, err := t.send(result.name, result.rt, clone)
+				sends <- sendResult{name: result.name, resp: resp, err: err, id: id}
+			}()
+
+		case s := <-sends:
+			cancel := cancels[s.id]
+			delete(cancels, s.id)
+			done++
+			if s.err != nil {
+				t.recordFailure(ctx, s.name)
+				rr.fail(s.name, PhaseRequest, s.err)
+			} else {
+				t.recordSuccess(s.name)
+				rr.succeed(s.name)
+			}
+			if !policy.retryOn(s.resp, s.err) {
+				drainAndClose(heldResp)
+				abandon()
+				if s.err != nil {
+					cancel()
+					drainAndClose(s.resp)
+					return tierResult{err: s.err, final: true}
+				}
+				s.resp.Body = &cancelOnClose{ReadCloser: s.resp.Body, cancel: cancel}
+				return tierResult{resp: s.resp, final: true}
+			}
+			if s.err != nil {
+				drainAndClose(s.resp)
+				cancel()
+				heldErr = s.err
+				continue
+			}
+			// Keep the send's context alive while its response is held.
+			s.resp.Body = &cancelOnClose{ReadCloser: s.resp.Body, cancel: cancel}
 			drainAndClose(heldResp)
-			return tierResult{resp: resp, final: true}
+			heldResp = s.resp
+			heldErr = fmt.Errorf("transport %s: http status %d", s.name, s.resp.StatusCode)
+			rr.fail(s.name, PhaseRequest, fmt.Errorf("http status %d", s.resp.StatusCode))
 
 		case <-ctx.Done():
-			// Budget spent. Hand back whatever this tier held (if anything) as
-			// non-final so RoundTrip can prefer it or an earlier tier's
-			// fallback; RoundTrip stops iterating because ctx is now done.
+			abandon()
 			err := heldErr
 			if err != nil {
 				err = fmt.Errorf("timed out, last error: %w", err)
@@ -271,12 +721,50 @@ func (t *raceTransport) raceTier(ctx context.Context, req *http.Request, tier []
 			return tierResult{resp: heldResp, err: err}
 		}
 	}
-
-	// Tier exhausted without a usable response; bubble up the best fallback so
-	// RoundTrip can try the next tier (or return it if none succeed).
 	return tierResult{resp: heldResp, err: heldErr}
 }
 
+// skipTripped drops transports whose circuit breaker is open, unless that
+// would leave nothing to race.
+func (t *raceTransport) skipTripped(ctx context.Context, transports []Transport) []Transport {
+	if t.breaker == nil {
+		return transports
+	}
+	allowed := make([]Transport, 0, len(transports))
+	for _, tr := range transports {
+		if t.breaker.allow(tr.Name()) {
+			allowed = append(allowed, tr)
+		} else {
+			t.logFor(ctx).Debug("Skipping transport: circuit open", "name", tr.Name())
+		}
+	}
+	if len(allowed) == 0 {
+		return transports
+	}
+	return allowed
+}
+
+// recordSuccess notes that the named transport delivered a response, which
+// closes its circuit breaker.
+func (t *raceTransport) recordSuccess(name string) {
+	if t.breaker != nil {
+		t.breaker.success(name)
+	}
+	t.stats.success(name)
+}
+
+// recordFailure counts a transport failure toward its circuit breaker.
+// Failures caused by the request's own context ending are the caller's doing,
+// not the transport's, and don't count.
+func (t *raceTransport) recordFailure(ctx context.Context, name string) {
+	if t.breaker == nil || ctx.Err() != nil {
+		return
+	}
+	if t.breaker.failure(name) {
+		t.log.Warn("Transport failing repeatedly, pausing it", "name", name)
+	}
+}
+
 // drainAndClose drains and closes a response body so the connection can be
 // reused and nothing leaks. Safe to call with a nil response or nil body.
 func drainAndClose(resp *http.Response) {
@@ -303,27 +791,97 @@ func isRetryableMethod(method string) bool {
 	return false
 }
 
-// connect establishes a connection using the given transport and sends the
-// result (success or failure) on the results channel. Panics are recovered.
+// connect establishes a connection using the given transport, or reuses a
+// pooled one, and sends the result (success or failure) on the results
+// channel.
 func (t *raceTransport) connect(ctx context.Context, tr Transport, addr string, results chan<- connectResult) {
+	key := poolKeyFor(ctx, tr, addr)
+	pool := t.poolFor(tr)
+	rt, ok := pool.get(key)
+	var redial func(context.Context) (http.RoundTripper, error)
+	if ok {
+		t.logFor(ctx).Debug("Reusing pooled transport", "name", tr.Name(), "addr", addr)
+		redial = func(ctx context.Context) (http.RoundTripper, error) {
+			return t.newRoundTripper(ctx, tr, addr)
+		}
+	} else {
+		var err error
+		if rt, err = t.newRoundTripper(ctx, tr, addr); err != nil {
+			results <- connectResult{name: tr.Name(), err: err}
+			return
+		}
+	}
+	// The wrappers are free to modify the requests they're given, since
+	// every send gets its own clone. Bytes are counted and limited under
+	// compression, as they travel.
+	counted := t.withByteCounting(tr, t.withRateLimit(pool.wrap(key, rt, redial)))
+	results <- connectResult{
+		rt:     t.withPadding(t.withCompression(tr, counted)),
+		name:   tr.Name(),
+		unused: func() { t.park(tr, key, rt) },
+	}
+}
+
+// park keeps rt, connected by tr but not needed, in the pool for a later
+// request, or releases it if tr's round-trippers aren't pooled.
+func (t *raceTransport) park(tr Transport, key poolKey, rt http.RoundTripper) {
+	pool := t.poolFor(tr)
+	if pool == nil {
+		closeIdle(rt)
+		return
+	}
+	pool.put(key, rt)
+}
+
+// poolFor returns the pool for tr's round-trippers, or nil if they aren't
+// pooled.
+func (t *raceTransport) poolFor(tr Transport) *roundTripperPool {
+	if !reusable(tr) {
+		return nil
+	}
+	return t.pool
+}
+
+// newRoundTripper connects tr to addr. Panics are recovered.
+func (t *raceTransport) newRoundTripper(ctx context.Context, tr Transport, addr string) (rt http.RoundTripper, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			msg := fmt.Sprintf("panic in transport %s: %v", tr.Name(), r)
 			t.panicListener(msg)
-			results <- connectResult{name: tr.Name(), err: errors.New(msg)}
+			rt, err = nil, errors.New(msg)
 		}
 	}()
 
-	rt, err := tr.NewRoundTripper(ctx, addr)
+	done, err := t.acquireDial(ctx, addr)
 	if err != nil {
-		results <- connectResult{name: tr.Name(), err: err}
-		return
+		return nil, err
 	}
-	if ctx.Err() != nil {
-		results <- connectResult{name: tr.Name(), err: ctx.Err()}
-		return
+	defer done()
+
+	parent := ctx
+	timeout := t.connectTimeout(tr)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = contextWithTimeout(ctx, t.clock, timeout)
+		defer cancel()
+	}
+	rt, err = tr.NewRoundTripper(ctx, addr)
+	if err == nil {
+		if err = ctx.Err(); err != nil {
+			// Connected too late for this request, but maybe not for the
+			// next.
+			t.park(tr, poolKeyFor(ctx, tr, addr), rt)
+		}
 	}
-	results <- connectResult{rt: rt, name: tr.Name()}
+	if err != nil {
+		if timeout > 0 && ctx.Err() != nil && parent.Err() == nil {
+			// Not context.DeadlineExceeded: the request itself still has
+			// time for other transports.
+			return nil, fmt.Errorf("connect timed out after %v", timeout)
+		}
+		return nil, err
+	}
+	return rt, nil
 }
 
 // transportPriority is an optional interface a Transport may implement to
@@ -368,20 +926,34 @@ func groupByPriority(transports []Transport) [][]Transport {
 
 // filterTransports returns only the transports eligible for this request,
 // based on body size limits and streaming support.
-func (t *raceTransport) filterTransports(req *http.Request, bodyBytes []byte) []Transport {
+func (t *raceTransport) filterTransports(req *http.Request, bodySize int64) []Transport {
 	isStreaming := req.Header.Get("Accept") == "text/event-stream"
-	eligible := make([]Transport, 0, len(t.transports))
-	for _, tr := range t.transports {
-		if tr.MaxLength() > 0 && len(bodyBytes) > tr.MaxLength() {
-			t.log.Debug("Skipping transport: body exceeds limit",
+	log := t.logFor(req.Context())
+	transports := t.transports
+	if t.source != nil {
+		transports = t.source()
+	}
+	eligible := make([]Transport, 0, len(transports))
+	for _, tr := range transports {
+		if tr.MaxLength() > 0 && bodySize > int64(tr.MaxLength()) && t.chunking && !isStreaming {
+			log.Debug("Chunking body for length-limited transport",
+				"name", tr.Name(),
+				"bodySize", bodySize,
+				"maxLength", tr.MaxLength(),
+			)
+			eligible = append(eligible, &chunkedTransport{Transport: tr})
+			continue
+		}
+		if tr.MaxLength() > 0 && bodySize > int64(tr.MaxLength()) {
+			log.Debug("Skipping transport: body exceeds limit",
 				"name", tr.Name(),
-				"bodySize", len(bodyBytes),
+				"bodySize", bodySize,
 				"maxLength", tr.MaxLength(),
 			)
 			continue
 		}
 		if isStreaming && !tr.IsStreamable() {
-			t.log.Debug("Skipping non-streamable transport",
+			log.Debug("Skipping non-streamable transport",
 				"name", tr.Name(),
 			)
 			continue
@@ -407,17 +979,27 @@ func hostWithPort(host, scheme string) string {
 	return net.JoinHostPort(host, "80")
 }
 
-// cloneRequest creates a copy of the HTTP request with the body replaced by
-// bodyBytes and Kindling-specific tracing headers added.
-func cloneRequest(req *http.Request, app, method string, bodyBytes []byte) *http.Request {
+// cloneRequest creates a copy of the HTTP request with a fresh reader over
+// body and the identification headers from policy added. GetBody is set too,
+// so the underlying transport can replay the body on its own connection
+// retries.
+func cloneRequest(req *http.Request, policy *HeaderPolicy, app, method string, body *requestBody) (*http.Request, error) {
 	clone := req.Clone(req.Context())
-	clone.Header.Set("X-Kindling-App", app)
-	clone.Header.Set("X-Kindling-Method", method)
-	if req.Body != nil && req.Body != http.NoBody && len(bodyBytes) > 0 {
-		clone.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-		clone.ContentLength = int64(len(bodyBytes))
+	policy.apply(clone.Header, app, method, requestIDFrom(req.Context()))
+	if body != nil {
+		r, err := body.reader()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = r
+		clone.GetBody = body.reader
+		clone.ContentLength = body.Len()
+		if len(req.Trailer) > 0 {
+			// HTTP/1.1 only sends trailers with a chunked body.
+			clone.ContentLength = -1
+		}
 	}
-	return clone
+	return clone, nil
 }
 
 // requestTimeout returns the race budget for the request, using the
@@ -436,17 +1018,3 @@ func (t *raceTransport) requestTimeout(req *http.Request, eligible []Transport)
 	}
 	return base
 }
-
-// drainRequestBody reads the full request body into a byte slice and restores
-// it on the request. Returns nil for requests with no body.
-func drainRequestBody(req *http.Request) ([]byte, error) {
-	if req.Body == nil || req.Body == http.NoBody {
-		return nil, nil
-	}
-	bodyBytes, err := io.ReadAll(req.Body)
-	if err != nil {
-		return nil, err
-	}
-	req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-	return bodyBytes, nil
-}
@@ -27,6 +27,7 @@ type mockTransport struct {
 	maxLength       int
 	reqTimeout      time.Duration
 	priority        int
+	reusable        bool
 	newRoundTripper func(ctx context.Context, addr string) (http.RoundTripper, error)
 }
 
@@ -35,10 +36,24 @@ func (m *mockTransport) IsStreamable() bool            { return m.isStreamable }
 func (m *mockTransport) MaxLength() int                { return m.maxLength }
 func (m *mockTransport) RequestTimeout() time.Duration { return m.reqTimeout }
 func (m *mockTransport) Priority() int                 { return m.priority }
+func (m *mockTransport) Reusable() bool                { return m.reusable }
 func (m *mockTransport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
 	return m.newRoundTripper(ctx, addr)
 }
 
+// newSlowDirect returns a transport named "direct" that connects straight
+// to the origin, but only after a pause that lets a transport failing
+// alongside it in the race fail first, so the failure is always recorded.
+func newSlowDirect() *mockTransport {
+	return &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			time.Sleep(20 * time.Millisecond)
+			return http.DefaultTransport, nil
+		},
+	}
+}
+
 func TestRaceTransport_StreamingHeaderFilter(t *testing.T) {
 	t.Parallel()
 
@@ -1067,7 +1082,8 @@ func TestCloneRequest_NilBody(t *testing.T) {
 	req, err := http.NewRequest("GET", "http://example.com", nil)
 	require.NoError(t, err)
 
-	cloned := cloneRequest(req, "test", "test", nil)
+	cloned, err := cloneRequest(req, &defaultHeaderPolicy, "test", "test", nil)
+	require.NoError(t, err)
 	assert.NotSame(t, req, cloned)
 	assert.True(t, cloned.Body == nil || cloned.Body == http.NoBody,
 		"expected nil or NoBody, got %v", cloned.Body)
@@ -1077,7 +1093,8 @@ func TestCloneRequest_NoBody(t *testing.T) {
 	req, err := http.NewRequest("GET", "http://example.com", http.NoBody)
 	require.NoError(t, err)
 
-	cloned := cloneRequest(req, "test", "test", nil)
+	cloned, err := cloneRequest(req, &defaultHeaderPolicy, "test", "test", nil)
+	require.NoError(t, err)
 	assert.NotSame(t, req, cloned)
 	assert.Equal(t, http.NoBody, cloned.Body)
 }
@@ -1087,10 +1104,11 @@ func TestCloneRequest_WithBody(t *testing.T) {
 	req, err := http.NewRequest("POST", "http://example.com", io.NopCloser(strings.NewReader(originalBody)))
 	require.NoError(t, err)
 
-	bodyBytes, err := io.ReadAll(req.Body)
+	body, err := newRequestBody(req, bodySpillThreshold)
 	require.NoError(t, err)
 
-	cloned := cloneRequest(req, "test", "method-x", bodyBytes)
+	cloned, err := cloneRequest(req, &defaultHeaderPolicy, "test", "method-x", body)
+	require.NoError(t, err)
 
 	// Verify cloned body matches original content.
 	clonedBody, err := io.ReadAll(cloned.Body)
@@ -1103,6 +1121,13 @@ func TestCloneRequest_WithBody(t *testing.T) {
 
 	// Verify ContentLength is set correctly.
 	assert.Equal(t, int64(len(originalBody)), cloned.ContentLength)
+
+	// GetBody replays the same content for transport-level retries.
+	replay, err := cloned.GetBody()
+	require.NoError(t, err)
+	replayed, err := io.ReadAll(replay)
+	require.NoError(t, err)
+	assert.Equal(t, originalBody, string(replayed))
 }
 
 func TestHostWithPort(t *testing.T) {
@@ -1125,32 +1150,6 @@ func TestHostWithPort(t *testing.T) {
 	}
 }
 
-func TestDrainRequestBody(t *testing.T) {
-	t.Parallel()
-
-	t.Run("NilBody", func(t *testing.T) {
-		req, err := http.NewRequest("GET", "http://example.com", nil)
-		require.NoError(t, err)
-		body, err := drainRequestBody(req)
-		require.NoError(t, err)
-		assert.Nil(t, body)
-	})
-
-	t.Run("WithContent", func(t *testing.T) {
-		content := "request body"
-		req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(content))
-		require.NoError(t, err)
-		body, err := drainRequestBody(req)
-		require.NoError(t, err)
-		assert.Equal(t, content, string(body))
-
-		// Verify body was restored on the request.
-		restored, err := io.ReadAll(req.Body)
-		require.NoError(t, err)
-		assert.Equal(t, content, string(restored))
-	})
-}
-
 func TestRequestTimeout(t *testing.T) {
 	t.Parallel()
 
@@ -1208,9 +1207,61 @@ func TestRequestTimeout(t *testing.T) {
 		req, _ := http.NewRequest("POST", "http://example.com",
 			bytes.NewReader(make([]byte, 1000)))
 		req.ContentLength = 1000
-		eligible := rt.filterTransports(req, make([]byte, 1000))
+		eligible := rt.filterTransports(req, 1000)
 		// "slow" is filtered out, so its 10m timeout must not apply; the
 		// budget comes from the eligible "fast" transport instead.
 		assert.Equal(t, 4*time.Minute, rt.requestTimeout(req, eligible))
 	})
 }
+
+func TestRaceContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("CallerSooner", func(t *testing.T) {
+		t.Parallel()
+		var logs bytes.Buffer
+		parent, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		want, _ := parent.Deadline()
+		ctx, cancelRace := raceContext(parent, systemClock, 80*time.Second, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		defer cancelRace()
+		got, ok := ctx.Deadline()
+		require.True(t, ok)
+		assert.Equal(t, want, got)
+		assert.Contains(t, logs.String(), "caller's deadline")
+	})
+
+	t.Run("BudgetSooner", func(t *testing.T) {
+		t.Parallel()
+		var logs bytes.Buffer
+		parent, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		ctx, cancelRace := raceContext(parent, systemClock, time.Second, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		defer cancelRace()
+		got, ok := ctx.Deadline()
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), got, 500*time.Millisecond)
+		assert.Contains(t, logs.String(), "kindling's deadline")
+	})
+}
+
+func TestRaceTransport_CallerDeadlineEndsRace(t *testing.T) {
+	t.Parallel()
+	rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+		&mockTransport{
+			name: "hung",
+			newRoundTripper: func(ctx context.Context, _ string) (http.RoundTripper, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil).WithContext(ctx)
+
+	start := time.Now()
+	_, err := rt.RoundTrip(req)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
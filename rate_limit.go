@@ -0,0 +1,129 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithRateLimit holds kindling's request and response bodies to bytesPerSec
+// in each direction, shared by every transport and HTTP client of the
+// instance, so config refreshes and other background fetches leave room for
+// the app's own traffic on slow links. Up to a second's worth of bytes may
+// pass at once after an idle spell.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(k *kindling) error {
+		if bytesPerSec <= 0 {
+			return fmt.Errorf("rate limit must be positive, got %d", bytesPerSec)
+		}
+		k.rateLimit = &rateLimit{
+			sent:     newTokenBucket(bytesPerSec),
+			received: newTokenBucket(bytesPerSec),
+		}
+		return nil
+	}
+}
+
+// rateLimit is the pair of buckets WithRateLimit shares across an instance.
+type rateLimit struct {
+	sent     *tokenBucket
+	received *tokenBucket
+}
+
+// withRateLimit wraps rt so its bodies are held to the instance's rate
+// limit, if it has one.
+func (t *raceTransport) withRateLimit(rt http.RoundTripper) http.RoundTripper {
+	if t.rateLimit == nil {
+		return rt
+	}
+	return &rateLimitedRoundTripper{rt: rt, limit: t.rateLimit}
+}
+
+type rateLimitedRoundTripper struct {
+	rt    http.RoundTripper
+	limit *rateLimit
+}
+
+// RoundTrip throttles reads of the request body against the send budget
+// and reads of the response body against the receive budget.
+func (r *rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &rateLimitedBody{ReadCloser: req.Body, ctx: ctx, bucket: r.limit.sent}
+	}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil || body == http.NoBody {
+				return body, err
+			}
+			return &rateLimitedBody{ReadCloser: body, ctx: ctx, bucket: r.limit.sent}, nil
+		}
+	}
+	resp, err := r.rt.RoundTrip(req)
+	if resp != nil && resp.Body != nil {
+		resp.Body = &rateLimitedBody{ReadCloser: resp.Body, ctx: ctx, bucket: r.limit.received}
+	}
+	return resp, err
+}
+
+// rateLimitedBody waits after each read until its bucket allows the bytes
+// read, which slows the sender down through flow control.
+type rateLimitedBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	bucket *tokenBucket
+}
+
+func (b *rateLimitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := b.bucket.wait(b.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// tokenBucket lets rate bytes a second through, with bursts of up to a
+// second's worth.
+type tokenBucket struct {
+	rate float64
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(bytesPerSec),
+		now:    time.Now,
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens, blocking until the bucket has refilled enough to
+// cover them or ctx is done. Tokens are taken up front, so concurrent
+// readers queue behind each other.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := b.now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+	if deficit <= 0 {
+		return nil
+	}
+	if !sleepContext(ctx, systemClock, time.Duration(deficit/b.rate*float64(time.Second))) {
+		return ctx.Err()
+	}
+	return nil
+}
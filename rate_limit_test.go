@@ -0,0 +1,73 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, WithRateLimit(0)(&kindling{}))
+		assert.Error(t, WithRateLimit(-1)(&kindling{}))
+	})
+
+	t.Run("ThrottlesResponses", func(t *testing.T) {
+		t.Parallel()
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("x", 30_000)))
+		}))
+		t.Cleanup(origin.Close)
+		direct := &mockTransport{
+			name: "direct",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return http.DefaultTransport, nil
+			},
+		}
+		k, err := NewKindling("test", WithTransport(direct), WithRateLimit(20_000))
+		require.NoError(t, err)
+
+		start := time.Now()
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Len(t, body, 30_000)
+		// A second's burst covers 20,000 bytes; the other 10,000 take half a
+		// second more.
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+}
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	b := newTokenBucket(1000)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	assert.NoError(t, b.wait(t.Context(), 1000), "a full bucket passes a burst")
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.ErrorIs(t, b.wait(ctx, 500), context.Canceled, "an empty bucket waits")
+
+	// The canceled wait's bytes were still read, so a second's refill pays
+	// off its 500 and leaves 500.
+	now = now.Add(time.Second)
+	assert.NoError(t, b.wait(t.Context(), 500))
+	now = now.Add(time.Hour)
+	assert.NoError(t, b.wait(t.Context(), 1000), "refills are capped at a second's worth")
+	assert.ErrorIs(t, b.wait(ctx, 1), context.Canceled)
+}
@@ -0,0 +1,435 @@
+// Package rendezvous keeps a kindling instance's transports fresh from a
+// broker. Configs shipped with an app go stale in the field as fronting
+// domains, bridges, and tunnel keys get blocked; a Client fetches the
+// current transport parameters from a broker through whichever of the
+// instance's transports still work, builds transports from them, and adds
+// them to the running instance, replacing any with the same name.
+//
+// The broker answers a GET with a JSON document listing transport offers,
+// each with a type and type-specific parameters:
+//
+//	{"transports": [
+//	  {"type": "fronted", "params": {"config": "providers: ..."}},
+//	  {"type": "bridge", "params": {"bridge_line": "obfs4 192.0.2.1:443 cert=... iat-mode=0"}}
+//	]}
+//
+// "fronted" offers carry a domainfront config and replace the domain
+// fronting transport. "bridge" offers carry a Pluggable Transports bridge
+// line and need the matching client registered with WithPTClient. Other
+// types, dnstt tunnels for example, are built by functions registered with
+// WithBuilder.
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/domainfront"
+	"github.com/getlantern/kindling"
+)
+
+// fetchTimeout bounds a single broker fetch.
+const fetchTimeout = time.Minute
+
+// maxAnswerSize caps a broker answer so a misbehaving broker can't exhaust
+// memory.
+const maxAnswerSize = 1 << 20
+
+// Offer is one transport in a broker's answer.
+type Offer struct {
+	// Type selects the Builder for the offer.
+	Type string `json:"type"`
+	// Params are the type-specific parameters.
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Answer is the document a broker returns.
+type Answer struct {
+	Transports []Offer `json:"transports"`
+}
+
+// Builder builds a transport from an offer. The transport's name decides
+// which of the instance's transports it replaces. If it is an io.Closer, the
+// Client closes it once it is replaced, withdrawn, or the Client is closed.
+// ctx lasts as long as the Client.
+type Builder func(ctx context.Context, offer Offer) (kindling.Transport, error)
+
+// Option configures a Client.
+type Option func(*Client) error
+
+// WithBuilder registers b for offers of type typ, replacing any builder
+// already registered for it, the built-in ones included.
+func WithBuilder(typ string, b Builder) Option {
+	return func(c *Client) error {
+		if typ == "" {
+			return fmt.Errorf("offer type is empty")
+		}
+		if b == nil {
+			return fmt.Errorf("builder for %q is nil", typ)
+		}
+		c.builders[typ] = b
+		return nil
+	}
+}
+
+// WithPTClient registers the Pluggable Transports client binary, run with
+// args, for "bridge" offers whose method is method, e.g. "obfs4" with the
+// path to obfs4proxy. Bridge offers for methods without a client fail.
+func WithPTClient(method, binary string, args ...string) Option {
+	return func(c *Client) error {
+		if method == "" || binary == "" {
+			return fmt.Errorf("pluggable transport method and binary are required")
+		}
+		c.ptClients[method] = ptClient{binary: binary, args: args}
+		return nil
+	}
+}
+
+// WithSignatureKey requires broker answers to carry a base64 Ed25519
+// signature of the body in headerName, made with the key matching pubKey.
+// Answers can travel through intermediaries able to rewrite them, such as
+// AMP caches, and hand out transports an adversary controls, so production
+// brokers should sign them.
+func WithSignatureKey(pubKey ed25519.PublicKey, headerName string) Option {
+	return func(c *Client) error {
+		if len(pubKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(pubKey))
+		}
+		if headerName == "" {
+			return fmt.Errorf("signature header name is empty")
+		}
+		c.pubKey = pubKey
+		c.sigHeader = headerName
+		return nil
+	}
+}
+
+// WithLogger sets the logger. By default nothing is logged.
+func WithLogger(log *slog.Logger) Option {
+	return func(c *Client) error {
+		if log == nil {
+			return fmt.Errorf("logger is nil")
+		}
+		c.log = log
+		return nil
+	}
+}
+
+type ptClient struct {
+	binary string
+	args   []string
+}
+
+// Client fetches transport offers from a broker and installs them in a
+// kindling instance.
+type Client struct {
+	k         kindling.Kindling
+	url       string
+	builders  map[string]Builder
+	ptClients map[string]ptClient
+	pubKey    ed25519.PublicKey
+	sigHeader string
+	log       *slog.Logger
+
+	// ctx is handed to builders and canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu serializes refreshes and guards installed.
+	mu sync.Mutex
+	// installed are the transports this Client added, by name, with the
+	// offer each was built from.
+	installed map[string]installed
+	last      []byte
+}
+
+type installed struct {
+	offer     string
+	transport kindling.Transport
+}
+
+// New returns a Client that fetches offers from brokerURL through k.
+// Nothing is fetched until Refresh or Run is called.
+func New(k kindling.Kindling, brokerURL string, opts ...Option) (*Client, error) {
+	if k == nil {
+		return nil, fmt.Errorf("kindling instance is nil")
+	}
+	if brokerURL == "" {
+		return nil, fmt.Errorf("broker url is empty")
+	}
+	c := &Client{
+		k:         k,
+		url:       brokerURL,
+		ptClients: make(map[string]ptClient),
+		log:       slog.New(slog.DiscardHandler),
+		installed: make(map[string]installed),
+	}
+	c.builders = map[string]Builder{
+		"fronted": buildFronted,
+		"bridge":  c.buildBridge,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+// Run refreshes immediately and then every interval until ctx is done or
+// the Client is closed. Failed refreshes are logged and retried on the next
+// tick.
+func (c *Client) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Refresh(ctx); err != nil {
+			c.log.Warn("Rendezvous refresh failed", "url", c.url, slog.Any("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches the broker's answer once and installs it: offers not seen
+// before are built and added, replacing transports of the same name, and
+// transports from earlier answers that the broker no longer offers are
+// removed. An offer that fails to build is skipped, and its error is part of
+// the one returned, without holding up the others; nothing is removed then.
+func (c *Client) Refresh(ctx context.Context) error {
+	body, err := c.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		return kindling.ErrClosed
+	}
+	if bytes.Equal(body, c.last) {
+		return nil
+	}
+	var answer Answer
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return fmt.Errorf("parsing broker answer: %w", err)
+	}
+
+	var errs []error
+	offered := make(map[string]bool)
+	for _, offer := range answer.Transports {
+		key, _ := json.Marshal(offer)
+		if name, ok := c.installedFrom(string(key)); ok {
+			offered[name] = true
+			continue
+		}
+		t, err := c.build(offer)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s offer: %w", offer.Type, err))
+			continue
+		}
+		if err := c.install(t); err != nil {
+			closeTransport(t)
+			errs = append(errs, err)
+			continue
+		}
+		c.installed[t.Name()] = installed{offer: string(key), transport: t}
+		offered[t.Name()] = true
+		c.log.Info("Installed transport from broker", "type", offer.Type, "name", t.Name())
+	}
+	if len(errs) > 0 {
+		// A failed offer may have been meant to update a transport, so
+		// nothing is withdrawn until the whole answer installs.
+		return errors.Join(errs...)
+	}
+	for name, in := range c.installed {
+		if offered[name] {
+			continue
+		}
+		if err := c.k.RemoveTransport(kindling.TransportName(name)); err != nil {
+			c.log.Debug("Withdrawn transport already gone", "name", name, slog.Any("error", err))
+		}
+		closeTransport(in.transport)
+		delete(c.installed, name)
+		c.log.Info("Removed transport withdrawn by broker", "name", name)
+	}
+	c.last = body
+	return nil
+}
+
+// Close stops Run and removes the transports this Client installed from the
+// instance, closing them.
+func (c *Client) Close() error {
+	c.cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for name, in := range c.installed {
+		c.k.RemoveTransport(kindling.TransportName(name))
+		errs = append(errs, closeTransport(in.transport))
+		delete(c.installed, name)
+	}
+	return errors.Join(errs...)
+}
+
+// fetch gets the broker's answer through the kindling instance.
+func (c *Client) fetch(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.k.NewHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected broker status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAnswerSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxAnswerSize {
+		return nil, fmt.Errorf("broker answer exceeds %d bytes", maxAnswerSize)
+	}
+	if c.pubKey != nil {
+		sig, err := base64.StdEncoding.DecodeString(resp.Header.Get(c.sigHeader))
+		if err != nil || !ed25519.Verify(c.pubKey, body, sig) {
+			return nil, fmt.Errorf("broker answer has no valid signature in %s", c.sigHeader)
+		}
+	}
+	return body, nil
+}
+
+// installedFrom returns the name of the transport installed from the offer
+// with JSON key, if any.
+func (c *Client) installedFrom(key string) (string, bool) {
+	for name, in := range c.installed {
+		if in.offer == key {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (c *Client) build(offer Offer) (kindling.Transport, error) {
+	b, ok := c.builders[offer.Type]
+	if !ok {
+		return nil, fmt.Errorf("no builder for offer type %q", offer.Type)
+	}
+	t, err := b(c.ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("builder returned no transport")
+	}
+	return t, nil
+}
+
+// install adds t to the instance in place of any transport with its name,
+// closing the one it replaces if this Client built it.
+func (c *Client) install(t kindling.Transport) error {
+	name := t.Name()
+	// RemoveTransport fails only when there's nothing to replace.
+	c.k.RemoveTransport(kindling.TransportName(name))
+	if err := c.k.AddTransport(t); err != nil {
+		return fmt.Errorf("adding transport %s: %w", name, err)
+	}
+	if old, ok := c.installed[name]; ok {
+		closeTransport(old.transport)
+	}
+	return nil
+}
+
+func closeTransport(t kindling.Transport) error {
+	if c, ok := t.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// buildFronted builds a domain fronting transport from a "fronted" offer,
+// whose params are {"config": "<domainfront config>"}.
+func buildFronted(ctx context.Context, offer Offer) (kindling.Transport, error) {
+	var params struct {
+		Config string `json:"config"`
+	}
+	if err := json.Unmarshal(offer.Params, &params); err != nil {
+		return nil, fmt.Errorf("parsing params: %w", err)
+	}
+	if params.Config == "" {
+		return nil, fmt.Errorf("config is empty")
+	}
+	cfg, err := domainfront.ParseConfig([]byte(params.Config))
+	if err != nil {
+		return nil, fmt.Errorf("parsing domainfront config: %w", err)
+	}
+	client, err := domainfront.New(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating domainfront client: %w", err)
+	}
+	return &frontedTransport{client: client}, nil
+}
+
+// frontedTransport races a domainfront.Client under the name WithDomainFronting
+// uses, so it replaces the shipped one.
+type frontedTransport struct {
+	client *domainfront.Client
+}
+
+func (t *frontedTransport) NewRoundTripper(ctx context.Context, addr string) (http.RoundTripper, error) {
+	return t.client.NewConnectedRoundTripper(ctx, addr)
+}
+
+func (t *frontedTransport) MaxLength() int                { return 0 }
+func (t *frontedTransport) IsStreamable() bool            { return true }
+func (t *frontedTransport) Name() string                  { return string(kindling.TransportDomainfront) }
+func (t *frontedTransport) RequestTimeout() time.Duration { return 0 }
+
+func (t *frontedTransport) Close() error {
+	t.client.Close()
+	return nil
+}
+
+// buildBridge launches the registered PT client for a "bridge" offer, whose
+// params are {"bridge_line": "<method> <addr> [fingerprint] [key=value ...]"}.
+func (c *Client) buildBridge(_ context.Context, offer Offer) (kindling.Transport, error) {
+	var params struct {
+		BridgeLine string `json:"bridge_line"`
+	}
+	if err := json.Unmarshal(offer.Params, &params); err != nil {
+		return nil, fmt.Errorf("parsing params: %w", err)
+	}
+	var method string
+	if fields := strings.Fields(params.BridgeLine); len(fields) > 0 {
+		method = fields[0]
+		if strings.EqualFold(method, "Bridge") && len(fields) > 1 {
+			method = fields[1]
+		}
+	}
+	pt, ok := c.ptClients[method]
+	if !ok {
+		return nil, fmt.Errorf("no pluggable transport client for method %q", method)
+	}
+	return kindling.NewPluggableTransport(pt.binary, pt.args, params.BridgeLine)
+}
@@ -0,0 +1,255 @@
+package rendezvous
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getlantern/kindling"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// directTransport sends requests straight to the origin.
+type directTransport struct {
+	name   string
+	closed atomic.Bool
+}
+
+func (t *directTransport) NewRoundTripper(context.Context, string) (http.RoundTripper, error) {
+	return http.DefaultTransport, nil
+}
+
+func (t *directTransport) MaxLength() int                { return 0 }
+func (t *directTransport) IsStreamable() bool            { return true }
+func (t *directTransport) Name() string                  { return t.name }
+func (t *directTransport) RequestTimeout() time.Duration { return 0 }
+
+func (t *directTransport) Close() error {
+	t.closed.Store(true)
+	return nil
+}
+
+// broker serves a settable answer, signed with key when it's set.
+type broker struct {
+	*httptest.Server
+	mu     sync.Mutex
+	answer string
+	key    ed25519.PrivateKey
+}
+
+func newBroker(t *testing.T) *broker {
+	b := &broker{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.key != nil {
+			w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(b.key, []byte(b.answer))))
+		}
+		w.Write([]byte(b.answer))
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *broker) set(answer string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.answer = answer
+}
+
+// testBuilder builds directTransports named by the offer's params, recording
+// each one built.
+type testBuilder struct {
+	mu    sync.Mutex
+	built []*directTransport
+}
+
+func (b *testBuilder) build(_ context.Context, offer Offer) (kindling.Transport, error) {
+	var params struct {
+		Name string `json:"name"`
+		Fail bool   `json:"fail"`
+	}
+	if err := json.Unmarshal(offer.Params, &params); err != nil {
+		return nil, err
+	}
+	if params.Fail {
+		return nil, errors.New("bad params")
+	}
+	t := &directTransport{name: params.Name}
+	b.mu.Lock()
+	b.built = append(b.built, t)
+	b.mu.Unlock()
+	return t, nil
+}
+
+func (b *testBuilder) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.built)
+}
+
+func newTestKindling(t *testing.T) kindling.Kindling {
+	k, err := kindling.NewKindling("test", kindling.WithTransport(&directTransport{name: "shipped"}))
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+func transportNames(k kindling.Kindling) []string {
+	var names []string
+	for _, info := range k.Transports() {
+		names = append(names, info.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	k := newTestKindling(t)
+
+	_, err := New(nil, "https://broker.example")
+	assert.Error(t, err)
+	_, err = New(k, "")
+	assert.Error(t, err)
+	_, err = New(k, "https://broker.example", WithBuilder("", (&testBuilder{}).build))
+	assert.Error(t, err)
+	_, err = New(k, "https://broker.example", WithPTClient("obfs4", ""))
+	assert.Error(t, err)
+	_, err = New(k, "https://broker.example", WithSignatureKey(ed25519.PublicKey("short"), "X-Signature"))
+	assert.Error(t, err)
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+
+	t.Run("InstallsReplacesAndWithdraws", func(t *testing.T) {
+		t.Parallel()
+		k := newTestKindling(t)
+		b := newBroker(t)
+		builder := &testBuilder{}
+		c, err := New(k, b.URL, WithBuilder("test", builder.build))
+		require.NoError(t, err)
+		defer c.Close()
+
+		b.set(`{"transports": [
+			{"type": "test", "params": {"name": "a"}},
+			{"type": "test", "params": {"name": "b"}}
+		]}`)
+		require.NoError(t, c.Refresh(t.Context()))
+		assert.Equal(t, []string{"a", "b", "shipped"}, transportNames(k))
+
+		// An unchanged offer isn't rebuilt.
+		b.set(`{"transports": [
+			{"type": "test", "params": {"name": "a"}},
+			{"type": "test", "params": {"name": "c"}}
+		]}`)
+		require.NoError(t, c.Refresh(t.Context()))
+		assert.Equal(t, []string{"a", "c", "shipped"}, transportNames(k))
+		assert.Equal(t, 3, builder.count())
+		assert.True(t, builder.built[1].closed.Load(), "withdrawn transport not closed")
+		assert.False(t, builder.built[0].closed.Load())
+
+		// A new offer under a shipped transport's name replaces it.
+		b.set(`{"transports": [{"type": "test", "params": {"name": "shipped", "v": 2}}]}`)
+		require.NoError(t, c.Refresh(t.Context()))
+		assert.Equal(t, []string{"shipped"}, transportNames(k))
+
+		require.NoError(t, c.Close())
+		assert.Empty(t, transportNames(k))
+		assert.True(t, builder.built[3].closed.Load(), "Close didn't close installed transport")
+	})
+
+	t.Run("FailedOfferKeepsOthers", func(t *testing.T) {
+		t.Parallel()
+		k := newTestKindling(t)
+		b := newBroker(t)
+		builder := &testBuilder{}
+		c, err := New(k, b.URL, WithBuilder("test", builder.build))
+		require.NoError(t, err)
+		defer c.Close()
+
+		b.set(`{"transports": [{"type": "test", "params": {"name": "a"}}]}`)
+		require.NoError(t, c.Refresh(t.Context()))
+
+		b.set(`{"transports": [
+			{"type": "test", "params": {"name": "b"}},
+			{"type": "test", "params": {"fail": true}},
+			{"type": "unknown"}
+		]}`)
+		err = c.Refresh(t.Context())
+		assert.ErrorContains(t, err, "bad params")
+		assert.ErrorContains(t, err, `no builder for offer type "unknown"`)
+		assert.Equal(t, []string{"a", "b", "shipped"}, transportNames(k), "nothing withdrawn after a failed offer")
+	})
+
+	t.Run("BridgeNeedsPTClient", func(t *testing.T) {
+		t.Parallel()
+		b := newBroker(t)
+		c, err := New(newTestKindling(t), b.URL)
+		require.NoError(t, err)
+		defer c.Close()
+		b.set(`{"transports": [{"type": "bridge", "params": {"bridge_line": "obfs4 192.0.2.1:443 cert=abc"}}]}`)
+		assert.ErrorContains(t, c.Refresh(t.Context()), `no pluggable transport client for method "obfs4"`)
+	})
+
+	t.Run("Signature", func(t *testing.T) {
+		t.Parallel()
+		pub, priv, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		k := newTestKindling(t)
+		b := newBroker(t)
+		c, err := New(k, b.URL, WithBuilder("test", (&testBuilder{}).build), WithSignatureKey(pub, "X-Signature"))
+		require.NoError(t, err)
+		defer c.Close()
+
+		b.set(`{"transports": [{"type": "test", "params": {"name": "a"}}]}`)
+		assert.ErrorContains(t, c.Refresh(t.Context()), "no valid signature")
+		assert.Equal(t, []string{"shipped"}, transportNames(k))
+
+		b.mu.Lock()
+		b.key = priv
+		b.mu.Unlock()
+		require.NoError(t, c.Refresh(t.Context()))
+		assert.Equal(t, []string{"a", "shipped"}, transportNames(k))
+	})
+
+	t.Run("ClosedClient", func(t *testing.T) {
+		t.Parallel()
+		b := newBroker(t)
+		b.set(`{"transports": []}`)
+		c, err := New(newTestKindling(t), b.URL)
+		require.NoError(t, err)
+		require.NoError(t, c.Close())
+		assert.ErrorIs(t, c.Refresh(t.Context()), kindling.ErrClosed)
+	})
+}
+
+func TestRunStopsOnClose(t *testing.T) {
+	t.Parallel()
+	b := newBroker(t)
+	b.set(`{"transports": []}`)
+	c, err := New(newTestKindling(t), b.URL)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		c.Run(context.Background(), time.Hour)
+		close(done)
+	}()
+	require.NoError(t, c.Close())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after Close")
+	}
+}
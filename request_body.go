@@ -0,0 +1,199 @@
+package kindling
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// bodySpillThreshold is how much of a request body raceTransport keeps in
+// memory for replay. Larger bodies without a GetBody are spilled to a temp
+// file so multi-megabyte uploads don't have to fit in RAM on mobile.
+const bodySpillThreshold = 1 << 20
+
+// maxPooledBodyBuffer caps the buffers kept in bodyBufferPool, so one large
+// body doesn't pin its memory for the life of the process.
+const maxPooledBodyBuffer = 64 << 10
+
+// bodyBufferPool recycles the buffers small bodies are read into, which for
+// concurrent small POSTs would otherwise be most of what a request
+// allocates.
+var bodyBufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func putBodyBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBodyBuffer {
+		bodyBufferPool.Put(buf)
+	}
+}
+
+// requestBody lets raceTransport replay one request body across transports
+// and retries. It prefers the caller's GetBody, which the stdlib sets for
+// bytes and strings readers, and otherwise reads the body once into memory,
+// spilling to a temp file past a threshold. A nil *requestBody means the
+// request has no body.
+type requestBody struct {
+	size    int64
+	getBody func() (io.ReadCloser, error)
+	mem     []byte
+	file    *os.File
+
+	// buf backs mem. It goes back to bodyBufferPool once the race and
+	// every reader it handed out are done with it, which refs counts:
+	// transports may close a request body after RoundTrip returns.
+	buf      *bytes.Buffer
+	refs     atomic.Int32
+	released sync.Once
+}
+
+// newRequestBody takes ownership of req.Body. Bodies of unknown length, or
+// without a GetBody, are consumed and closed here; with a usable GetBody the
+// original body is closed unread.
+func newRequestBody(req *http.Request, threshold int64) (*requestBody, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil && req.ContentLength > 0 {
+		req.Body.Close()
+		return &requestBody{size: req.ContentLength, getBody: req.GetBody}, nil
+	}
+	defer req.Body.Close()
+
+	buf := bodyBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if req.ContentLength > 0 && req.ContentLength <= threshold {
+		// ReadFrom wants MinRead bytes free before it sees EOF, so leave
+		// room for them rather than regrowing, and copying, at the end.
+		buf.Grow(int(req.ContentLength) + bytes.MinRead)
+	}
+	n, err := buf.ReadFrom(io.LimitReader(req.Body, threshold+1))
+	if err != nil {
+		putBodyBuffer(buf)
+		return nil, err
+	}
+	if n <= threshold {
+		if n == 0 {
+			putBodyBuffer(buf)
+			return nil, nil
+		}
+		b := &requestBody{size: n, mem: buf.Bytes(), buf: buf}
+		b.refs.Store(1)
+		return b, nil
+	}
+	defer putBodyBuffer(buf)
+
+	f, err := os.CreateTemp("", "kindling-body-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating spill file: %w", err)
+	}
+	b := &requestBody{file: f}
+	if b.size, err = io.Copy(f, io.MultiReader(buf, req.Body)); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Len returns the body size in bytes, or 0 for a nil body.
+func (b *requestBody) Len() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.size
+}
+
+// reader returns a fresh reader over the full body. It is safe to call
+// concurrently, so parallel sends can each stream their own copy.
+func (b *requestBody) reader() (io.ReadCloser, error) {
+	switch {
+	case b == nil:
+		return http.NoBody, nil
+	case b.getBody != nil:
+		return b.getBody()
+	case b.file != nil:
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size)), nil
+	default:
+		for {
+			refs := b.refs.Load()
+			if refs == 0 {
+				return nil, errors.New("request body already released")
+			}
+			if b.refs.CompareAndSwap(refs, refs+1) {
+				return &memBodyReader{r: bytes.NewReader(b.mem), body: b}, nil
+			}
+		}
+	}
+}
+
+// unref drops a reference to buf, returning it to the pool with the last.
+func (b *requestBody) unref() {
+	if b.refs.Add(-1) == 0 {
+		putBodyBuffer(b.buf)
+	}
+}
+
+// Close removes the spill file, if any, and lets the in-memory buffer go
+// back to the pool once no reader is left using it.
+func (b *requestBody) Close() error {
+	switch {
+	case b == nil:
+		return nil
+	case b.buf != nil:
+		b.released.Do(b.unref)
+		return nil
+	case b.file != nil:
+		return errors.Join(b.file.Close(), os.Remove(b.file.Name()))
+	}
+	return nil
+}
+
+// memBodyReader reads a pooled body. Once closed it stops reading, as the
+// buffer may then be serving another request; the lock keeps a Read racing
+// Close, as net/http may do when a request is canceled, from seeing it.
+type memBodyReader struct {
+	mu   sync.Mutex
+	r    *bytes.Reader
+	body *requestBody
+}
+
+func (m *memBodyReader) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.r == nil {
+		return 0, errors.New("read on closed request body")
+	}
+	return m.r.Read(p)
+}
+
+func (m *memBodyReader) Close() error {
+	m.mu.Lock()
+	closed := m.r == nil
+	m.r = nil
+	m.mu.Unlock()
+	if !closed {
+		m.body.unref()
+	}
+	return nil
+}
+
+// releaseAfter arranges for the body to be released once resp is done with.
+// The transport that produced resp may still be streaming the upload while
+// the caller reads the response, so a spill file is kept until resp.Body is
+// closed.
+func (b *requestBody) releaseAfter(resp *http.Response) *http.Response {
+	if b == nil || b.file == nil {
+		// Readers of an in-memory body hold their own references.
+		b.Close()
+		return resp
+	}
+	if resp == nil || resp.Body == nil {
+		b.Close()
+		return resp
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: func() { b.Close() }}
+	return resp
+}
@@ -0,0 +1,241 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// opaqueReader hides the concrete reader type so http.NewRequest can't
+// derive a GetBody from it.
+type opaqueReader struct{ io.Reader }
+
+func readAllBody(t *testing.T, b *requestBody) string {
+	t.Helper()
+	r, err := b.reader()
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestNewRequestBody(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NilBody", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		require.NoError(t, err)
+		body, err := newRequestBody(req, bodySpillThreshold)
+		require.NoError(t, err)
+		assert.Nil(t, body)
+		assert.Zero(t, body.Len())
+	})
+
+	t.Run("UsesGetBody", func(t *testing.T) {
+		t.Parallel()
+		content := "request body"
+		req, err := http.NewRequest("POST", "http://example.com", strings.NewReader(content))
+		require.NoError(t, err)
+		getBodyCalls := 0
+		getBody := req.GetBody
+		req.GetBody = func() (io.ReadCloser, error) {
+			getBodyCalls++
+			return getBody()
+		}
+
+		body, err := newRequestBody(req, bodySpillThreshold)
+		require.NoError(t, err)
+		assert.Nil(t, body.mem, "must not buffer a body it can get again")
+		assert.Equal(t, int64(len(content)), body.Len())
+		assert.Equal(t, content, readAllBody(t, body))
+		assert.Equal(t, content, readAllBody(t, body))
+		assert.Equal(t, 2, getBodyCalls)
+	})
+
+	t.Run("BuffersSmallBodyInMemory", func(t *testing.T) {
+		t.Parallel()
+		content := "request body"
+		req, err := http.NewRequest("POST", "http://example.com", opaqueReader{strings.NewReader(content)})
+		require.NoError(t, err)
+		require.Nil(t, req.GetBody)
+
+		body, err := newRequestBody(req, bodySpillThreshold)
+		require.NoError(t, err)
+		defer body.Close()
+		assert.Nil(t, body.file)
+		assert.Equal(t, int64(len(content)), body.Len())
+		assert.Equal(t, content, readAllBody(t, body))
+		assert.Equal(t, content, readAllBody(t, body))
+	})
+
+	t.Run("SpillsLargeBodyToDisk", func(t *testing.T) {
+		t.Parallel()
+		content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+		req, err := http.NewRequest("POST", "http://example.com", opaqueReader{bytes.NewReader(content)})
+		require.NoError(t, err)
+
+		body, err := newRequestBody(req, 1024)
+		require.NoError(t, err)
+		require.NotNil(t, body.file)
+		assert.Nil(t, body.mem)
+		assert.Equal(t, int64(len(content)), body.Len())
+		assert.Equal(t, string(content), readAllBody(t, body))
+		assert.Equal(t, string(content), readAllBody(t, body))
+
+		name := body.file.Name()
+		require.NoError(t, body.Close())
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err), "spill file should be removed on Close")
+	})
+
+	t.Run("SizedFromContentLength", func(t *testing.T) {
+		t.Parallel()
+		content := strings.Repeat("x", 10000)
+		req, err := http.NewRequest("POST", "http://example.com", opaqueReader{strings.NewReader(content)})
+		require.NoError(t, err)
+		req.ContentLength = int64(len(content))
+
+		body, err := newRequestBody(req, bodySpillThreshold)
+		require.NoError(t, err)
+		defer body.Close()
+		assert.Equal(t, int64(len(content)), body.Len())
+		assert.Equal(t, content, readAllBody(t, body))
+	})
+
+	t.Run("PooledBufferOutlivesReaders", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest("POST", "http://example.com", opaqueReader{strings.NewReader("request body")})
+		require.NoError(t, err)
+		body, err := newRequestBody(req, bodySpillThreshold)
+		require.NoError(t, err)
+		require.NotNil(t, body.buf)
+
+		r, err := body.reader()
+		require.NoError(t, err)
+		body.releaseAfter(&http.Response{Body: http.NoBody})
+		assert.EqualValues(t, 1, body.refs.Load(), "an open reader keeps the buffer")
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "request body", string(data))
+
+		require.NoError(t, r.Close())
+		require.NoError(t, r.Close())
+		assert.Zero(t, body.refs.Load())
+		_, err = r.Read(make([]byte, 1))
+		assert.Error(t, err, "a closed reader must not read a recycled buffer")
+		_, err = body.reader()
+		assert.Error(t, err)
+		require.NoError(t, body.Close())
+	})
+
+	t.Run("ConcurrentReaders", func(t *testing.T) {
+		t.Parallel()
+		content := strings.Repeat("0123456789", 100)
+		req, err := http.NewRequest("POST", "http://example.com", opaqueReader{strings.NewReader(content)})
+		require.NoError(t, err)
+		body, err := newRequestBody(req, bodySpillThreshold)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, err := body.reader()
+				if !assert.NoError(t, err) {
+					return
+				}
+				go r.Close()
+				io.Copy(io.Discard, r)
+			}()
+		}
+		assert.Equal(t, content, readAllBody(t, body))
+		wg.Wait()
+		require.NoError(t, body.Close())
+	})
+
+	t.Run("SpillFileKeptUntilResponseClosed", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest("POST", "http://example.com", opaqueReader{bytes.NewReader(make([]byte, 64))})
+		require.NoError(t, err)
+		body, err := newRequestBody(req, 16)
+		require.NoError(t, err)
+		name := body.file.Name()
+
+		resp := body.releaseAfter(&http.Response{Body: io.NopCloser(strings.NewReader("ok"))})
+		_, err = os.Stat(name)
+		require.NoError(t, err, "spill file must outlive RoundTrip while the response is open")
+
+		require.NoError(t, resp.Body.Close())
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestRaceTransport_ReplaysSpilledBodyAcrossTransports(t *testing.T) {
+	t.Parallel()
+
+	content := bytes.Repeat([]byte("x"), bodySpillThreshold+1)
+	var received [][]byte
+	// The fallback tier only dials after the first tier's 502, so the
+	// uploads are sequential and received needs no lock.
+	makeTransport := func(name string, priority, status int) Transport {
+		return &mockTransport{name: name, priority: priority, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				data, err := io.ReadAll(req.Body)
+				if err != nil {
+					return nil, err
+				}
+				received = append(received, data)
+				return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+			}), nil
+		}}
+	}
+	rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+		makeTransport("first", priorityDefault, http.StatusBadGateway),
+		makeTransport("second", priorityFallback, http.StatusOK),
+	})
+
+	req, err := http.NewRequest(http.MethodPut, "http://example.com/upload", opaqueReader{bytes.NewReader(content)})
+	require.NoError(t, err)
+	req.Header.Set(IdempotentHeader, "1")
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, received, 2)
+	for _, got := range received {
+		assert.True(t, bytes.Equal(content, got), "each transport must receive the full body")
+	}
+}
+
+func BenchmarkNewRequestBody(b *testing.B) {
+	content := strings.Repeat("x", 512)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req, _ := http.NewRequest("POST", "http://example.com", opaqueReader{strings.NewReader(content)})
+			req.ContentLength = int64(len(content))
+			body, err := newRequestBody(req, bodySpillThreshold)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for range 2 {
+				r, _ := body.reader()
+				io.Copy(io.Discard, r)
+				r.Close()
+			}
+			body.Close()
+		}
+	})
+}
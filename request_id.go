@@ -0,0 +1,59 @@
+package kindling
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that gives requests made with it the ID
+// id. Otherwise each request gets a random one, or, when the HeaderPolicy
+// names a RequestIDHeader, the value the request already has there. The ID
+// is attached to every log line about the request, sent in the
+// RequestIDHeader if there is one, and reported in RaceError, so client logs
+// can be matched with the origin's access logs whichever transport won.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom returns the request ID carried by ctx, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID returns req with its request ID in its context, picking one
+// if the caller hasn't.
+func (t *raceTransport) withRequestID(req *http.Request) *http.Request {
+	if requestIDFrom(req.Context()) != "" {
+		return req
+	}
+	var id string
+	if t.headerPolicy != nil && t.headerPolicy.RequestIDHeader != "" {
+		id = req.Header.Get(t.headerPolicy.RequestIDHeader)
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+	return req.WithContext(WithRequestID(req.Context(), id))
+}
+
+// newRequestID returns 16 random hex digits.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// logFor returns the logger for the request ctx belongs to, which tags each
+// line with its ID.
+func (t *raceTransport) logFor(ctx context.Context) *slog.Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return t.log.With("requestID", id)
+	}
+	return t.log
+}
@@ -0,0 +1,117 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var seen []string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r.Header.Get("X-Request-Id"))
+	}))
+	t.Cleanup(origin.Close)
+	last := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen[len(seen)-1]
+	}
+
+	direct := &mockTransport{
+		name: "direct",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return http.DefaultTransport, nil
+		},
+	}
+	var logs syncBuffer
+	k, err := NewKindling("test",
+		WithTransport(direct),
+		WithHeaderPolicy(HeaderPolicy{RequestIDHeader: "X-Request-Id"}),
+		WithLogWriter(&logs),
+	)
+	require.NoError(t, err)
+	client := k.NewHTTPClient()
+
+	t.Run("Generated", func(t *testing.T) {
+		for range 2 {
+			resp, err := client.Get(origin.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		mu.Lock()
+		first, second := seen[len(seen)-2], seen[len(seen)-1]
+		mu.Unlock()
+		assert.Len(t, first, 16)
+		assert.NotEqual(t, first, second)
+		assert.Contains(t, logs.String(), "requestID="+second)
+	})
+
+	t.Run("FromContext", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(WithRequestID(t.Context(), "abc123"), http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "abc123", last())
+	})
+
+	t.Run("FromHeader", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-Id", "from-header")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "from-header", last())
+	})
+}
+
+func TestRequestIDInRaceError(t *testing.T) {
+	t.Parallel()
+
+	broken := &mockTransport{
+		name: "broken",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return nil, errors.New("blocked")
+		},
+	}
+	k, err := NewKindling("test", WithTransport(broken))
+	require.NoError(t, err)
+	req, err := http.NewRequestWithContext(WithRequestID(t.Context(), "abc123"), http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+	_, err = k.NewHTTPClient().Do(req)
+	var raceErr *RaceError
+	require.ErrorAs(t, err, &raceErr)
+	assert.Equal(t, "abc123", raceErr.RequestID)
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
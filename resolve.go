@@ -0,0 +1,45 @@
+package kindling
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// defaultResolveURL is the DNS-over-HTTPS server Resolve asks without
+// WithDoHResolver.
+const defaultResolveURL = "https://dns.google/dns-query"
+
+// Resolve looks host up with a DNS-over-HTTPS query sent like any other
+// request, over whichever transport wins the race, so a poisoned or
+// censored local resolver never sees the name. It asks the WithDoHResolver
+// server if there is one, or else Google Public DNS. The server needn't be
+// in WithAllowedHosts, though WithBlockedHosts still applies to it. IPv4
+// addresses come first. An IP literal is returned as is.
+func (k *kindling) Resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []net.IP{addr.AsSlice()}, nil
+	}
+	serverURL := defaultResolveURL
+	if k.dohURL != nil {
+		serverURL = k.dohURL.String()
+	}
+	// The allowed hosts are the app's own; the resolver is kindling's.
+	rt := k.newRaceTransport(nil)
+	rt.source = k.snapshot
+	rt.hostFilter.allowed = nil
+	r := &dohResolver{
+		url:    serverURL,
+		client: &http.Client{Timeout: dohTimeout, Transport: rt},
+	}
+	addrs, err := r.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.AsSlice()
+	}
+	return ips, nil
+}
@@ -0,0 +1,108 @@
+package kindling_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/getlantern/kindling"
+	"github.com/getlantern/kindling/kindlingtest"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	// dohServer answers for example.com with 192.0.2.1 and 2001:db8::1,
+	// and NXDOMAIN for anything else.
+	dohServer := func(t *testing.T, queries *atomic.Int32) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries.Add(1)
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			var q dnsmessage.Message
+			require.NoError(t, q.Unpack(body))
+			question := q.Questions[0]
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: q.ID, Response: true},
+				Questions: q.Questions,
+			}
+			hdr := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case question.Name.String() != "example.com.":
+				resp.RCode = dnsmessage.RCodeNameError
+			case question.Type == dnsmessage.TypeA:
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}})
+			case question.Type == dnsmessage.TypeAAAA:
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}})
+			}
+			packed, err := resp.Pack()
+			require.NoError(t, err)
+			w.Header().Set("Content-Type", "application/dns-message")
+			w.Write(packed)
+		})
+	}
+	newKindling := func(t *testing.T, queries *atomic.Int32, opts ...kindling.Option) kindling.Kindling {
+		t.Helper()
+		k, err := kindling.NewKindling("test", append([]kindling.Option{
+			kindling.WithLogWriter(io.Discard),
+			kindling.WithTransport(kindlingtest.HandlerTransport(dohServer(t, queries))),
+			kindling.WithDoHResolver("https://doh.example/dns-query"),
+		}, opts...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		return k
+	}
+
+	t.Run("ThroughTransport", func(t *testing.T) {
+		t.Parallel()
+		var queries atomic.Int32
+		ips, err := newKindling(t, &queries).Resolve(context.Background(), "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 1).To4(), net.ParseIP("2001:db8::1")}, ips)
+		assert.EqualValues(t, 2, queries.Load())
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		var queries atomic.Int32
+		_, err := newKindling(t, &queries).Resolve(context.Background(), "missing.example")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		assert.True(t, dnsErr.IsNotFound)
+	})
+
+	t.Run("ResolverNotInAllowedHosts", func(t *testing.T) {
+		t.Parallel()
+		var queries atomic.Int32
+		k := newKindling(t, &queries, kindling.WithAllowedHosts("api.example"))
+		ips, err := k.Resolve(context.Background(), "example.com")
+		require.NoError(t, err)
+		assert.Len(t, ips, 2)
+		_, err = k.NewHTTPClient().Get("https://doh.example/dns-query")
+		assert.ErrorIs(t, err, kindling.ErrHostNotAllowed, "other requests to the resolver are still filtered")
+	})
+
+	t.Run("ResolverBlocked", func(t *testing.T) {
+		t.Parallel()
+		var queries atomic.Int32
+		_, err := newKindling(t, &queries, kindling.WithBlockedHosts("doh.example")).Resolve(context.Background(), "example.com")
+		assert.ErrorIs(t, err, kindling.ErrHostNotAllowed)
+		assert.Zero(t, queries.Load())
+	})
+
+	t.Run("IPLiteral", func(t *testing.T) {
+		t.Parallel()
+		var queries atomic.Int32
+		ips, err := newKindling(t, &queries).Resolve(context.Background(), "198.51.100.7")
+		require.NoError(t, err)
+		assert.Equal(t, []net.IP{net.IPv4(198, 51, 100, 7).To4()}, ips)
+		assert.Zero(t, queries.Load())
+	})
+}
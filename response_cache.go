@@ -0,0 +1,325 @@
+package kindling
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheHeader is set on responses served from the WithResponseCache cache or
+// a WithResponseStore store. Its value is "stale" when the stored copy stood
+// in for a failed fetch, and "revalidated" when the origin confirmed it with
+// a 304 Not Modified.
+const CacheHeader = "X-Kindling-Cache"
+
+// WithResponseCache keeps successful GET responses in dir, up to maxBytes in
+// total, and serves the cached copy when every transport fails or the origin
+// answers with a 5xx. That lets a client still boot with its last known
+// config when it's fully blocked. Requests for cached URLs are made
+// conditional on the entry's ETag or Last-Modified, so an unchanged entity
+// costs only a 304 over the wire. The least recently used entries are
+// evicted first.
+func WithResponseCache(dir string, maxBytes int64) Option {
+	return func(k *kindling) error {
+		if dir == "" {
+			return fmt.Errorf("cache directory is empty")
+		}
+		if maxBytes <= 0 {
+			return fmt.Errorf("cache size must be positive, got %d", maxBytes)
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("creating cache directory: %w", err)
+		}
+		k.cache = &responseCache{dir: dir, maxBytes: maxBytes}
+		return nil
+	}
+}
+
+// cacheable reports whether req may be answered from the cache.
+func cacheable(req *http.Request) bool {
+	return req.Method == http.MethodGet && req.Header.Get("Range") == ""
+}
+
+// responseStore is where raceTransport keeps previous entities: the on-disk
+// responseCache, or a caller's ResponseStore.
+type responseStore interface {
+	// load returns the stored response for req, or an error wrapping
+	// os.ErrNotExist if there is none.
+	load(req *http.Request) (*http.Response, error)
+	// store returns resp with its body arranged to be stored once the
+	// caller has read it in full.
+	store(req *http.Request, resp *http.Response) *http.Response
+}
+
+// conditionalHeaders are the request headers with which a caller takes
+// charge of revalidation itself.
+var conditionalHeaders = []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"}
+
+// revalidatedHeaders are the stored headers a 304 response may update.
+var revalidatedHeaders = []string{"Etag", "Last-Modified", "Cache-Control", "Expires", "Date", TransportHeader}
+
+// cachedRoundTrip races req, revalidating the stored entity if there is one,
+// and stores a successful response. When the race fails it falls back to
+// the stored copy.
+func (t *raceTransport) cachedRoundTrip(req *http.Request) (*http.Response, error) {
+	cached, cerr := t.cache.load(req)
+	if cerr != nil && !errors.Is(cerr, os.ErrNotExist) {
+		t.logFor(req.Context()).Warn("Reading cached response failed", "url", req.URL.String(), "error", cerr)
+	}
+	closeCached := func() {
+		if cached != nil {
+			cached.Body.Close()
+		}
+	}
+
+	sent, conditional := req, false
+	if cached != nil && !slices.ContainsFunc(conditionalHeaders, func(h string) bool { return req.Header.Get(h) != "" }) {
+		etag, modified := cached.Header.Get("Etag"), cached.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			sent = req.Clone(req.Context())
+			if etag != "" {
+				sent.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				sent.Header.Set("If-Modified-Since", modified)
+			}
+			conditional = true
+		}
+	}
+
+	resp, err := t.race(sent)
+	if err == nil && resp.StatusCode == http.StatusNotModified && conditional {
+		drainAndClose(resp)
+		for _, h := range revalidatedHeaders {
+			if v := resp.Header.Values(h); len(v) > 0 {
+				cached.Header[h] = v
+			}
+		}
+		cached.Header.Set(CacheHeader, "revalidated")
+		return cached, nil
+	}
+	if err == nil && resp.StatusCode < 500 {
+		closeCached()
+		if resp.StatusCode == http.StatusOK && storable(resp) {
+			return t.cache.store(req, resp), nil
+		}
+		return resp, nil
+	}
+	if req.Context().Err() != nil || cached == nil {
+		// Without a stored copy there's nothing to fall back to, and a
+		// caller that gave up isn't waiting for one.
+		closeCached()
+		return resp, err
+	}
+	t.logFor(req.Context()).Info("All transports failed, serving stale cached response", "url", req.URL.String(), "error", err)
+	drainAndClose(resp)
+	cached.Header.Del(TransportHeader)
+	cached.Header.Set(CacheHeader, "stale")
+	return cached, nil
+}
+
+// storable reports whether the origin allows resp to be kept.
+func storable(resp *http.Response) bool {
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+			return false
+		}
+	}
+	return true
+}
+
+// responseCache stores one file per URL: a JSON cacheMeta line followed by
+// the raw body. Files are written to a temp name and renamed into place, so a
+// reader never sees a partial entry.
+type responseCache struct {
+	dir      string
+	maxBytes int64
+
+	// mu serializes eviction.
+	mu sync.Mutex
+}
+
+type cacheMeta struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Stored     time.Time   `json:"stored"`
+}
+
+func (c *responseCache) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String()))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// store returns resp with its body teed into a new cache entry. The entry is
+// committed only if the caller reads the body to EOF.
+func (c *responseCache) store(req *http.Request, resp *http.Response) *http.Response {
+	f, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return resp
+	}
+	meta, _ := json.Marshal(cacheMeta{
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Stored:     time.Now(),
+	})
+	if _, err := f.Write(append(meta, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return resp
+	}
+	resp.Body = &cacheWriter{ReadCloser: resp.Body, f: f, path: c.path(req), cache: c}
+	return resp
+}
+
+// load returns the cached response for req, or an error wrapping
+// os.ErrNotExist if there is none.
+func (c *responseCache) load(req *http.Request) (*http.Response, error) {
+	path := c.path(req)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading cache entry: %w", err)
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(line, &meta); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decoding cache entry: %w", err)
+	}
+	if meta.URL != req.URL.String() {
+		f.Close()
+		return nil, fmt.Errorf("cache entry is for %s: %w", meta.URL, os.ErrNotExist)
+	}
+	var size int64 = -1
+	if info, err := f.Stat(); err == nil {
+		size = info.Size() - int64(len(line))
+	}
+	// Touch the entry so eviction sees it as recently used.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	header := meta.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", meta.StatusCode, http.StatusText(meta.StatusCode)),
+		StatusCode: meta.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body: struct {
+			io.Reader
+			io.Closer
+		}{r, f},
+		ContentLength: size,
+		Request:       req,
+	}, nil
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxBytes.
+func (c *responseCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []entry
+	var total int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), "tmp-") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, entry{filepath.Join(c.dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	slices.SortFunc(files, func(a, b entry) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			return
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
+
+// cacheWriter copies a response body into a temp file as the caller reads
+// it, and commits the file as a cache entry at EOF.
+type cacheWriter struct {
+	io.ReadCloser
+	f     *os.File
+	path  string
+	cache *responseCache
+}
+
+func (w *cacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if w.f != nil && n > 0 {
+		if _, werr := w.f.Write(p[:n]); werr != nil {
+			w.abort()
+		}
+	}
+	if err == io.EOF && w.f != nil {
+		w.commit()
+	} else if err != nil {
+		w.abort()
+	}
+	return n, err
+}
+
+func (w *cacheWriter) Close() error {
+	w.abort()
+	return w.ReadCloser.Close()
+}
+
+func (w *cacheWriter) commit() {
+	f := w.f
+	w.f = nil
+	if f.Close() != nil || os.Rename(f.Name(), w.path) != nil {
+		os.Remove(f.Name())
+		return
+	}
+	w.cache.evict()
+}
+
+func (w *cacheWriter) abort() {
+	if w.f == nil {
+		return
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+	w.f = nil
+}
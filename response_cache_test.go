@@ -0,0 +1,193 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithResponseCache(t *testing.T) {
+	t.Parallel()
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithResponseCache("", 1024))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithResponseCache(t.TempDir(), 0))
+		assert.Error(t, err)
+	})
+
+	// origin answers with the current status and body; a status of 0
+	// fails the round trip instead.
+	type origin struct {
+		status atomic.Int32
+		body   atomic.Value
+		header http.Header
+	}
+	newOrigin := func(header http.Header) (*origin, Transport) {
+		o := &origin{header: header}
+		o.status.Store(http.StatusOK)
+		o.body.Store("v1")
+		return o, &mockTransport{name: "mock", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				status := int(o.status.Load())
+				if status == 0 {
+					return nil, errors.New("blocked")
+				}
+				h := o.header.Clone()
+				if h == nil {
+					h = http.Header{}
+				}
+				return &http.Response{StatusCode: status, Header: h, Body: io.NopCloser(strings.NewReader(o.body.Load().(string))), Request: req}, nil
+			}), nil
+		}}
+	}
+	get := func(t *testing.T, client *http.Client, url string) (*http.Response, string, error) {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body), nil
+	}
+	newClient := func(t *testing.T, tr Transport, dir string, maxBytes int64) *http.Client {
+		k, err := NewKindling("test", WithTransport(tr), WithResponseCache(dir, maxBytes))
+		require.NoError(t, err)
+		return k.NewHTTPClient()
+	}
+
+	t.Run("ServesStaleOnError", func(t *testing.T) {
+		t.Parallel()
+		o, tr := newOrigin(http.Header{"Etag": {`"abc"`}})
+		client := newClient(t, tr, t.TempDir(), 1<<20)
+
+		resp, body, err := get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", body)
+		assert.Empty(t, resp.Header.Get(CacheHeader))
+		assert.Equal(t, "mock", TransportFromResponse(resp))
+
+		o.status.Store(0)
+		resp, body, err = get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", body)
+		assert.Equal(t, "stale", resp.Header.Get(CacheHeader))
+		assert.Empty(t, TransportFromResponse(resp), "no transport carried a stale copy")
+		assert.Equal(t, `"abc"`, resp.Header.Get("Etag"))
+
+		o.status.Store(http.StatusBadGateway)
+		_, body, err = get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", body, "a 5xx also falls back to the cache")
+
+		o.status.Store(0)
+		_, _, err = get(t, client, "http://example.com/other")
+		assert.Error(t, err, "no cached copy for another URL")
+	})
+
+	t.Run("UpdatesOnSuccess", func(t *testing.T) {
+		t.Parallel()
+		o, tr := newOrigin(nil)
+		client := newClient(t, tr, t.TempDir(), 1<<20)
+
+		_, _, err := get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		o.body.Store("v2")
+		_, body, err := get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", body)
+
+		o.status.Store(0)
+		_, body, err = get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", body)
+	})
+
+	t.Run("NoStoreNotCached", func(t *testing.T) {
+		t.Parallel()
+		o, tr := newOrigin(http.Header{"Cache-Control": {"private, no-store"}})
+		dir := t.TempDir()
+		client := newClient(t, tr, dir, 1<<20)
+
+		_, _, err := get(t, client, "http://example.com/config")
+		require.NoError(t, err)
+		o.status.Store(0)
+		_, _, err = get(t, client, "http://example.com/config")
+		assert.Error(t, err)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("UnreadBodyNotCached", func(t *testing.T) {
+		t.Parallel()
+		_, tr := newOrigin(nil)
+		dir := t.TempDir()
+		client := newClient(t, tr, dir, 1<<20)
+
+		resp, err := client.Get("http://example.com/config")
+		require.NoError(t, err)
+		resp.Body.Close()
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries, "partial bodies must not be committed or left behind")
+	})
+
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		t.Parallel()
+		o, tr := newOrigin(nil)
+		o.body.Store(strings.Repeat("x", 400))
+		dir := t.TempDir()
+		// Room for two entries of roughly 500 bytes each.
+		client := newClient(t, tr, dir, 1100)
+
+		cache := &responseCache{dir: dir}
+		for i, path := range []string{"/a", "/b", "/c"} {
+			url := "http://example.com" + path
+			_, _, err := get(t, client, url)
+			require.NoError(t, err)
+			// Space out the modification times, which can otherwise tie
+			// on coarse-grained filesystems.
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			when := time.Now().Add(time.Duration(i-3) * time.Hour)
+			if _, err := os.Stat(cache.path(req)); err == nil {
+				require.NoError(t, os.Chtimes(cache.path(req), when, when))
+			}
+		}
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+
+		o.status.Store(0)
+		_, _, err = get(t, client, "http://example.com/a")
+		assert.Error(t, err, "the oldest entry was evicted")
+		_, _, err = get(t, client, "http://example.com/c")
+		assert.NoError(t, err)
+	})
+
+	t.Run("PostNotCached", func(t *testing.T) {
+		t.Parallel()
+		_, tr := newOrigin(nil)
+		dir := t.TempDir()
+		client := newClient(t, tr, dir, 1<<20)
+		resp, err := client.Post("http://example.com/config", "text/plain", strings.NewReader("x"))
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
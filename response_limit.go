@@ -0,0 +1,66 @@
+package kindling
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ResponseTooLargeError is returned from reading a response body that runs
+// past the WithMaxResponseBytes limit.
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds %d bytes", e.Limit)
+}
+
+// WithMaxResponseBytes caps how much of a response body callers can read.
+// Reading past n bytes fails with a *ResponseTooLargeError, which protects
+// embedded clients from giant responses, whether malicious or mangled by an
+// intermediary.
+func WithMaxResponseBytes(n int64) Option {
+	return func(k *kindling) error {
+		if n <= 0 {
+			return fmt.Errorf("max response bytes must be positive, got %d", n)
+		}
+		k.maxResponseBytes = n
+		return nil
+	}
+}
+
+// limitResponse wraps resp's body so it yields at most limit bytes. A limit
+// of 0 disables the check.
+func limitResponse(resp *http.Response, limit int64) *http.Response {
+	if resp == nil || resp.Body == nil || limit <= 0 {
+		return resp
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit, limit: limit}
+	return resp
+}
+
+// limitedBody is an io.LimitReader that reports overflow as an error rather
+// than a silent EOF, so a truncated body is never mistaken for a whole one.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, &ResponseTooLargeError{Limit: b.limit}
+	}
+	// Read one byte past the limit to tell a body of exactly limit bytes
+	// from a longer one.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), &ResponseTooLargeError{Limit: b.limit}
+	}
+	return n, err
+}
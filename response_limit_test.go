@@ -0,0 +1,74 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMaxResponseBytes(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RejectsNonPositive", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithMaxResponseBytes(0))
+		assert.Error(t, err)
+	})
+
+	respond := func(body string) Transport {
+		return &mockTransport{name: "mock", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+			}), nil
+		}}
+	}
+	get := func(t *testing.T, body string, limit int64) ([]byte, error) {
+		k, err := NewKindling("test", WithTransport(respond(body)), WithMaxResponseBytes(limit))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get("http://example.com/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		t.Parallel()
+		got, err := get(t, "hello", 5)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(got))
+	})
+
+	t.Run("OverLimit", func(t *testing.T) {
+		t.Parallel()
+		got, err := get(t, "hello world", 5)
+		var tooLarge *ResponseTooLargeError
+		require.True(t, errors.As(err, &tooLarge), "got %v", err)
+		assert.Equal(t, int64(5), tooLarge.Limit)
+		assert.Equal(t, "hello", string(got), "bytes up to the limit are still delivered")
+	})
+}
+
+func TestLimitedBody(t *testing.T) {
+	t.Parallel()
+
+	resp := limitResponse(&http.Response{Body: io.NopCloser(strings.NewReader("abcdef"))}, 3)
+	buf := make([]byte, 2)
+	n, err := resp.Body.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = resp.Body.Read(make([]byte, 10))
+	assert.Equal(t, 1, n)
+	assert.Error(t, err)
+	_, err = resp.Body.Read(buf)
+	assert.Error(t, err, "overflow is sticky")
+
+	assert.Nil(t, limitResponse(nil, 3))
+	unlimited := &http.Response{Body: http.NoBody}
+	assert.Equal(t, http.NoBody, limitResponse(unlimited, 0).Body)
+}
@@ -0,0 +1,112 @@
+package kindling
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// maxStoredBodyBytes bounds the bodies buffered for a ResponseStore. Larger
+// responses are passed through without being stored.
+const maxStoredBodyBytes = 8 << 20
+
+// StoredResponse is a response kept in a ResponseStore.
+type StoredResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// ResponseStore keeps the last successful GET response for each URL, for
+// conditional requests and stale-on-error fallback. Implementations must be
+// safe for concurrent use.
+type ResponseStore interface {
+	// Load returns the stored response for url, or nil if there is none.
+	Load(url string) (*StoredResponse, error)
+	// Save stores resp for url, replacing any previous entry.
+	Save(url string, resp *StoredResponse) error
+}
+
+// WithResponseStore keeps previous responses in store instead of on disk,
+// for apps that already have a place to persist data. It behaves like
+// WithResponseCache, which it replaces: requests for stored URLs are made
+// conditional, a 304 returns the stored body, and the stored copy stands in
+// when every transport fails.
+func WithResponseStore(store ResponseStore) Option {
+	return func(k *kindling) error {
+		if store == nil {
+			return fmt.Errorf("response store is nil")
+		}
+		k.cache = &callerStore{ResponseStore: store, log: k.log}
+		return nil
+	}
+}
+
+// callerStore adapts a ResponseStore to responseStore.
+type callerStore struct {
+	ResponseStore
+	log *slog.Logger
+}
+
+func (s *callerStore) load(req *http.Request) (*http.Response, error) {
+	stored, err := s.Load(req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, os.ErrNotExist
+	}
+	header := stored.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", stored.StatusCode, http.StatusText(stored.StatusCode)),
+		StatusCode:    stored.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(stored.Body)),
+		ContentLength: int64(len(stored.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (s *callerStore) store(req *http.Request, resp *http.Response) *http.Response {
+	url, header, status := req.URL.String(), resp.Header.Clone(), resp.StatusCode
+	resp.Body = &bufferingBody{ReadCloser: resp.Body, done: func(body []byte) {
+		if err := s.Save(url, &StoredResponse{StatusCode: status, Header: header, Body: body}); err != nil {
+			s.log.Warn("Saving response failed", "url", url, "error", err)
+		}
+	}}
+	return resp
+}
+
+// bufferingBody collects a response body as the caller reads it and hands
+// it to done at EOF, unless it grows past maxStoredBodyBytes.
+type bufferingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	done     func([]byte)
+	overflow bool
+}
+
+func (b *bufferingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		b.buf.Write(p[:n])
+		if b.buf.Len() > maxStoredBodyBytes {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		}
+	}
+	if err == io.EOF && !b.overflow && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
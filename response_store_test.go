@@ -0,0 +1,165 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mapStore struct {
+	mu      sync.Mutex
+	entries map[string]*StoredResponse
+}
+
+func (m *mapStore) Load(url string) (*StoredResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entries[url], nil
+}
+
+func (m *mapStore) Save(url string, resp *StoredResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entries == nil {
+		m.entries = make(map[string]*StoredResponse)
+	}
+	m.entries[url] = resp
+	return nil
+}
+
+// failingStore stores nothing and fails every Save.
+type failingStore struct{}
+
+func (failingStore) Load(string) (*StoredResponse, error) { return nil, nil }
+
+func (failingStore) Save(string, *StoredResponse) error { return errors.New("disk full") }
+
+// etagOrigin serves body with an ETag, answering matching conditional
+// requests with 304, and records the conditional headers it receives.
+type etagOrigin struct {
+	mu          sync.Mutex
+	body        string
+	blocked     bool
+	ifNoneMatch []string
+}
+
+func (o *etagOrigin) transport() Transport {
+	return &mockTransport{name: "mock", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			if o.blocked {
+				return nil, errors.New("blocked")
+			}
+			etag := `"` + o.body + `"`
+			inm := req.Header.Get("If-None-Match")
+			o.ifNoneMatch = append(o.ifNoneMatch, inm)
+			h := http.Header{"Etag": {etag}}
+			if inm == etag {
+				return &http.Response{StatusCode: http.StatusNotModified, Header: h, Body: http.NoBody, Request: req}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader(o.body)), Request: req}, nil
+		}), nil
+	}}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	fetch := func(t *testing.T, client *http.Client, req *http.Request) (*http.Response, string) {
+		t.Helper()
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+	get := func(t *testing.T, client *http.Client) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/config", nil)
+		require.NoError(t, err)
+		return fetch(t, client, req)
+	}
+
+	for name, opt := range map[string]func(t *testing.T) Option{
+		"DiskCache": func(t *testing.T) Option { return WithResponseCache(t.TempDir(), 1<<20) },
+		"Store":     func(t *testing.T) Option { return WithResponseStore(&mapStore{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			o := &etagOrigin{body: "v1"}
+			k, err := NewKindling("test", WithTransport(o.transport()), opt(t))
+			require.NoError(t, err)
+			client := k.NewHTTPClient()
+
+			resp, body := get(t, client)
+			assert.Equal(t, "v1", body)
+			assert.Empty(t, resp.Header.Get(CacheHeader))
+
+			// Unchanged: the origin answers 304 and the stored body is
+			// returned as a 200.
+			resp, body = get(t, client)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "v1", body)
+			assert.Equal(t, "revalidated", resp.Header.Get(CacheHeader))
+
+			// Changed: the new entity replaces the stored one.
+			o.mu.Lock()
+			o.body = "v2"
+			o.mu.Unlock()
+			resp, body = get(t, client)
+			assert.Equal(t, "v2", body)
+			assert.Empty(t, resp.Header.Get(CacheHeader))
+
+			o.mu.Lock()
+			assert.Equal(t, []string{"", `"v1"`, `"v1"`}, o.ifNoneMatch)
+			o.blocked = true
+			o.mu.Unlock()
+			resp, body = get(t, client)
+			assert.Equal(t, "v2", body)
+			assert.Equal(t, "stale", resp.Header.Get(CacheHeader))
+		})
+	}
+
+	t.Run("CallerConditionalPassesThrough", func(t *testing.T) {
+		t.Parallel()
+		o := &etagOrigin{body: "v1"}
+		k, err := NewKindling("test", WithTransport(o.transport()), WithResponseStore(&mapStore{}))
+		require.NoError(t, err)
+		client := k.NewHTTPClient()
+		get(t, client)
+
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/config", nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", `"v1"`)
+		resp, body := fetch(t, client, req)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Empty(t, body)
+	})
+
+	t.Run("SaveErrorLogged", func(t *testing.T) {
+		t.Parallel()
+		var logs syncBuffer
+		o := &etagOrigin{body: "v1"}
+		k, err := NewKindling("test", WithLogWriter(&logs), WithTransport(o.transport()), WithResponseStore(failingStore{}))
+		require.NoError(t, err)
+		_, body := get(t, k.NewHTTPClient())
+		assert.Equal(t, "v1", body)
+		assert.Contains(t, logs.String(), "Saving response failed")
+		assert.Contains(t, logs.String(), "disk full")
+	})
+
+	t.Run("NilStore", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithResponseStore(nil))
+		assert.Error(t, err)
+	})
+}
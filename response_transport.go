@@ -0,0 +1,18 @@
+package kindling
+
+import "net/http"
+
+// TransportHeader is set on every response kindling returns to the name of
+// the transport that carried it, such as "smart" or "domainfront", so apps
+// can show how a request got through and tests can assert it. Stale
+// responses served from a WithResponseCache cache don't have it.
+const TransportHeader = "X-Kindling-Transport"
+
+// TransportFromResponse returns the name of the transport that carried resp,
+// or "" if kindling didn't fetch it over a transport.
+func TransportFromResponse(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	return resp.Header.Get(TransportHeader)
+}
@@ -0,0 +1,41 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportFromResponse(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(origin.Close)
+
+	broken := &mockTransport{name: "broken", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		return nil, errors.New("blocked")
+	}}
+	direct := &mockTransport{name: "direct", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+		return http.DefaultTransport, nil
+	}}
+	k, err := NewKindling("test", WithLogWriter(io.Discard), WithTransport(broken), WithTransport(direct))
+	require.NoError(t, err)
+	t.Cleanup(func() { k.Close() })
+
+	resp, err := k.NewHTTPClient().Get(origin.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "direct", TransportFromResponse(resp))
+	assert.Equal(t, "direct", resp.Header.Get(TransportHeader))
+
+	assert.Empty(t, TransportFromResponse(nil))
+	assert.Empty(t, TransportFromResponse(&http.Response{}))
+}
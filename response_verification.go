@@ -0,0 +1,91 @@
+package kindling
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidSignature is returned, wrapped, for a response whose body doesn't
+// match the signature required by WithResponseVerification.
+var ErrInvalidSignature = errors.New("invalid response signature")
+
+// maxVerifiedBodyBytes bounds how much of a response is buffered to check its
+// signature.
+const maxVerifiedBodyBytes = 32 << 20
+
+// WithResponseVerification requires every response to carry a base64 Ed25519
+// signature of its body in headerName, made with the key matching pubKey.
+// Responses are buffered and checked before they are returned; a missing or
+// bad signature counts as a transport failure, so replay-safe requests retry
+// on the next transport. This protects control-plane fetches that travel
+// through intermediaries able to rewrite them, such as AMP caches and DNS
+// tunnels. The origin must sign every response, errors included.
+func WithResponseVerification(pubKey ed25519.PublicKey, headerName string) Option {
+	return func(k *kindling) error {
+		if len(pubKey) != ed25519.PublicKeySize {
+			return fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(pubKey))
+		}
+		if strings.TrimSpace(headerName) == "" {
+			return fmt.Errorf("signature header name is empty")
+		}
+		k.verifier = &responseVerifier{key: pubKey, header: headerName}
+		return nil
+	}
+}
+
+type responseVerifier struct {
+	key    ed25519.PublicKey
+	header string
+}
+
+// verify buffers resp's body and checks it against the signature header. On
+// success resp.Body is replaced with the buffered copy; on failure the body
+// is closed.
+func (v *responseVerifier) verify(resp *http.Response) error {
+	sig, err := base64.StdEncoding.DecodeString(resp.Header.Get(v.header))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		drainAndClose(resp)
+		return fmt.Errorf("%w: missing or malformed %s header", ErrInvalidSignature, v.header)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVerifiedBodyBytes+1))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading response body to verify: %w", err)
+	}
+	if len(body) > maxVerifiedBodyBytes {
+		return fmt.Errorf("response body too large to verify: over %d bytes", maxVerifiedBodyBytes)
+	}
+	if !ed25519.Verify(v.key, body, sig) {
+		return ErrInvalidSignature
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return nil
+}
+
+// send performs one request on rt, the named transport's round-tripper,
+// and, with WithResponseVerification, checks the response signature,
+// turning a bad one into an error. The response is tagged with
+// TransportHeader.
+func (t *raceTransport) send(name string, rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+	resp, err := rt.RoundTrip(req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	if t.verifier != nil {
+		if err := t.verifier.verify(resp); err != nil {
+			return nil, err
+		}
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set(TransportHeader, name)
+	return resp, nil
+}
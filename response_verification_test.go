@@ -0,0 +1,91 @@
+package kindling
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithResponseVerification(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	const header = "X-Signature"
+	sign := func(body string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(body)))
+	}
+	// respond serves body with the given signature header value.
+	respond := func(name string, priority int, body, sig string) Transport {
+		return &mockTransport{name: name, priority: priority, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				h := http.Header{}
+				if sig != "" {
+					h.Set(header, sig)
+				}
+				return &http.Response{StatusCode: http.StatusOK, Header: h, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+			}), nil
+		}}
+	}
+	newClient := func(t *testing.T, transports ...Transport) *http.Client {
+		opts := []Option{WithResponseVerification(pub, header)}
+		for _, tr := range transports {
+			opts = append(opts, WithTransport(tr))
+		}
+		k, err := NewKindling("test", opts...)
+		require.NoError(t, err)
+		return k.NewHTTPClient()
+	}
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithResponseVerification(pub[:10], header))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithResponseVerification(pub, " "))
+		assert.Error(t, err)
+	})
+
+	t.Run("ValidSignature", func(t *testing.T) {
+		t.Parallel()
+		resp, err := newClient(t, respond("good", priorityDefault, "config", sign("config"))).Get("http://example.com/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "config", string(body))
+	})
+
+	t.Run("TamperedBodyRejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := newClient(t, respond("bad", priorityDefault, "evil", sign("config"))).Get("http://example.com/")
+		assert.True(t, errors.Is(err, ErrInvalidSignature), "got %v", err)
+	})
+
+	t.Run("MissingSignatureRejected", func(t *testing.T) {
+		t.Parallel()
+		_, err := newClient(t, respond("bad", priorityDefault, "config", "")).Get("http://example.com/")
+		assert.True(t, errors.Is(err, ErrInvalidSignature), "got %v", err)
+	})
+
+	t.Run("RetriesOnNextTransport", func(t *testing.T) {
+		t.Parallel()
+		client := newClient(t,
+			respond("tampering", priorityDefault, "evil", sign("config")),
+			respond("honest", priorityFallback, "config", sign("config")),
+		)
+		resp, err := client.Get("http://example.com/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "config", string(body))
+	})
+}
@@ -0,0 +1,70 @@
+package kindling
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// BackoffFunc returns how long to wait before the next attempt, given the
+// number of attempts already made (starting at 1).
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialBackoff returns a BackoffFunc that waits base after the first
+// attempt and doubles the wait after each further attempt, up to max.
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		return min(d, max)
+	}
+}
+
+// WithRetryPolicy controls how requests that may be replayed (GET, HEAD, or
+// marked with IdempotentHeader) fall back across transports:
+//
+//   - retryOn decides whether a transport's outcome is a failure to fall
+//     back from. nil keeps the default: transport errors and 5xx responses.
+//     Return true for e.g. 429 to treat rate limiting as a transport problem.
+//   - maxAttempts caps how many requests are sent in total. Zero means one
+//     per eligible transport.
+//   - backoff, if set, spaces attempts out.
+//
+// Once attempts run out, the last retryable response is returned if there
+// was one, or else the last error. Requests that can't be replayed are still
+// sent exactly once.
+func WithRetryPolicy(maxAttempts int, backoff BackoffFunc, retryOn func(*http.Response, error) bool) Option {
+	return func(k *kindling) error {
+		if maxAttempts < 0 {
+			return fmt.Errorf("retry policy max attempts must not be negative")
+		}
+		if retryOn == nil {
+			retryOn = defaultRetryOn
+		}
+		k.retry = &retryPolicy{maxAttempts: maxAttempts, backoff: backoff, retryOn: retryOn}
+		return nil
+	}
+}
+
+type retryPolicy struct {
+	maxAttempts int
+	backoff     BackoffFunc
+	retryOn     func(*http.Response, error) bool
+}
+
+var defaultRetryPolicy = &retryPolicy{retryOn: defaultRetryOn}
+
+// defaultRetryOn falls back on transport errors and 5xx responses, which may
+// come from a blocked intermediary rather than the origin.
+func defaultRetryOn(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+func (t *raceTransport) retryPolicy() *retryPolicy {
+	if t.retry != nil {
+		return t.retry
+	}
+	return defaultRetryPolicy
+}
@@ -0,0 +1,130 @@
+package kindling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	t.Parallel()
+	b := ExponentialBackoff(100*time.Millisecond, time.Second)
+	assert.Equal(t, 100*time.Millisecond, b(1))
+	assert.Equal(t, 200*time.Millisecond, b(2))
+	assert.Equal(t, 800*time.Millisecond, b(4))
+	assert.Equal(t, time.Second, b(5))
+	assert.Equal(t, time.Second, b(50))
+}
+
+func TestWithRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	// statusServer answers every request with status.
+	statusServer := func(t *testing.T, status int) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	// staggered returns transports that connect in order, each delay after
+	// the previous, routing to the given servers.
+	staggered := func(calls *atomic.Int32, servers ...*httptest.Server) []Transport {
+		var out []Transport
+		for i, srv := range servers {
+			out = append(out, &mockTransport{
+				name: srv.URL,
+				newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+					time.Sleep(time.Duration(i) * 30 * time.Millisecond)
+					calls.Add(1)
+					return &urlRewritingTransport{target: srv.URL}, nil
+				},
+			})
+		}
+		return out
+	}
+	get := func(t *testing.T, rt *raceTransport) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("InvalidMaxAttempts", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithRetryPolicy(-1, nil, nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("CustomRetryOn", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		rt := newRaceTransport("test", testLog, func(string) {},
+			staggered(&calls, statusServer(t, http.StatusTooManyRequests), statusServer(t, http.StatusOK)))
+		rt.retry = &retryPolicy{retryOn: func(resp *http.Response, err error) bool {
+			return err != nil || resp.StatusCode == http.StatusTooManyRequests
+		}}
+		assert.Equal(t, http.StatusOK, get(t, rt).StatusCode)
+
+		// The default policy treats 429 as the origin's answer.
+		rt.retry = nil
+		assert.Equal(t, http.StatusTooManyRequests, get(t, rt).StatusCode)
+	})
+
+	t.Run("MaxAttempts", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		rt := newRaceTransport("test", testLog, func(string) {},
+			staggered(&calls, statusServer(t, http.StatusBadGateway), statusServer(t, http.StatusOK)))
+		rt.retry = &retryPolicy{maxAttempts: 1, retryOn: defaultRetryOn}
+		assert.Equal(t, http.StatusBadGateway, get(t, rt).StatusCode)
+	})
+
+	t.Run("BackoffBetweenAttempts", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		var mu sync.Mutex
+		var gotAttempts []int
+		rt := newRaceTransport("test", testLog, func(string) {}, staggered(&calls,
+			statusServer(t, http.StatusInternalServerError),
+			statusServer(t, http.StatusInternalServerError),
+			statusServer(t, http.StatusOK)))
+		rt.retry = &retryPolicy{
+			retryOn: defaultRetryOn,
+			backoff: func(attempt int) time.Duration {
+				mu.Lock()
+				gotAttempts = append(gotAttempts, attempt)
+				mu.Unlock()
+				return 10 * time.Millisecond
+			},
+		}
+		assert.Equal(t, http.StatusOK, get(t, rt).StatusCode)
+		assert.Equal(t, []int{1, 2}, gotAttempts)
+	})
+
+	t.Run("BackoffRespectsDeadline", func(t *testing.T) {
+		t.Parallel()
+		var calls atomic.Int32
+		rt := newRaceTransport("test", testLog, func(string) {},
+			staggered(&calls, statusServer(t, http.StatusServiceUnavailable), statusServer(t, http.StatusOK)))
+		rt.retry = &retryPolicy{retryOn: defaultRetryOn, backoff: func(int) time.Duration { return time.Hour }}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err, "the held 503 is still the best answer")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
@@ -0,0 +1,380 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// tunnelIdleTimeout is preconnectedTransport's IdleConnTimeout, after
+	// which a stream transport's idle tunnel is closed.
+	tunnelIdleTimeout = 90 * time.Second
+	// defaultPoolIdleTimeout is how long a connected round-tripper waits in
+	// the pool for reuse. It stays under tunnelIdleTimeout.
+	defaultPoolIdleTimeout = 60 * time.Second
+	// defaultMaxIdlePerKey bounds the idle round-trippers kept per transport
+	// and host.
+	defaultMaxIdlePerKey = 4
+)
+
+// WithRoundTripperPool sets how long a connected transport is kept for
+// reuse by later requests to the same host. By default idle round-trippers
+// are kept for a minute, so repeated requests to a control-plane host skip
+// the tunnel handshake. Only the stream-based transports kindling builds,
+// and transports that declare their round-trippers reusable with a
+// Reusable() bool method, are pooled; the round-trippers of the others,
+// such as domain fronting's and the AMP cache's, are made for a single
+// request. Zero disables pooling.
+func WithRoundTripperPool(idleTimeout time.Duration) Option {
+	return func(k *kindling) error {
+		if idleTimeout < 0 {
+			return fmt.Errorf("pool idle timeout must not be negative, got %v", idleTimeout)
+		}
+		if idleTimeout == 0 {
+			k.pool = nil
+			return nil
+		}
+		k.pool = newRoundTripperPool(idleTimeout)
+		return nil
+	}
+}
+
+// ConnectionPoolConfig sizes the pool of connected transports kept for
+// reuse between requests. Zero fields keep their defaults.
+type ConnectionPoolConfig struct {
+	// MaxIdleConns bounds the idle connections kept across all hosts; when
+	// full, the one idle longest is closed. By default only
+	// MaxIdleConnsPerHost applies.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost bounds the idle connections kept per host and
+	// transport. The default is 4.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept, at most 90
+	// seconds, when its tunnel closes anyway. The default is a minute.
+	IdleConnTimeout time.Duration
+}
+
+// WithConnectionPoolConfig sizes the pool WithRoundTripperPool turns on or
+// off, for example to keep far fewer idle tunnels on a phone than a server
+// would.
+func WithConnectionPoolConfig(config ConnectionPoolConfig) Option {
+	return func(k *kindling) error {
+		if config.MaxIdleConns < 0 || config.MaxIdleConnsPerHost < 0 {
+			return fmt.Errorf("idle connection limits must not be negative, got %d and %d", config.MaxIdleConns, config.MaxIdleConnsPerHost)
+		}
+		if config.IdleConnTimeout < 0 || config.IdleConnTimeout > tunnelIdleTimeout {
+			return fmt.Errorf("idle connection timeout must be between 0 and %v, got %v", tunnelIdleTimeout, config.IdleConnTimeout)
+		}
+		idleTimeout := config.IdleConnTimeout
+		if idleTimeout == 0 {
+			idleTimeout = defaultPoolIdleTimeout
+		}
+		k.pool = newRoundTripperPool(idleTimeout)
+		k.pool.maxIdle = config.MaxIdleConns
+		if config.MaxIdleConnsPerHost > 0 {
+			k.pool.maxIdlePerKey = config.MaxIdleConnsPerHost
+		}
+		return nil
+	}
+}
+
+// poolKey identifies round-trippers that may stand in for each other.
+// Chunking wraps the transport's round-tripper, so chunked and plain ones
+// are pooled apart, as are ones presenting different TLS server names (see
+// WithSNI).
+type poolKey struct {
+	name    string
+	addr    string
+	chunked bool
+	sni     string
+}
+
+// transportReusable is an optional interface a Transport implements to have
+// its round-trippers pooled, when each can carry request after request. The
+// round-trippers of a transport that doesn't implement it are used for a
+// single request.
+type transportReusable interface {
+	Reusable() bool
+}
+
+// reusable reports whether tr's round-trippers may be pooled.
+func reusable(tr Transport) bool {
+	r, ok := tr.(transportReusable)
+	return ok && r.Reusable()
+}
+
+func poolKeyFor(ctx context.Context, tr Transport, addr string) poolKey {
+	_, chunked := tr.(*chunkedTransport)
+	return poolKey{name: tr.Name(), addr: addr, chunked: chunked, sni: sniFrom(ctx)}
+}
+
+// roundTripperPool holds connected round-trippers between requests. A
+// round-tripper is checked out for a single request and returned once its
+// response body has been read to EOF, so one that carries a single
+// connection is never shared by two requests at once. Round-trippers whose
+// request failed, whose body was abandoned, or whose connection the request
+// or response marked to close, are dropped and their connections closed.
+// One whose tunnel the peer closed while it sat idle is replaced by a newly
+// connected one when checked out. A pool is shared by every client an
+// instance creates.
+type roundTripperPool struct {
+	idleTimeout time.Duration
+	// maxIdle bounds the idle round-trippers kept in all; 0 is no limit.
+	maxIdle       int
+	maxIdlePerKey int
+	now           func() time.Time
+
+	mu     sync.Mutex
+	idle   map[poolKey][]idleRoundTripper
+	closed bool
+}
+
+type idleRoundTripper struct {
+	rt    http.RoundTripper
+	since time.Time
+}
+
+func newRoundTripperPool(idleTimeout time.Duration) *roundTripperPool {
+	return &roundTripperPool{
+		idleTimeout:   idleTimeout,
+		maxIdlePerKey: defaultMaxIdlePerKey,
+		now:           time.Now,
+		idle:          make(map[poolKey][]idleRoundTripper),
+	}
+}
+
+// get checks out the most recently used idle round-tripper for key. A nil
+// pool has none.
+func (p *roundTripperPool) get(key poolKey) (http.RoundTripper, bool) {
+	if p == nil {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(key)
+	idle := p.idle[key]
+	if len(idle) == 0 {
+		return nil, false
+	}
+	rt := idle[len(idle)-1].rt
+	p.idle[key] = idle[:len(idle)-1]
+	return rt, true
+}
+
+// wrap arranges for rt to return to the pool once it has carried a request.
+// redial, set when rt was checked out of the pool, connects afresh should
+// rt's tunnel turn out to have closed while idle. A nil pool returns rt
+// unchanged.
+func (p *roundTripperPool) wrap(key poolKey, rt http.RoundTripper, redial func(context.Context) (http.RoundTripper, error)) http.RoundTripper {
+	if p == nil {
+		return rt
+	}
+	return &pooledRoundTripper{rt: rt, pool: p, key: key, redial: redial}
+}
+
+// put returns rt to the pool.
+func (p *roundTripperPool) put(key poolKey, rt http.RoundTripper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		closeIdle(rt)
+		return
+	}
+	p.expireLocked(key)
+	idle := p.idle[key]
+	if len(idle) >= p.maxIdlePerKey {
+		closeIdle(idle[0].rt)
+		idle = idle[1:]
+	}
+	p.idle[key] = idle
+	if p.maxIdle > 0 {
+		n := 0
+		for _, idle := range p.idle {
+			n += len(idle)
+		}
+		for ; n >= p.maxIdle; n-- {
+			p.dropOldestLocked()
+		}
+	}
+	p.idle[key] = append(p.idle[key], idleRoundTripper{rt: rt, since: p.now()})
+}
+
+// dropOldestLocked closes the round-tripper that has been idle longest,
+// whatever its key.
+func (p *roundTripperPool) dropOldestLocked() {
+	var oldest poolKey
+	found := false
+	for key, idle := range p.idle {
+		if len(idle) > 0 && (!found || idle[0].since.Before(p.idle[oldest][0].since)) {
+			oldest, found = key, true
+		}
+	}
+	if !found {
+		return
+	}
+	idle := p.idle[oldest]
+	closeIdle(idle[0].rt)
+	if len(idle) == 1 {
+		delete(p.idle, oldest)
+		return
+	}
+	p.idle[oldest] = idle[1:]
+}
+
+// evict drops every idle round-tripper for the named transport, so one that
+// has been replaced or removed isn't reused.
+func (p *roundTripperPool) evict(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, idle := range p.idle {
+		if key.name != name {
+			continue
+		}
+		for _, i := range idle {
+			closeIdle(i.rt)
+		}
+		delete(p.idle, key)
+	}
+}
+
+// drain drops every idle round-tripper, leaving the pool open.
+func (p *roundTripperPool) drain() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drainLocked()
+}
+
+// close drops every idle round-tripper and closes the pool to new ones.
+func (p *roundTripperPool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drainLocked()
+	p.closed = true
+}
+
+func (p *roundTripperPool) drainLocked() {
+	for _, idle := range p.idle {
+		for _, i := range idle {
+			closeIdle(i.rt)
+		}
+	}
+	clear(p.idle)
+}
+
+func (p *roundTripperPool) expireLocked(key poolKey) {
+	idle := p.idle[key]
+	cutoff := p.now().Add(-p.idleTimeout)
+	n := 0
+	for n < len(idle) && !idle[n].since.After(cutoff) {
+		closeIdle(idle[n].rt)
+		n++
+	}
+	if n == len(idle) {
+		delete(p.idle, key)
+		return
+	}
+	p.idle[key] = idle[n:]
+}
+
+// closeIdle releases the idle connections of rt, if it has any.
+func closeIdle(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// pooledRoundTripper is a round-tripper checked out of, or newly connected
+// for, a roundTripperPool. It goes back to the pool when the response to
+// the one request it carries has been read in full, and is closed when it
+// is dropped instead.
+type pooledRoundTripper struct {
+	rt     http.RoundTripper
+	pool   *roundTripperPool
+	key    poolKey
+	redial func(context.Context) (http.RoundTripper, error)
+}
+
+func (p *pooledRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.rt.RoundTrip(req)
+	if p.redial != nil && errors.Is(err, errTunnelUsed) {
+		// The pooled tunnel closed while idle and the request never left,
+		// so even one that isn't idempotent can go over a new tunnel.
+		closeIdle(p.rt)
+		if resp, err = p.retry(req); err != nil {
+			return nil, err
+		}
+	}
+	drop := func() { closeIdle(p.rt) }
+	if err != nil || resp.Body == nil {
+		drop()
+		return resp, err
+	}
+	if req.Close || resp.Close {
+		// The connection is done for, so the round-tripper is too.
+		resp.Body = &releaseOnEOF{ReadCloser: resp.Body, release: drop, drop: drop}
+		return resp, nil
+	}
+	resp.Body = &releaseOnEOF{ReadCloser: resp.Body, release: func() { p.pool.put(p.key, p.rt) }, drop: drop}
+	return resp, nil
+}
+
+// retry sends req on a newly connected round-tripper, which takes the
+// place of the dead one.
+func (p *pooledRoundTripper) retry(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errTunnelUsed
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	rt, err := p.redial(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("reconnecting after the pooled tunnel closed: %w", err)
+	}
+	p.rt = rt
+	return p.rt.RoundTrip(req)
+}
+
+// releaseOnEOF calls release the first time its body reads to EOF, or drop
+// if it is closed before then.
+type releaseOnEOF struct {
+	io.ReadCloser
+	release func()
+	drop    func()
+	once    sync.Once
+}
+
+func (r *releaseOnEOF) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if err == io.EOF {
+		r.once.Do(r.release)
+	}
+	return n, err
+}
+
+func (r *releaseOnEOF) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.drop)
+	return err
+}
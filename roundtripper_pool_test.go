@@ -0,0 +1,292 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTripperPool(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	p := newRoundTripperPool(time.Minute)
+	p.now = func() time.Time { return now }
+	key := poolKey{name: "a", addr: "example.com:443"}
+	a, b := &http.Transport{}, &http.Transport{}
+
+	_, ok := p.get(key)
+	assert.False(t, ok)
+
+	p.put(key, a)
+	p.put(key, b)
+	rt, ok := p.get(key)
+	require.True(t, ok)
+	assert.Same(t, b, rt, "most recently used first")
+	_, ok = p.get(poolKey{name: "a", addr: "example.com:443", chunked: true})
+	assert.False(t, ok, "chunked round-trippers are pooled apart")
+
+	now = now.Add(time.Minute)
+	_, ok = p.get(key)
+	assert.False(t, ok, "idle too long")
+
+	for range defaultMaxIdlePerKey + 2 {
+		p.put(key, &http.Transport{})
+	}
+	assert.Len(t, p.idle[key], defaultMaxIdlePerKey)
+
+	p.evict("a")
+	_, ok = p.get(key)
+	assert.False(t, ok)
+
+	var nilPool *roundTripperPool
+	_, ok = nilPool.get(key)
+	assert.False(t, ok)
+	assert.Same(t, a, nilPool.wrap(key, a, nil))
+}
+
+func TestWithConnectionPoolConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, config := range []ConnectionPoolConfig{
+		{MaxIdleConns: -1},
+		{MaxIdleConnsPerHost: -1},
+		{IdleConnTimeout: -time.Second},
+		{IdleConnTimeout: 2 * time.Minute},
+	} {
+		assert.Error(t, WithConnectionPoolConfig(config)(&kindling{}), "%+v", config)
+	}
+
+	k := &kindling{}
+	require.NoError(t, WithConnectionPoolConfig(ConnectionPoolConfig{})(k))
+	assert.Equal(t, defaultPoolIdleTimeout, k.pool.idleTimeout)
+	assert.Equal(t, defaultMaxIdlePerKey, k.pool.maxIdlePerKey)
+	assert.Zero(t, k.pool.maxIdle)
+
+	require.NoError(t, WithConnectionPoolConfig(ConnectionPoolConfig{MaxIdleConns: 8, MaxIdleConnsPerHost: 1, IdleConnTimeout: 20 * time.Second})(k))
+	assert.Equal(t, 20*time.Second, k.pool.idleTimeout)
+	assert.Equal(t, 1, k.pool.maxIdlePerKey)
+	assert.Equal(t, 8, k.pool.maxIdle)
+}
+
+func TestRoundTripperPoolLimits(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	p := newRoundTripperPool(time.Minute)
+	p.now = func() time.Time { return now }
+	p.maxIdle = 3
+	p.maxIdlePerKey = 2
+	a := poolKey{name: "a", addr: "a.example:443"}
+	b := poolKey{name: "a", addr: "b.example:443"}
+
+	oldest := &http.Transport{}
+	p.put(a, oldest)
+	for range 3 {
+		now = now.Add(time.Second)
+		p.put(a, &http.Transport{})
+	}
+	assert.Len(t, p.idle[a], 2, "per-host limit")
+	assert.NotSame(t, oldest, p.idle[a][0].rt)
+
+	now = now.Add(time.Second)
+	p.put(b, &http.Transport{})
+	now = now.Add(time.Second)
+	p.put(b, &http.Transport{})
+	assert.Len(t, p.idle[a], 1, "the longest idle goes to make room")
+	assert.Len(t, p.idle[b], 2)
+}
+
+func TestRoundTripperReuse(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1<<16))
+	}))
+	t.Cleanup(origin.Close)
+
+	// tunnel is a stream transport that counts its handshakes.
+	tunnel := func(dials *atomic.Int32) Transport {
+		return newStreamTransport("tunnel", transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			dials.Add(1)
+			return (&transport.TCPDialer{}).DialStream(ctx, origin.Listener.Addr().String())
+		}))
+	}
+	get := func(t *testing.T, client *http.Client, read bool) {
+		resp, err := client.Get(origin.URL)
+		require.NoError(t, err)
+		if read {
+			_, err = io.Copy(io.Discard, resp.Body)
+			require.NoError(t, err)
+		}
+		resp.Body.Close()
+	}
+
+	t.Run("Reused", func(t *testing.T) {
+		t.Parallel()
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(tunnel(&dials)))
+		require.NoError(t, err)
+		for range 3 {
+			get(t, k.NewHTTPClient(), true)
+		}
+		assert.EqualValues(t, 1, dials.Load())
+	})
+
+	t.Run("AbandonedBodyNotReused", func(t *testing.T) {
+		t.Parallel()
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(tunnel(&dials)))
+		require.NoError(t, err)
+		get(t, k.NewHTTPClient(), false)
+		get(t, k.NewHTTPClient(), true)
+		assert.EqualValues(t, 2, dials.Load())
+	})
+
+	t.Run("ConnectionCloseNotReused", func(t *testing.T) {
+		t.Parallel()
+		closing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Connection", "close")
+			io.WriteString(w, "bye")
+		}))
+		t.Cleanup(closing.Close)
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(newStreamTransport("tunnel", transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			dials.Add(1)
+			return (&transport.TCPDialer{}).DialStream(ctx, closing.Listener.Addr().String())
+		}))))
+		require.NoError(t, err)
+		for range 2 {
+			resp, err := k.NewHTTPClient().Get(closing.URL)
+			require.NoError(t, err, "a closed connection isn't handed to the next request")
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, "bye", string(body))
+		}
+		assert.EqualValues(t, 2, dials.Load())
+	})
+
+	t.Run("DeadTunnelRedialed", func(t *testing.T) {
+		t.Parallel()
+		echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(w, r.Body)
+		}))
+		t.Cleanup(echo.Close)
+		var dials atomic.Int32
+		closed := make(chan struct{}, 2)
+		k, err := NewKindling("test", WithTransport(newStreamTransport("tunnel", transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			dials.Add(1)
+			conn, err := (&transport.TCPDialer{}).DialStream(ctx, echo.Listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return &closeSignalConn{StreamConn: conn, closed: closed}, nil
+		}))))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Post(echo.URL, "text/plain", strings.NewReader("one"))
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		// The server hangs up on the pooled tunnel, and a request that isn't
+		// idempotent goes out over a new one.
+		echo.CloseClientConnections()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the pooled tunnel wasn't closed")
+		}
+		resp, err = k.NewHTTPClient().Post(echo.URL, "text/plain", strings.NewReader("two"))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "two", string(body))
+		assert.EqualValues(t, 2, dials.Load())
+	})
+
+	t.Run("AbandonedBodyClosesTunnel", func(t *testing.T) {
+		t.Parallel()
+		closed := make(chan struct{}, 1)
+		k, err := NewKindling("test", WithTransport(newStreamTransport("tunnel", transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+			conn, err := (&transport.TCPDialer{}).DialStream(ctx, origin.Listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return &closeSignalConn{StreamConn: conn, closed: closed}, nil
+		}))))
+		require.NoError(t, err)
+		get(t, k.NewHTTPClient(), false)
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the abandoned round-tripper's tunnel was left open")
+		}
+	})
+
+	t.Run("OneShotTransportsNotPooled", func(t *testing.T) {
+		t.Parallel()
+		var connects atomic.Int32
+		oneShot := &mockTransport{name: "fronted", newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			connects.Add(1)
+			return origin.Client().Transport, nil
+		}}
+		k, err := NewKindling("test", WithTransport(oneShot))
+		require.NoError(t, err)
+		get(t, k.NewHTTPClient(), true)
+		get(t, k.NewHTTPClient(), true)
+		assert.EqualValues(t, 2, connects.Load())
+		assert.Empty(t, k.(*kindling).pool.idle)
+	})
+
+	t.Run("RemovedTransportEvicted", func(t *testing.T) {
+		t.Parallel()
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(tunnel(&dials)))
+		require.NoError(t, err)
+		get(t, k.NewHTTPClient(), true)
+		require.NoError(t, k.RemoveTransport("tunnel"))
+		require.NoError(t, k.AddTransport(tunnel(&dials)))
+		get(t, k.NewHTTPClient(), true)
+		assert.EqualValues(t, 2, dials.Load())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+		var dials atomic.Int32
+		k, err := NewKindling("test", WithTransport(tunnel(&dials)), WithRoundTripperPool(0))
+		require.NoError(t, err)
+		get(t, k.NewHTTPClient(), true)
+		get(t, k.NewHTTPClient(), true)
+		assert.EqualValues(t, 2, dials.Load())
+	})
+
+	t.Run("NegativeTimeout", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithRoundTripperPool(-time.Second))
+		assert.Error(t, err)
+	})
+}
+
+// closeSignalConn sends on closed when it is first closed.
+type closeSignalConn struct {
+	transport.StreamConn
+	closed chan<- struct{}
+	once   sync.Once
+}
+
+func (c *closeSignalConn) Close() error {
+	c.once.Do(func() { c.closed <- struct{}{} })
+	return c.StreamConn.Close()
+}
@@ -0,0 +1,132 @@
+package kindling
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/x/configurl"
+)
+
+// shadowsocksKeyFetchTimeout bounds fetching a dynamic access key during
+// NewKindling's deferred phase.
+const shadowsocksKeyFetchTimeout = 15 * time.Second
+
+// WithShadowsocks adds a transport that tunnels through a Shadowsocks server,
+// independent of the smart dialer's strategy config. accessKey is either a
+// static ss:// key (SIP002 or legacy base64 form, with an optional prefix
+// parameter) or an Outline dynamic access key: an ssconf:// or https:// URL
+// serving a static key or its JSON form. A dynamic key is fetched once during
+// NewKindling, directly over WithStreamDialer's dialer.
+func WithShadowsocks(accessKey string) Option {
+	return func(k *kindling) error {
+		u, err := url.Parse(strings.TrimSpace(accessKey))
+		if err != nil {
+			return fmt.Errorf("parsing shadowsocks access key: %w", err)
+		}
+		switch u.Scheme {
+		case "ss", "ssconf", "https":
+		default:
+			return fmt.Errorf("shadowsocks access key must be an ss://, ssconf://, or https:// URL")
+		}
+		k.deferred = append(k.deferred, func() error {
+			base := k.baseStreamDialer()
+			key := u.String()
+			if u.Scheme != "ss" {
+				ctx, cancel := context.WithTimeout(context.Background(), shadowsocksKeyFetchTimeout)
+				defer cancel()
+				key, err = fetchShadowsocksKey(ctx, base, u)
+				if err != nil {
+					return fmt.Errorf("fetching shadowsocks access key: %w", err)
+				}
+			}
+			d, err := newShadowsocksDialer(base, key)
+			if err != nil {
+				return fmt.Errorf("creating shadowsocks dialer: %w", err)
+			}
+			k.transports = append(k.transports, newStreamTransport(string(TransportShadowsocks), d))
+			return nil
+		})
+		return nil
+	}
+}
+
+// newShadowsocksDialer builds a Shadowsocks StreamDialer for an ss:// key
+// whose hop to the server goes through base.
+func newShadowsocksDialer(base transport.StreamDialer, key string) (transport.StreamDialer, error) {
+	providers := configurl.NewProviderContainer()
+	providers.StreamDialers.BaseInstance = base
+	configurl.RegisterDefaultProviders(providers)
+	return providers.NewStreamDialer(context.Background(), key)
+}
+
+// shadowsocksJSONKey is the JSON form of an Outline dynamic access key.
+type shadowsocksJSONKey struct {
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Prefix     string `json:"prefix"`
+}
+
+// fetchShadowsocksKey resolves a dynamic access key to a static ss:// key.
+// ssconf:// is the Outline convention for an https:// URL.
+func fetchShadowsocksKey(ctx context.Context, base transport.StreamDialer, keyURL *url.URL) (string, error) {
+	u := *keyURL
+	u.Scheme = "https"
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return base.DialStream(ctx, addr)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	return parseShadowsocksKey(body)
+}
+
+// parseShadowsocksKey accepts a dynamic key document, either a static ss://
+// key or its JSON form, and returns the static key.
+func parseShadowsocksKey(doc []byte) (string, error) {
+	text := strings.TrimSpace(string(doc))
+	if strings.HasPrefix(text, "ss://") {
+		return text, nil
+	}
+	var jk shadowsocksJSONKey
+	if err := json.Unmarshal([]byte(text), &jk); err != nil {
+		return "", fmt.Errorf("access key is neither ss:// nor JSON: %w", err)
+	}
+	if jk.Server == "" || jk.ServerPort == 0 || jk.Method == "" || jk.Password == "" {
+		return "", fmt.Errorf("access key JSON is missing server, server_port, method, or password")
+	}
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(jk.Method + ":" + jk.Password))
+	key := "ss://" + userInfo + "@" + net.JoinHostPort(jk.Server, strconv.Itoa(jk.ServerPort))
+	if jk.Prefix != "" {
+		key += "?" + url.Values{"prefix": {jk.Prefix}}.Encode()
+	}
+	return key, nil
+}
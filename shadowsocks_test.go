@@ -0,0 +1,169 @@
+package kindling
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithShadowsocks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("RejectsUnknownScheme", func(t *testing.T) {
+		t.Parallel()
+		for _, key := range []string{"", "vmess://abc", "http://example.com/key"} {
+			_, err := NewKindling("test", WithShadowsocks(key))
+			assert.Error(t, err, "access key %q", key)
+		}
+	})
+
+	t.Run("StaticKey", func(t *testing.T) {
+		t.Parallel()
+		ki, err := NewKindling("test",
+			WithShadowsocks("ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzZWNyZXQ@127.0.0.1:8388"),
+		)
+		require.NoError(t, err)
+		k := ki.(*kindling)
+		require.Len(t, k.transports, 1)
+		assert.Equal(t, string(TransportShadowsocks), k.transports[0].Name())
+	})
+
+	t.Run("TunnelsRequest", func(t *testing.T) {
+		t.Parallel()
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "via shadowsocks")
+		}))
+		t.Cleanup(origin.Close)
+		k, err := NewKindling("test", WithShadowsocks(testShadowsocksKey(serveShadowsocks(t))))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "via shadowsocks", string(body))
+	})
+
+	t.Run("MalformedStaticKey_IsDeferredError", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithShadowsocks("ss://not-a-key@127.0.0.1:8388"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "creating shadowsocks dialer")
+	})
+}
+
+func TestParseShadowsocksKey(t *testing.T) {
+	t.Parallel()
+
+	t.Run("StaticKeyPassesThrough", func(t *testing.T) {
+		key, err := parseShadowsocksKey([]byte("  ss://abc@example.com:443\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "ss://abc@example.com:443", key)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		key, err := parseShadowsocksKey([]byte(`{
+			"server": "example.com",
+			"server_port": 8388,
+			"password": "secret",
+			"method": "chacha20-ietf-poly1305",
+			"prefix": "\u0016\u0003\u0001"
+		}`))
+		require.NoError(t, err)
+		u, err := url.Parse(key)
+		require.NoError(t, err)
+		assert.Equal(t, "ss", u.Scheme)
+		assert.Equal(t, "example.com:8388", u.Host)
+		assert.Equal(t, "Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzZWNyZXQ", u.User.String())
+		assert.Equal(t, "\x16\x03\x01", u.Query().Get("prefix"))
+	})
+
+	t.Run("IncompleteJSON", func(t *testing.T) {
+		_, err := parseShadowsocksKey([]byte(`{"server": "example.com"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Garbage", func(t *testing.T) {
+		_, err := parseShadowsocksKey([]byte("<html>blocked</html>"))
+		assert.Error(t, err)
+	})
+}
+
+func TestFetchShadowsocksKey(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ss://abc@example.com:443")
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	u.Scheme = "ssconf"
+
+	// The test server's certificate is self-signed, so the fetch must fail
+	// verification rather than trusting whatever answers on the key URL.
+	_, err = fetchShadowsocksKey(context.Background(), &transport.TCPDialer{}, u)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+}
+
+// testShadowsocksSecret is the password of the server serveShadowsocks
+// starts.
+const testShadowsocksSecret = "secret"
+
+// testShadowsocksKey returns an ss:// access key for the serveShadowsocks
+// server at addr.
+func testShadowsocksKey(addr string) string {
+	return "ss://" + base64.RawURLEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:"+testShadowsocksSecret)) + "@" + addr
+}
+
+// serveShadowsocks starts a minimal Shadowsocks server on the loopback
+// interface and returns its address. It's just enough protocol to relay a
+// stream to the address the client asks for.
+func serveShadowsocks(t *testing.T) string {
+	t.Helper()
+	key, err := shadowsocks.NewEncryptionKey(shadowsocks.CHACHA20IETFPOLY1305, testShadowsocksSecret)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := shadowsocks.NewReader(conn, key)
+				addr, err := readTestSOCKSAddr(r)
+				if err != nil {
+					return
+				}
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go func() {
+					r.WriteTo(upstream)
+					upstream.Close()
+				}()
+				io.Copy(shadowsocks.NewWriter(conn, key), upstream)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
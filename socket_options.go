@@ -0,0 +1,93 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// SocketOptions tunes the sockets kindling's default dialers open. A zero
+// field leaves its setting at Go's or the system's default.
+type SocketOptions struct {
+	// TCPNoDelay turns Nagle's algorithm off when true and back on when
+	// false. Go turns it off for every TCP connection already.
+	TCPNoDelay *bool
+	// KeepAlive is how long a TCP connection idles before keepalive probes
+	// start, and the interval between them. It overrides WithKeepAlive for
+	// TCP connections; negative turns the probes off.
+	KeepAlive time.Duration
+	// SendBuf and RecvBuf size each socket's send and receive buffers, in
+	// bytes. They're set before connecting, so the TCP window scale
+	// reflects RecvBuf.
+	SendBuf, RecvBuf int
+}
+
+// WithSocketOptions applies opts to every socket kindling's default dialers
+// open, the same sockets WithDialerControl covers. Latency-sensitive apps on
+// mobile networks may want smaller buffers than the system gives, so a
+// slow link doesn't queue up seconds of data, or keepalives that outlive a
+// carrier's NAT timeouts.
+func WithSocketOptions(opts SocketOptions) Option {
+	return func(k *kindling) error {
+		if opts.SendBuf < 0 || opts.RecvBuf < 0 {
+			return fmt.Errorf("socket buffer sizes must not be negative, got %d and %d", opts.SendBuf, opts.RecvBuf)
+		}
+		if opts.TCPNoDelay != nil {
+			noDelay := *opts.TCPNoDelay
+			opts.TCPNoDelay = &noDelay
+		}
+		k.socketOptions = &opts
+		return nil
+	}
+}
+
+// applyToDialer sets o's options that take effect before connecting on d,
+// which dials network.
+func (o *SocketOptions) applyToDialer(d *net.Dialer, network string) {
+	if o.KeepAlive != 0 && !strings.HasPrefix(network, "udp") {
+		if o.KeepAlive < 0 {
+			d.KeepAlive, d.KeepAliveConfig = -1, net.KeepAliveConfig{}
+		} else {
+			d.KeepAliveConfig = tcpKeepAlive(o.KeepAlive)
+		}
+	}
+	if o.SendBuf == 0 && o.RecvBuf == 0 {
+		return
+	}
+	next := d.Control
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		if err := setSocketBuffers(c, o.SendBuf, o.RecvBuf); err != nil {
+			return fmt.Errorf("sizing socket buffers: %w", err)
+		}
+		if next != nil {
+			return next(network, address, c)
+		}
+		return nil
+	}
+}
+
+// socketOptionsDialer applies the options net.Dialer can't set before
+// connecting, as it turns Nagle's algorithm off itself once connected.
+type socketOptionsDialer struct {
+	transport.StreamDialer
+	opts *SocketOptions
+}
+
+func (d *socketOptionsDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	conn, err := d.StreamDialer.DialStream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if err := tc.SetNoDelay(*d.opts.TCPNoDelay); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("setting TCP_NODELAY: %w", err)
+		}
+	}
+	return conn, nil
+}
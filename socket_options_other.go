@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package kindling
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setSocketBuffers(syscall.RawConn, int, int) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,49 @@
+package kindling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithSocketOptions(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithSocketOptions(SocketOptions{SendBuf: -1})(&kindling{}))
+	assert.Error(t, WithSocketOptions(SocketOptions{RecvBuf: -1})(&kindling{}))
+
+	t.Run("KeepAlive", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{keepAlive: 25 * time.Second}
+		require.NoError(t, WithSocketOptions(SocketOptions{KeepAlive: 10 * time.Second})(k))
+		assert.Equal(t, 10*time.Second, k.netDialer("tcp").KeepAliveConfig.Idle, "overrides WithKeepAlive")
+		assert.Zero(t, k.netDialer("udp").KeepAliveConfig)
+
+		require.NoError(t, WithSocketOptions(SocketOptions{KeepAlive: -1})(k))
+		d := k.netDialer("tcp")
+		assert.False(t, d.KeepAliveConfig.Enable)
+		assert.Negative(t, d.KeepAlive)
+	})
+
+	t.Run("Dial", func(t *testing.T) {
+		t.Parallel()
+		addr := closingListener(t, "127.0.0.1:0")
+		noDelay := false
+		k := &kindling{}
+		require.NoError(t, WithSocketOptions(SocketOptions{TCPNoDelay: &noDelay, SendBuf: 64 << 10, RecvBuf: 64 << 10})(k))
+		require.True(t, k.customNetDialer())
+		noDelay = true
+		assert.False(t, *k.socketOptions.TCPNoDelay, "the option keeps its own copy")
+
+		conn, err := k.baseStreamDialer().DialStream(t.Context(), addr)
+		require.NoError(t, err)
+		conn.Close()
+
+		udp := k.netDialer("udp")
+		uc, err := udp.DialContext(t.Context(), "udp", "127.0.0.1:53")
+		require.NoError(t, err)
+		uc.Close()
+	})
+}
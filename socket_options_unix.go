@@ -0,0 +1,22 @@
+//go:build unix
+
+package kindling
+
+import "syscall"
+
+// setSocketBuffers sets the socket's SO_SNDBUF and SO_RCVBUF, skipping
+// sizes of 0.
+func setSocketBuffers(c syscall.RawConn, send, recv int) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		if send > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, send)
+		}
+		if err == nil && recv > 0 {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, recv)
+		}
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package kindling
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// socks5DialTimeout bounds how long a SOCKS5 client waits for the transports
+// to connect to its destination.
+const socks5DialTimeout = 60 * time.Second
+
+// SOCKS5 reply codes (RFC 1928 §6).
+const (
+	socks5Succeeded           = 0x00
+	socks5HostUnreachable     = 0x04
+	socks5CommandNotSupported = 0x07
+	socks5AddrNotSupported    = 0x08
+)
+
+// ListenSOCKS5 starts a local SOCKS5 proxy on addr (e.g. "127.0.0.1:1080").
+// Each CONNECT is dialed through the configured transports that can carry
+// raw TCP (proxyless, Tor, Shadowsocks, ...), racing them like HTTP
+// requests. Only unauthenticated CONNECT is supported, so bind addr to
+// loopback unless every client on the network should be able to use it.
+// Closing the listener stops new clients; established tunnels run until
+// either side closes.
+func (k *kindling) ListenSOCKS5(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for socks5: %w", err)
+	}
+	if err := k.onClose(l); err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					k.log.Error("SOCKS5 accept failed", slog.Any("error", err))
+				}
+				return
+			}
+			go k.serveSOCKS5(conn)
+		}
+	}()
+	return l, nil
+}
+
+func (k *kindling) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socks5DialTimeout))
+	target, err := readSOCKS5Request(conn)
+	if err != nil {
+		k.log.Debug("Bad SOCKS5 request", slog.Any("error", err))
+		var code socks5Error
+		if errors.As(err, &code) {
+			writeSOCKS5Reply(conn, byte(code))
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socks5DialTimeout)
+	upstream, err := k.DialStream(ctx, target)
+	cancel()
+	if err != nil {
+		k.log.Debug("SOCKS5 dial failed", "target", target, slog.Any("error", err))
+		writeSOCKS5Reply(conn, socks5HostUnreachable)
+		return
+	}
+	defer upstream.Close()
+	if err := writeSOCKS5Reply(conn, socks5Succeeded); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.CloseWrite()
+		close(done)
+	}()
+	io.Copy(conn, upstream)
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	}
+	<-done
+}
+
+// socks5Error is a request failure to report to the client with that reply
+// code.
+type socks5Error byte
+
+func (e socks5Error) Error() string { return fmt.Sprintf("socks5 error %d", byte(e)) }
+
+// readSOCKS5Request runs the method negotiation and reads a CONNECT request,
+// returning its destination as host:port.
+func readSOCKS5Request(conn io.ReadWriter) (string, error) {
+	// Greeting: VER NMETHODS METHODS...
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return "", err
+	}
+	if hdr[0] != 5 {
+		return "", fmt.Errorf("unsupported socks version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if !slices.Contains(methods, 0) {
+		conn.Write([]byte{5, 0xff})
+		return "", errors.New("client requires authentication")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	if req[1] != 1 {
+		return "", socks5Error(socks5CommandNotSupported)
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if req[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", socks5Error(socks5AddrNotSupported)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSOCKS5Reply sends a reply with an unspecified bound address; clients
+// of a CONNECT proxy don't use it.
+func writeSOCKS5Reply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
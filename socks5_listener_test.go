@@ -0,0 +1,87 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenSOCKS5(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via socks")
+	}))
+	t.Cleanup(origin.Close)
+
+	newClient := func(t *testing.T, k Kindling) transport.StreamDialer {
+		t.Helper()
+		l, err := k.ListenSOCKS5("127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Close() })
+		client, err := socks5.NewClient(&transport.StreamDialerEndpoint{
+			Dialer:  &transport.TCPDialer{},
+			Address: l.Addr().String(),
+		})
+		require.NoError(t, err)
+		return client
+	}
+
+	t.Run("TunnelsThroughStreamTransport", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithUpstreamProxy("socks5://"+serveSOCKS5(t)))
+		require.NoError(t, err)
+		client := newClient(t, k)
+
+		conn, err := client.DialStream(context.Background(), origin.Listener.Addr().String())
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: preconnectedTransport(conn)}).Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "via socks", string(body))
+	})
+
+	t.Run("DialFailure_RepliesUnreachable", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(&blockedStreamTransport{}))
+		require.NoError(t, err)
+		client := newClient(t, k)
+
+		_, err = client.DialStream(context.Background(), origin.Listener.Addr().String())
+		var replyErr socks5.ReplyCode
+		require.ErrorAs(t, err, &replyErr)
+		assert.Equal(t, socks5.ErrHostUnreachable, replyErr)
+	})
+
+	t.Run("ClosedListenerRefuses", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(&blockedStreamTransport{}))
+		require.NoError(t, err)
+		l, err := k.ListenSOCKS5("127.0.0.1:0")
+		require.NoError(t, err)
+		require.NoError(t, l.Close())
+		_, err = net.Dial("tcp", l.Addr().String())
+		assert.Error(t, err)
+	})
+}
+
+// blockedStreamTransport is a custom stream-capable transport whose dials
+// always fail.
+type blockedStreamTransport struct{ mockTransport }
+
+func (*blockedStreamTransport) Name() string { return "blocked" }
+
+func (*blockedStreamTransport) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	return nil, errors.New("blocked")
+}
@@ -0,0 +1,137 @@
+package kindling
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// Strategy decides how the transports within a priority tier are attempted.
+type Strategy int
+
+const (
+	// Race is the default. Every transport in a tier connects at once and
+	// the first to connect gets the request.
+	Race Strategy = iota
+
+	// Sequential tries the transports of a tier one at a time, in the order
+	// they were configured, connecting the next only once the previous has
+	// failed to produce a usable response. It's slower when the first
+	// transports are blocked but puts no load on the fallbacks, such as
+	// fronting providers, while they aren't needed.
+	Sequential
+
+	// WeightedRandom is Sequential in a random order, drawn afresh for each
+	// request, in which each transport comes first in proportion to its
+	// weight (see WithTransportWeight). It spreads the load over several
+	// providers rather than always leaning on the first.
+	WeightedRandom
+
+	// Adaptive is Sequential in an order learned from past requests. Each
+	// transport is an arm of a multi-armed bandit, scored by its success
+	// rate and latency, so traffic concentrates on the best performer while
+	// the others are still tried from time to time in case they've become
+	// better. The learning is shared by every client of the instance.
+	Adaptive
+)
+
+// String returns the strategy's name.
+func (s Strategy) String() string {
+	switch s {
+	case Race:
+		return "race"
+	case Sequential:
+		return "sequential"
+	case WeightedRandom:
+		return "weighted"
+	case Adaptive:
+		return "adaptive"
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// WithStrategy sets how the transports within each priority tier are
+// attempted. Tiers themselves are still tried in priority order, and the
+// retry policy decides which outcomes move on to the next transport, as with
+// racing.
+func WithStrategy(s Strategy) Option {
+	return func(k *kindling) error {
+		if s < Race || s > Adaptive {
+			return fmt.Errorf("unknown strategy %d", s)
+		}
+		k.strategy = s
+		if s == Adaptive && k.bandit == nil {
+			k.bandit = newBandit()
+		}
+		return nil
+	}
+}
+
+// WithTransportWeight sets the WeightedRandom weight of the named transport.
+// Transports without one weigh 1.
+func WithTransportWeight(name string, weight float64) Option {
+	return func(k *kindling) error {
+		if !(weight > 0) {
+			return fmt.Errorf("weight for %q must be positive, got %v", name, weight)
+		}
+		if k.weights == nil {
+			k.weights = make(map[string]float64)
+		}
+		k.weights[name] = weight
+		return nil
+	}
+}
+
+// applyStrategy turns tiers into the order they're attempted in. Race keeps
+// them; the others split each tier into tiers of one transport, so the race
+// loop falls back through them one at a time.
+func (t *raceTransport) applyStrategy(tiers [][]Transport) [][]Transport {
+	if t.strategy == Race {
+		return tiers
+	}
+	var out [][]Transport
+	for _, tier := range tiers {
+		switch t.strategy {
+		case WeightedRandom:
+			tier = weightedOrder(tier, t.weights, rand.Float64)
+		case Adaptive:
+			tier = t.bandit.order(tier)
+		}
+		for _, tr := range tier {
+			out = append(out, []Transport{tr})
+		}
+	}
+	return out
+}
+
+// weightedOrder returns transports in a random order in which each is drawn
+// next with probability proportional to its weight among those left. rnd
+// returns numbers in [0, 1).
+func weightedOrder(transports []Transport, weights map[string]float64, rnd func() float64) []Transport {
+	left := slices.Clone(transports)
+	out := make([]Transport, 0, len(left))
+	for len(left) > 0 {
+		var total float64
+		for _, tr := range left {
+			total += weightOf(weights, tr.Name())
+		}
+		pick := rnd() * total
+		i := 0
+		for ; i < len(left)-1; i++ {
+			pick -= weightOf(weights, left[i].Name())
+			if pick < 0 {
+				break
+			}
+		}
+		out = append(out, left[i])
+		left = slices.Delete(left, i, i+1)
+	}
+	return out
+}
+
+func weightOf(weights map[string]float64, name string) float64 {
+	if w, ok := weights[name]; ok {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,129 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialRecorder returns transports that record the order they were dialed in.
+type dialRecorder struct {
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *dialRecorder) transport(name string, rt http.RoundTripper, err error) Transport {
+	return &mockTransport{
+		name: name,
+		newRoundTripper: func(context.Context, string) (http.RoundTripper, error) {
+			d.mu.Lock()
+			d.dialed = append(d.dialed, name)
+			d.mu.Unlock()
+			return rt, err
+		},
+	}
+}
+
+func (d *dialRecorder) order() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.dialed...)
+}
+
+func TestWithStrategy(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithStrategy(Strategy(42))(&kindling{}))
+	assert.Error(t, WithTransportWeight("a", 0)(&kindling{}))
+
+	k := &kindling{}
+	require.NoError(t, WithStrategy(WeightedRandom)(k))
+	require.NoError(t, WithTransportWeight("a", 2.5)(k))
+	assert.Equal(t, WeightedRandom, k.strategy)
+	assert.Equal(t, map[string]float64{"a": 2.5}, k.weights)
+	assert.Equal(t, "weighted", WeightedRandom.String())
+}
+
+func TestStrategy_Sequential(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	t.Run("StopsAtFirstSuccess", func(t *testing.T) {
+		t.Parallel()
+		rec := &dialRecorder{}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			rec.transport("blocked", nil, errors.New("blocked")),
+			rec.transport("direct", server.Client().Transport, nil),
+			rec.transport("fronted", server.Client().Transport, nil),
+		})
+		rt.strategy = Sequential
+
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, []string{"blocked", "direct"}, rec.order(), "fronted must not be dialed")
+	})
+
+	t.Run("NonIdempotentFallsBackOnConnectFailure", func(t *testing.T) {
+		t.Parallel()
+		rec := &dialRecorder{}
+		rt := newRaceTransport("test", testLog, func(string) {}, []Transport{
+			rec.transport("blocked", nil, errors.New("blocked")),
+			rec.transport("direct", server.Client().Transport, nil),
+		})
+		rt.strategy = Sequential
+
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodPost, server.URL, http.NoBody))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, []string{"blocked", "direct"}, rec.order())
+	})
+}
+
+func TestStrategy_WeightedRandom(t *testing.T) {
+	t.Parallel()
+	rt := &raceTransport{strategy: WeightedRandom, weights: map[string]float64{"heavy": 1e9}}
+	tiers := rt.applyStrategy([][]Transport{
+		{bareTransport{"light"}, bareTransport{"heavy"}},
+		{bareTransport{"last"}},
+	})
+	require.Len(t, tiers, 3)
+	var order []string
+	for _, tier := range tiers {
+		require.Len(t, tier, 1)
+		order = append(order, tier[0].Name())
+	}
+	assert.Equal(t, []string{"heavy", "light", "last"}, order, "tiers keep their order")
+}
+
+func TestWeightedOrder(t *testing.T) {
+	t.Parallel()
+	transports := []Transport{bareTransport{"a"}, bareTransport{"b"}, bareTransport{"c"}}
+	weights := map[string]float64{"a": 1, "b": 2, "c": 1}
+
+	tests := []struct {
+		rnd  []float64
+		want []string
+	}{
+		// Total 4: a covers [0, 1), b [1, 3), c [3, 4).
+		{[]float64{0.1, 0.1, 0}, []string{"a", "b", "c"}},
+		{[]float64{0.5, 0.9, 0}, []string{"b", "c", "a"}},
+		{[]float64{0.99, 0.5, 0}, []string{"c", "b", "a"}},
+	}
+	for _, tt := range tests {
+		rnd := tt.rnd
+		got := weightedOrder(transports, weights, func() float64 {
+			r := rnd[0]
+			rnd = rnd[1:]
+			return r
+		})
+		assert.Equal(t, tt.want, names(got), "draws %v", tt.rnd)
+	}
+}
@@ -0,0 +1,89 @@
+package kindling
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"slices"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// WithRootCAs verifies origins' certificates against roots instead of the
+// system pool, for deployments whose control plane uses a private PKI. It
+// applies to the TLS connections kindling itself makes to origins, over the
+// proxyless smart transport, WithHTTP3, and tunnels such as
+// WithUpstreamProxy, WithShadowsocks, and WithTor. Clients passed in by the
+// caller, such as a domainfront.Client, keep their own TLS settings.
+func WithRootCAs(roots *x509.CertPool) Option {
+	return func(k *kindling) error {
+		if roots == nil {
+			return fmt.Errorf("root ca pool is nil")
+		}
+		k.rootCAs = roots
+		return nil
+	}
+}
+
+// WithClientCertificates presents certs to origins that ask for a client
+// certificate (mutual TLS), on the same connections as WithRootCAs.
+func WithClientCertificates(certs ...tls.Certificate) Option {
+	return func(k *kindling) error {
+		if len(certs) == 0 {
+			return fmt.Errorf("no client certificates given")
+		}
+		k.clientCerts = slices.Clone(certs)
+		return nil
+	}
+}
+
+// applyTLSConfig hands the instance's TLS settings to the stream transports
+// and DoH resolvers kindling built.
+func (k *kindling) applyTLSConfig() {
+	if r, ok := k.resolver.(*dohResolver); ok {
+		r.applyTLSConfig(k)
+	}
+	if k.ech != nil {
+		k.ech.resolver.applyTLSConfig(k)
+	}
+	if k.rootCAs == nil && len(k.clientCerts) == 0 && !k.postQuantum && k.sessionCache == nil {
+		return
+	}
+	config := k.tlsConfig(&tls.Config{RootCAs: k.rootCAs, Certificates: k.clientCerts})
+	for _, t := range k.transports {
+		if nt, ok := t.(*namedTransport); ok && nt.dialer != nil {
+			nt.tlsConfig = config
+		}
+	}
+}
+
+// tlsConfig applies the instance-wide settings that every TLS connection
+// kindling makes shares, such as WithPostQuantumTLS and the session cache,
+// to c and returns it.
+func (k *kindling) tlsConfig(c *tls.Config) *tls.Config {
+	if k.postQuantum {
+		c.CurvePreferences = slices.Clone(postQuantumCurves)
+	}
+	if c.ClientSessionCache == nil {
+		c.ClientSessionCache = k.sessionCache
+	}
+	return c
+}
+
+// utlsConfig returns a uTLS config for host with base's roots and client
+// certificates. base may be nil.
+func utlsConfig(host string, base *tls.Config) *utls.Config {
+	config := &utls.Config{ServerName: host}
+	if base == nil {
+		return config
+	}
+	config.RootCAs = base.RootCAs
+	for _, c := range base.Certificates {
+		config.Certificates = append(config.Certificates, utls.Certificate{
+			Certificate: c.Certificate,
+			PrivateKey:  c.PrivateKey,
+			Leaf:        c.Leaf,
+		})
+	}
+	return config
+}
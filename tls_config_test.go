@@ -0,0 +1,124 @@
+package kindling
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	utls "github.com/refraction-networking/utls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newClientCert returns a self-signed client certificate.
+func newClientCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kindling test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestWithRootCAs(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithRootCAs(nil)(&kindling{}))
+	assert.Error(t, WithClientCertificates()(&kindling{}))
+
+	k := &kindling{}
+	require.NoError(t, WithRootCAs(x509.NewCertPool())(k))
+	require.NoError(t, WithClientCertificates(newClientCert(t))(k))
+	assert.NotNil(t, k.rootCAs)
+	assert.Len(t, k.clientCerts, 1)
+}
+
+func TestOriginTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	cert := newClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert.Leaf)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	// Every origin is served by srv.
+	dialer := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*net.TCPConn), nil
+	})
+	get := func(t *testing.T, tr Transport) (string, error) {
+		rt, err := tr.NewRoundTripper(t.Context(), "example.com:443")
+		if err != nil {
+			return "", err
+		}
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("StreamTransport", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{rootCAs: roots, clientCerts: []tls.Certificate{cert}}
+		k.transports = []Transport{newStreamTransport("tunnel", dialer)}
+		k.applyTLSConfig()
+		body, err := get(t, k.transports[0])
+		require.NoError(t, err)
+		assert.Equal(t, "kindling test client", body)
+	})
+
+	t.Run("Fingerprint", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{rootCAs: roots, clientCerts: []tls.Certificate{cert}, fingerprint: &utls.HelloChrome_Auto}
+		k.transports = []Transport{k.smartTransport(dialer)}
+		k.applyTLSConfig()
+		body, err := get(t, k.transports[0])
+		require.NoError(t, err)
+		assert.Equal(t, "kindling test client", body)
+	})
+
+	t.Run("Unset", func(t *testing.T) {
+		t.Parallel()
+		k := &kindling{transports: []Transport{newStreamTransport("tunnel", dialer)}}
+		k.applyTLSConfig()
+		_, err := get(t, k.transports[0])
+		assert.Error(t, err, "the test server's certificate isn't in the system pool")
+	})
+}
@@ -0,0 +1,63 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// WithTLSFingerprint makes the proxyless smart transport's TLS handshakes
+// with id's ClientHello, such as utls.HelloChrome_Auto,
+// utls.HelloFirefox_Auto, or utls.HelloRandomized, instead of Go's easily
+// recognized one, so deployments can blend in with the browsers common in
+// their region. Origins reached with ECH (see WithECH) keep Go's ClientHello.
+func WithTLSFingerprint(id utls.ClientHelloID) Option {
+	return func(k *kindling) error {
+		if id.Client == "" {
+			return fmt.Errorf("tls fingerprint is empty")
+		}
+		if id == utls.HelloCustom {
+			return fmt.Errorf("custom tls fingerprints are not supported")
+		}
+		k.fingerprint = &id
+		return nil
+	}
+}
+
+// fingerprintRoundTripper makes the TLS handshake on conn with id's
+// ClientHello, and returns a RoundTripper that speaks whichever of HTTP/2
+// and HTTP/1.1 the server picked over it. An HTTP/2 connection is pinged
+// when idle for keepAlive, if set.
+func fingerprintRoundTripper(ctx context.Context, conn net.Conn, config *utls.Config, id utls.ClientHelloID, keepAlive time.Duration) (http.RoundTripper, error) {
+	uconn := utls.UClient(conn, config, id)
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	if uconn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		cc, err := (&http2.Transport{ReadIdleTimeout: keepAlive}).NewClientConn(uconn)
+		if err != nil {
+			uconn.Close()
+			return nil, fmt.Errorf("starting http/2: %w", err)
+		}
+		return h2RoundTripper{cc}, nil
+	}
+	// The handshake is done, so net/http must not make its own.
+	rt := preconnectedTransport(uconn)
+	rt.DialTLSContext = rt.DialContext
+	return rt, nil
+}
+
+// h2RoundTripper lets the round-tripper pool close an HTTP/2 connection.
+type h2RoundTripper struct {
+	*http2.ClientConn
+}
+
+func (r h2RoundTripper) CloseIdleConnections() {
+	r.Close()
+}
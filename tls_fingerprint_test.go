@@ -0,0 +1,94 @@
+package kindling
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	utls "github.com/refraction-networking/utls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTLSFingerprint(t *testing.T) {
+	t.Parallel()
+
+	k := &kindling{}
+	require.NoError(t, WithTLSFingerprint(utls.HelloFirefox_Auto)(k))
+	assert.Equal(t, utls.HelloFirefox_Auto, *k.fingerprint)
+
+	assert.Error(t, WithTLSFingerprint(utls.ClientHelloID{})(&kindling{}))
+	assert.Error(t, WithTLSFingerprint(utls.HelloCustom)(&kindling{}))
+}
+
+func TestFingerprintRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		http2 bool
+		proto int
+	}{
+		{"HTTP1", false, 1},
+		{"HTTP2", true, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "hello")
+			}))
+			srv.EnableHTTP2 = tc.http2
+			srv.StartTLS()
+			t.Cleanup(srv.Close)
+			roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			require.NoError(t, err)
+			rt, err := fingerprintRoundTripper(t.Context(), conn, &utls.Config{ServerName: "example.com", RootCAs: roots}, utls.HelloChrome_Auto, 0)
+			require.NoError(t, err)
+			defer rt.(interface{ CloseIdleConnections() }).CloseIdleConnections()
+
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, "hello", string(body))
+			assert.Equal(t, tc.proto, resp.ProtoMajor)
+		})
+	}
+
+	t.Run("HandshakeFails", func(t *testing.T) {
+		t.Parallel()
+		srv := httptest.NewTLSServer(http.NotFoundHandler())
+		t.Cleanup(srv.Close)
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		_, err = fingerprintRoundTripper(t.Context(), conn, &utls.Config{ServerName: "example.com"}, utls.HelloChrome_Auto, 0)
+		assert.ErrorContains(t, err, "tls handshake")
+	})
+}
+
+func TestSmartTransportFingerprint(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	dialer := transport.FuncStreamDialer(func(ctx context.Context, addr string) (transport.StreamConn, error) {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*net.TCPConn), nil
+	})
+
+	k := &kindling{fingerprint: &utls.HelloChrome_Auto}
+	// The handshake happens while connecting, so the untrusted test
+	// certificate fails it here rather than in RoundTrip.
+	_, err := k.smartTransport(dialer).NewRoundTripper(t.Context(), "example.com:443")
+	assert.ErrorContains(t, err, "tls handshake")
+}
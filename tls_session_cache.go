@@ -0,0 +1,30 @@
+package kindling
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// defaultSessionCacheSize is how many TLS sessions an instance remembers
+// without WithTLSSessionCache.
+const defaultSessionCacheSize = 256
+
+// WithTLSSessionCache sets how many TLS sessions kindling keeps to resume,
+// 256 by default. The cache is shared by every TLS config kindling builds,
+// so a retry, a later request, or another transport's attempt reaching the
+// same server can resume the session rather than make a full handshake,
+// which saves a round trip on slow fallback paths. Zero turns resumption
+// off, so no session ticket can link one connection to the next.
+// WithTLSFingerprint handshakes don't resume.
+func WithTLSSessionCache(capacity int) Option {
+	return func(k *kindling) error {
+		if capacity < 0 {
+			return fmt.Errorf("tls session cache capacity must not be negative, got %d", capacity)
+		}
+		k.sessionCache = nil
+		if capacity > 0 {
+			k.sessionCache = tls.NewLRUClientSessionCache(capacity)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package kindling
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTLSSessionCache(t *testing.T) {
+	t.Parallel()
+
+	assert.Error(t, WithTLSSessionCache(-1)(&kindling{}))
+	k := &kindling{}
+	require.NoError(t, WithTLSSessionCache(8)(k))
+	assert.NotNil(t, k.sessionCache)
+	require.NoError(t, WithTLSSessionCache(0)(k))
+	assert.Nil(t, k.sessionCache)
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.DidResume)
+	}))
+	t.Cleanup(server.Close)
+	roots := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	// resumed makes two requests on fresh connections, through different
+	// transports, and reports whether each resumed a session.
+	resumed := func(t *testing.T, cache tls.ClientSessionCache) []string {
+		k := &kindling{rootCAs: roots, sessionCache: cache, transports: []Transport{
+			newStreamTransport("a", &transport.TCPDialer{}),
+			newStreamTransport("b", &transport.TCPDialer{}),
+		}}
+		k.applyTLSConfig()
+		var got []string
+		for _, tr := range k.transports {
+			rt := newRaceTransport("test", testLog, func(string) {}, []Transport{tr})
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL, nil))
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			got = append(got, string(body))
+		}
+		return got
+	}
+
+	t.Run("SharedAcrossTransports", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, []string{"false", "true"}, resumed(t, tls.NewLRUClientSessionCache(8)))
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, []string{"false", "false"}, resumed(t, nil))
+	})
+
+	t.Run("OnByDefault", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithLogWriter(io.Discard))
+		require.NoError(t, err)
+		t.Cleanup(func() { k.Close() })
+		assert.NotNil(t, k.(*kindling).sessionCache)
+		assert.Same(t, k.(*kindling).sessionCache, k.(*kindling).tlsConfig(&tls.Config{}).ClientSessionCache)
+	})
+}
@@ -0,0 +1,233 @@
+package kindling
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+// TorConfig configures WithTor.
+type TorConfig struct {
+	// SOCKSAddr is the SOCKS5 listener of an already-running Tor client to
+	// attach to, e.g. "127.0.0.1:9050". When empty, kindling launches Binary
+	// and waits for it to bootstrap.
+	SOCKSAddr string
+
+	// Binary is the tor executable to launch. Defaults to "tor" on PATH.
+	Binary string
+
+	// DataDir is the launched client's data directory. Reusing one across
+	// runs keeps Tor's cached consensus, which makes bootstrapping much
+	// faster. Empty uses a fresh temporary directory.
+	DataDir string
+
+	// OnBootstrap, if set, is called with the launched client's bootstrap
+	// progress (0–100) and Tor's summary of the current phase. It is not
+	// called when attaching to SOCKSAddr.
+	OnBootstrap func(progress int, summary string)
+}
+
+// WithTor adds a transport that reaches origins through the Tor network,
+// either by attaching to a running Tor client's SOCKS port or by launching
+// one. Race attempts made before a launched client has finished
+// bootstrapping wait for it (bounded by the request's context), so Tor
+// simply stops losing the race once it's usable.
+//
+// A launched client is tied to this process via Tor's
+// __OwningControllerProcess option, so it exits when the process does.
+func WithTor(cfg TorConfig) Option {
+	return func(k *kindling) error {
+		k.deferred = append(k.deferred, func() error {
+			d, err := newTorDialer(k.log, cfg)
+			if err != nil {
+				return fmt.Errorf("starting tor: %w", err)
+			}
+			if err := k.onClose(d); err != nil {
+				return err
+			}
+			k.transports = append(k.transports, newStreamTransport(string(TransportTor), d))
+			return nil
+		})
+		return nil
+	}
+}
+
+// torDialer dials through Tor's SOCKS port once the client is ready.
+type torDialer struct {
+	socks transport.StreamDialer
+	// ready is closed once Tor reports 100% bootstrap (immediately when
+	// attaching). exited is closed if a launched client dies; exitErr then
+	// holds the reason.
+	ready   chan struct{}
+	exited  chan struct{}
+	exitErr error
+	// cmd is the launched client, nil when attaching. tempDir is its data
+	// directory if kindling created it.
+	cmd     *exec.Cmd
+	tempDir string
+}
+
+func newTorDialer(log *slog.Logger, cfg TorConfig) (*torDialer, error) {
+	d := &torDialer{
+		ready:  make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	socksAddr := cfg.SOCKSAddr
+	if socksAddr == "" {
+		addr, err := d.launch(log, cfg)
+		if err != nil {
+			return nil, err
+		}
+		socksAddr = addr
+	} else {
+		close(d.ready)
+	}
+	// Tor's SOCKS port is on loopback, so it's dialed directly rather than
+	// through WithStreamDialer.
+	client, err := socks5.NewClient(&transport.StreamDialerEndpoint{
+		Dialer:  &transport.TCPDialer{},
+		Address: socksAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.socks = client
+	return d, nil
+}
+
+// launch starts the tor binary on a free loopback SOCKS port and watches its
+// log for bootstrap progress. It returns the SOCKS address.
+func (d *torDialer) launch(log *slog.Logger, cfg TorConfig) (string, error) {
+	bin := cfg.Binary
+	if bin == "" {
+		bin = "tor"
+	}
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dir, err := os.MkdirTemp("", "kindling-tor-")
+		if err != nil {
+			return "", fmt.Errorf("creating data dir: %w", err)
+		}
+		dataDir = dir
+		d.tempDir = dir
+	}
+	socksAddr, err := freeLoopbackAddr()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(bin,
+		"--SocksPort", socksAddr,
+		"--DataDirectory", dataDir,
+		"--__OwningControllerProcess", strconv.Itoa(os.Getpid()),
+		"--Log", "notice stdout",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	d.cmd = cmd
+
+	go func() {
+		d.watchBootstrap(log, stdout, cfg.OnBootstrap)
+		err := cmd.Wait()
+		if err == nil {
+			err = errors.New("tor exited")
+		}
+		d.exitErr = err
+		close(d.exited)
+	}()
+	return socksAddr, nil
+}
+
+// watchBootstrap reads Tor's log until it closes, reporting bootstrap
+// progress and closing ready at 100%.
+func (d *torDialer) watchBootstrap(log *slog.Logger, r io.Reader, onBootstrap func(int, string)) {
+	var once sync.Once
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		progress, summary, ok := parseTorBootstrap(scanner.Text())
+		if !ok {
+			continue
+		}
+		log.Debug("Tor bootstrap", "progress", progress, "summary", summary)
+		if onBootstrap != nil {
+			onBootstrap(progress, summary)
+		}
+		if progress == 100 {
+			once.Do(func() { close(d.ready) })
+		}
+	}
+	// Keep draining so tor never blocks on a full stdout pipe.
+	io.Copy(io.Discard, r)
+}
+
+func (d *torDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	select {
+	case <-d.ready:
+	case <-d.exited:
+		return nil, fmt.Errorf("tor not running: %w", d.exitErr)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for tor to bootstrap: %w", ctx.Err())
+	}
+	return d.socks.DialStream(ctx, addr)
+}
+
+// Close stops a launched client and removes the data directory kindling
+// created for it. An attached client is left running.
+func (d *torDialer) Close() error {
+	if d.cmd == nil {
+		return nil
+	}
+	if err := d.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("stopping tor: %w", err)
+	}
+	<-d.exited
+	if d.tempDir != "" {
+		return os.RemoveAll(d.tempDir)
+	}
+	return nil
+}
+
+// torBootstrapRE matches Tor's bootstrap log lines, e.g.
+// "Bootstrapped 45% (requesting_descriptors): Asking for relay descriptors".
+var torBootstrapRE = regexp.MustCompile(`Bootstrapped (\d{1,3})%(?: \([^)]*\))?: (.*)$`)
+
+// parseTorBootstrap extracts the progress and summary from a Tor log line,
+// reporting ok=false for lines that aren't bootstrap status.
+func parseTorBootstrap(line string) (progress int, summary string, ok bool) {
+	m := torBootstrapRE.FindStringSubmatch(line)
+	if m == nil {
+		return 0, "", false
+	}
+	progress, err := strconv.Atoi(m[1])
+	if err != nil || progress > 100 {
+		return 0, "", false
+	}
+	return progress, m[2], true
+}
+
+// freeLoopbackAddr returns a currently unused loopback TCP address for a
+// child process to listen on.
+func freeLoopbackAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("finding a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
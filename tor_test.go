@@ -0,0 +1,237 @@
+package kindling
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTorBootstrap(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		line         string
+		wantProgress int
+		wantSummary  string
+		wantOK       bool
+	}{
+		{"Oct 16 00:00:00.000 [notice] Bootstrapped 0% (starting): Starting", 0, "Starting", true},
+		{"[notice] Bootstrapped 45% (requesting_descriptors): Asking for relay descriptors", 45, "Asking for relay descriptors", true},
+		{"[notice] Bootstrapped 100% (done): Done", 100, "Done", true},
+		{"[notice] Bootstrapped 80%: Connecting to the Tor network", 80, "Connecting to the Tor network", true},
+		{"[notice] Opening Socks listener on 127.0.0.1:9050", 0, "", false},
+		{"[notice] Bootstrapped 900% (bogus): Nope", 0, "", false},
+	}
+	for _, tt := range tests {
+		progress, summary, ok := parseTorBootstrap(tt.line)
+		assert.Equal(t, tt.wantOK, ok, tt.line)
+		assert.Equal(t, tt.wantProgress, progress, tt.line)
+		assert.Equal(t, tt.wantSummary, summary, tt.line)
+	}
+}
+
+func TestWithTor(t *testing.T) {
+	t.Parallel()
+
+	t.Run("AttachToSOCKSPort", func(t *testing.T) {
+		t.Parallel()
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello from tor")
+		}))
+		defer origin.Close()
+
+		ki, err := NewKindling("test", WithTor(TorConfig{SOCKSAddr: serveSOCKS5(t)}))
+		require.NoError(t, err)
+		k := ki.(*kindling)
+		require.Len(t, k.transports, 1)
+		assert.Equal(t, string(TransportTor), k.transports[0].Name())
+
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello from tor", string(body))
+	})
+
+	t.Run("LaunchFailure_IsDeferredError", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithTor(TorConfig{Binary: filepath.Join(t.TempDir(), "no-such-tor")}))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "starting tor")
+	})
+
+	t.Run("LaunchReportsBootstrap", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("fake tor binary is a shell script")
+		}
+		script := filepath.Join(t.TempDir(), "tor")
+		require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo "[notice] Tor 0.4.8 opening log file."
+echo "[notice] Bootstrapped 5% (conn): Connecting to a relay"
+echo "[notice] Bootstrapped 100% (done): Done"
+`), 0o755))
+
+		var mu sync.Mutex
+		var progress []int
+		d, err := newTorDialer(testLog, TorConfig{
+			Binary:  script,
+			DataDir: t.TempDir(),
+			OnBootstrap: func(p int, _ string) {
+				mu.Lock()
+				progress = append(progress, p)
+				mu.Unlock()
+			},
+		})
+		require.NoError(t, err)
+
+		select {
+		case <-d.ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("tor never reported bootstrap completion")
+		}
+		mu.Lock()
+		assert.Equal(t, []int{5, 100}, progress)
+		mu.Unlock()
+	})
+
+	t.Run("DialWaitsForBootstrap", func(t *testing.T) {
+		t.Parallel()
+		d := &torDialer{ready: make(chan struct{}), exited: make(chan struct{})}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := d.DialStream(ctx, "example.com:443")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "waiting for tor to bootstrap")
+	})
+}
+
+// serveSOCKS5 starts a minimal no-auth SOCKS5 server that supports CONNECT and
+// returns its address. It's just enough protocol for attaching tests.
+func serveSOCKS5(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleTestSOCKS5(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func handleTestSOCKS5(conn net.Conn) { handleTestSOCKS5Auth(conn, "", "") }
+
+// handleTestSOCKS5Auth is handleTestSOCKS5 requiring RFC 1929
+// username/password authentication when user is non-empty.
+func handleTestSOCKS5Auth(conn net.Conn, user, pass string) {
+	defer conn.Close()
+	// Greeting: VER NMETHODS METHODS...
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, hdr[1])); err != nil {
+		return
+	}
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		// Auth: VER ULEN UNAME PLEN PASSWD
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+		gotUser := make([]byte, b[1])
+		io.ReadFull(conn, gotUser)
+		io.ReadFull(conn, b[:1])
+		gotPass := make([]byte, b[0])
+		io.ReadFull(conn, gotPass)
+		if string(gotUser) != user || string(gotPass) != pass {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 3)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	addr, err := readTestSOCKSAddr(conn)
+	if err != nil {
+		return
+	}
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+// readTestSOCKSAddr reads a SOCKS address, ATYP DST.ADDR DST.PORT, as
+// SOCKS5 requests and Shadowsocks streams begin with, and returns it as
+// host:port.
+func readTestSOCKSAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	default:
+		return "", fmt.Errorf("unknown address type %d", atyp[0])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
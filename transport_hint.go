@@ -0,0 +1,52 @@
+package kindling
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+type (
+	transportHintKey    struct{}
+	excludeTransportKey struct{}
+)
+
+// WithTransportHint returns a context that pins requests made with it to the
+// named transports: a single name forces that transport, several restrict the
+// race to them. It's meant for debugging field issues and for requests that
+// must not traverse certain intermediaries. Domain policies still apply on
+// top of the hint, and a transport pinned on its own is tried even if its
+// circuit breaker is open. ListenSOCKS5 connections aren't affected, but
+// other stream dials made with the context are.
+func WithTransportHint(ctx context.Context, transports ...string) context.Context {
+	return context.WithValue(ctx, transportHintKey{}, slices.Clone(transports))
+}
+
+// ExcludeTransports returns a context that keeps requests made with it off
+// the named transports, for example to keep a large upload off DNS
+// tunneling without reconfiguring the whole client. Exclusions add up
+// across nested calls, and combine with WithTransportHint.
+func ExcludeTransports(ctx context.Context, transports ...string) context.Context {
+	excluded, _ := ctx.Value(excludeTransportKey{}).([]string)
+	return context.WithValue(ctx, excludeTransportKey{}, append(slices.Clone(excluded), transports...))
+}
+
+// applyTransportHint narrows transports to the ones pinned by ctx, if any,
+// less the ones it excludes.
+func applyTransportHint(ctx context.Context, transports []Transport) ([]Transport, error) {
+	hint, pinned := ctx.Value(transportHintKey{}).([]string)
+	excluded, _ := ctx.Value(excludeTransportKey{}).([]string)
+	if !pinned && len(excluded) == 0 {
+		return transports, nil
+	}
+	transports = slices.DeleteFunc(slices.Clone(transports), func(tr Transport) bool {
+		return (pinned && !slices.Contains(hint, tr.Name())) || slices.Contains(excluded, tr.Name())
+	})
+	if len(transports) == 0 {
+		if pinned {
+			return nil, fmt.Errorf("no eligible transports for request: transport hint %v, excluding %v, matches no usable transport", hint, excluded)
+		}
+		return nil, fmt.Errorf("no eligible transports for request: all usable transports excluded (%v)", excluded)
+	}
+	return transports, nil
+}
@@ -0,0 +1,117 @@
+package kindling
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTransportHint(t *testing.T) {
+	t.Parallel()
+
+	// newRecorder returns transports named names whose connects are
+	// recorded in the returned func's result.
+	newRecorder := func(names ...string) ([]Transport, func() []string) {
+		var mu sync.Mutex
+		var dialed []string
+		var transports []Transport
+		for _, name := range names {
+			transports = append(transports, &mockTransport{name: name, newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				mu.Lock()
+				dialed = append(dialed, name)
+				mu.Unlock()
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+				}), nil
+			}})
+		}
+		return transports, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), dialed...)
+		}
+	}
+	get := func(t *testing.T, rt http.RoundTripper, ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com/", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	t.Run("Pins", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted", "amp", "dnstt")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		require.NoError(t, get(t, rt, WithTransportHint(context.Background(), "amp")))
+		assert.Equal(t, []string{"amp"}, dialed())
+	})
+
+	t.Run("Restricts", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted", "amp", "dnstt")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		require.NoError(t, get(t, rt, WithTransportHint(context.Background(), "amp", "dnstt")))
+		assert.NotContains(t, dialed(), "fronted")
+	})
+
+	t.Run("UnknownTransport", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		err := get(t, rt, WithTransportHint(context.Background(), "tor"))
+		assert.ErrorContains(t, err, "transport hint")
+		assert.Empty(t, dialed())
+	})
+
+	t.Run("DomainPolicyStillApplies", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted", "amp")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		rt.domainPolicy = map[string][]string{"example.com": {"fronted"}}
+		err := get(t, rt, WithTransportHint(context.Background(), "amp"))
+		assert.Error(t, err)
+		assert.Empty(t, dialed())
+	})
+
+	t.Run("NoHint", func(t *testing.T) {
+		t.Parallel()
+		transports, _ := newRecorder("fronted")
+		got, err := applyTransportHint(context.Background(), transports)
+		require.NoError(t, err)
+		assert.Equal(t, transports, got)
+	})
+
+	t.Run("Excludes", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted", "amp", "dnstt")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		ctx := ExcludeTransports(ExcludeTransports(context.Background(), "dnstt"), "amp")
+		require.NoError(t, get(t, rt, ctx))
+		assert.Equal(t, []string{"fronted"}, dialed())
+	})
+
+	t.Run("ExcludesFromHint", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted", "amp", "dnstt")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		ctx := ExcludeTransports(WithTransportHint(context.Background(), "amp", "dnstt"), "dnstt")
+		require.NoError(t, get(t, rt, ctx))
+		assert.Equal(t, []string{"amp"}, dialed())
+	})
+
+	t.Run("ExcludesEverything", func(t *testing.T) {
+		t.Parallel()
+		transports, dialed := newRecorder("fronted")
+		rt := newRaceTransport("test", testLog, func(string) {}, transports)
+		err := get(t, rt, ExcludeTransports(context.Background(), "fronted"))
+		assert.ErrorContains(t, err, "excluded")
+		assert.Empty(t, dialed())
+	})
+}
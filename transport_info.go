@@ -0,0 +1,125 @@
+package kindling
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportState says whether a transport currently takes part in races.
+type TransportState string
+
+const (
+	// TransportEnabled transports join every race they're eligible for.
+	TransportEnabled TransportState = "enabled"
+	// TransportQuarantined transports failed repeatedly and are sitting out
+	// their circuit breaker cooldown (see WithCircuitBreaker).
+	TransportQuarantined TransportState = "quarantined"
+)
+
+// TransportInfo describes a configured transport, for apps that display or
+// debug the current transport set.
+type TransportInfo struct {
+	Name         string
+	MaxLength    int
+	IsStreamable bool
+	Priority     int
+	State        TransportState
+	// QuarantinedUntil is when a quarantined transport will next be
+	// probed; zero otherwise.
+	QuarantinedUntil time.Time
+	// LastSuccess is when the transport last delivered a response; zero if
+	// it hasn't yet.
+	LastSuccess time.Time
+	// BytesSent and BytesReceived are the request and response bytes the
+	// transport has carried, losing races included. They count headers and
+	// bodies, but not TLS, framing, or tunneling overhead, so they
+	// understate what expensive transports such as dnstt really use.
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// Transports describes the configured transports in race order.
+func (k *kindling) Transports() []TransportInfo {
+	transports := k.snapshot()
+	infos := make([]TransportInfo, 0, len(transports))
+	for _, tier := range groupByPriority(transports) {
+		for _, tr := range tier {
+			info := TransportInfo{
+				Name:         tr.Name(),
+				MaxLength:    tr.MaxLength(),
+				IsStreamable: tr.IsStreamable(),
+				Priority:     priorityOf(tr),
+				State:        TransportEnabled,
+				LastSuccess:  k.stats.lastSuccess(tr.Name()),
+			}
+			if c := k.stats.counts(tr.Name()); c != nil {
+				info.BytesSent = c.sent.Load()
+				info.BytesReceived = c.received.Load()
+			}
+			if until, ok := k.breaker.quarantinedUntil(tr.Name()); ok {
+				info.State = TransportQuarantined
+				info.QuarantinedUntil = until
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// transportStats records per-transport outcomes across every client an
+// instance creates. A nil *transportStats records nothing.
+type transportStats struct {
+	mu        sync.Mutex
+	successes map[string]time.Time
+	bytes     map[string]*byteCounts
+	// recent holds the last maxRecentAttempts outcomes, oldest first.
+	recent []attemptRecord
+}
+
+// byteCounts are one transport's running byte totals.
+type byteCounts struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func newTransportStats() *transportStats {
+	return &transportStats{
+		successes: make(map[string]time.Time),
+		bytes:     make(map[string]*byteCounts),
+	}
+}
+
+// counts returns the byte totals for the named transport, creating them if
+// needed. A nil *transportStats returns nil.
+func (s *transportStats) counts(name string) *byteCounts {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.bytes[name]
+	if !ok {
+		c = &byteCounts{}
+		s.bytes[name] = c
+	}
+	return c
+}
+
+func (s *transportStats) success(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.successes[name] = time.Now()
+}
+
+func (s *transportStats) lastSuccess(name string) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.successes[name]
+}
@@ -0,0 +1,79 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransports(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(origin.Close)
+
+	broken := &mockTransport{
+		name: "broken",
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			return nil, errors.New("blocked")
+		},
+	}
+	direct := newSlowDirect()
+	amp := &mockTransport{name: "amp", maxLength: 6000, priority: priorityFallback}
+
+	t.Run("Initial", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(amp), WithTransport(direct))
+		require.NoError(t, err)
+		assert.Equal(t, []TransportInfo{
+			{Name: "direct", State: TransportEnabled},
+			{Name: "amp", MaxLength: 6000, Priority: priorityFallback, State: TransportEnabled},
+		}, k.Transports())
+	})
+
+	t.Run("AfterRequests", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test",
+			WithTransport(broken),
+			WithTransport(direct),
+			WithCircuitBreaker(1, time.Hour),
+		)
+		require.NoError(t, err)
+		before := time.Now()
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		infos := k.Transports()
+		require.Len(t, infos, 2)
+		assert.Equal(t, "broken", infos[0].Name)
+		assert.Equal(t, TransportQuarantined, infos[0].State)
+		assert.True(t, infos[0].QuarantinedUntil.After(before))
+		assert.True(t, infos[0].LastSuccess.IsZero())
+
+		assert.Equal(t, "direct", infos[1].Name)
+		assert.Equal(t, TransportEnabled, infos[1].State)
+		assert.True(t, infos[1].QuarantinedUntil.IsZero())
+		assert.False(t, infos[1].LastSuccess.Before(before))
+	})
+
+	t.Run("BreakerDisabled", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTransport(broken), WithTransport(direct), WithCircuitBreaker(0, 0))
+		require.NoError(t, err)
+		for range 3 {
+			resp, err := k.NewHTTPClient().Get(origin.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		for _, info := range k.Transports() {
+			assert.Equal(t, TransportEnabled, info.State, info.Name)
+		}
+	})
+}
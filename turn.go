@@ -0,0 +1,656 @@
+package kindling
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// WithTURN adds a transport that relays TCP through a TURN server using TCP
+// allocations (RFC 6062). Many networks let TURN through for conferencing
+// apps, which makes it a plausible covert channel for small control-plane
+// payloads. server is a turn: or turns: URI (RFC 7065), or a bare host:port
+// for plain TCP; username and credential are the server's long-term
+// credentials.
+//
+// Each race attempt makes its own allocation, which is refreshed for as
+// long as the returned connection lives. DialPacket relays UDP through a
+// UDP allocation too, over the same TCP or TLS connection to the server.
+// The origin's address is resolved locally because TURN relays to IP
+// addresses only.
+func WithTURN(server, username, credential string) Option {
+	return func(k *kindling) error {
+		addr, useTLS, err := parseTURNServer(server)
+		if err != nil {
+			return err
+		}
+		if username == "" || credential == "" {
+			return fmt.Errorf("turn username and credential are required")
+		}
+		k.deferred = append(k.deferred, func() error {
+			d := &turnDialer{
+				base:       k.baseStreamDialer(),
+				resolver:   k.hostResolver(),
+				serverAddr: addr,
+				username:   username,
+				password:   credential,
+			}
+			if useTLS {
+				host, _, _ := net.SplitHostPort(addr)
+				d.tlsConfig = k.tlsConfig(&tls.Config{ServerName: host})
+			}
+			k.transports = append(k.transports, newStreamTransport(string(TransportTURN), d))
+			return nil
+		})
+		return nil
+	}
+}
+
+// parseTURNServer accepts "turn:host[:port][?transport=tcp]",
+// "turns:host[:port]", or "host:port", returning the server address and
+// whether the control connection uses TLS.
+func parseTURNServer(server string) (addr string, useTLS bool, err error) {
+	if !strings.HasPrefix(server, "turn:") && !strings.HasPrefix(server, "turns:") {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return "", false, fmt.Errorf("turn server %q: %w", server, err)
+		}
+		return server, false, nil
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", false, fmt.Errorf("parsing turn server: %w", err)
+	}
+	if t := u.Query().Get("transport"); t != "" && t != "tcp" {
+		return "", false, fmt.Errorf("turn server %q: only TCP transport can relay TCP", server)
+	}
+	hostport, _, _ := strings.Cut(u.Opaque, "?")
+	useTLS = u.Scheme == "turns"
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		port := "3478"
+		if useTLS {
+			port = "5349"
+		}
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+	}
+	return hostport, useTLS, nil
+}
+
+// turnDialer opens TCP connections to peers through TURN TCP allocations.
+type turnDialer struct {
+	base       transport.StreamDialer
+	resolver   hostResolver
+	serverAddr string
+	tlsConfig  *tls.Config
+	username   string
+	password   string
+	// refresh is how often allocations are refreshed; 0 is
+	// turnRefreshInterval.
+	refresh time.Duration
+}
+
+var (
+	_ transport.StreamDialer = (*turnDialer)(nil)
+	_ transport.PacketDialer = (*turnDialer)(nil)
+)
+
+func (d *turnDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	peer, err := resolveTCPAddr(ctx, d.resolver, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl, err := d.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stopCtrl := context.AfterFunc(ctx, func() { ctrl.SetDeadline(time.Unix(1, 0)) })
+	defer stopCtrl()
+	c := &turnClient{conn: ctrl, username: d.username, password: d.password}
+	if err := c.allocate(protocolTCP); err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("turn allocate: %w", err)
+	}
+	connID, err := c.connect(peer)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("turn connect to %s: %w", peer, err)
+	}
+
+	data, err := d.dialServer(ctx)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	stopData := context.AfterFunc(ctx, func() { data.SetDeadline(time.Unix(1, 0)) })
+	defer stopData()
+	if err := c.bind(data, connID); err != nil {
+		data.Close()
+		ctrl.Close()
+		return nil, fmt.Errorf("turn connection bind: %w", err)
+	}
+	if !stopCtrl() || !stopData() {
+		data.Close()
+		ctrl.Close()
+		return nil, ctx.Err()
+	}
+	tc := &turnConn{StreamConn: data, ctrl: ctrl, client: c, peer: peer, done: make(chan struct{})}
+	go tc.keepAlive(d.refreshInterval())
+	return tc, nil
+}
+
+// DialPacket relays datagrams to addr through a UDP allocation made over a
+// connection to the server, carrying them in Send and Data indications
+// (RFC 5766 §10).
+func (d *turnDialer) DialPacket(ctx context.Context, addr string) (net.Conn, error) {
+	peer, err := resolveTCPAddr(ctx, d.resolver, addr)
+	if err != nil {
+		return nil, err
+	}
+	ctrl, err := d.dialServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { ctrl.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	c := &turnClient{conn: ctrl, username: d.username, password: d.password}
+	if err := c.allocate(protocolUDP); err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("turn allocate: %w", err)
+	}
+	if err := c.createPermission(peer); err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("turn permission for %s: %w", peer, err)
+	}
+	if !stop() {
+		ctrl.Close()
+		return nil, ctx.Err()
+	}
+	pc := &turnPacketConn{Conn: ctrl, client: c, peer: peer, done: make(chan struct{})}
+	go pc.keepAlive(d.refreshInterval())
+	return pc, nil
+}
+
+func (d *turnDialer) refreshInterval() time.Duration {
+	if d.refresh > 0 {
+		return d.refresh
+	}
+	return turnRefreshInterval
+}
+
+// dialServer opens a connection to the TURN server, wrapped in TLS for turns:.
+func (d *turnDialer) dialServer(ctx context.Context) (transport.StreamConn, error) {
+	conn, err := d.base.DialStream(ctx, d.serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing turn server %s: %w", d.serverAddr, err)
+	}
+	if d.tlsConfig == nil {
+		return conn, nil
+	}
+	tc := tls.Client(conn, d.tlsConfig)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake with turn server: %w", err)
+	}
+	return &tunnelConn{Conn: tc, raw: conn, r: bufio.NewReader(tc)}, nil
+}
+
+// turnConn is a relayed TCP connection. Its allocation lives on the control
+// connection, which is torn down with it.
+type turnConn struct {
+	transport.StreamConn
+	ctrl   net.Conn
+	client *turnClient
+	peer   *net.TCPAddr
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *turnConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	err := c.StreamConn.Close()
+	c.ctrl.Close()
+	return err
+}
+
+// keepAlive refreshes the allocation and the peer's permission every
+// interval until the connection is closed. Nothing else uses the control
+// connection once the data connection is bound, so these are full
+// transactions.
+func (c *turnConn) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if _, err := c.client.do(c.ctrl, turnMethodRefresh, func([12]byte) []stunAttr { return nil }); err != nil {
+			return
+		}
+		if err := c.client.createPermission(c.peer); err != nil {
+			return
+		}
+	}
+}
+
+// turnRefreshInterval is how often a relay refreshes its allocation and
+// permission, inside the permission's five-minute lifetime and the
+// allocation's ten.
+const turnRefreshInterval = 4 * time.Minute
+
+// turnPacketConn is a datagram association relayed through a TURN UDP
+// allocation. Each Write is one datagram to the peer, and each Read one
+// datagram from it, truncated to fit like a UDP socket's.
+type turnPacketConn struct {
+	net.Conn
+	client *turnClient
+	peer   *net.TCPAddr
+
+	// writeMu keeps Writes and refreshes from interleaving on the stream.
+	writeMu   sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *turnPacketConn) Read(b []byte) (int, error) {
+	for {
+		m, err := readSTUN(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		// Refresh responses and the like come in on the stream as well.
+		if m.typ != turnMethodData|stunClassIndication {
+			continue
+		}
+		data, ok := m.get(stunAttrData)
+		if !ok {
+			continue
+		}
+		return copy(b, data), nil
+	}
+}
+
+func (c *turnPacketConn) Write(b []byte) (int, error) {
+	m := &stunMessage{typ: turnMethodSend | stunClassIndication}
+	rand.Read(m.txID[:])
+	m.attrs = []stunAttr{
+		{stunAttrXORPeerAddress, xorAddress(c.peer, m.txID)},
+		{stunAttrData, b},
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(m.encode(nil)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *turnPacketConn) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: c.peer.IP, Port: c.peer.Port}
+}
+
+func (c *turnPacketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// keepAlive refreshes the allocation and permission every interval until
+// the connection is closed. Their responses are left for Read to skip.
+func (c *turnPacketConn) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		refresh, key := c.client.request(turnMethodRefresh, func([12]byte) []stunAttr { return nil })
+		permission, _ := c.client.request(turnMethodCreatePermission, func(txID [12]byte) []stunAttr {
+			return []stunAttr{{stunAttrXORPeerAddress, xorAddress(c.peer, txID)}}
+		})
+		c.writeMu.Lock()
+		_, err := c.Conn.Write(append(refresh.encode(key), permission.encode(key)...))
+		c.writeMu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// resolveTCPAddr resolves addr's host to an IP, preferring IPv4.
+func resolveTCPAddr(ctx context.Context, r hostResolver, addr string) (*net.TCPAddr, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	}
+	ips, err := r.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	best := ips[0]
+	for _, ip := range ips {
+		if ip.Is4() || ip.Is4In6() {
+			best = ip.Unmap()
+			break
+		}
+	}
+	return &net.TCPAddr{IP: best.AsSlice(), Port: port}, nil
+}
+
+// --- Minimal STUN/TURN (RFC 5389, RFC 5766, RFC 6062) ---
+
+const (
+	stunMagicCookie = 0x2112A442
+	stunHeaderSize  = 20
+
+	turnMethodAllocate         = 0x003
+	turnMethodRefresh          = 0x004
+	turnMethodSend             = 0x006
+	turnMethodData             = 0x007
+	turnMethodCreatePermission = 0x008
+	turnMethodConnect          = 0x00a
+	turnMethodConnectionBind   = 0x00b
+
+	stunClassIndication = 0x0010
+	stunClassSuccess    = 0x0100
+	stunClassError      = 0x0110
+
+	stunAttrUsername           = 0x0006
+	stunAttrMessageIntegrity   = 0x0008
+	stunAttrErrorCode          = 0x0009
+	stunAttrRealm              = 0x0014
+	stunAttrNonce              = 0x0015
+	stunAttrXORPeerAddress     = 0x0012
+	stunAttrData               = 0x0013
+	stunAttrRequestedTransport = 0x0019
+	stunAttrConnectionID       = 0x002a
+
+	// protocolTCP and protocolUDP are REQUESTED-TRANSPORT's values for TCP
+	// and UDP allocations.
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+type stunAttr struct {
+	typ   uint16
+	value []byte
+}
+
+type stunMessage struct {
+	typ   uint16
+	txID  [12]byte
+	attrs []stunAttr
+	// raw is the message as read off the wire, for integrity checks.
+	raw []byte
+}
+
+func newSTUNRequest(method uint16, attrs ...stunAttr) *stunMessage {
+	m := &stunMessage{typ: method, attrs: attrs}
+	rand.Read(m.txID[:])
+	return m
+}
+
+func (m *stunMessage) get(typ uint16) ([]byte, bool) {
+	for _, a := range m.attrs {
+		if a.typ == typ {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+// encode serializes m, appending MESSAGE-INTEGRITY keyed with key when key is
+// non-nil.
+func (m *stunMessage) encode(key []byte) []byte {
+	buf := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(buf[0:], m.typ)
+	binary.BigEndian.PutUint32(buf[4:], stunMagicCookie)
+	copy(buf[8:], m.txID[:])
+	for _, a := range m.attrs {
+		buf = appendSTUNAttr(buf, a.typ, a.value)
+	}
+	if key != nil {
+		// The integrity HMAC covers the header with its length already
+		// counting the MESSAGE-INTEGRITY attribute itself.
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)-stunHeaderSize+24))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(buf)
+		buf = appendSTUNAttr(buf, stunAttrMessageIntegrity, mac.Sum(nil))
+	}
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)-stunHeaderSize))
+	return buf
+}
+
+func appendSTUNAttr(buf []byte, typ uint16, value []byte) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, typ)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	buf = append(buf, value...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// checkIntegrity reports whether m carries a MESSAGE-INTEGRITY attribute
+// valid under key.
+func (m *stunMessage) checkIntegrity(key []byte) bool {
+	for off := stunHeaderSize; off+4 <= len(m.raw); {
+		typ := binary.BigEndian.Uint16(m.raw[off:])
+		n := int(binary.BigEndian.Uint16(m.raw[off+2:]))
+		if typ == stunAttrMessageIntegrity {
+			if n != sha1.Size || off+4+n > len(m.raw) {
+				return false
+			}
+			covered := append([]byte(nil), m.raw[:off]...)
+			binary.BigEndian.PutUint16(covered[2:], uint16(off-stunHeaderSize+24))
+			mac := hmac.New(sha1.New, key)
+			mac.Write(covered)
+			return hmac.Equal(mac.Sum(nil), m.raw[off+4:off+4+n])
+		}
+		off += 4 + (n+3)&^3
+	}
+	return false
+}
+
+// readSTUN reads exactly one STUN message from r, so a stream that switches
+// to relayed data after a ConnectionBind response loses no bytes.
+func readSTUN(r io.Reader) (*stunMessage, error) {
+	hdr := make([]byte, stunHeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(hdr[4:]) != stunMagicCookie {
+		return nil, errors.New("not a STUN message")
+	}
+	n := int(binary.BigEndian.Uint16(hdr[2:]))
+	raw := make([]byte, stunHeaderSize+n)
+	copy(raw, hdr)
+	if _, err := io.ReadFull(r, raw[stunHeaderSize:]); err != nil {
+		return nil, err
+	}
+	m := &stunMessage{typ: binary.BigEndian.Uint16(hdr), raw: raw}
+	copy(m.txID[:], hdr[8:])
+	for off := stunHeaderSize; off+4 <= len(raw); {
+		typ := binary.BigEndian.Uint16(raw[off:])
+		l := int(binary.BigEndian.Uint16(raw[off+2:]))
+		if off+4+l > len(raw) {
+			return nil, errors.New("truncated STUN attribute")
+		}
+		m.attrs = append(m.attrs, stunAttr{typ: typ, value: raw[off+4 : off+4+l]})
+		off += 4 + (l+3)&^3
+	}
+	return m, nil
+}
+
+// stunErrorCode decodes an ERROR-CODE attribute value.
+func stunErrorCode(v []byte) (int, string) {
+	if len(v) < 4 {
+		return 0, ""
+	}
+	return int(v[2]&0x7)*100 + int(v[3]), string(v[4:])
+}
+
+// xorAddress encodes addr as an XOR-*-ADDRESS attribute value for txID.
+func xorAddress(addr *net.TCPAddr, txID [12]byte) []byte {
+	var cookie [4]byte
+	binary.BigEndian.PutUint32(cookie[:], stunMagicCookie)
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	v := []byte{0, family, 0, 0}
+	binary.BigEndian.PutUint16(v[2:], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	mask := append(cookie[:], txID[:]...)
+	for i, b := range ip {
+		v = append(v, b^mask[i])
+	}
+	return v
+}
+
+// turnClient runs TURN transactions over the control connection, tracking
+// the long-term credential state (realm, nonce) the server hands out.
+type turnClient struct {
+	conn     io.ReadWriter
+	username string
+	password string
+	realm    string
+	nonce    string
+}
+
+func (c *turnClient) key() []byte {
+	sum := md5.Sum([]byte(c.username + ":" + c.realm + ":" + c.password))
+	return sum[:]
+}
+
+// do runs a transaction for method over rw and returns the success response.
+// attrs builds the request attributes for each attempt's transaction ID,
+// which XOR-encoded addresses depend on. It authenticates on a 401 challenge
+// and retries once on a stale nonce (438).
+func (c *turnClient) do(rw io.ReadWriter, method uint16, attrs func(txID [12]byte) []stunAttr) (*stunMessage, error) {
+	for attempt := 0; ; attempt++ {
+		req, key := c.request(method, attrs)
+		if _, err := rw.Write(req.encode(key)); err != nil {
+			return nil, err
+		}
+		resp, err := readSTUN(rw)
+		if err != nil {
+			return nil, err
+		}
+		if resp.txID != req.txID {
+			return nil, errors.New("mismatched STUN transaction")
+		}
+		switch resp.typ {
+		case method | stunClassSuccess:
+			if _, ok := resp.get(stunAttrMessageIntegrity); ok && key != nil && !resp.checkIntegrity(key) {
+				return nil, errors.New("response failed integrity check")
+			}
+			return resp, nil
+		case method | stunClassError:
+			v, _ := resp.get(stunAttrErrorCode)
+			code, reason := stunErrorCode(v)
+			realm, _ := resp.get(stunAttrRealm)
+			nonce, hasNonce := resp.get(stunAttrNonce)
+			// The 401 challenge to our unauthenticated first request and a
+			// 438 stale nonce both carry fresh state worth one retry.
+			retryable := (code == 401 && c.nonce == "") || code == 438
+			if retryable && hasNonce && attempt == 0 {
+				if len(realm) > 0 {
+					c.realm = string(realm)
+				}
+				c.nonce = string(nonce)
+				continue
+			}
+			return nil, fmt.Errorf("turn error %d %s", code, reason)
+		default:
+			return nil, fmt.Errorf("unexpected STUN message type %#04x", resp.typ)
+		}
+	}
+}
+
+// request builds a request for method, authenticated once the server has
+// handed out a nonce, and returns it with the key to sign it with.
+func (c *turnClient) request(method uint16, attrs func(txID [12]byte) []stunAttr) (*stunMessage, []byte) {
+	req := newSTUNRequest(method)
+	req.attrs = attrs(req.txID)
+	if c.nonce == "" {
+		return req, nil
+	}
+	req.attrs = append(req.attrs,
+		stunAttr{stunAttrUsername, []byte(c.username)},
+		stunAttr{stunAttrRealm, []byte(c.realm)},
+		stunAttr{stunAttrNonce, []byte(c.nonce)},
+	)
+	return req, c.key()
+}
+
+// allocate asks for an allocation relaying protocol, protocolTCP or
+// protocolUDP.
+func (c *turnClient) allocate(protocol byte) error {
+	_, err := c.do(c.conn, turnMethodAllocate, func([12]byte) []stunAttr {
+		return []stunAttr{{stunAttrRequestedTransport, []byte{protocol, 0, 0, 0}}}
+	})
+	return err
+}
+
+// createPermission lets peer send datagrams to the allocation.
+func (c *turnClient) createPermission(peer *net.TCPAddr) error {
+	_, err := c.do(c.conn, turnMethodCreatePermission, func(txID [12]byte) []stunAttr {
+		return []stunAttr{{stunAttrXORPeerAddress, xorAddress(peer, txID)}}
+	})
+	return err
+}
+
+// connect asks the server to open a TCP connection from the allocation to
+// peer and returns the CONNECTION-ID for binding a data connection to it.
+func (c *turnClient) connect(peer *net.TCPAddr) ([]byte, error) {
+	resp, err := c.do(c.conn, turnMethodConnect, func(txID [12]byte) []stunAttr {
+		return []stunAttr{{stunAttrXORPeerAddress, xorAddress(peer, txID)}}
+	})
+	if err != nil {
+		return nil, err
+	}
+	id, ok := resp.get(stunAttrConnectionID)
+	if !ok || len(id) != 4 {
+		return nil, errors.New("connect response missing CONNECTION-ID")
+	}
+	return id, nil
+}
+
+// bind turns data into the relayed connection identified by connID.
+func (c *turnClient) bind(data io.ReadWriter, connID []byte) error {
+	_, err := c.do(data, turnMethodConnectionBind, func([12]byte) []stunAttr {
+		return []stunAttr{{stunAttrConnectionID, connID}}
+	})
+	return err
+}
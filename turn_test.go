@@ -0,0 +1,284 @@
+package kindling
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTURNServer starts a minimal TURN server that supports TCP allocations
+// (RFC 6062) and UDP ones relayed over the control connection, with
+// long-term credentials, and returns its address and a count of the
+// Refresh requests it has answered.
+func newTURNServer(t *testing.T, username, password string) (string, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	const realm, nonce = "kindling.test", "nonce-1"
+	key := (&turnClient{username: username, realm: realm, password: password}).key()
+	var (
+		mu        sync.Mutex
+		nextID    uint32
+		peers     = map[uint32]net.Conn{}
+		refreshes atomic.Int32
+	)
+	reply := func(conn net.Conn, req *stunMessage, class uint16, attrs ...stunAttr) {
+		resp := &stunMessage{typ: req.typ | class, txID: req.txID, attrs: attrs}
+		if class == stunClassSuccess {
+			conn.Write(resp.encode(key))
+		} else {
+			conn.Write(resp.encode(nil))
+		}
+	}
+	peerAddr := func(m *stunMessage) *net.UDPAddr {
+		v, _ := m.get(stunAttrXORPeerAddress)
+		port := binary.BigEndian.Uint16(v[2:]) ^ uint16(stunMagicCookie>>16)
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(v[4:])^stunMagicCookie)
+		return &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+	unauthorized := func(conn net.Conn, req *stunMessage) {
+		reply(conn, req, stunClassError,
+			stunAttr{stunAttrErrorCode, append([]byte{0, 0, 4, 1}, "Unauthorized"...)},
+			stunAttr{stunAttrRealm, []byte(realm)},
+			stunAttr{stunAttrNonce, []byte(nonce)})
+	}
+	handle := func(raw net.Conn) {
+		defer raw.Close()
+		// Data indications from a UDP relay share the connection with
+		// replies.
+		conn := &lockedConn{Conn: raw}
+		var relay net.PacketConn
+		defer func() {
+			if relay != nil {
+				relay.Close()
+			}
+		}()
+		for {
+			req, err := readSTUN(conn)
+			if err != nil {
+				return
+			}
+			if req.typ == turnMethodSend|stunClassIndication {
+				// Indications aren't authenticated.
+				if data, ok := req.get(stunAttrData); ok && relay != nil {
+					relay.WriteTo(data, peerAddr(req))
+				}
+				continue
+			}
+			user, _ := req.get(stunAttrUsername)
+			if string(user) != username || !req.checkIntegrity(key) {
+				unauthorized(conn, req)
+				continue
+			}
+			switch req.typ {
+			case turnMethodAllocate:
+				if v, _ := req.get(stunAttrRequestedTransport); len(v) > 0 && v[0] == protocolUDP {
+					relay, err = net.ListenPacket("udp", "127.0.0.1:0")
+					require.NoError(t, err)
+					go func() {
+						buf := make([]byte, 64<<10)
+						for {
+							n, from, err := relay.ReadFrom(buf)
+							if err != nil {
+								return
+							}
+							m := &stunMessage{typ: turnMethodData | stunClassIndication}
+							m.attrs = []stunAttr{
+								{stunAttrXORPeerAddress, xorAddress(&net.TCPAddr{IP: from.(*net.UDPAddr).IP, Port: from.(*net.UDPAddr).Port}, m.txID)},
+								{stunAttrData, buf[:n]},
+							}
+							conn.Write(m.encode(nil))
+						}
+					}()
+				}
+				reply(conn, req, stunClassSuccess)
+			case turnMethodRefresh:
+				refreshes.Add(1)
+				reply(conn, req, stunClassSuccess)
+			case turnMethodCreatePermission:
+				reply(conn, req, stunClassSuccess)
+			case turnMethodConnect:
+				addr := peerAddr(req)
+				peer, err := net.Dial("tcp", net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port)))
+				if err != nil {
+					reply(conn, req, stunClassError, stunAttr{stunAttrErrorCode, append([]byte{0, 0, 4, 47}, "Connection Timeout or Failure"...)})
+					continue
+				}
+				mu.Lock()
+				nextID++
+				id := nextID
+				peers[id] = peer
+				mu.Unlock()
+				reply(conn, req, stunClassSuccess, stunAttr{stunAttrConnectionID, binary.BigEndian.AppendUint32(nil, id)})
+			case turnMethodConnectionBind:
+				v, _ := req.get(stunAttrConnectionID)
+				mu.Lock()
+				peer := peers[binary.BigEndian.Uint32(v)]
+				mu.Unlock()
+				if peer == nil {
+					return
+				}
+				reply(conn, req, stunClassSuccess)
+				go func() {
+					io.Copy(peer, conn)
+					peer.Close()
+				}()
+				io.Copy(conn, peer)
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().String(), &refreshes
+}
+
+// lockedConn serializes writes to a net.Conn.
+type lockedConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *lockedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestParseTURNServer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		server  string
+		addr    string
+		useTLS  bool
+		wantErr bool
+	}{
+		{server: "turn.example.com:3478", addr: "turn.example.com:3478"},
+		{server: "turn:turn.example.com", addr: "turn.example.com:3478"},
+		{server: "turn:turn.example.com:80?transport=tcp", addr: "turn.example.com:80"},
+		{server: "turns:turn.example.com", addr: "turn.example.com:5349", useTLS: true},
+		{server: "turns:turn.example.com:443", addr: "turn.example.com:443", useTLS: true},
+		{server: "turn:turn.example.com?transport=udp", wantErr: true},
+		{server: "turn.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			addr, useTLS, err := parseTURNServer(tt.server)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.addr, addr)
+			assert.Equal(t, tt.useTLS, useTLS)
+		})
+	}
+}
+
+func TestSTUNMessageIntegrity(t *testing.T) {
+	t.Parallel()
+	key := []byte("key")
+	req := newSTUNRequest(turnMethodAllocate, stunAttr{stunAttrUsername, []byte("odd-len")})
+	raw := req.encode(key)
+	assert.Zero(t, len(raw)%4, "attributes must be padded")
+
+	got, err := readSTUN(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, req.txID, got.txID)
+	user, ok := got.get(stunAttrUsername)
+	require.True(t, ok)
+	assert.Equal(t, "odd-len", string(user))
+	assert.True(t, got.checkIntegrity(key))
+	assert.False(t, got.checkIntegrity([]byte("other")))
+}
+
+func TestWithTURN(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "relayed")
+	}))
+	t.Cleanup(origin.Close)
+	server, refreshes := newTURNServer(t, "alice", "secret")
+
+	t.Run("InvalidArguments", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithTURN("turn:example.com?transport=udp", "alice", "secret"))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithTURN(server, "", ""))
+		assert.Error(t, err)
+	})
+
+	t.Run("RelaysRequest", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTURN("turn:"+server, "alice", "secret"))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "relayed", string(body))
+	})
+
+	t.Run("RelaysPackets", func(t *testing.T) {
+		t.Parallel()
+		echo := serveUDPEcho(t)
+		k, err := NewKindling("test", WithTURN("turn:"+server, "alice", "secret"))
+		require.NoError(t, err)
+		defer k.Close()
+		conn, err := k.DialPacket(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, echo, conn.RemoteAddr().String())
+		assertEchoes(t, conn)
+		assertEchoes(t, conn)
+	})
+
+	t.Run("RefreshesTCPAllocation", func(t *testing.T) {
+		t.Parallel()
+		d := &turnDialer{base: &transport.TCPDialer{}, serverAddr: server, username: "alice", password: "secret", refresh: 10 * time.Millisecond}
+		conn, err := d.DialStream(t.Context(), origin.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		before := refreshes.Load()
+		require.Eventually(t, func() bool { return refreshes.Load() >= before+2 }, 5*time.Second, time.Millisecond)
+
+		_, err = io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+		require.NoError(t, err)
+		body, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "relayed", "the relay outlives its refreshes")
+	})
+
+	t.Run("WrongCredential_Fails", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithTURN(server, "alice", "wrong"))
+		require.NoError(t, err)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,89 @@
+package kindling
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+// WithUpstreamProxy adds a transport that reaches origins through an existing
+// proxy, for users who already have one that works. proxyURL is one of:
+//
+//   - http://[user:pass@]host[:port]  — HTTP CONNECT proxy
+//   - https://[user:pass@]host[:port] — HTTP CONNECT proxy over TLS
+//   - socks5://[user:pass@]host:port  — SOCKS5 proxy
+//
+// Credentials in the URL are sent with SOCKS5 username/password
+// authentication (RFC 1929), or as Basic Proxy-Authorization for HTTP
+// proxies. An HTTP proxy that answers with a 407 Digest challenge gets a
+// Digest response instead, within the same dial, and later tunnels answer
+// its latest challenge up front.
+// Origin hostnames are resolved by the proxy, not locally.
+func WithUpstreamProxy(proxyURL string) Option {
+	return func(k *kindling) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("parsing upstream proxy url: %w", err)
+		}
+		if u.Hostname() == "" {
+			return fmt.Errorf("upstream proxy url %q has no host", proxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https":
+		case "socks5", "socks5h":
+			if u.Port() == "" {
+				return fmt.Errorf("upstream proxy url %q needs a port", proxyURL)
+			}
+		default:
+			return fmt.Errorf("unsupported upstream proxy scheme %q", u.Scheme)
+		}
+		// Deferred so the hop to the proxy picks up WithStreamDialer
+		// regardless of option order.
+		k.deferred = append(k.deferred, func() error {
+			d, err := k.newUpstreamProxyDialer(k.baseStreamDialer(), u)
+			if err != nil {
+				return fmt.Errorf("creating upstream proxy dialer: %w", err)
+			}
+			k.transports = append(k.transports, newStreamTransport(string(TransportUpstreamProxy), d))
+			return nil
+		})
+		return nil
+	}
+}
+
+// newUpstreamProxyDialer returns a dialer that tunnels through the proxy at
+// u, whose scheme has already been validated.
+func (k *kindling) newUpstreamProxyDialer(base transport.StreamDialer, u *url.URL) (transport.StreamDialer, error) {
+	user := u.User.Username()
+	pass, _ := u.User.Password()
+	if u.Scheme == "socks5" || u.Scheme == "socks5h" {
+		client, err := socks5.NewClient(&transport.StreamDialerEndpoint{Dialer: base, Address: u.Host})
+		if err != nil {
+			return nil, err
+		}
+		if u.User != nil {
+			if err := client.SetCredentials([]byte(user), []byte(pass)); err != nil {
+				return nil, err
+			}
+		}
+		return client, nil
+	}
+
+	d := &connectDialer{
+		base:      base,
+		proxyAddr: hostWithPort(u.Host, u.Scheme),
+	}
+	if u.Scheme == "https" {
+		d.tlsConfig = k.tlsConfig(&tls.Config{
+			ServerName: u.Hostname(),
+			NextProtos: []string{"http/1.1"},
+		})
+	}
+	if u.User != nil {
+		d.auth = &proxyAuth{user: user, pass: pass}
+	}
+	return d, nil
+}
@@ -0,0 +1,110 @@
+package kindling
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithUpstreamProxy(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via upstream")
+	}))
+	t.Cleanup(origin.Close)
+
+	get := func(t *testing.T, k Kindling) (string, error) {
+		t.Helper()
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("InvalidURL", func(t *testing.T) {
+		t.Parallel()
+		for _, u := range []string{"ftp://proxy:21", "socks5://proxy", "http://"} {
+			_, err := NewKindling("test", WithUpstreamProxy(u))
+			assert.Error(t, err, u)
+		}
+	})
+
+	t.Run("HTTPWithBasicAuth", func(t *testing.T) {
+		t.Parallel()
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:p@ss"))
+		proxy := newConnectProxy(t, false, want)
+		k, err := NewKindling("test", WithUpstreamProxy("http://user:p%40ss@"+proxy.addr()))
+		require.NoError(t, err)
+		body, err := get(t, k)
+		require.NoError(t, err)
+		assert.Equal(t, "via upstream", body)
+		assert.Equal(t, int64(1), proxy.tunnels.Load())
+	})
+
+	t.Run("HTTPWrongAuth_Fails", func(t *testing.T) {
+		t.Parallel()
+		proxy := newConnectProxy(t, false, "Basic nope")
+		k, err := NewKindling("test", WithUpstreamProxy("http://user:wrong@"+proxy.addr()))
+		require.NoError(t, err)
+		_, err = get(t, k)
+		assert.Error(t, err)
+	})
+
+	t.Run("HTTPS", func(t *testing.T) {
+		t.Parallel()
+		proxy := newConnectProxy(t, true, "")
+		u, err := url.Parse("https://" + proxy.addr())
+		require.NoError(t, err)
+		d, err := (&kindling{}).newUpstreamProxyDialer(&transport.TCPDialer{}, u)
+		require.NoError(t, err)
+		cd := d.(*connectDialer)
+		cd.tlsConfig.RootCAs = proxy.clientTLSConfig().RootCAs
+
+		rt, err := newStreamTransport("test", d).NewRoundTripper(context.Background(), origin.Listener.Addr().String())
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: rt}).Get(origin.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, int64(1), proxy.tunnels.Load())
+	})
+
+	t.Run("SOCKS5WithAuth", func(t *testing.T) {
+		t.Parallel()
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Close() })
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go handleTestSOCKS5Auth(conn, "user", "secret")
+			}
+		}()
+
+		k, err := NewKindling("test", WithUpstreamProxy("socks5://user:secret@"+l.Addr().String()))
+		require.NoError(t, err)
+		body, err := get(t, k)
+		require.NoError(t, err)
+		assert.Equal(t, "via upstream", body)
+
+		k, err = NewKindling("test", WithUpstreamProxy("socks5://user:wrong@"+l.Addr().String()))
+		require.NoError(t, err)
+		_, err = get(t, k)
+		assert.Error(t, err)
+	})
+}
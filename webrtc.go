@@ -0,0 +1,102 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+// Rendezvous connects to volunteer WebRTC peers the way snowflake and
+// broflake clients do: it creates a peer connection, trades session
+// descriptions with a broker, and returns the open data channel as a
+// net.Conn. Kindling has no WebRTC stack of its own, so implementations
+// typically wrap pion/webrtc and the broker's signaling protocol.
+//
+// broker reaches the broker through kindling's other transports, so
+// signaling keeps working when the broker's domain is blocked directly.
+type Rendezvous interface {
+	DialDataChannel(ctx context.Context, broker *http.Client) (net.Conn, error)
+}
+
+// WithWebRTC adds a transport that tunnels through WebRTC data channels
+// opened by rendezvous. The peer on the far end of each data channel is
+// expected to bridge it to a SOCKS5 egress, which kindling asks to connect
+// to each request's origin.
+//
+// The broker client races every other configured transport, so WithWebRTC
+// needs at least one more transport to be useful.
+func WithWebRTC(rendezvous Rendezvous) Option {
+	return func(k *kindling) error {
+		if rendezvous == nil {
+			return fmt.Errorf("webrtc rendezvous is nil")
+		}
+		endpoint := k.multiplexedEndpoint(TransportWebRTC, transport.FuncStreamEndpoint(func(ctx context.Context) (transport.StreamConn, error) {
+			conn, err := rendezvous.DialDataChannel(ctx, k.httpClientExcluding(string(TransportWebRTC)))
+			if err != nil {
+				return nil, fmt.Errorf("opening webrtc data channel: %w", err)
+			}
+			return asStreamConn(conn), nil
+		}))
+		d, err := socks5.NewClient(endpoint)
+		if err != nil {
+			return fmt.Errorf("creating webrtc dialer: %w", err)
+		}
+		k.transports = append(k.transports, newStreamTransport(string(TransportWebRTC), d))
+		return nil
+	}
+}
+
+// httpClientExcluding returns an HTTP client that races the transports
+// configured at request time, minus those named in exclude. Transports use it
+// to bootstrap their own signaling through the rest of the race.
+func (k *kindling) httpClientExcluding(exclude ...string) *http.Client {
+	return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		k.mu.Lock()
+		var snapshot []Transport
+		for _, t := range k.transports {
+			if !slices.Contains(exclude, t.Name()) {
+				snapshot = append(snapshot, t)
+			}
+		}
+		k.mu.Unlock()
+		if len(snapshot) == 0 {
+			return nil, errors.New("no other transports configured")
+		}
+		return k.newRaceTransport(snapshot).RoundTrip(req)
+	})}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// asStreamConn adapts a net.Conn to transport.StreamConn, passing half-closes
+// through when the underlying connection supports them.
+func asStreamConn(conn net.Conn) transport.StreamConn {
+	if sc, ok := conn.(transport.StreamConn); ok {
+		return sc
+	}
+	return &halfCloseConn{conn}
+}
+
+type halfCloseConn struct{ net.Conn }
+
+func (c *halfCloseConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return errors.ErrUnsupported
+}
+
+func (c *halfCloseConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,95 @@
+package kindling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRendezvous asks the broker for a peer address and dials it directly,
+// standing in for the SDP exchange and data channel of a real WebRTC stack.
+type fakeRendezvous struct{ brokerURL string }
+
+func (r *fakeRendezvous) DialDataChannel(ctx context.Context, broker *http.Client) (net.Conn, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.brokerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := broker.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", string(answer))
+}
+
+func TestWithWebRTC(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via data channel")
+	}))
+	t.Cleanup(origin.Close)
+	peer := serveSOCKS5(t)
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, peer)
+	}))
+	t.Cleanup(broker.Close)
+	brokerURL, err := url.Parse(broker.URL)
+	require.NoError(t, err)
+
+	// brokerOnly can reach the broker but nothing else, so requests to the
+	// origin must go through the WebRTC transport.
+	brokerOnly := &mockTransport{
+		name:         "direct",
+		isStreamable: true,
+		newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+			if addr != brokerURL.Host {
+				return nil, errors.New("blocked")
+			}
+			return http.DefaultTransport, nil
+		},
+	}
+
+	t.Run("NilRendezvous", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithWebRTC(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("SignalsThroughOtherTransports", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test",
+			WithTransport(brokerOnly),
+			WithWebRTC(&fakeRendezvous{brokerURL: broker.URL}),
+		)
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "via data channel", string(body))
+	})
+
+	t.Run("NoOtherTransports_Fails", func(t *testing.T) {
+		t.Parallel()
+		k, err := NewKindling("test", WithWebRTC(&fakeRendezvous{brokerURL: broker.URL}))
+		require.NoError(t, err)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		assert.ErrorContains(t, err, "no other transports")
+	})
+}
@@ -0,0 +1,170 @@
+package kindling
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+)
+
+// WithWebTunnel adds a WebTunnel (HTTPT-style) transport. To an observer, each
+// race attempt is an ordinary HTTPS connection to the web server at
+// serverURL that upgrades to a WebSocket on path; in reality the upgraded
+// stream is a raw tunnel. As with Tor's WebTunnel bridges, the server only
+// upgrades on the secret path and otherwise serves its innocuous site.
+//
+// The server forwards upgraded streams to a SOCKS5 proxy, which kindling then
+// asks to connect to each request's origin. serverPubKey pins the server's
+// TLS public key, as a PEM "PUBLIC KEY" block or base64 DER
+// SubjectPublicKeyInfo; when set it replaces certificate-authority
+// validation, so bridges can use self-signed certificates. Empty uses normal
+// certificate validation.
+func WithWebTunnel(serverURL, path, serverPubKey string) Option {
+	return func(k *kindling) error {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return fmt.Errorf("parsing webtunnel url: %w", err)
+		}
+		if u.Scheme != "https" || u.Hostname() == "" {
+			return fmt.Errorf("webtunnel url %q must be an https:// URL with a host", serverURL)
+		}
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("webtunnel path %q must start with /", path)
+		}
+		tlsConfig := &tls.Config{
+			ServerName: u.Hostname(),
+			NextProtos: []string{"http/1.1"},
+		}
+		if serverPubKey != "" {
+			pin, err := parsePublicKeyPin(serverPubKey)
+			if err != nil {
+				return fmt.Errorf("parsing webtunnel server key: %w", err)
+			}
+			// Verification moves entirely to VerifyConnection's pin check.
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyConnection = verifyPublicKeyPin(pin)
+		}
+		k.deferred = append(k.deferred, func() error {
+			endpoint := k.multiplexedEndpoint(TransportWebTunnel, &webTunnelEndpoint{
+				base:       k.baseStreamDialer(),
+				serverAddr: hostWithPort(u.Host, u.Scheme),
+				host:       u.Host,
+				path:       path,
+				tlsConfig:  k.tlsConfig(tlsConfig),
+			})
+			d, err := socks5.NewClient(endpoint)
+			if err != nil {
+				return fmt.Errorf("creating webtunnel dialer: %w", err)
+			}
+			k.transports = append(k.transports, newStreamTransport(string(TransportWebTunnel), d))
+			return nil
+		})
+		return nil
+	}
+}
+
+// webTunnelEndpoint is a transport.StreamEndpoint whose streams are
+// WebSocket-upgraded HTTPS connections to a WebTunnel server.
+type webTunnelEndpoint struct {
+	base       transport.StreamDialer
+	serverAddr string
+	host       string
+	path       string
+	tlsConfig  *tls.Config
+}
+
+var _ transport.StreamEndpoint = (*webTunnelEndpoint)(nil)
+
+func (e *webTunnelEndpoint) ConnectStream(ctx context.Context) (transport.StreamConn, error) {
+	return dialHTTPTunnel(ctx, e.base, e.serverAddr, e.tlsConfig, e.upgrade)
+}
+
+// websocketGUID is the fixed GUID from RFC 6455 §1.3 used to derive
+// Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// upgrade sends the WebSocket upgrade request over conn and checks the
+// server switched protocols with a matching Sec-WebSocket-Accept.
+func (e *webTunnelEndpoint) upgrade(conn net.Conn) (*bufio.Reader, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: e.path},
+		Host:   e.host,
+		Header: http.Header{
+			"Connection":            {"Upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+			"User-Agent":            {""},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("writing upgrade request: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading upgrade response: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("webtunnel upgrade: %s", resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("webtunnel upgrade: bad Sec-WebSocket-Accept")
+	}
+	return br, nil
+}
+
+// parsePublicKeyPin decodes a public key given as a PEM block or base64 DER
+// SubjectPublicKeyInfo and returns its DER encoding.
+func parsePublicKeyPin(s string) ([]byte, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		der = block.Bytes
+	} else {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("key is neither PEM nor base64: %w", err)
+		}
+		der = b
+	}
+	if _, err := x509.ParsePKIXPublicKey(der); err != nil {
+		return nil, err
+	}
+	return der, nil
+}
+
+// verifyPublicKeyPin returns a tls.Config.VerifyConnection callback that
+// accepts only a leaf certificate carrying the pinned public key.
+func verifyPublicKeyPin(pin []byte) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		if !bytes.Equal(cs.PeerCertificates[0].RawSubjectPublicKeyInfo, pin) {
+			return errors.New("server public key does not match pin")
+		}
+		return nil
+	}
+}
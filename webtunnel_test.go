@@ -0,0 +1,137 @@
+package kindling
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWebTunnelServer starts a TLS server that upgrades requests on path and
+// hands the upgraded stream to a test SOCKS5 handler, like a WebTunnel bridge
+// fronting a SOCKS proxy. Other paths get the server's "innocuous" site.
+func newWebTunnelServer(t *testing.T, path string) *httptest.Server {
+	t.Helper()
+	return newWebTunnelServerWith(t, path, handleTestSOCKS5)
+}
+
+// newWebTunnelServerWith is newWebTunnelServer handing upgraded streams to
+// handle instead.
+func newWebTunnelServerWith(t *testing.T, path string, handle func(net.Conn)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path || r.Header.Get("Upgrade") != "websocket" {
+			io.WriteString(w, "<html>nothing to see here</html>")
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n\r\n")
+		handle(conn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWithWebTunnel(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the tunnel")
+	}))
+	t.Cleanup(origin.Close)
+
+	t.Run("InvalidArguments", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewKindling("test", WithWebTunnel("http://example.com", "/secret", ""))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithWebTunnel("https://example.com", "secret", ""))
+		assert.Error(t, err)
+		_, err = NewKindling("test", WithWebTunnel("https://example.com", "/secret", "not a key"))
+		assert.Error(t, err)
+	})
+
+	t.Run("PinnedKey_TunnelsRequest", func(t *testing.T) {
+		t.Parallel()
+		srv := newWebTunnelServer(t, "/secret")
+		pin := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: srv.Certificate().RawSubjectPublicKeyInfo})
+
+		k, err := NewKindling("test", WithWebTunnel(srv.URL, "/secret", string(pin)))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "through the tunnel", string(body))
+	})
+
+	t.Run("WrongPin_Fails", func(t *testing.T) {
+		t.Parallel()
+		srv := newWebTunnelServer(t, "/secret")
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		require.NoError(t, err)
+
+		k, err := NewKindling("test", WithWebTunnel(srv.URL, "/secret", base64.StdEncoding.EncodeToString(der)))
+		require.NoError(t, err)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not match pin")
+	})
+
+	t.Run("WrongPath_NotUpgraded", func(t *testing.T) {
+		t.Parallel()
+		srv := newWebTunnelServer(t, "/secret")
+		pin := base64.StdEncoding.EncodeToString(srv.Certificate().RawSubjectPublicKeyInfo)
+		k, err := NewKindling("test", WithWebTunnel(srv.URL, "/guess", pin))
+		require.NoError(t, err)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "webtunnel upgrade")
+	})
+
+	t.Run("UnpinnedSelfSigned_Fails", func(t *testing.T) {
+		t.Parallel()
+		srv := newWebTunnelServer(t, "/secret")
+		k, err := NewKindling("test", WithWebTunnel(srv.URL, "/secret", ""))
+		require.NoError(t, err)
+		_, err = k.NewHTTPClient().Get(origin.URL)
+		require.Error(t, err)
+	})
+}
+
+func TestParsePublicKeyPin(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	der := srv.Certificate().RawSubjectPublicKeyInfo
+
+	got, err := parsePublicKeyPin(base64.StdEncoding.EncodeToString(der))
+	require.NoError(t, err)
+	assert.Equal(t, der, got)
+
+	got, err = parsePublicKeyPin(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	require.NoError(t, err)
+	assert.Equal(t, der, got)
+
+	_, err = parsePublicKeyPin(base64.StdEncoding.EncodeToString([]byte("junk")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,331 @@
+package kindling
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// WireGuardConfig describes a userspace WireGuard tunnel to a single peer.
+// ParseWireGuardConfig fills it from a wg-quick style configuration file.
+type WireGuardConfig struct {
+	// PrivateKey, PeerPublicKey and PresharedKey are base64 keys as used in
+	// wg-quick files. PresharedKey is optional.
+	PrivateKey    string
+	PeerPublicKey string
+	PresharedKey  string
+	// Endpoint is the peer's host:port.
+	Endpoint string
+	// Addresses are the tunnel addresses assigned to this end.
+	Addresses []netip.Addr
+	// DNS servers to resolve origin names through, inside the tunnel.
+	DNS []netip.Addr
+	// AllowedIPs defaults to all of IPv4 and IPv6.
+	AllowedIPs []netip.Prefix
+	// MTU defaults to 1420.
+	MTU int
+	// PersistentKeepalive is optional; zero disables keepalives.
+	PersistentKeepalive time.Duration
+}
+
+// ParseWireGuardConfig parses a wg-quick configuration with one [Interface]
+// and one [Peer] section. Directives kindling has no use for (PostUp,
+// Table, ListenPort, ...) are ignored.
+func ParseWireGuardConfig(conf string) (*WireGuardConfig, error) {
+	cfg := &WireGuardConfig{}
+	var section string
+	var peers int
+	sc := bufio.NewScanner(strings.NewReader(conf))
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			if section == "peer" {
+				if peers++; peers > 1 {
+					return nil, fmt.Errorf("line %d: only one [Peer] is supported", n)
+				}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		var err error
+		switch section + "." + key {
+		case "interface.privatekey":
+			cfg.PrivateKey = value
+		case "interface.address":
+			for _, v := range splitList(value) {
+				// Addresses are usually written with a prefix length.
+				var p netip.Prefix
+				if p, err = netip.ParsePrefix(v); err == nil {
+					cfg.Addresses = append(cfg.Addresses, p.Addr())
+				} else {
+					var a netip.Addr
+					if a, err = netip.ParseAddr(v); err == nil {
+						cfg.Addresses = append(cfg.Addresses, a)
+					}
+				}
+				if err != nil {
+					break
+				}
+			}
+		case "interface.dns":
+			for _, v := range splitList(value) {
+				var a netip.Addr
+				// Search domains may be mixed in with servers; skip them.
+				if a, err = netip.ParseAddr(v); err == nil {
+					cfg.DNS = append(cfg.DNS, a)
+				}
+				err = nil
+			}
+		case "interface.mtu":
+			cfg.MTU, err = strconv.Atoi(value)
+		case "peer.publickey":
+			cfg.PeerPublicKey = value
+		case "peer.presharedkey":
+			cfg.PresharedKey = value
+		case "peer.endpoint":
+			cfg.Endpoint = value
+		case "peer.allowedips":
+			for _, v := range splitList(value) {
+				var p netip.Prefix
+				if p, err = netip.ParsePrefix(v); err != nil {
+					break
+				}
+				cfg.AllowedIPs = append(cfg.AllowedIPs, p)
+			}
+		case "peer.persistentkeepalive":
+			if value != "off" {
+				var secs int
+				secs, err = strconv.Atoi(value)
+				cfg.PersistentKeepalive = time.Duration(secs) * time.Second
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %w", n, key, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// validate checks required fields and fills in defaults.
+func (c *WireGuardConfig) validate() error {
+	if _, err := wireGuardKeyHex(c.PrivateKey); err != nil {
+		return fmt.Errorf("wireguard private key: %w", err)
+	}
+	if _, err := wireGuardKeyHex(c.PeerPublicKey); err != nil {
+		return fmt.Errorf("wireguard peer public key: %w", err)
+	}
+	if c.PresharedKey != "" {
+		if _, err := wireGuardKeyHex(c.PresharedKey); err != nil {
+			return fmt.Errorf("wireguard preshared key: %w", err)
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		return fmt.Errorf("wireguard endpoint %q: %w", c.Endpoint, err)
+	}
+	if len(c.Addresses) == 0 {
+		return fmt.Errorf("wireguard config has no interface address")
+	}
+	if len(c.AllowedIPs) == 0 {
+		c.AllowedIPs = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	}
+	if c.MTU == 0 {
+		c.MTU = 1420
+	}
+	return nil
+}
+
+// UAPI renders the peer configuration in WireGuard's cross-platform
+// userspace API format, as taken by wireguard-go's Device.IpcSet. Endpoint
+// hostnames are resolved here since the UAPI only accepts IP addresses.
+func (c *WireGuardConfig) UAPI() string {
+	var b strings.Builder
+	priv, _ := wireGuardKeyHex(c.PrivateKey)
+	pub, _ := wireGuardKeyHex(c.PeerPublicKey)
+	fmt.Fprintf(&b, "private_key=%s\npublic_key=%s\n", priv, pub)
+	if c.PresharedKey != "" {
+		psk, _ := wireGuardKeyHex(c.PresharedKey)
+		fmt.Fprintf(&b, "preshared_key=%s\n", psk)
+	}
+	endpoint := c.Endpoint
+	if host, port, err := net.SplitHostPort(endpoint); err == nil && net.ParseIP(host) == nil {
+		if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
+			endpoint = net.JoinHostPort(ips[0].String(), port)
+		}
+	}
+	fmt.Fprintf(&b, "endpoint=%s\n", endpoint)
+	if c.PersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", int(c.PersistentKeepalive/time.Second))
+	}
+	for _, p := range c.AllowedIPs {
+		fmt.Fprintf(&b, "allowed_ip=%s\n", p)
+	}
+	return b.String()
+}
+
+// wireGuardKeyHex converts a base64 WireGuard key to the hex form the UAPI
+// expects.
+func wireGuardKeyHex(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) != 32 {
+		return "", fmt.Errorf("key is %d bytes, want 32", len(raw))
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// WithWireGuard adds a transport that routes requests through a userspace
+// WireGuard tunnel. The device runs on wireguard-go's netstack, so nothing
+// touches the OS routing table or needs elevated privileges. Bringing up the
+// device is heavier than the other transports' per-request connections, so
+// the tunnel is only started the first time the race reaches it, and it
+// races as a fallback behind the default tier. Close shuts the device down.
+func WithWireGuard(config WireGuardConfig) Option {
+	return func(k *kindling) error {
+		cfg := config
+		if err := cfg.validate(); err != nil {
+			return err
+		}
+		d := &wireGuardDialer{cfg: &cfg, start: startWireGuard}
+		k.deferred = append(k.deferred, func() error {
+			d.log = k.log
+			return k.onClose(d)
+		})
+		nt := newStreamTransport(string(TransportWireGuard), d)
+		nt.priority = priorityFallback
+		k.transports = append(k.transports, nt)
+		return nil
+	}
+}
+
+// tunnelDialFunc has the signature of net.Dialer.DialContext.
+type tunnelDialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// startWireGuard brings up a userspace device for cfg and returns a dialer
+// for the tunnel's network stack and the device, to close it.
+func startWireGuard(cfg *WireGuardConfig, log *slog.Logger) (tunnelDialFunc, io.Closer, error) {
+	tun, tnet, err := netstack.CreateNetTUN(cfg.Addresses, cfg.DNS, cfg.MTU)
+	if err != nil {
+		return nil, nil, err
+	}
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), &device.Logger{
+		Verbosef: device.DiscardLogf,
+		Errorf: func(format string, args ...any) {
+			log.Debug("WireGuard error", "error", fmt.Sprintf(format, args...))
+		},
+	})
+	if err := dev.IpcSet(cfg.UAPI()); err != nil {
+		dev.Close()
+		return nil, nil, err
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, nil, err
+	}
+	return tnet.DialContext, closerFunc(dev.Close), nil
+}
+
+// wireGuardDialer starts the tunnel on first use. A failed start is retried
+// on a later dial, since the peer may only have been unreachable briefly.
+type wireGuardDialer struct {
+	cfg   *WireGuardConfig
+	start func(*WireGuardConfig, *slog.Logger) (tunnelDialFunc, io.Closer, error)
+	log   *slog.Logger
+
+	mu     sync.Mutex
+	dial   tunnelDialFunc
+	dev    io.Closer
+	closed bool
+}
+
+var (
+	_ transport.StreamDialer = (*wireGuardDialer)(nil)
+	_ transport.PacketDialer = (*wireGuardDialer)(nil)
+)
+
+func (d *wireGuardDialer) DialStream(ctx context.Context, addr string) (transport.StreamConn, error) {
+	dial, err := d.tunnel()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return asStreamConn(conn), nil
+}
+
+// DialPacket opens a UDP association with addr through the tunnel.
+func (d *wireGuardDialer) DialPacket(ctx context.Context, addr string) (net.Conn, error) {
+	dial, err := d.tunnel()
+	if err != nil {
+		return nil, err
+	}
+	return dial(ctx, "udp", addr)
+}
+
+// tunnel starts the tunnel if it isn't up yet and returns its dial func.
+func (d *wireGuardDialer) tunnel() (tunnelDialFunc, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrClosed
+	}
+	if d.dial == nil {
+		dial, dev, err := d.start(d.cfg, d.log)
+		if err != nil {
+			return nil, fmt.Errorf("starting wireguard tunnel: %w", err)
+		}
+		d.dial, d.dev = dial, dev
+	}
+	return d.dial, nil
+}
+
+// Close shuts the device down if it was started.
+func (d *wireGuardDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if d.dev == nil {
+		return nil
+	}
+	return d.dev.Close()
+}
@@ -0,0 +1,221 @@
+package kindling
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	wgconn "golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+const testWireGuardConf = `
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.64.0.2/32, fd00::2/128
+DNS = 10.64.0.1, corp.example
+MTU = 1380
+PostUp = iptables -A FORWARD # ignored
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+Endpoint = 192.0.2.1:51820
+AllowedIPs = 0.0.0.0/0
+PersistentKeepalive = 25
+`
+
+func TestParseWireGuardConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := ParseWireGuardConfig(testWireGuardConf)
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.64.0.2"), netip.MustParseAddr("fd00::2")}, cfg.Addresses)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.64.0.1")}, cfg.DNS)
+	assert.Equal(t, 1380, cfg.MTU)
+	assert.Equal(t, "192.0.2.1:51820", cfg.Endpoint)
+	assert.Equal(t, 25*time.Second, cfg.PersistentKeepalive)
+
+	uapi := cfg.UAPI()
+	assert.Contains(t, uapi, "private_key=c809f3e5317e9575c9b5ed78b638b7ce530dabe85ddab614220241801ddf0669\n")
+	assert.Contains(t, uapi, "endpoint=192.0.2.1:51820\n")
+	assert.Contains(t, uapi, "persistent_keepalive_interval=25\n")
+	assert.Contains(t, uapi, "allowed_ip=0.0.0.0/0\n")
+
+	_, err = ParseWireGuardConfig("[Interface]\nAddress = not-an-ip\n")
+	assert.Error(t, err)
+	_, err = ParseWireGuardConfig("[Peer]\n[Peer]\n")
+	assert.Error(t, err)
+}
+
+// withFakeWireGuard is WithWireGuard with the device replaced by start, so
+// tests can route through a plain dialer.
+func withFakeWireGuard(cfg WireGuardConfig, start func(*WireGuardConfig) (tunnelDialFunc, error)) Option {
+	return func(k *kindling) error {
+		if err := WithWireGuard(cfg)(k); err != nil {
+			return err
+		}
+		d := k.transports[len(k.transports)-1].(*namedTransport).dialer.(*wireGuardDialer)
+		d.start = func(cfg *WireGuardConfig, _ *slog.Logger) (tunnelDialFunc, io.Closer, error) {
+			dial, err := start(cfg)
+			return dial, closerFunc(func() {}), err
+		}
+		return nil
+	}
+}
+
+// newWireGuardKeys returns a base64 private key and its public key.
+func newWireGuardKeys(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+func TestWithWireGuard(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the tunnel")
+	}))
+	t.Cleanup(origin.Close)
+
+	parsed, err := ParseWireGuardConfig(testWireGuardConf)
+	require.NoError(t, err)
+	var dialer net.Dialer
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		t.Parallel()
+		bad := *parsed
+		bad.PeerPublicKey = "short"
+		_, err := NewKindling("test", WithWireGuard(bad))
+		assert.ErrorContains(t, err, "peer public key")
+
+		bad = *parsed
+		bad.Addresses = nil
+		_, err = NewKindling("test", WithWireGuard(bad))
+		assert.ErrorContains(t, err, "no interface address")
+	})
+
+	t.Run("StartsTunnelOnceAndRoutesRequests", func(t *testing.T) {
+		t.Parallel()
+		var starts atomic.Int32
+		k, err := NewKindling("test", withFakeWireGuard(*parsed, func(got *WireGuardConfig) (tunnelDialFunc, error) {
+			starts.Add(1)
+			assert.True(t, strings.HasPrefix(got.UAPI(), "private_key="))
+			return dialer.DialContext, nil
+		}))
+		require.NoError(t, err)
+		assert.Zero(t, starts.Load(), "tunnel must not start before it's needed")
+
+		for range 2 {
+			resp, err := k.NewHTTPClient().Get(origin.URL)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, "through the tunnel", string(body))
+		}
+		assert.EqualValues(t, 1, starts.Load())
+	})
+
+	t.Run("CarriesPackets", func(t *testing.T) {
+		t.Parallel()
+		echo := serveUDPEcho(t)
+		k, err := NewKindling("test", withFakeWireGuard(*parsed, func(*WireGuardConfig) (tunnelDialFunc, error) {
+			return dialer.DialContext, nil
+		}))
+		require.NoError(t, err)
+		defer k.Close()
+		conn, err := k.DialPacket(context.Background(), echo)
+		require.NoError(t, err)
+		defer conn.Close()
+		assertEchoes(t, conn)
+	})
+
+	t.Run("RacesAsFallback", func(t *testing.T) {
+		t.Parallel()
+		var started atomic.Bool
+		direct := &mockTransport{
+			name: "direct",
+			newRoundTripper: func(ctx context.Context, addr string) (http.RoundTripper, error) {
+				return http.DefaultTransport, nil
+			},
+		}
+		k, err := NewKindling("test", WithTransport(direct), withFakeWireGuard(*parsed, func(*WireGuardConfig) (tunnelDialFunc, error) {
+			started.Store(true)
+			return nil, errors.New("unused")
+		}))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get(origin.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.False(t, started.Load(), "fallback tier should not race while the default tier works")
+	})
+
+	t.Run("ThroughDevice", func(t *testing.T) {
+		t.Parallel()
+		clientKey, clientPub := newWireGuardKeys(t)
+		peerKey, peerPub := newWireGuardKeys(t)
+		hexKey := func(key string) string {
+			h, err := wireGuardKeyHex(key)
+			require.NoError(t, err)
+			return h
+		}
+
+		// The peer is a second wireguard-go device, serving HTTP on its
+		// own netstack at 10.64.0.1.
+		peerTun, peerNet, err := netstack.CreateNetTUN([]netip.Addr{netip.MustParseAddr("10.64.0.1")}, nil, 1420)
+		require.NoError(t, err)
+		peer := device.NewDevice(peerTun, wgconn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, ""))
+		t.Cleanup(peer.Close)
+		require.NoError(t, peer.IpcSet(fmt.Sprintf("private_key=%s\npublic_key=%s\nallowed_ip=10.64.0.2/32\n", hexKey(peerKey), hexKey(clientPub))))
+		require.NoError(t, peer.Up())
+		state, err := peer.IpcGet()
+		require.NoError(t, err)
+		_, port, ok := strings.Cut(state, "listen_port=")
+		require.True(t, ok, "peer has no listen port")
+		port, _, _ = strings.Cut(port, "\n")
+
+		l, err := peerNet.ListenTCP(&net.TCPAddr{Port: 80})
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Close() })
+		go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello from "+r.RemoteAddr)
+		}))
+
+		k, err := NewKindling("test", WithLogWriter(io.Discard), WithWireGuard(WireGuardConfig{
+			PrivateKey:    clientKey,
+			PeerPublicKey: peerPub,
+			Endpoint:      net.JoinHostPort("127.0.0.1", port),
+			Addresses:     []netip.Addr{netip.MustParseAddr("10.64.0.2")},
+			AllowedIPs:    []netip.Prefix{netip.MustParsePrefix("10.64.0.0/24")},
+		}))
+		require.NoError(t, err)
+		resp, err := k.NewHTTPClient().Get("http://10.64.0.1/")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(body), "hello from 10.64.0.2:"), "got %q", body)
+
+		require.NoError(t, k.Close())
+		_, err = k.NewHTTPClient().Get("http://10.64.0.1/")
+		assert.ErrorIs(t, err, ErrClosed)
+	})
+}